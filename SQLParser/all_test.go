@@ -1,6 +1,8 @@
 package SQLParser
 
 import (
+	"context"
+	"math"
 	"testing"
 
 	"fmt"
@@ -121,7 +123,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	//PrintExplain(ast.Mtree, stmt)
 	es, err := NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err := es.Materialize()
+	cs, err := es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 29)
 
@@ -130,7 +132,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	evalAndPrint(c, err, false, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 29)
 
@@ -140,7 +142,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	evalAndPrint(c, err, false, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len() == 0, Equals, true)
 	c.Assert(err == nil, Equals, true)
@@ -152,7 +154,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	//PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 0)
 	c.Assert(err == nil, Equals, true)
@@ -164,7 +166,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	//PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len() == 29, Equals, true)
 	c.Assert(err == nil, Equals, true)
@@ -175,7 +177,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len() == 1, Equals, true)
 	c.Assert(err == nil, Equals, true)
@@ -186,7 +188,7 @@ func (s *TestSuite) TestExecutableStatement(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	count := cs.GetColumn("Count").([]int64)
 	fmt.Println("Count = ", count)
@@ -199,7 +201,7 @@ func (s *TestSuite) TestStatementErrors(c *C) {
 	evalAndPrint(c, err, false, stmt)
 	es, err := NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err := es.Materialize()
+	cs, err := es.Materialize(context.Background())
 	evalAndPrint(c, err, true, stmt)
 	_ = cs
 }
@@ -210,11 +212,508 @@ func (s *TestSuite) TestInsertInto(c *C) {
 	//PrintExplain(ast.Mtree, stmt)
 	es, err := NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err := es.Materialize()
+	cs, err := es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 1)
 }
 
+func (s *TestSuite) TestJoin(c *C) {
+	stmt := "SELECT Epoch, a.Close, b.Close AS BClose from `AAPL/1Min/OHLCV` a JOIN `BBPL/1Min/OHLCV` b ON a.Epoch = b.Epoch WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 29)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "a.Close", "BClose"})
+
+	// Without aliases the symbol is the column prefix
+	stmt = "SELECT * from `BBPL/1Min/OHLCV` LEFT JOIN `CCPL/5Min/OHLCV` USING (Epoch) WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 29)
+	c.Assert(cs.Exists("BBPL.Open"), Equals, true)
+	c.Assert(cs.Exists("CCPL.Open"), Equals, true)
+	epoch := cs.GetEpoch()
+	// The unmatched rows are NULL, NaN for floats and zero for integers
+	open5 := cs.GetByName("CCPL.Open").([]float32)
+	volume5 := reflect.ValueOf(cs.GetByName("CCPL.Volume"))
+	var matched int
+	for i := range epoch {
+		if epoch[i]%300 == 0 {
+			matched++
+			c.Assert(open5[i] != 0, Equals, true)
+		} else {
+			c.Assert(math.IsNaN(float64(open5[i])), Equals, true)
+			c.Assert(volume5.Index(i).Int(), Equals, int64(0))
+		}
+	}
+	c.Assert(matched, Equals, 5)
+
+	stmt = "SELECT Epoch, BBPL.Open from `BBPL/1Min/OHLCV` INNER JOIN `CCPL/5Min/OHLCV` USING (Epoch) WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, matched)
+
+	// Predicates on the prefixed columns
+	stmt = "SELECT * from `AAPL/1Min/OHLCV` a JOIN `BBPL/1Min/OHLCV` b USING (Epoch) WHERE b.Open > 10.234 AND (Epoch > '2000-01-05-12:30' AND Epoch < '2000-01-05-13:00');"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 0)
+
+	// The same prefix on both sides
+	stmt = "SELECT * from `AAPL/1Min/OHLCV` JOIN `AAPL/5Min/OHLCV` USING (Epoch);"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+
+	// Only Epoch joins are supported
+	stmt = "SELECT * from `AAPL/1Min/OHLCV` a JOIN `BBPL/1Min/OHLCV` b ON a.Open = b.Open;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+
+	stmt = "SELECT * from `AAPL/1Min/OHLCV` a FULL OUTER JOIN `BBPL/1Min/OHLCV` b USING (Epoch);"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+}
+
+func (s *TestSuite) TestWildcardTable(c *C) {
+	stmt := `SELECT Epoch, Close, Symbol, Timeframe, AttributeGroup FROM "*/1Min/OHLCV" WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';`
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 3*29)
+	symbols := cs.GetByName("Symbol").([]string)
+	c.Assert(symbols[0], Equals, "AAPL")
+	c.Assert(symbols[29], Equals, "BBPL")
+	c.Assert(symbols[58], Equals, "CCPL")
+	c.Assert(cs.GetByName("Timeframe").([]string)[0], Equals, "1Min")
+	c.Assert(cs.GetByName("AttributeGroup").([]string)[0], Equals, "OHLCV")
+
+	// Key column predicates restrict the keys read
+	stmt = `SELECT * FROM "*/1Min/OHLCV" WHERE Symbol IN ('AAPL', 'CCPL', 'ZZZZ') AND Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 2*29)
+	symbols = cs.GetByName("Symbol").([]string)
+	c.Assert(symbols[0], Equals, "AAPL")
+	c.Assert(symbols[29], Equals, "CCPL")
+
+	// All timeframes of a symbol
+	stmt = `SELECT Epoch, Timeframe FROM "BBPL/*/OHLCV" WHERE Timeframe = '1H' AND Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:30';`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 1)
+	c.Assert(cs.GetByName("Timeframe").([]string)[0], Equals, "1H")
+
+	stmt = `SELECT Epoch, Timeframe FROM "BBPL/*/OHLCV" WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:30';`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	timeframes := make(map[string]int)
+	for _, tf := range cs.GetByName("Timeframe").([]string) {
+		timeframes[tf]++
+	}
+	c.Assert(timeframes["1Min"], Equals, 59)
+	c.Assert(timeframes["15Min"], Equals, 3)
+	c.Assert(timeframes["1H"], Equals, 1)
+
+	// GROUP BY on a key column
+	stmt = `SELECT Symbol, count(*) FROM "*/1Min/OHLCV" WHERE Symbol IN ('CCPL', 'BBPL') AND Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Symbol;`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetByName("Symbol"), DeepEquals, []string{"BBPL", "CCPL"})
+	c.Assert(cs.GetByName("Count"), DeepEquals, []int64{29, 29})
+
+	// Other key column predicates are applied after the keys are read
+	stmt = `SELECT Symbol, count(*) FROM "*/1Min/OHLCV" WHERE Symbol <> 'BBPL' AND Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Symbol;`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetByName("Symbol"), DeepEquals, []string{"AAPL", "CCPL"})
+
+	// Key columns only support equality and IN lists
+	stmt = `SELECT * FROM "*/1Min/OHLCV" WHERE Symbol > 'BBPL';`
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+}
+
+func (s *TestSuite) TestWherePredicates(c *C) {
+	// The dummy data has Close = 4 * Open and Volume counting up by one
+	window := "Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00'"
+	countOf := func(where string) int64 {
+		stmt := "SELECT count(*) FROM `AAPL/1Min/OHLCV` WHERE " + window + " AND " + where + ";"
+		ast, err := NewAstBuilder(stmt)
+		evalAndPrint(c, err, false, stmt)
+		es, err := NewExecutableStatement(ast.Mtree)
+		evalAndPrint(c, err, false, stmt)
+		cs, err := es.Materialize(context.Background())
+		evalAndPrint(c, err, false, stmt)
+		return cs.GetByName("Count").([]int64)[0]
+	}
+	c.Assert(countOf("Close > Open"), Equals, int64(29))
+	c.Assert(countOf("Close < Open"), Equals, int64(0))
+	c.Assert(countOf("Volume > 6520"), Equals, int64(20))
+	c.Assert(countOf("Volume > 6520 AND Close > Open"), Equals, int64(20))
+	c.Assert(countOf("(Volume < 6515 OR Volume >= 6538)"), Equals, int64(6))
+	c.Assert(countOf("NOT Volume < 6535"), Equals, int64(6))
+	c.Assert(countOf("Volume <> 6520"), Equals, int64(28))
+	c.Assert(countOf("Volume IN (6520, 6521, 1)"), Equals, int64(2))
+	c.Assert(countOf("Volume NOT IN (6520, 6521)"), Equals, int64(27))
+	c.Assert(countOf("Volume NOT BETWEEN 6515 AND 6530"), Equals, int64(15))
+	c.Assert(countOf("Open = 0.1"), Equals, int64(1))
+
+	// Rows are filtered before the LIMIT
+	stmt := "SELECT Volume FROM `AAPL/1Min/OHLCV` WHERE Volume > 6530 AND " + window + " LIMIT 3;"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetByName("Volume"), DeepEquals, []int32{6531, 6532, 6533})
+
+	stmt = "SELECT * FROM `AAPL/1Min/OHLCV` WHERE Nonexistent > 1;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	_, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, true, stmt)
+}
+
+func (s *TestSuite) TestExpressions(c *C) {
+	// The dummy data has High = 2 * Open, Low = 3 * Open and Close = 4 * Open
+	stmt := "SELECT Epoch, (High-Low)/Close AS range_pct, Close*Volume AS notional, Volume % 2, -Open FROM `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "range_pct", "notional", "Volume%2", "0-Open"})
+	c.Assert(cs.Len(), Equals, 29)
+	rangePct := cs.GetByName("range_pct").([]float32)
+	c.Assert(math.Abs(float64(rangePct[0])+0.25) < 1e-6, Equals, true)
+	notional := cs.GetByName("notional").([]float64)
+	close := float64(0.4 * float32(1+6511%15))
+	c.Assert(math.Abs(notional[0]-close*6512) < 1e-3, Equals, true)
+	c.Assert(cs.GetByName("Volume%2"), DeepEquals, []int64{
+		0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0})
+	c.Assert(cs.GetByName("0-Open").([]float32)[0] < 0, Equals, true)
+
+	// Integer literals take the type of the column, division is floating point
+	stmt = "SELECT Volume/2 AS half, Volume*2 AS twice, Open*2 AS open2 FROM `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' ORDER BY half DESC LIMIT 1;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetByName("half"), DeepEquals, []float64{3270})
+	c.Assert(cs.GetByName("twice"), DeepEquals, []int64{13080})
+	c.Assert(cs.GetByName("open2"), FitsTypeOf, []float32{})
+
+	// An expression named after a source column replaces it
+	stmt = "SELECT Epoch, Close*2 AS Close, Open FROM `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00';"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "Close", "Open"})
+	c.Assert(cs.GetByName("Close").([]float32)[0], Equals, 8*cs.GetByName("Open").([]float32)[0])
+
+	// Expressions of the GROUP BY columns have one value per group
+	stmt = "SELECT Open, Open*2 AS x, count(*) FROM `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Open ORDER BY Open;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "Open", "x", "Count"})
+	c.Assert(cs.Len(), Equals, 15)
+	open := cs.GetByName("Open").([]float32)
+	x := cs.GetByName("x").([]float32)
+	for i := range open {
+		c.Assert(x[i], Equals, 2*open[i])
+	}
+
+	for _, stmt := range []string{
+		"SELECT Close*Nonexistent FROM `AAPL/1Min/OHLCV`;",
+		"SELECT Close, Open*2 AS Close FROM `AAPL/1Min/OHLCV`;",
+		"SELECT Close*2 AS Close, Close AS plain FROM `AAPL/1Min/OHLCV`;",
+		"SELECT Open, Close*2 AS x, count(*) FROM `AAPL/1Min/OHLCV` GROUP BY Open;",
+		"SELECT Close*2 AS x, count(*) FROM `AAPL/1Min/OHLCV`;",
+	} {
+		ast, err = NewAstBuilder(stmt)
+		evalAndPrint(c, err, false, stmt)
+		es, err = NewExecutableStatement(ast.Mtree)
+		evalAndPrint(c, err, false, stmt)
+		_, err = es.Materialize(context.Background())
+		evalAndPrint(c, err, true, stmt)
+	}
+}
+
+func (s *TestSuite) TestPreparedStatement(c *C) {
+	stmt := `SELECT Epoch, Close, Symbol FROM "*/1Min/OHLCV" WHERE Symbol = ? AND Epoch BETWEEN ? AND ? AND Volume > ?;`
+	ps, err := NewPreparedStatement(stmt)
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(ps.NumParameters(), Equals, 4)
+
+	start := time.Date(2000, time.January, 5, 12, 30, 0, 0, time.UTC)
+	end := time.Date(2000, time.January, 5, 13, 0, 0, 0, time.UTC)
+	for _, symbol := range []string{"AAPL", "CCPL"} {
+		es, err := ps.Bind(symbol, start, end.Unix(), 6530)
+		evalAndPrint(c, err, false, stmt)
+		cs, err := es.Materialize(context.Background())
+		evalAndPrint(c, err, false, stmt)
+		c.Assert(cs.Len(), Equals, 10)
+		c.Assert(cs.GetByName("Symbol").([]string)[0], Equals, symbol)
+	}
+
+	// A bound string is a value, it is never parsed
+	es, err := ps.Bind("AAPL' OR Symbol = 'BBPL", start, end, 6530)
+	evalAndPrint(c, err, false, stmt)
+	_, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, true, stmt)
+
+	_, err = ps.Bind("AAPL", start)
+	evalAndPrint(c, err, true, stmt)
+	_, err = ps.Bind("AAPL", start, end, []int{1})
+	evalAndPrint(c, err, true, stmt)
+
+	// Parameters are only accepted in prepared statements
+	stmt = "SELECT * FROM `AAPL/1Min/OHLCV` WHERE Volume > ?;"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+
+	// Parameters in expressions
+	stmt = "SELECT Volume * ? AS scaled FROM `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN ? AND ?;"
+	ps, err = NewPreparedStatement(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = ps.Bind(2, start, end)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetByName("scaled").([]int64)[0], Equals, int64(2*6512))
+}
+
+func (s *TestSuite) TestGroupByOrderBy(c *C) {
+	// The dummy data cycles through 15 prices
+	stmt := "SELECT Open, count(*) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Open ORDER BY Open DESC;"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 15)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "Open", "Count"})
+	open := cs.GetByName("Open").([]float32)
+	count := cs.GetByName("Count").([]int64)
+	var total int64
+	for i := range open {
+		if i > 0 {
+			c.Assert(open[i] < open[i-1], Equals, true)
+		}
+		total += count[i]
+	}
+	c.Assert(total, Equals, int64(29))
+
+	stmt = "SELECT Open, max(High) AS MaxHigh, min(Low) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Open ORDER BY MaxHigh DESC LIMIT 3;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 3)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "Open", "MaxHigh", "Min"})
+	maxHigh := cs.GetByName("MaxHigh").([]float32)
+	c.Assert(maxHigh[0], Equals, float32(0.2*15))
+	c.Assert(maxHigh[0] > maxHigh[1] && maxHigh[1] > maxHigh[2], Equals, true)
+
+	// ORDER BY without grouping, ties keep their time order
+	stmt = "SELECT Epoch, Close from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' ORDER BY Close, Epoch DESC LIMIT 4;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 4)
+	closes := cs.GetByName("Close").([]float32)
+	epochs := cs.GetEpoch()
+	var ties int
+	for i := 1; i < len(closes); i++ {
+		c.Assert(closes[i-1] <= closes[i], Equals, true)
+		if closes[i-1] == closes[i] {
+			ties++
+			c.Assert(epochs[i-1] > epochs[i], Equals, true)
+		}
+	}
+	c.Assert(ties > 0, Equals, true)
+
+	// Columns outside of the GROUP BY have to be aggregated
+	stmt = "SELECT High, count(*) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Open;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	_, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, true, stmt)
+
+	// Any column of the source can be sorted on, and aliases name their column
+	stmt = "SELECT Epoch, Close AS C from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' ORDER BY High DESC, C;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 29)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "C"})
+	closes = cs.GetByName("C").([]float32)
+	for i := 1; i < len(closes); i++ {
+		c.Assert(closes[i-1] >= closes[i], Equals, true)
+	}
+
+	// Grouped queries can only sort on their output
+	stmt = "SELECT Open, count(*) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY Open ORDER BY High;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	_, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, true, stmt)
+}
+
+func (s *TestSuite) TestOrderByNulls(c *C) {
+	nan := float32(math.NaN())
+	newSeries := func() *io.ColumnSeries {
+		cs := io.NewColumnSeries()
+		cs.AddColumn("Epoch", []int64{1, 2, 3, 4})
+		cs.AddColumn("Price", []float32{2, nan, 1, nan})
+		return cs
+	}
+
+	// Nulls are the largest values unless placed otherwise
+	cs := newSeries()
+	c.Assert(orderBy(cs, []SortItem{{Column: "Price"}}), IsNil)
+	c.Assert(cs.GetEpoch(), DeepEquals, []int64{3, 1, 2, 4})
+	cs = newSeries()
+	c.Assert(orderBy(cs, []SortItem{{Column: "Price", Order: DESCENDING}}), IsNil)
+	c.Assert(cs.GetEpoch(), DeepEquals, []int64{2, 4, 1, 3})
+
+	cs = newSeries()
+	c.Assert(orderBy(cs, []SortItem{{Column: "Price", NullOrder: FIRST}}), IsNil)
+	c.Assert(cs.GetEpoch(), DeepEquals, []int64{2, 4, 3, 1})
+	cs = newSeries()
+	c.Assert(orderBy(cs, []SortItem{{Column: "Price", Order: DESCENDING, NullOrder: LAST}}), IsNil)
+	c.Assert(cs.GetEpoch(), DeepEquals, []int64{1, 3, 2, 4})
+
+	stmt := "SELECT Epoch, Close from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' ORDER BY Close DESC NULLS LAST;"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	closes := cs.GetByName("Close").([]float32)
+	for i := 1; i < len(closes); i++ {
+		c.Assert(closes[i-1] >= closes[i], Equals, true)
+	}
+}
+
+func (s *TestSuite) TestTimeBucket(c *C) {
+	stmt := "SELECT time_bucket('5Min', Epoch) AS Bucket, max(High), count(*) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY time_bucket('5Min', Epoch);"
+	ast, err := NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err := NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err := es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "Bucket", "Max", "Count"})
+	c.Assert(cs.Len(), Equals, 6)
+	start := time.Date(2000, 1, 5, 12, 30, 0, 0, time.UTC)
+	for i, epoch := range cs.GetEpoch() {
+		c.Assert(epoch, Equals, start.Add(time.Duration(i)*5*time.Minute).Unix())
+	}
+	c.Assert(cs.GetByName("Bucket"), DeepEquals, cs.GetEpoch())
+	c.Assert(cs.GetByName("Count"), DeepEquals, []int64{4, 5, 5, 5, 5, 5})
+
+	// Combined with a column, over a calendar window
+	stmt = "SELECT Open, count(*) from `AAPL/1Min/OHLCV` WHERE Epoch BETWEEN '2000-01-05-12:30' AND '2000-01-05-13:00' GROUP BY time_bucket('1W', Epoch), Open ORDER BY Open;"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	es, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, false, stmt)
+	cs, err = es.Materialize(context.Background())
+	evalAndPrint(c, err, false, stmt)
+	c.Assert(cs.Len(), Equals, 15)
+	for _, epoch := range cs.GetEpoch() {
+		c.Assert(epoch, Equals, time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC).Unix()) // Monday
+	}
+
+	stmt = "SELECT count(*) from `AAPL/1Min/OHLCV` GROUP BY time_bucket('5Min', Open);"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+
+	stmt = "SELECT count(*) from `AAPL/1Min/OHLCV` GROUP BY time_bucket('fooble', Epoch);"
+	ast, err = NewAstBuilder(stmt)
+	evalAndPrint(c, err, false, stmt)
+	_, err = NewExecutableStatement(ast.Mtree)
+	evalAndPrint(c, err, true, stmt)
+}
+
 func (s *TestSuite) TestAggregation(c *C) {
 	cs := makeTestCS()
 	epoch := cs.GetColumn("Epoch").([]int64)
@@ -261,7 +760,7 @@ func (s *TestSuite) TestAggregation(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err := NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 29)
 
@@ -271,7 +770,7 @@ func (s *TestSuite) TestAggregation(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	c.Assert(cs.Len(), Equals, 6)
 	//fmt.Println(cs)
@@ -296,7 +795,7 @@ func (s *TestSuite) TestCount(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err := NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	count = cs.GetColumn("Count").([]int64)
 	c.Assert(count[0], Equals, int64(29))
@@ -310,7 +809,7 @@ func (s *TestSuite) TestCount(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	count = cs.GetColumn("Count").([]int64)
 	c.Assert(count[0], Equals, int64(1578240))
@@ -321,7 +820,7 @@ func (s *TestSuite) TestCount(c *C) {
 	T_PrintExplain(ast.Mtree, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, false, stmt)
 	count = cs.GetColumn("Count").([]int64)
 	c.Assert(count[0], Equals, int64(1))
@@ -334,7 +833,7 @@ func (s *TestSuite) TestCount(c *C) {
 	evalAndPrint(c, err, false, stmt)
 	es, err = NewExecutableStatement(ast.Mtree)
 	evalAndPrint(c, err, false, stmt)
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	evalAndPrint(c, err, true, stmt)
 }
 
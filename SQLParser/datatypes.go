@@ -1,12 +1,16 @@
 package SQLParser
 
-import "github.com/dannyluong408/marketstore/utils/io"
+import (
+	"context"
+
+	"github.com/dannyluong408/marketstore/utils/io"
+)
 
 //go:generate ./buildVisitorCode.sh visitorcodegenerated.go
 //go:generate stringer -type=StatementTypeEnum,PrimaryExpressionEnum
 
 type Relation interface {
-	Materialize() (cs *io.ColumnSeries, err error)
+	Materialize(ctx context.Context) (cs *io.ColumnSeries, err error)
 }
 
 type QueryTree struct {
@@ -43,6 +47,7 @@ const (
 )
 
 type SortItem struct {
+	Column    string
 	Order     SortOrderEnum
 	NullOrder NullOrderEnum
 }
@@ -2,18 +2,22 @@ package SQLParser
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"github.com/dannyluong408/marketstore/utils"
 	"github.com/dannyluong408/marketstore/utils/io"
 	"reflect"
+	"strings"
 	"time"
 )
 
 type ExecutableStatement struct {
 	QueryTree
 	BaseSQLQueryTreeVisitor
-	nodeCursor *ExecutableStatement
-	pendingSP  *StaticPredicate
-	IsExplain  bool
+	nodeCursor    *ExecutableStatement
+	pendingColumn *ColumnReference
+	parameters    map[int]*Literal // Bound values by parameter token index
+	IsExplain     bool
 }
 
 func NewExecutableStatement(qtree ...IMSTree) (es *ExecutableStatement, err error) {
@@ -36,33 +40,33 @@ func (es *ExecutableStatement) GetPendingStaticPredicateGroup() (spg StaticPredi
 	}
 }
 
-func (es *ExecutableStatement) Materialize() (cs *io.ColumnSeries, err error) {
+func (es *ExecutableStatement) Materialize(ctx context.Context) (cs *io.ColumnSeries, err error) {
 	var child_cs *io.ColumnSeries
 	if es.GetChildCount() != 0 {
 		node := es.GetChild(0)
-		switch ctx := node.(type) {
+		switch rel := node.(type) {
 		case *ExecutableStatement:
 			//fmt.Println("Materialize Executable Statement")
-			child_cs, err = ctx.Materialize()
+			child_cs, err = rel.Materialize(ctx)
 		case *SelectRelation:
 			//fmt.Println("Materialize Select Relation")
-			child_cs, err = ctx.Materialize()
+			child_cs, err = rel.Materialize(ctx)
 		case *ExplainStatement:
 			//fmt.Println("Materialize Explain Statement")
-			child_cs, err = ctx.Materialize()
+			child_cs, err = rel.Materialize(ctx)
 		case *InsertIntoStatement:
 			//fmt.Println("Materialize InsertInto Statement")
-			child_cs, err = ctx.Materialize()
+			child_cs, err = rel.Materialize(ctx)
 		}
 		if err != nil {
 			return nil, err
 		}
 		return child_cs, nil
 	} else {
-		switch ctx := es.nodeCursor.payload.(type) {
+		switch rel := es.nodeCursor.payload.(type) {
 		case *SelectRelation:
 			//			fmt.Println("Materialize Select Relation Statement (no children)")
-			cs, err = ctx.Materialize()
+			cs, err = rel.Materialize(ctx)
 			return cs, err
 		default:
 			//			fmt.Println("Materialize Default (nil)")
@@ -168,13 +172,16 @@ func (es *ExecutableStatement) VisitQueryParse(ctx *QueryParse) interface{} {
 	return ctx.queryNoWith
 }
 func (es *ExecutableStatement) VisitQueryNoWithParse(ctx *QueryNoWithParse) interface{} {
-	if ctx.sortItems != nil {
-		// TODO: Support ORDER BY
-		return fmt.Errorf("Unsupported statement type: %s", "Query with ORDER BY")
-	}
-
 	sr := NewSelectRelation()
 	sr.Limit = ctx.limit
+	for _, item := range ctx.sortItems {
+		switch value := es.nodeCursor.Visit(item).(type) {
+		case SortItem:
+			sr.OrderBy = append(sr.OrderBy, value)
+		case error:
+			return value
+		}
+	}
 
 	es.nodeCursor.payload = sr // For retrieval of the dynamic type later
 	return ctx.queryTerm
@@ -253,6 +260,15 @@ func (es *ExecutableStatement) VisitQuerySpecificationParse(ctx *QuerySpecificat
 				ai.IsAliased = true
 			}
 			sr.SelectList = append(sr.SelectList, ai)
+		case *ArithmeticExpression:
+			ai := NewAliasedIdentifier()
+			ai.AddRuntimeExpression(cr)
+			if len(aliasName) != 0 {
+				ai.AddAlias(aliasName)
+			}
+			sr.SelectList = append(sr.SelectList, ai)
+		case error:
+			return cr
 		}
 	}
 	if sr.IsSelectAll && len(ctx.selectItems) > 1 {
@@ -272,14 +288,30 @@ func (es *ExecutableStatement) VisitQuerySpecificationParse(ctx *QuerySpecificat
 			//fmt.Println("Gathered subquery")
 			sr.IsPrimary = false
 			sr.Subquery = value
+		case *JoinRelation:
+			if len(ctx.relations) > 1 {
+				return fmt.Errorf("Unsupported option: A JOIN along with other relations")
+			}
+			sr.Join = value
 		case error:
 			return value
 		}
 	}
 
 	/*
-		Retrieve conforming Epoch column predicates from the WHERE
-		expressions so they can be pushed down.
+		Gather the GROUP BY columns
+	*/
+	if ctx.groupBy != nil {
+		i_err := es.nodeCursor.Visit(ctx.groupBy)
+		if err, ok := i_err.(error); ok {
+			return err
+		}
+	}
+
+	/*
+		The WHERE expression is evaluated on the rows read, conforming
+		predicates ANDed at the top level are also kept as static predicates
+		so they can be pushed down.
 
 		Conforming predicates are of the form:
 		       Epoch [<,>,==,>=,<=] time_specification
@@ -288,9 +320,15 @@ func (es *ExecutableStatement) VisitQuerySpecificationParse(ctx *QuerySpecificat
 
 	*/
 	if ctx.where != nil {
-		i_err := es.nodeCursor.Visit(ctx.where) // BooleanExpression
-		if err, ok := i_err.(error); ok {
-			return err
+		i_where := es.nodeCursor.Visit(ctx.where) // BooleanExpression
+		switch where := i_where.(type) {
+		case *RuntimePredicate:
+			sr.WherePredicate = where
+			if err := sr.StaticPredicates.AddConjuncts(where); err != nil {
+				return err
+			}
+		case error:
+			return where
 		}
 	}
 	return nil
@@ -309,9 +347,64 @@ func (es *ExecutableStatement) VisitValueExpressionParse(ctx *ValueExpressionPar
 	switch cctx := child.(type) {
 	case *PrimaryExpressionParse: // Primary Expression
 		return es.nodeCursor.Visit(cctx)
+	case *ArithmeticUnaryParse, *ArithmeticBinaryParse:
+		return es.nodeCursor.Visit(cctx)
 	default:
 		// TODO: Support non primary expressions
-		return fmt.Errorf("Only Primary Expressions supported")
+		return fmt.Errorf("Only Primary and Arithmetic Expressions supported")
+	}
+}
+func (es *ExecutableStatement) VisitArithmeticUnaryParse(ctx *ArithmeticUnaryParse) interface{} {
+	value := es.nodeCursor.Visit(ctx.value)
+	if ctx.operator == PLUS {
+		return value
+	}
+	/*
+		A negated literal stays a literal, so it can be used in predicates
+	*/
+	if literal, ok := value.(*Literal); ok {
+		switch v := literal.Value.(type) {
+		case int64:
+			return NewLiteral(-v, literal.Type)
+		case float64:
+			return NewLiteral(-v, literal.Type)
+		}
+	}
+	operand, err := arithmeticOperand(value)
+	if err != nil {
+		return err
+	}
+	zero := NewLiteral(int64(0), INTEGER_LITERAL)
+	left, _ := NewLiteralExpression(zero)
+	return NewArithmeticExpression(MINUS, left, operand)
+}
+func (es *ExecutableStatement) VisitArithmeticBinaryParse(ctx *ArithmeticBinaryParse) interface{} {
+	left, err := arithmeticOperand(es.nodeCursor.Visit(ctx.left))
+	if err != nil {
+		return err
+	}
+	right, err := arithmeticOperand(es.nodeCursor.Visit(ctx.right))
+	if err != nil {
+		return err
+	}
+	return NewArithmeticExpression(ctx.operator, left, right)
+}
+
+/*
+arithmeticOperand converts a visited value expression to an expression node
+*/
+func arithmeticOperand(value interface{}) (*ArithmeticExpression, error) {
+	switch operand := value.(type) {
+	case *ArithmeticExpression:
+		return operand, nil
+	case *ColumnReference:
+		return NewColumnExpression(operand.Value.PrimaryName), nil
+	case *Literal:
+		return NewLiteralExpression(operand)
+	case error:
+		return nil, operand
+	default:
+		return nil, fmt.Errorf("Only columns and numeric literals are supported in expressions")
 	}
 }
 func (es *ExecutableStatement) VisitPrimaryExpressionParse(ctx *PrimaryExpressionParse) interface{} {
@@ -321,12 +414,21 @@ func (es *ExecutableStatement) VisitPrimaryExpressionParse(ctx *PrimaryExpressio
 	switch ctx.primaryType {
 	case NULL_LITERAL, STRING_LITERAL, BINARY_LITERAL, DECIMAL_LITERAL, INTEGER_LITERAL, BOOLEAN_LITERAL:
 		return NewLiteral(ctx.payload, ctx.primaryType)
-	case COLUMN_REFERENCE:
+	case PARAMETER:
+		literal, ok := es.nodeCursor.parameters[ctx.payload.(int)]
+		if !ok {
+			return fmt.Errorf("No value bound for parameter, statements with parameters have to be prepared")
+		}
+		// Literals can be coerced in place, each use gets its own copy
+		return NewLiteral(literal.Value, literal.Type)
+	case COLUMN_REFERENCE, DEREFERENCE:
 		retval := es.nodeCursor.Visit(ctx.GetChild(0))
 		switch value := retval.(type) {
 		case string:
 			cr := NewColumnReference(value)
 			return cr
+		case error:
+			return value
 		default:
 			return fmt.Errorf("Non string returned as column reference")
 		}
@@ -346,12 +448,167 @@ func (es *ExecutableStatement) VisitPrimaryExpressionParse(ctx *PrimaryExpressio
 			ctx.primaryType.String())
 	}
 }
+func (es *ExecutableStatement) VisitSortItemParse(ctx *SortItemParse) interface{} {
+	cr, ok := es.nodeCursor.Visit(ctx.expression).(*ColumnReference)
+	if !ok {
+		return fmt.Errorf("Only columns are supported in ORDER BY")
+	}
+	order := ctx.sortOrdering
+	if order == 0 {
+		order = ASCENDING
+	}
+	return SortItem{Column: cr.GetName(), Order: order, NullOrder: ctx.nullOrdering}
+}
+func (es *ExecutableStatement) VisitGroupByParse(ctx *GroupByParse) interface{} {
+	sr := es.nodeCursor.payload.(*SelectRelation)
+	for _, item := range ctx.groupingElements {
+		ge := item.(*GroupingElementParse)
+		if ge.groupingExp == nil {
+			return fmt.Errorf("Unsupported option: ROLLUP, CUBE and GROUPING SETS")
+		}
+		for _, expr := range ge.groupingExp.(*GroupingExpressionsParse).expressions {
+			switch value := es.nodeCursor.Visit(expr).(type) {
+			case *ColumnReference:
+				sr.GroupBy = append(sr.GroupBy, value.GetName())
+			case *FunctionCallReference:
+				if !value.IsTimeBucket() {
+					return fmt.Errorf("Only columns and time_bucket are supported in GROUP BY")
+				}
+				if sr.TimeBucket != nil {
+					return fmt.Errorf("Only one time_bucket is supported in GROUP BY")
+				}
+				cd, err := value.GetTimeBucket()
+				if err != nil {
+					return err
+				}
+				sr.TimeBucket = cd
+			case error:
+				return value
+			default:
+				return fmt.Errorf("Only columns and time_bucket are supported in GROUP BY")
+			}
+		}
+	}
+	if len(sr.GroupBy) == 0 && sr.TimeBucket == nil {
+		return fmt.Errorf("GROUP BY needs at least one column")
+	}
+	return nil
+}
 func (es *ExecutableStatement) VisitIDParse(ctx *IDParse) interface{} {
 	return ctx.name
 }
+func (es *ExecutableStatement) VisitDereferenceParse(ctx *DereferenceParse) interface{} {
+	/*
+		A column of a joined table, referenced as "alias.column"
+	*/
+	i_base := es.nodeCursor.Visit(ctx.base)
+	base, ok := i_base.(*ColumnReference)
+	if !ok {
+		return fmt.Errorf("Only table aliases can be dereferenced")
+	}
+	fieldName := es.nodeCursor.Visit(ctx.fieldName).(string)
+	if fieldName == "Epoch" {
+		// Epoch is the join key, shared by all tables in the join
+		return fieldName
+	}
+	return base.GetName() + "." + fieldName
+}
 func (es *ExecutableStatement) VisitRelationParse(ctx *RelationParse) interface{} {
+	if ctx.left != nil {
+		jr, err := es.joinWalk(ctx, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		return jr
+	}
 	return es.nodeCursor.Visit(ctx.sampled)
 }
+
+/*
+joinWalk builds a JoinRelation from a join RelationParse. Only joins on Epoch
+are supported, either "ON a.Epoch = b.Epoch" or "USING (Epoch)". Tables without
+an alias use their symbol as the column prefix, aliases tracks the prefixes in
+use so that they stay unique across nested joins.
+*/
+func (es *ExecutableStatement) joinWalk(ctx *RelationParse, aliases map[string]bool) (jr *JoinRelation, err error) {
+	if err = es.checkJoinCriteria(ctx.criteria); err != nil {
+		return nil, err
+	}
+	var sides [2]Relation
+	for i, node := range []IMSTree{ctx.left, ctx.right} {
+		sides[i], err = es.joinSideWalk(node.(*RelationParse), aliases)
+		if err != nil {
+			return nil, err
+		}
+	}
+	joinType := ctx.joinType
+	if joinType == 0 {
+		joinType = INNER // Plain "JOIN"
+	}
+	return NewJoinRelation(joinType, sides[0], sides[1])
+}
+
+func (es *ExecutableStatement) joinSideWalk(ctx *RelationParse, aliases map[string]bool) (Relation, error) {
+	if ctx.left != nil {
+		return es.joinWalk(ctx, aliases)
+	}
+	ar := ctx.sampled.(*SampledRelationParse).aliasedRelation.(*AliasedRelationParse)
+	if ar.hasAliases {
+		return nil, fmt.Errorf("Column Aliases not supported in a JOIN")
+	}
+	rp := ar.relationPrimary.(*RelationPrimaryParse)
+	if rp.IsRelation {
+		return es.joinSideWalk(rp.GetChild(0).(*RelationParse), aliases)
+	}
+	if !rp.IsTableName {
+		return nil, fmt.Errorf("Only tables can be joined")
+	}
+	name := es.nodeCursor.Visit(rp.GetChild(0)).(string)
+	alias := strings.Split(name, "/")[0]
+	if ar.hasID {
+		alias = es.nodeCursor.Visit(ar.identifier).(string)
+	}
+	if aliases[alias] {
+		return nil, fmt.Errorf("Table name %s is used more than once in the JOIN, use a table alias", alias)
+	}
+	aliases[alias] = true
+
+	spg, err := es.GetPendingStaticPredicateGroup()
+	if err != nil {
+		return nil, err
+	}
+	return NewTableRelation(name, alias, spg), nil
+}
+
+func (es *ExecutableStatement) checkJoinCriteria(i_criteria IMSTree) error {
+	criteria, ok := i_criteria.(*JoinCriteriaParse)
+	if !ok {
+		return fmt.Errorf("A JOIN needs an ON or USING clause")
+	}
+	if criteria.onExpression == nil {
+		if len(criteria.identifiers) == 1 &&
+			es.nodeCursor.Visit(criteria.identifiers[0]) == "Epoch" {
+			return nil
+		}
+		return fmt.Errorf("Only joins on Epoch are supported")
+	}
+
+	be := criteria.onExpression.(*BooleanExpressionParse)
+	var cmp *ComparisonParse
+	if be.predicate != nil {
+		cmp, _ = be.predicate.GetChild(0).(*ComparisonParse)
+	}
+	if cmp == nil || cmp.comparisonOperator != io.EQ {
+		return fmt.Errorf("Only joins on Epoch are supported")
+	}
+	for _, node := range []IMSTree{be.left, cmp.right} {
+		cr, ok := es.nodeCursor.Visit(node).(*ColumnReference)
+		if !ok || cr.GetName() != "Epoch" {
+			return fmt.Errorf("Only joins on Epoch are supported")
+		}
+	}
+	return nil
+}
 func (es *ExecutableStatement) VisitSampledRelationParse(ctx *SampledRelationParse) interface{} {
 	return es.nodeCursor.Visit(ctx.aliasedRelation)
 }
@@ -415,133 +672,157 @@ func (es *ExecutableStatement) VisitQualifiedNameParse(ctx *QualifiedNameParse)
 
 func (es *ExecutableStatement) VisitBooleanExpressionParse(ctx *BooleanExpressionParse) interface{} {
 	/*
-		Search for static predicates and return them
-		Both left and right types are ValueExpression
+		Build the predicate tree of the expression, the tree is evaluated at
+		runtime and the simple terms are also used as static predicates
 	*/
-	spg, err := es.GetPendingStaticPredicateGroup()
-	if err != nil {
-		return err
-	}
-
-	var done bool
-	doneRight := (ctx.right == nil) // No right side
-
-	node := ctx.left
-	for {
-		if done {
-			if !doneRight {
-				node = ctx.right
-				done = false
-				doneRight = true
-			} else {
-				break
-			}
-		}
-		i_value := es.nodeCursor.Visit(node) // Descend left
-		if i_value == nil {
-			done = true
-		} else {
+	var rp *RuntimePredicate
+	switch {
+	case ctx.IsLiteral:
+		rp = NewLiteralPredicate(ctx.value)
+	case ctx.right != nil:
+		var sides [2]*RuntimePredicate
+		for i, node := range []IMSTree{ctx.left, ctx.right} {
+			i_value := es.nodeCursor.Visit(node)
 			switch value := i_value.(type) {
-			case *ColumnReference:
-				// Create new predicate for this column
-				es.nodeCursor.pendingSP = NewStaticPredicate(value)
-				// Descend to merge static predicates into this column
-				i_value = es.nodeCursor.Visit(ctx.predicate)
-				if err, ok := i_value.(error); ok {
-					return err
-				}
-				err := spg.Merge(es.nodeCursor.pendingSP, false) // AND predicate
-				if err != nil {
-					return err
-				}
-				es.nodeCursor.pendingSP = nil
-				done = true
-			case *ExpressionParse:
-				node = value // Continue to descend left
-			case *ValueExpressionParse:
-				node = value // Continue to descend left
-			case *BooleanExpressionParse:
-				node = value // Continue to descend left
+			case *RuntimePredicate:
+				sides[i] = value
 			case error:
 				return value
-			case nil:
-				done = true
 			default:
-				return fmt.Errorf("Unknown type: %s", reflect.ValueOf(value).Type())
+				return fmt.Errorf("Operands of AND and OR have to be boolean expressions")
 			}
-
+		}
+		rp = NewLogicalPredicate(ctx.operator, sides[0], sides[1])
+	default:
+		i_value := es.nodeCursor.Visit(ctx.left)
+		switch value := i_value.(type) {
+		case *RuntimePredicate: // Parenthesized expression
+			if ctx.predicate != nil && ctx.predicate.GetChildCount() != 0 {
+				return fmt.Errorf("Unsupported predicate on a boolean expression")
+			}
+			rp = value
+		case *ColumnReference:
+			if ctx.predicate == nil || ctx.predicate.GetChildCount() == 0 {
+				return fmt.Errorf("Column %s is not a boolean expression", value.GetName())
+			}
+			es.nodeCursor.pendingColumn = value
+			i_value = es.nodeCursor.Visit(ctx.predicate)
+			es.nodeCursor.pendingColumn = nil
+			switch pred := i_value.(type) {
+			case *RuntimePredicate:
+				rp = pred
+			case error:
+				return pred
+			default:
+				return fmt.Errorf("Unsupported predicate on column %s", value.GetName())
+			}
+		case error:
+			return value
+		default:
+			return fmt.Errorf("Unknown type: %s", reflect.ValueOf(value).Type())
 		}
 	}
-	return nil
+	if ctx.IsNot {
+		rp.IsNot = !rp.IsNot
+	}
+	return rp
 }
 func (es *ExecutableStatement) VisitPredicateParse(ctx *PredicateParse) interface{} {
 	node := ctx.GetChild(0)
 	switch node.(type) {
-	case *ComparisonParse, *BetweenParse:
+	case *ComparisonParse, *BetweenParse, *InListParse:
 		return es.nodeCursor.Visit(node)
 	case *QuantifiedComparisonParse:
 		return fmt.Errorf("Quantified Comparisons (ALL/ANY/SOME) not supported")
-	case *InListParse, *InSubqueryParse, *LikeParse, *NullPredicateParse, *DistinctFromParse:
-		// TODO: Implement dynamic predicates (and inlist)
-		return fmt.Errorf("Unsupported predicate type, only static types are supported")
+	case *InSubqueryParse, *LikeParse, *NullPredicateParse, *DistinctFromParse:
+		return fmt.Errorf("Unsupported predicate type, only comparisons, BETWEEN and IN are supported")
 	}
 	return nil
 }
 
-func (es *ExecutableStatement) VisitBetweenParse(ctx *BetweenParse) interface{} {
-	i_literal := es.nodeCursor.Visit(ctx.lower)
-	if literal, ok := i_literal.(*Literal); !ok {
-		return fmt.Errorf("Dynamic predicate bounds not supported")
-	} else {
+/*
+predicateOperand resolves the right hand side of a predicate on column to
+either a column reference or a value. Strings are times, except when compared
+to a key column.
+*/
+func (es *ExecutableStatement) predicateOperand(column string, node IMSTree) (cr *ColumnReference, value interface{}, err error) {
+	switch operand := es.nodeCursor.Visit(node).(type) {
+	case *ColumnReference:
+		return operand, nil, nil
+	case *Literal:
+		if isKeyColumn(column) {
+			value, err = keyLiteral(operand)
+			return nil, value, err
+		}
 		/*
 			Make sure that the literal represents a numeric quantity
 		*/
-		err := CoerceToNumeric(literal)
-		if err != nil {
-			return err
-		}
-		if ctx.IsNot {
-			es.nodeCursor.pendingSP.AddComparison(io.LTE, literal.Value)
-		} else {
-			es.nodeCursor.pendingSP.AddComparison(io.GT, literal.Value)
+		if err = CoerceToNumeric(operand); err != nil {
+			return nil, nil, err
 		}
+		return nil, operand.Value, nil
+	case error:
+		return nil, nil, operand
 	}
+	return nil, nil, fmt.Errorf("Only columns and literals are supported in predicates")
+}
 
-	i_literal = es.nodeCursor.Visit(ctx.upper)
-	if literal, ok := i_literal.(*Literal); !ok {
-		return fmt.Errorf("Dynamic predicate bounds not supported")
-	} else {
-		/*
-			Make sure that the literal represents a numeric quantity
-		*/
-		err := CoerceToNumeric(literal)
-		if err != nil {
-			return err
-		}
-		if ctx.IsNot {
-			es.nodeCursor.pendingSP.AddComparison(io.GTE, literal.Value)
-		} else {
-			es.nodeCursor.pendingSP.AddComparison(io.LT, literal.Value)
-		}
+func (es *ExecutableStatement) comparison(column string, op io.ComparisonOperatorEnum,
+	node IMSTree) (*RuntimePredicate, error) {
+	cr, value, err := es.predicateOperand(column, node)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if isKeyColumn(column) && op != io.EQ && op != io.NEQ {
+		return nil, fmt.Errorf("Only =, <> and IN comparisons are supported on %s", column)
+	}
+	if cr != nil {
+		return NewColumnComparisonPredicate(column, op, cr.GetName()), nil
+	}
+	return NewComparisonPredicate(column, op, value), nil
+}
+
+func (es *ExecutableStatement) VisitBetweenParse(ctx *BetweenParse) interface{} {
+	column := es.nodeCursor.pendingColumn.GetName()
+	lowerOp, upperOp, logicalOp := io.GT, io.LT, AND_OP
+	if ctx.IsNot {
+		lowerOp, upperOp, logicalOp = io.LTE, io.GTE, OR_OP
+	}
+	lower, err := es.comparison(column, lowerOp, ctx.lower)
+	if err != nil {
+		return err
+	}
+	upper, err := es.comparison(column, upperOp, ctx.upper)
+	if err != nil {
+		return err
+	}
+	return NewLogicalPredicate(logicalOp, lower, upper)
 }
 
 func (es *ExecutableStatement) VisitComparisonParse(ctx *ComparisonParse) interface{} {
-	i_literal := es.nodeCursor.Visit(ctx.right)
-	if literal, ok := i_literal.(*Literal); !ok {
-		return fmt.Errorf("Dynamic predicate bounds not supported")
-	} else {
-		/*
-			Make sure that the literal represents a numeric quantity
-		*/
-		err := CoerceToNumeric(literal)
+	rp, err := es.comparison(es.nodeCursor.pendingColumn.GetName(), ctx.comparisonOperator, ctx.right)
+	if err != nil {
+		return err
+	}
+	return rp
+}
+
+func (es *ExecutableStatement) VisitInListParse(ctx *InListParse) interface{} {
+	column := es.nodeCursor.pendingColumn.GetName()
+	var inlist []interface{}
+	for _, node := range ctx.inlist {
+		cr, value, err := es.predicateOperand(column, node)
 		if err != nil {
 			return err
 		}
-		es.nodeCursor.pendingSP.AddComparison(ctx.comparisonOperator, literal.Value)
+		if cr != nil {
+			return fmt.Errorf("Only literals are supported in an IN list")
+		}
+		inlist = append(inlist, value)
 	}
-	return nil
+	rp := NewInListPredicate(column, inlist)
+	rp.IsNot = ctx.IsNot
+	return rp
 }
 
 func (es *ExecutableStatement) VisitFunctionCallParse(ctx *FunctionCallParse) interface{} {
@@ -599,6 +880,28 @@ func (fc *FunctionCallReference) GetIDs() (idList []string) {
 	return idList
 }
 
+/*
+IsTimeBucket is true for time_bucket('5Min', Epoch), which buckets Epoch into
+the calendar aware windows of a utils.CandleDuration
+*/
+func (fc *FunctionCallReference) IsTimeBucket() bool {
+	return strings.EqualFold(fc.Name, "time_bucket")
+}
+
+func (fc *FunctionCallReference) GetTimeBucket() (cd *utils.CandleDuration, err error) {
+	literals, ids := fc.GetLiterals(), fc.GetIDs()
+	if len(literals) != 1 || len(ids) != 1 || ids[0] != "Epoch" ||
+		literals[0].Type != STRING_LITERAL {
+		return nil, fmt.Errorf("time_bucket takes a window and Epoch, e.g. time_bucket('5Min', Epoch)")
+	}
+	value := literals[0].Value.(string)
+	value = value[1 : len(value)-1] // Strip the quotes
+	if cd = utils.CandleDurationFromString(value); cd == nil {
+		return nil, fmt.Errorf("Unable to parse time_bucket window: %s", value)
+	}
+	return cd, nil
+}
+
 func (fc *FunctionCallReference) GetLiterals() (literals []*Literal) {
 	for _, i_arg := range fc.Args {
 		switch arg := i_arg.(type) {
@@ -1,6 +1,7 @@
 package SQLParser
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/dannyluong408/marketstore/utils/io"
 )
@@ -17,7 +18,7 @@ func NewExplainStatement(ctx *StatementParse, queryText string) (es *ExplainStat
 	return es
 }
 
-func (es *ExplainStatement) Materialize() (cs *io.ColumnSeries, err error) {
+func (es *ExplainStatement) Materialize(ctx context.Context) (cs *io.ColumnSeries, err error) {
 	result := Explain(es.GetChild(0))
 	cs = io.NewColumnSeries()
 	cs.AddColumn("explain-output", result)
@@ -0,0 +1,339 @@
+package SQLParser
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+ArithmeticExpression is a value expression in the select list evaluated over
+the columns of a result. A node is either an operation on the Left and Right
+nodes, a column or a numeric literal.
+*/
+type ArithmeticExpression struct {
+	Operator    ArithmeticOperatorEnum
+	Left, Right *ArithmeticExpression
+	Column      string
+	Value       interface{} // int64 or float64 literal
+}
+
+func NewArithmeticExpression(op ArithmeticOperatorEnum, left, right *ArithmeticExpression) (ae *ArithmeticExpression) {
+	ae = new(ArithmeticExpression)
+	ae.Operator = op
+	ae.Left = left
+	ae.Right = right
+	return ae
+}
+
+func NewColumnExpression(column string) (ae *ArithmeticExpression) {
+	ae = new(ArithmeticExpression)
+	ae.Column = column
+	return ae
+}
+
+func NewLiteralExpression(literal *Literal) (ae *ArithmeticExpression, err error) {
+	switch literal.Type {
+	case INTEGER_LITERAL, DECIMAL_LITERAL:
+	default:
+		return nil, fmt.Errorf("Only numeric literals are supported in expressions")
+	}
+	ae = new(ArithmeticExpression)
+	ae.Value = literal.Value
+	return ae, nil
+}
+
+/*
+Columns returns the names of all columns used in the expression
+*/
+func (ae *ArithmeticExpression) Columns() (names []string) {
+	if ae.Left != nil {
+		return append(ae.Left.Columns(), ae.Right.Columns()...)
+	}
+	if len(ae.Column) != 0 {
+		names = append(names, ae.Column)
+	}
+	return names
+}
+
+/*
+String returns the expression as text, used to name an unaliased result column
+*/
+func (ae *ArithmeticExpression) String() string {
+	if ae.Left == nil {
+		if len(ae.Column) != 0 {
+			return ae.Column
+		}
+		return fmt.Sprint(ae.Value)
+	}
+	var op string
+	switch ae.Operator {
+	case PLUS:
+		op = "+"
+	case MINUS:
+		op = "-"
+	case MULTIPLY:
+		op = "*"
+	case DIVIDE:
+		op = "/"
+	case PERCENT:
+		op = "%"
+	}
+	operand := func(node *ArithmeticExpression) string {
+		if node.Left != nil {
+			return "(" + node.String() + ")"
+		}
+		return node.String()
+	}
+	return operand(ae.Left) + op + operand(ae.Right)
+}
+
+/*
+Eval returns the column resulting from the expression over the rows of cs. The
+operands are promoted to a common type before each operation, see
+io.PromoteElementTypes, with a literal taking the type of the column it is
+combined with where it fits. Division always has a floating point result.
+*/
+func (ae *ArithmeticExpression) Eval(cs *io.ColumnSeries) (result interface{}, err error) {
+	result, isScalar, err := ae.eval(cs)
+	if err != nil {
+		return nil, err
+	}
+	if isScalar {
+		return broadcast(result, cs.Len()), nil
+	}
+	return result, nil
+}
+
+func (ae *ArithmeticExpression) eval(cs *io.ColumnSeries) (value interface{}, isScalar bool, err error) {
+	if ae.Left == nil {
+		if len(ae.Column) == 0 {
+			return ae.Value, true, nil
+		}
+		col := cs.GetByName(ae.Column)
+		if col == nil {
+			return nil, false, fmt.Errorf("Expression column %s not found", ae.Column)
+		}
+		return col, false, nil
+	}
+	left, leftScalar, err := ae.Left.eval(cs)
+	if err != nil {
+		return nil, false, err
+	}
+	right, rightScalar, err := ae.Right.eval(cs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	leftType, rightType := io.GetElementType(left), io.GetElementType(right)
+	switch {
+	case leftScalar && !rightScalar:
+		leftType = literalType(leftType, rightType)
+	case rightScalar && !leftScalar:
+		rightType = literalType(rightType, leftType)
+	}
+	resultType := io.PromoteElementTypes(leftType, rightType)
+	if resultType == io.NONE {
+		return nil, false, fmt.Errorf("Can not evaluate %s on non numeric values", ae.String())
+	}
+	if ae.Operator == DIVIDE && resultType == io.INT64 {
+		resultType = io.FLOAT64
+	}
+
+	length := cs.Len()
+	if leftScalar && rightScalar {
+		length = 1
+	}
+	if leftScalar {
+		left = broadcast(left, length)
+	}
+	if rightScalar {
+		right = broadcast(right, length)
+	}
+	value, err = arithmetic(ae.Operator, resultType, left, right)
+	if err != nil {
+		return nil, false, err
+	}
+	if leftScalar && rightScalar {
+		return reflect.ValueOf(value).Index(0).Interface(), true, nil
+	}
+	return value, false, nil
+}
+
+/*
+literalType returns the type of a literal combined with a column, an integer
+literal takes the column type and a decimal one keeps a FLOAT32 column as is
+*/
+func literalType(literal, column io.EnumElementType) io.EnumElementType {
+	if io.PromoteElementTypes(column, column) == io.NONE {
+		return literal
+	}
+	switch {
+	case literal == io.INT64:
+		return column
+	case column == io.FLOAT32:
+		return io.FLOAT32
+	}
+	return literal
+}
+
+func broadcast(value interface{}, length int) interface{} {
+	col := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(value)), length, length)
+	v := reflect.ValueOf(value)
+	for i := 0; i < length; i++ {
+		col.Index(i).Set(v)
+	}
+	return col.Interface()
+}
+
+func arithmetic(op ArithmeticOperatorEnum, resultType io.EnumElementType,
+	left, right interface{}) (result interface{}, err error) {
+	switch resultType {
+	case io.INT64:
+		l, r := io.ColumnAsInt64(left), io.ColumnAsInt64(right)
+		out := make([]int64, len(l))
+		switch op {
+		case PLUS:
+			for i := range out {
+				out[i] = l[i] + r[i]
+			}
+		case MINUS:
+			for i := range out {
+				out[i] = l[i] - r[i]
+			}
+		case MULTIPLY:
+			for i := range out {
+				out[i] = l[i] * r[i]
+			}
+		case PERCENT:
+			for i := range out {
+				if r[i] == 0 {
+					return nil, fmt.Errorf("Division by zero")
+				}
+				out[i] = l[i] % r[i]
+			}
+		}
+		return out, nil
+	case io.FLOAT32:
+		l, err := columnAsFloat32(left)
+		if err != nil {
+			return nil, err
+		}
+		r, err := columnAsFloat32(right)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]float32, len(l))
+		switch op {
+		case PLUS:
+			for i := range out {
+				out[i] = l[i] + r[i]
+			}
+		case MINUS:
+			for i := range out {
+				out[i] = l[i] - r[i]
+			}
+		case MULTIPLY:
+			for i := range out {
+				out[i] = l[i] * r[i]
+			}
+		case DIVIDE:
+			for i := range out {
+				out[i] = l[i] / r[i]
+			}
+		case PERCENT:
+			for i := range out {
+				out[i] = float32(math.Mod(float64(l[i]), float64(r[i])))
+			}
+		}
+		return out, nil
+	default:
+		l, err := io.ColumnAsFloat64(left)
+		if err != nil {
+			return nil, err
+		}
+		r, err := io.ColumnAsFloat64(right)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]float64, len(l))
+		switch op {
+		case PLUS:
+			for i := range out {
+				out[i] = l[i] + r[i]
+			}
+		case MINUS:
+			for i := range out {
+				out[i] = l[i] - r[i]
+			}
+		case MULTIPLY:
+			for i := range out {
+				out[i] = l[i] * r[i]
+			}
+		case DIVIDE:
+			for i := range out {
+				out[i] = l[i] / r[i]
+			}
+		case PERCENT:
+			for i := range out {
+				out[i] = math.Mod(l[i], r[i])
+			}
+		}
+		return out, nil
+	}
+}
+
+func columnAsFloat32(col interface{}) (out []float32, err error) {
+	if f32, ok := col.([]float32); ok {
+		return f32, nil
+	}
+	f64, err := io.ColumnAsFloat64(col)
+	if err != nil {
+		return nil, err
+	}
+	out = make([]float32, len(f64))
+	for i, val := range f64 {
+		out[i] = float32(val)
+	}
+	return out, nil
+}
+
+/*
+evalExpressions adds a column for each expression in the select list, named
+by its alias or the expression text. An expression named after a column of
+the source replaces it, once all of the expressions are evaluated on the
+source values.
+*/
+func (sr *SelectRelation) evalExpressions(cs *io.ColumnSeries) error {
+	var names []string
+	var cols []interface{}
+	for _, item := range sr.SelectList {
+		if item.RuntimeExpression == nil {
+			continue
+		}
+		for _, other := range sr.SelectList {
+			if other != item && (other.OutputName() == item.OutputName() ||
+				other.IsPrimary && other.PrimaryName == item.OutputName()) {
+				return fmt.Errorf("Column %s is selected more than once", item.OutputName())
+			}
+		}
+		col, err := item.RuntimeExpression.Eval(cs)
+		if err != nil {
+			return err
+		}
+		names = append(names, item.OutputName())
+		cols = append(cols, col)
+	}
+	for i, name := range names {
+		if cs.Exists(name) {
+			if err := cs.Replace(name, cols[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		cs.AddColumn(name, cols[i])
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package SQLParser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dannyluong408/marketstore/uda"
+	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+newAggregate looks up the aggregate named in a select list function call
+in the AggRegistry and initializes it with the call arguments
+*/
+func newAggregate(fc *FunctionCallReference) (aggfunc uda.AggInterface, err error) {
+	aggName := fc.Name
+	agg := AggRegistry[strings.ToLower(aggName)]
+	if agg == nil {
+		return nil, fmt.Errorf("No function in the UDA Registry named \"%s\"", aggName)
+	}
+	aggfunc, argMap := agg.New()
+
+	if fc.IsAsterisk {
+		/*
+			If an asterisk is provided, use Epoch as the mapped input column
+		*/
+		argMap.MapRequiredColumn("*", io.DataShape{
+			Name: "Epoch", Type: io.INT64,
+		})
+	} else {
+		idList := fc.GetIDs()
+		err = argMap.PrepareArguments(idList)
+		if err != nil {
+			return nil, fmt.Errorf("Argument mapping error for %s: %s", aggName, err.Error())
+		}
+	}
+
+	/*
+		Initialize the Aggregate
+			An agg may have init parameters, which are used only to initialize it
+			These are single value literals (like '1Min')
+	*/
+	requiredInitDSV := aggfunc.GetInitArgs()
+	requiredInitNames := io.GetNamesFromDSV(requiredInitDSV)
+
+	initList := fc.GetLiterals()
+	if len(requiredInitNames) > len(initList) {
+		return nil, fmt.Errorf(
+			"Not enough init arguments for %s, need %d have %d",
+			aggName,
+			len(requiredInitNames),
+			len(initList),
+		)
+	}
+	// TODO: Handle different argument types from string
+	var initArgList []string
+	for _, lit := range initList {
+		value := lit.Value.(string)
+		value = value[1 : len(value)-1] // Strip the quotes
+		initArgList = append(
+			initArgList,
+			value,
+		)
+	}
+	aggfunc.Init(initArgList)
+	return aggfunc, nil
+}
+
+/*
+groupBy splits the input rows into groups with equal values in the GROUP BY
+columns and runs the aggregates of the select list on each group. Every
+aggregate has to produce a single row per group. The output Epoch of a group
+is the Epoch of its first row, or the start of its window for a time_bucket.
+*/
+func (sr *SelectRelation) groupBy(input *io.ColumnSeries) (output *io.ColumnSeries, err error) {
+	if sr.IsSelectAll {
+		return nil, fmt.Errorf("Unsupported option: SELECT * along with GROUP BY")
+	}
+	isGroupColumn := make(map[string]bool, len(sr.GroupBy))
+	var keyParts []func(key *bytes.Buffer, i int)
+	epochs := input.GetEpoch()
+	if sr.TimeBucket != nil {
+		epochs = sr.timeBuckets(epochs)
+		keyParts = append(keyParts, groupKeyPart(epochs))
+	}
+	for _, name := range sr.GroupBy {
+		col := input.GetByName(name)
+		if col == nil {
+			return nil, fmt.Errorf("GROUP BY column %s not found", name)
+		}
+		isGroupColumn[name] = true
+		keyParts = append(keyParts, groupKeyPart(col))
+	}
+	for _, sl := range sr.SelectList {
+		if sl.IsPrimary && sl.PrimaryName != "Epoch" && !isGroupColumn[sl.PrimaryName] {
+			return nil, fmt.Errorf("Column %s must be in the GROUP BY or used in an aggregate",
+				sl.PrimaryName)
+		}
+		if sl.RuntimeExpression == nil {
+			continue
+		}
+		// An expression of the GROUP BY columns has one value per group
+		for _, name := range sl.RuntimeExpression.Columns() {
+			if !isGroupColumn[name] {
+				return nil, fmt.Errorf("Expression %s can only use the GROUP BY columns",
+					sl.RuntimeExpression)
+			}
+		}
+	}
+
+	/*
+		Assign the rows to groups, the groups are kept in order of first appearance
+	*/
+	var groups [][]int
+	groupIndex := make(map[string]int)
+	var key bytes.Buffer
+	for i := 0; i < input.Len(); i++ {
+		key.Reset()
+		for _, part := range keyParts {
+			part(&key, i)
+		}
+		g, ok := groupIndex[key.String()]
+		if !ok {
+			g = len(groups)
+			groupIndex[key.String()] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	firstRows := make([]int, len(groups))
+	groupSeries := make([]*io.ColumnSeries, len(groups))
+	for g, rows := range groups {
+		firstRows[g] = rows[0]
+		groupSeries[g] = io.NewColumnSeries()
+		for _, name := range input.GetColumnNames() {
+			groupSeries[g].AddColumn(name, gatherRows(input.GetColumn(name), rows))
+		}
+	}
+
+	output = io.NewColumnSeries()
+	output.AddColumn("Epoch", gatherRows(epochs, firstRows))
+	for _, sl := range sr.SelectList {
+		if sl.IsFunctionCall && sl.FunctionCall.IsTimeBucket() {
+			cd, err := sl.FunctionCall.GetTimeBucket()
+			if err != nil {
+				return nil, err
+			}
+			if sr.TimeBucket == nil || cd.String != sr.TimeBucket.String {
+				return nil, fmt.Errorf("time_bucket in the select list has to match the GROUP BY")
+			}
+			outname := "TimeBucket"
+			if sl.IsAliased {
+				outname = sl.Alias
+			}
+			output.AddColumn(outname, gatherRows(epochs, firstRows))
+			continue
+		}
+		if sl.RuntimeExpression != nil {
+			// The expression was evaluated on the input under its output name
+			output.AddColumn(sl.OutputName(), gatherRows(input.GetColumn(sl.OutputName()), firstRows))
+			continue
+		}
+		if !sl.IsFunctionCall {
+			if sl.PrimaryName == "Epoch" {
+				continue
+			}
+			outname := sl.PrimaryName
+			if sl.IsAliased {
+				outname = sl.Alias
+			}
+			output.AddColumn(outname, gatherRows(input.GetColumn(sl.PrimaryName), firstRows))
+			continue
+		}
+
+		aggfunc, err := newAggregate(sl.FunctionCall)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		var columns []reflect.Value
+		for g, gcs := range groupSeries {
+			aggfunc.Reset()
+			if err = aggfunc.Accum(gcs); err != nil {
+				return nil, err
+			}
+			functionResult := aggfunc.Output()
+			if functionResult == nil || functionResult.Len() != 1 {
+				return nil, fmt.Errorf(
+					"Aggregate %s has to return one row per group to be used with GROUP BY",
+					sl.FunctionCall.Name)
+			}
+			if g == 0 {
+				for _, name := range functionResult.GetColumnNames() {
+					if name == "Epoch" {
+						continue
+					}
+					col := reflect.ValueOf(functionResult.GetColumn(name))
+					names = append(names, name)
+					columns = append(columns, reflect.MakeSlice(col.Type(), 0, len(groups)))
+				}
+			}
+			for i, name := range names {
+				col := reflect.ValueOf(functionResult.GetColumn(name))
+				columns[i] = reflect.Append(columns[i], col.Index(0))
+			}
+		}
+		for i, name := range names {
+			outname := name
+			if sl.IsAliased {
+				outname = sl.Alias
+			}
+			output.AddColumn(outname, columns[i].Interface())
+		}
+	}
+	return output, nil
+}
+
+/*
+groupKeyPart returns a function adding the value of row i of col to a group key.
+Strings are length prefixed so that the parts of a key can not run together.
+*/
+func groupKeyPart(col interface{}) func(key *bytes.Buffer, i int) {
+	if strs, ok := col.([]string); ok {
+		return func(key *bytes.Buffer, i int) {
+			binary.Write(key, binary.LittleEndian, int64(len(strs[i])))
+			key.WriteString(strs[i])
+		}
+	}
+	typ := io.GetElementType(col)
+	colBytes := io.SwapSliceData(col, byte(0)).([]byte)
+	return func(key *bytes.Buffer, i int) {
+		key.Write(typ.SliceInBytesAt(colBytes, i))
+	}
+}
+
+/*
+timeBuckets returns the start of the time_bucket window of each epoch, the
+windows are taken in the configured timezone
+*/
+func (sr *SelectRelation) timeBuckets(epochs []int64) (buckets []int64) {
+	buckets = make([]int64, len(epochs))
+	for i, epoch := range epochs {
+		t := time.Unix(epoch, 0).In(utils.InstanceConfig.Timezone)
+		buckets[i] = sr.TimeBucket.Truncate(t).Unix()
+	}
+	return buckets
+}
@@ -1,11 +1,13 @@
 package SQLParser
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	"github.com/dannyluong408/marketstore/utils/io"
 )
 
@@ -25,9 +27,12 @@ func NewInsertIntoStatement(tableName, queryText string, selectRelation *SelectR
 	return is
 }
 
-func (is *InsertIntoStatement) Materialize() (outputColumnSeries *io.ColumnSeries, err error) {
+func (is *InsertIntoStatement) Materialize(ctx context.Context) (outputColumnSeries *io.ColumnSeries, err error) {
+	if err = auth.FromContext(ctx).Check(auth.WRITE, is.TableName); err != nil {
+		return nil, err
+	}
 	// Call Materialize on any child relations
-	inputColumnSeries, err := is.SelectRelation.Materialize()
+	inputColumnSeries, err := is.SelectRelation.Materialize(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +58,10 @@ func (is *InsertIntoStatement) Materialize() (outputColumnSeries *io.ColumnSerie
 
 	fi, err := executor.ThisInstance.CatalogDir.GetLatestTimeBucketInfoFromKey(targetMK)
 	if err != nil {
+		// Refuse to reveal whether the table exists to whom may not create it
+		if authErr := auth.FromContext(ctx).Check(auth.CREATE, is.TableName); authErr != nil {
+			return nil, authErr
+		}
 		return nil, err
 	}
 	targetDSV := fi.GetDataShapesWithEpoch()
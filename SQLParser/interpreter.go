@@ -16,6 +16,8 @@ type AstBuilder struct {
 	numberOfRelations int
 
 	Mtree IMSTree // The query tree, built from the parse tree
+
+	Parameters []int // Token indexes of the ? parameters in statement order
 }
 
 func NewAstBuilder(sourceString string) (ast *AstBuilder, err error) {
@@ -50,6 +52,11 @@ func NewAstBuilder(sourceString string) (ast *AstBuilder, err error) {
 		fmt.Println(parseErr.err.Error())
 		return nil, parseErr.err
 	}
+	for _, token := range tokens.GetAllTokens() {
+		if token.GetTokenType() == SQLBaseLexerT__3 { // '?'
+			ast.Parameters = append(ast.Parameters, token.GetTokenIndex())
+		}
+	}
 	return ast, nil
 }
 
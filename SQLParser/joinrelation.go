@@ -0,0 +1,189 @@
+package SQLParser
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/planner"
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+JoinRelation aligns two relations on Epoch. The columns of each table in the
+join are prefixed with the table alias, e.g. "a.Close", so the same column
+name can come from both sides. Epoch is the join key and is output once.
+*/
+type JoinRelation struct {
+	JoinType    JoinTypeEnum
+	Left, Right Relation
+}
+
+func NewJoinRelation(joinType JoinTypeEnum, left, right Relation) (jr *JoinRelation, err error) {
+	switch joinType {
+	case INNER, LEFT_OUTER:
+	default:
+		return nil, fmt.Errorf("Unsupported join type, only INNER and LEFT joins are supported")
+	}
+	jr = new(JoinRelation)
+	jr.JoinType = joinType
+	jr.Left = left
+	jr.Right = right
+	return jr, nil
+}
+
+func (jr *JoinRelation) Materialize(ctx context.Context) (outputColumnSeries *io.ColumnSeries, err error) {
+	left, err := jr.Left.Materialize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := jr.Right.Materialize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	/*
+		Merge the two sorted Epoch columns, recording the source row of each
+		output row. Duplicate epochs (VARIABLE records) produce every pairing.
+		A right row index of -1 is an unmatched LEFT join row.
+	*/
+	leftEpochs, rightEpochs := left.GetEpoch(), right.GetEpoch()
+	var epochs []int64
+	var leftRows, rightRows []int
+	var j int
+	for i, epoch := range leftEpochs {
+		for j < len(rightEpochs) && rightEpochs[j] < epoch {
+			j++
+		}
+		var matched bool
+		for k := j; k < len(rightEpochs) && rightEpochs[k] == epoch; k++ {
+			epochs = append(epochs, epoch)
+			leftRows = append(leftRows, i)
+			rightRows = append(rightRows, k)
+			matched = true
+		}
+		if !matched && jr.JoinType == LEFT_OUTER {
+			epochs = append(epochs, epoch)
+			leftRows = append(leftRows, i)
+			rightRows = append(rightRows, -1)
+		}
+	}
+	if epochs == nil {
+		epochs = []int64{}
+	}
+
+	outputColumnSeries = io.NewColumnSeries()
+	outputColumnSeries.AddColumn("Epoch", epochs)
+	for _, side := range []struct {
+		cs   *io.ColumnSeries
+		rows []int
+	}{{left, leftRows}, {right, rightRows}} {
+		for _, name := range side.cs.GetColumnNames() {
+			if name == "Epoch" {
+				continue
+			}
+			if outputColumnSeries.Exists(name) {
+				return nil, fmt.Errorf("Column %s is on both sides of the join, use a table alias", name)
+			}
+			outputColumnSeries.AddColumn(name, gatherRows(side.cs.GetColumn(name), side.rows))
+		}
+	}
+	return outputColumnSeries, nil
+}
+
+/*
+gatherRows builds a new column from the rows of col. A row of -1, the missing
+right row of a LEFT JOIN, is NULL: NaN for float columns, which sorts as NULL in
+ORDER BY. Other columns have no NULL, they get the zero value of their type.
+*/
+func gatherRows(col interface{}, rows []int) interface{} {
+	iv := reflect.ValueOf(col)
+	out := reflect.MakeSlice(iv.Type(), len(rows), len(rows))
+	var null reflect.Value
+	switch iv.Type().Elem().Kind() {
+	case reflect.Float32, reflect.Float64:
+		null = reflect.ValueOf(math.NaN()).Convert(iv.Type().Elem())
+	}
+	for i, row := range rows {
+		switch {
+		case row >= 0:
+			out.Index(i).Set(iv.Index(row))
+		case null.IsValid():
+			out.Index(i).Set(null)
+		}
+	}
+	return out.Interface()
+}
+
+/*
+TableRelation is a single table taking part in a join
+*/
+type TableRelation struct {
+	Name, Alias      string
+	StaticPredicates StaticPredicateGroup
+}
+
+func NewTableRelation(name, alias string, spg StaticPredicateGroup) (tr *TableRelation) {
+	tr = new(TableRelation)
+	tr.Name = name
+	tr.Alias = alias
+	tr.StaticPredicates = spg
+	return tr
+}
+
+func (tr *TableRelation) Materialize(ctx context.Context) (outputColumnSeries *io.ColumnSeries, err error) {
+	key := io.NewTimeBucketKey(tr.Name, "Symbol/Timeframe/AttributeGroup")
+	if key == nil {
+		return nil, fmt.Errorf("Table name must match \"one/two/three\" for three directory levels")
+	}
+	d := executor.ThisInstance.CatalogDir
+	dsv, err := d.GetDataShapes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	q := planner.NewQuery(d)
+	q.SetContext(ctx)
+	q.AddTargetKey(key)
+	if err = tr.StaticPredicates.pushDownEpoch(q); err != nil {
+		return nil, err
+	}
+	parsed, err := q.Parse()
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := executor.NewReader(parsed)
+	if err != nil {
+		return nil, err
+	}
+	csm, _, err := scanner.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	/*
+		An empty range still has to provide the columns to the join
+	*/
+	cs := csm[*key]
+	if cs == nil || cs.Len() == 0 {
+		cs = io.NewColumnSeries()
+		cs.AddColumn("Epoch", []int64{})
+		for _, ds := range dsv {
+			if ds.Name != "Epoch" {
+				cs.AddNullColumn(ds)
+			}
+		}
+	}
+
+	outputColumnSeries = io.NewColumnSeries()
+	for _, name := range cs.GetColumnNames() {
+		outname := name
+		if name != "Epoch" {
+			outname = tr.Alias + "." + name
+		}
+		outputColumnSeries.AddColumn(outname, cs.GetColumn(name))
+	}
+	return outputColumnSeries, nil
+}
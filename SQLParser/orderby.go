@@ -0,0 +1,168 @@
+package SQLParser
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+sourceSortItems returns the ORDER BY items of the select, with the aliases of
+the select list replaced by the source columns they rename, for sorting the
+rows ahead of the projection
+*/
+func (sr *SelectRelation) sourceSortItems() []SortItem {
+	items := make([]SortItem, len(sr.OrderBy))
+	for i, item := range sr.OrderBy {
+		for _, sl := range sr.SelectList {
+			if sl.IsAliased && sl.IsPrimary && sl.Alias == item.Column {
+				item.Column = sl.PrimaryName
+				break
+			}
+		}
+		items[i] = item
+	}
+	return items
+}
+
+/*
+orderBy sorts the rows of cs on the ORDER BY items, in order of precedence.
+The sort is stable, so rows with equal sort values stay in time order.
+NaN values are nulls, which sort last ascending and first descending unless
+NULLS FIRST or NULLS LAST says otherwise.
+*/
+func orderBy(cs *io.ColumnSeries, items []SortItem) error {
+	var compares []func(i, j int) int
+	for _, item := range items {
+		col := cs.GetByName(item.Column)
+		if col == nil {
+			return fmt.Errorf("ORDER BY column %s is not in the query output", item.Column)
+		}
+		cmp, isNull, err := columnComparison(col)
+		if err != nil {
+			return err
+		}
+		if item.Order == DESCENDING {
+			asc := cmp
+			cmp = func(i, j int) int { return asc(j, i) }
+		}
+		if isNull != nil {
+			cmp = withNulls(cmp, isNull, nullsFirst(item))
+		}
+		compares = append(compares, cmp)
+	}
+
+	rows := make([]int, cs.Len())
+	for i := range rows {
+		rows[i] = i
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, cmp := range compares {
+			if c := cmp(rows[i], rows[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	for _, name := range cs.GetColumnNames() {
+		if err := cs.Replace(name, gatherRows(cs.GetColumn(name), rows)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+nullsFirst returns whether the nulls of the column of item sort ahead of its values
+*/
+func nullsFirst(item SortItem) bool {
+	switch item.NullOrder {
+	case FIRST:
+		return true
+	case LAST:
+		return false
+	}
+	return item.Order == DESCENDING
+}
+
+/*
+withNulls wraps cmp to place the null rows ahead of or after all the others
+*/
+func withNulls(cmp func(i, j int) int, isNull func(i int) bool, first bool) func(i, j int) int {
+	nullSide := 1
+	if first {
+		nullSide = -1
+	}
+	return func(i, j int) int {
+		ni, nj := isNull(i), isNull(j)
+		switch {
+		case ni && nj:
+			return 0
+		case ni:
+			return nullSide
+		case nj:
+			return -nullSide
+		}
+		return cmp(i, j)
+	}
+}
+
+/*
+columnComparison returns a function comparing two rows of col, giving -1, 0 or 1,
+and for columns that hold nulls a function telling whether a row is null
+*/
+func columnComparison(col interface{}) (cmp func(i, j int) int, isNull func(i int) bool, err error) {
+	iv := reflect.ValueOf(col)
+	switch iv.Type().Elem().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(i, j int) int {
+			a, b := iv.Index(i).Int(), iv.Index(j).Int()
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}, nil, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(i, j int) int {
+			a, b := iv.Index(i).Uint(), iv.Index(j).Uint()
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}, nil, nil
+	case reflect.Float32, reflect.Float64:
+		isNull = func(i int) bool { return math.IsNaN(iv.Index(i).Float()) }
+		return func(i, j int) int {
+			a, b := iv.Index(i).Float(), iv.Index(j).Float()
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}, isNull, nil
+	case reflect.String:
+		return func(i, j int) int {
+			a, b := iv.Index(i).String(), iv.Index(j).String()
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("Unable to sort column of type %s", iv.Type())
+}
@@ -0,0 +1,182 @@
+package SQLParser
+
+import (
+	"fmt"
+
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+RuntimePredicate is a WHERE expression evaluated over the columns of a
+result. A node is one of:
+
+	a logical AND / OR of the Left and Right nodes
+	a comparison of a column with another column or a value
+	an IN list of values for a column
+	a TRUE / FALSE literal
+*/
+type RuntimePredicate struct {
+	Operator    BinaryOperatorEnum
+	IsNot       bool
+	Left, Right *RuntimePredicate
+	Column      string
+	Comparison  io.ComparisonOperatorEnum
+	RightColumn string
+	Value       interface{}
+	InList      []interface{}
+}
+
+func NewLogicalPredicate(op BinaryOperatorEnum, left, right *RuntimePredicate) (rp *RuntimePredicate) {
+	rp = new(RuntimePredicate)
+	rp.Operator = op
+	rp.Left = left
+	rp.Right = right
+	return rp
+}
+
+func NewComparisonPredicate(column string, op io.ComparisonOperatorEnum, value interface{}) (rp *RuntimePredicate) {
+	rp = new(RuntimePredicate)
+	rp.Column = column
+	rp.Comparison = op
+	rp.Value = value
+	return rp
+}
+
+func NewColumnComparisonPredicate(column string, op io.ComparisonOperatorEnum, rightColumn string) (rp *RuntimePredicate) {
+	rp = new(RuntimePredicate)
+	rp.Column = column
+	rp.Comparison = op
+	rp.RightColumn = rightColumn
+	return rp
+}
+
+func NewInListPredicate(column string, inlist []interface{}) (rp *RuntimePredicate) {
+	rp = new(RuntimePredicate)
+	rp.Column = column
+	rp.InList = inlist
+	return rp
+}
+
+func NewLiteralPredicate(value bool) (rp *RuntimePredicate) {
+	rp = new(RuntimePredicate)
+	rp.Value = value
+	return rp
+}
+
+/*
+Eval returns for each row of cs whether the row satisfies the predicate. The
+columns are compared as a whole, there is no per row evaluation of the tree.
+*/
+func (rp *RuntimePredicate) Eval(cs *io.ColumnSeries) (keep []bool, err error) {
+	switch {
+	case rp.Left != nil:
+		if keep, err = rp.Left.Eval(cs); err != nil {
+			return nil, err
+		}
+		right, err := rp.Right.Eval(cs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range keep {
+			if rp.Operator == OR_OP {
+				keep[i] = keep[i] || right[i]
+			} else {
+				keep[i] = keep[i] && right[i]
+			}
+		}
+	case rp.Column == "":
+		keep = make([]bool, cs.Len())
+		if rp.Value.(bool) {
+			for i := range keep {
+				keep[i] = true
+			}
+		}
+	case rp.InList != nil:
+		col, err := predicateColumn(cs, rp.Column)
+		if err != nil {
+			return nil, err
+		}
+		keep = make([]bool, cs.Len())
+		for _, item := range rp.InList {
+			match, err := io.VectorComparison(col, item, io.EQ)
+			if err != nil {
+				return nil, err
+			}
+			for i := range keep {
+				keep[i] = keep[i] || match[i]
+			}
+		}
+	default:
+		col, err := predicateColumn(cs, rp.Column)
+		if err != nil {
+			return nil, err
+		}
+		right := rp.Value
+		if len(rp.RightColumn) != 0 {
+			if right, err = predicateColumn(cs, rp.RightColumn); err != nil {
+				return nil, err
+			}
+		}
+		if keep, err = io.VectorComparison(col, right, rp.Comparison); err != nil {
+			return nil, fmt.Errorf("Unable to evaluate predicate on %s: %s", rp.Column, err.Error())
+		}
+	}
+	if rp.IsNot {
+		for i := range keep {
+			keep[i] = !keep[i]
+		}
+	}
+	return keep, nil
+}
+
+func predicateColumn(cs *io.ColumnSeries, name string) (interface{}, error) {
+	col := cs.GetByName(name)
+	if col == nil {
+		return nil, fmt.Errorf("WHERE column %s not found", name)
+	}
+	return col, nil
+}
+
+/*
+Columns returns the names of all columns used in the predicate
+*/
+func (rp *RuntimePredicate) Columns() (names []string) {
+	if rp.Left != nil {
+		return append(rp.Left.Columns(), rp.Right.Columns()...)
+	}
+	if len(rp.Column) != 0 {
+		names = append(names, rp.Column)
+	}
+	if len(rp.RightColumn) != 0 {
+		names = append(names, rp.RightColumn)
+	}
+	return names
+}
+
+/*
+Conjuncts splits the predicate into the terms that are ANDed together
+*/
+func (rp *RuntimePredicate) Conjuncts() []*RuntimePredicate {
+	if rp.Left != nil && rp.Operator == AND_OP && !rp.IsNot {
+		return append(rp.Left.Conjuncts(), rp.Right.Conjuncts()...)
+	}
+	return []*RuntimePredicate{rp}
+}
+
+/*
+filter removes the rows of cs that do not satisfy the WHERE clause
+*/
+func (sr *SelectRelation) filter(cs *io.ColumnSeries) error {
+	if sr.WherePredicate == nil || cs.Len() == 0 {
+		return nil
+	}
+	keep, err := sr.WherePredicate.Eval(cs)
+	if err != nil {
+		return err
+	}
+	removalBitmap := make([]bool, len(keep))
+	for i, k := range keep {
+		removalBitmap[i] = !k
+	}
+	return cs.RestrictViaBitmap(removalBitmap)
+}
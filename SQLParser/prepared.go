@@ -0,0 +1,85 @@
+package SQLParser
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+/*
+PreparedStatement is a statement parsed once and executed any number of times
+with values bound to its ? parameters. The values are bound as literals after
+parsing, they are never part of the statement text.
+*/
+type PreparedStatement struct {
+	SQLStatement string
+	ast          *AstBuilder
+}
+
+func NewPreparedStatement(sqlStatement string) (ps *PreparedStatement, err error) {
+	ast, err := NewAstBuilder(sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+	ps = new(PreparedStatement)
+	ps.SQLStatement = sqlStatement
+	ps.ast = ast
+	return ps, nil
+}
+
+func (ps *PreparedStatement) NumParameters() int {
+	return len(ps.ast.Parameters)
+}
+
+/*
+Bind returns an executable statement with the parameters in statement order
+set to the values. Strings, integers, floats, booleans and times are accepted,
+a time is bound as its epoch.
+*/
+func (ps *PreparedStatement) Bind(values ...interface{}) (es *ExecutableStatement, err error) {
+	if len(values) != ps.NumParameters() {
+		return nil, fmt.Errorf("Statement has %d parameters, have %d values",
+			ps.NumParameters(), len(values))
+	}
+	es = new(ExecutableStatement)
+	es.nodeCursor = es
+	es.parameters = make(map[int]*Literal, len(values))
+	for i, value := range values {
+		literal, err := NewParameterLiteral(value)
+		if err != nil {
+			return nil, fmt.Errorf("Parameter %d: %s", i+1, err.Error())
+		}
+		es.parameters[ps.ast.Parameters[i]] = literal
+	}
+	i_err := es.Visit(ps.ast.Mtree)
+	if err, ok := i_err.(error); ok {
+		return nil, err
+	}
+	return es, nil
+}
+
+/*
+NewParameterLiteral converts a bound parameter value to the literal it stands for
+*/
+func NewParameterLiteral(value interface{}) (*Literal, error) {
+	switch v := value.(type) {
+	case nil:
+		return NewLiteral(nil, NULL_LITERAL), nil
+	case string:
+		return NewLiteral("'"+v+"'", STRING_LITERAL), nil // Quoted like a parsed string
+	case bool:
+		return NewLiteral(v, BOOLEAN_LITERAL), nil
+	case time.Time:
+		return NewLiteral(v.Unix(), INTEGER_LITERAL), nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewLiteral(rv.Int(), INTEGER_LITERAL), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NewLiteral(int64(rv.Uint()), INTEGER_LITERAL), nil
+	case reflect.Float32, reflect.Float64:
+		return NewLiteral(rv.Float(), DECIMAL_LITERAL), nil
+	}
+	return nil, fmt.Errorf("Unsupported parameter type %T", value)
+}
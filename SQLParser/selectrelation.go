@@ -2,6 +2,7 @@ package SQLParser
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/dannyluong408/marketstore/executor"
 	"github.com/dannyluong408/marketstore/planner"
+	"github.com/dannyluong408/marketstore/utils"
 	"github.com/dannyluong408/marketstore/utils/io"
 )
 
@@ -20,7 +22,10 @@ type SelectRelation struct {
 	IsPrimary, IsSelectAll bool
 	PrimaryTargetName      []string
 	Subquery               *SelectRelation
-	WherePredicate         IMSTree // Runtime predicates
+	Join                   *JoinRelation
+	GroupBy                []string
+	TimeBucket             *utils.CandleDuration
+	WherePredicate         *RuntimePredicate // Runtime predicates
 	SetQuantifier          SetQuantifierEnum
 	StaticPredicates       StaticPredicateGroup
 }
@@ -30,7 +35,7 @@ func NewSelectRelation() (sr *SelectRelation) {
 	return sr
 }
 
-func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, err error) {
+func (sr *SelectRelation) Materialize(ctx context.Context) (outputColumnSeries *io.ColumnSeries, err error) {
 	// Call Materialize on any child relations
 	//	fmt.Println("In SelectRelation Materialize")
 	var inputColumnSeries *io.ColumnSeries
@@ -40,14 +45,14 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		case Relation: // Interface type
 			//fmt.Println("Subquery Interface found...")
 			//			fmt.Println("Relation")
-			inputColumnSeries, err = value.Materialize()
+			inputColumnSeries, err = value.Materialize(ctx)
 			if err != nil {
 				return nil, err
 			}
 		case *SelectRelation:
 			//			fmt.Println("*SelectRelation")
 			//fmt.Println("Subquery found...")
-			inputColumnSeries, err = value.Materialize()
+			inputColumnSeries, err = value.Materialize(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -56,10 +61,13 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 	//	fmt.Printf("Materialize... %+v\n", sr)
 	if !sr.IsPrimary {
 		//		fmt.Println("Materializing subquery")
-		inputColumnSeries, err = sr.Subquery.Materialize()
+		inputColumnSeries, err = sr.Subquery.Materialize(ctx)
 		if err != nil {
 			return nil, err
 		}
+		if err = sr.filter(inputColumnSeries); err != nil {
+			return nil, err
+		}
 	}
 
 	// Check for the early "always false predicate" case
@@ -69,6 +77,25 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		}
 	}
 
+	if sr.Join != nil {
+		inputColumnSeries, err = sr.Join.Materialize(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Predicates can use columns from both sides of the join
+		if err = sr.filter(inputColumnSeries); err != nil {
+			return nil, err
+		}
+	}
+
+	if inputColumnSeries == nil && len(sr.PrimaryTargetName) != 0 &&
+		isWildcardTable(sr.PrimaryTargetName[0]) {
+		inputColumnSeries, err = sr.materializeWildcard(ctx, sr.PrimaryTargetName[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	/*
 		Get column metadata, either from primary table or from input results
 	*/
@@ -130,32 +157,17 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		outputColumnSeries = inputColumnSeries
 	} else {
 		q := planner.NewQuery(executor.ThisInstance.CatalogDir)
+		q.SetContext(ctx)
 		q.AddTargetKey(key)
 
 		/*
 			Search for time/Epoch predicates and push them down to the IO query
 		*/
-		if sp, ok := sr.StaticPredicates["Epoch"]; ok {
-			if sp.ContentsEnum.IsSet(MINBOUND) {
-				val, err := io.GetValueAsInt64(sp.min)
-				if err != nil {
-					return nil, fmt.Errorf("Non date predicate found for Epoch")
-				}
-				if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-					val += 1
-				}
-				q.SetStart(val)
-			}
-			if sp.ContentsEnum.IsSet(MAXBOUND) {
-				val, err := io.GetValueAsInt64(sp.max)
-				if err != nil {
-					return nil, fmt.Errorf("Non date predicate found for Epoch")
-				}
-				if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-					val -= 1
-				}
-				q.SetEnd(val)
-			}
+		if err = sr.StaticPredicates.pushDownEpoch(q); err != nil {
+			return nil, err
+		}
+		if sr.WherePredicate != nil {
+			q.AddColumnQual(sr.WherePredicate.Eval)
 		}
 
 		/*
@@ -181,208 +193,13 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		}
 
 		outputColumnSeries = csm[*key]
-		if outputColumnSeries.Len() == 0 {
-			return outputColumnSeries, nil
-		}
+	}
 
-		/*
-			Evaluate all predicates on final results set
-		*/
-		totalLength := outputColumnSeries.Len()
-		removalBitmap := make([]bool, totalLength, totalLength) // true means we ditch the value, default is keep
-		for _, name := range outputColumnSeries.GetColumnNames() {
-			if sp, ok := sr.StaticPredicates[name]; ok {
-				i_col := outputColumnSeries.GetColumn(name)
-				switch col := i_col.(type) {
-				case []float32:
-					if sp.ContentsEnum.IsSet(EQUALITY) {
-						eqval, _ := io.GetValueAsFloat64(sp.equal)
-						for i, val := range col {
-							if val != float32(eqval) {
-								removalBitmap[i] = true // remove
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MINBOUND) {
-						minval, _ := io.GetValueAsFloat64(sp.min)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-								if val < float32(minval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val <= float32(minval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MAXBOUND) {
-						maxval, _ := io.GetValueAsFloat64(sp.max)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-								if val > float32(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val >= float32(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-				case []float64:
-					if sp.ContentsEnum.IsSet(EQUALITY) {
-						eqval, _ := io.GetValueAsFloat64(sp.equal)
-						for i, val := range col {
-							if val != eqval {
-								removalBitmap[i] = true // remove
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MINBOUND) {
-						minval, _ := io.GetValueAsFloat64(sp.min)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-								if val < minval {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val <= minval {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MAXBOUND) {
-						maxval, _ := io.GetValueAsFloat64(sp.max)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-								if val > maxval {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val >= maxval {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-				case []int:
-					if sp.ContentsEnum.IsSet(EQUALITY) {
-						eqval, _ := io.GetValueAsInt64(sp.equal)
-						for i, val := range col {
-							if val != int(eqval) {
-								removalBitmap[i] = true // remove
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MINBOUND) {
-						minval, _ := io.GetValueAsInt64(sp.min)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-								if val < int(minval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val <= int(minval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MAXBOUND) {
-						maxval, _ := io.GetValueAsInt64(sp.max)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-								if val > int(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val >= int(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-				case []int32:
-					if sp.ContentsEnum.IsSet(EQUALITY) {
-						eqval, _ := io.GetValueAsInt64(sp.equal)
-						for i, val := range col {
-							if val != int32(eqval) {
-								removalBitmap[i] = true // remove
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MINBOUND) {
-						minval, _ := io.GetValueAsInt64(sp.min)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-								if val < int32(minval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val <= int32(minval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MAXBOUND) {
-						maxval, _ := io.GetValueAsInt64(sp.max)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-								if val > int32(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val >= int32(maxval) {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-				case []int64:
-					if sp.ContentsEnum.IsSet(EQUALITY) {
-						eqval, _ := io.GetValueAsInt64(sp.equal)
-						for i, val := range col {
-							if val != eqval {
-								removalBitmap[i] = true // remove
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MINBOUND) {
-						minval, _ := io.GetValueAsInt64(sp.min)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMIN) {
-								if val < minval {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val <= minval {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-					if sp.ContentsEnum.IsSet(MAXBOUND) {
-						maxval, _ := io.GetValueAsInt64(sp.max)
-						for i, val := range col {
-							if sp.ContentsEnum.IsSet(INCLUSIVEMAX) {
-								if val > maxval {
-									removalBitmap[i] = true // remove
-								}
-							} else {
-								if val >= maxval {
-									removalBitmap[i] = true // remove
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-		outputColumnSeries.RestrictViaBitmap(removalBitmap)
+	/*
+		Evaluate the expressions in the select list into new columns
+	*/
+	if err = sr.evalExpressions(outputColumnSeries); err != nil {
+		return nil, err
 	}
 
 	/*
@@ -390,8 +207,19 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 	*/
 	var selectListOutput *io.ColumnSeries
 	var skipProjection bool // TODO: Only skip for SRF
-	if !sr.IsSelectAll {
+	if len(sr.GroupBy) != 0 || sr.TimeBucket != nil {
+		outputColumnSeries, err = sr.groupBy(outputColumnSeries)
+		if err != nil {
+			return nil, err
+		}
+		skipProjection = true
+	} else if !sr.IsSelectAll {
 		for _, sl := range sr.SelectList {
+			if sl.RuntimeExpression != nil && sr.hasFunctionCall() {
+				return nil, fmt.Errorf(
+					"Expression %s has to be grouped with GROUP BY to be selected with an aggregate",
+					sl.RuntimeExpression)
+			}
 			if sl.IsFunctionCall {
 				if selectListOutput == nil {
 					selectListOutput = io.NewColumnSeries()
@@ -399,60 +227,15 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 				// TODO: This only handles SRF
 				skipProjection = true
 				aggName := sl.FunctionCall.Name
-				agg := AggRegistry[strings.ToLower(aggName)]
-				if agg == nil {
-					return nil, fmt.Errorf("No function in the UDA Registry named \"%s\"", aggName)
-				}
-				aggfunc, argMap := agg.New()
-
-				if sl.FunctionCall.IsAsterisk {
-					/*
-						If an asterisk is provided, use Epoch as the mapped input column
-					*/
-					argMap.MapRequiredColumn("*", io.DataShape{
-						Name: "Epoch", Type: io.INT64,
-					})
-				} else {
-					idList := sl.FunctionCall.GetIDs()
-					err = argMap.PrepareArguments(idList)
-					if err != nil {
-						return nil, fmt.Errorf("Argument mapping error for %s: %s", aggName, err.Error())
-					}
-				}
-
-				/*
-					Initialize the Aggregate
-						An agg may have init parameters, which are used only to initialize it
-						These are single value literals (like '1Min')
-				*/
-				requiredInitDSV := aggfunc.GetInitArgs()
-				requiredInitNames := io.GetNamesFromDSV(requiredInitDSV)
-
-				initList := sl.FunctionCall.GetLiterals()
-				if len(requiredInitNames) > len(initList) {
-					return nil, fmt.Errorf(
-						"Not enough init arguments for %s, need %d have %d",
-						aggName,
-						len(requiredInitNames),
-						len(initList),
-					)
-				}
-				// TODO: Handle different argument types from string
-				var initArgList []string
-				for _, lit := range initList {
-					value := lit.Value.(string)
-					value = value[1 : len(value)-1] // Strip the quotes
-					initArgList = append(
-						initArgList,
-						value,
-					)
+				aggfunc, err := newAggregate(sl.FunctionCall)
+				if err != nil {
+					return nil, err
 				}
-				aggfunc.Init(initArgList)
 
 				/*
 					Execute the aggregate function
 				*/
-				err := aggfunc.Accum(outputColumnSeries)
+				err = aggfunc.Accum(outputColumnSeries)
 				if err != nil {
 					return nil, err
 				}
@@ -496,6 +279,16 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		}
 	}
 
+	/*
+		Enforce ORDER BY ahead of the projection when the rows are those of the
+		source, so that any column of the source can be sorted on
+	*/
+	if len(sr.OrderBy) != 0 && !skipProjection {
+		if err = orderBy(outputColumnSeries, sr.sourceSortItems()); err != nil {
+			return nil, err
+		}
+	}
+
 	/*
 		Handle column projection and aliases
 	*/
@@ -507,7 +300,7 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		}
 		// Column alias remapping on exit
 		for _, item := range sr.SelectList {
-			if item.IsAliased {
+			if item.IsAliased && item.IsPrimary {
 				err := outputColumnSeries.Rename(item.Alias, item.PrimaryName)
 				if err != nil {
 					return nil, err
@@ -516,6 +309,15 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 		}
 	}
 
+	/*
+		Enforce ORDER BY on the grouped results, ahead of the LIMIT
+	*/
+	if len(sr.OrderBy) != 0 && skipProjection {
+		if err = orderBy(outputColumnSeries, sr.OrderBy); err != nil {
+			return nil, fmt.Errorf("%s of the grouped query", err)
+		}
+	}
+
 	/*
 		Enforce LIMIT on the final results
 	*/
@@ -526,6 +328,16 @@ func (sr *SelectRelation) Materialize() (outputColumnSeries *io.ColumnSeries, er
 	return outputColumnSeries, nil
 }
 
+// hasFunctionCall returns whether the select list calls a function
+func (sr *SelectRelation) hasFunctionCall() bool {
+	for _, sl := range sr.SelectList {
+		if sl.IsFunctionCall {
+			return true
+		}
+	}
+	return false
+}
+
 func (sr *SelectRelation) Explain() string {
 	if sr != nil {
 		jsonStruct, _ := json.Marshal(*sr)
@@ -610,9 +422,89 @@ func (spg StaticPredicateGroup) Merge(sp *StaticPredicate, IsOr bool) error {
 		tgtSP.SetLike(sp.likePattern, sp.likeEsc)
 	}
 	if sp.ContentsEnum.IsSet(INLIST) {
-		tgtSP.ContentsEnum.AddOption(INLIST)
-		for _, item := range sp.inlist {
-			tgtSP.inlist = append(tgtSP.inlist, item)
+		if tgtSP.ContentsEnum.IsSet(INLIST) {
+			// Both lists are ANDed, keep only the items in both
+			var inlist []interface{}
+			for _, item := range tgtSP.inlist {
+				for _, other := range sp.inlist {
+					if item == other {
+						inlist = append(inlist, item)
+						break
+					}
+				}
+			}
+			tgtSP.inlist = inlist
+		} else {
+			tgtSP.ContentsEnum.AddOption(INLIST)
+			for _, item := range sp.inlist {
+				tgtSP.inlist = append(tgtSP.inlist, item)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+epochRanger is the part of the planner query used to push down Epoch predicates
+*/
+type epochRanger interface {
+	SetStart(start int64)
+	SetEnd(end int64)
+}
+
+/*
+AddConjuncts adds the terms of a WHERE expression that are ANDed at the top
+level and compare a column to a value as static predicates
+*/
+func (spg StaticPredicateGroup) AddConjuncts(rp *RuntimePredicate) error {
+	for _, term := range rp.Conjuncts() {
+		if term.IsNot || term.Left != nil || len(term.Column) == 0 || len(term.RightColumn) != 0 {
+			continue
+		}
+		sp := NewStaticPredicate(NewColumnReference(term.Column))
+		switch {
+		case term.InList != nil:
+			sp.SetInlist(term.InList)
+		case term.Comparison == io.NEQ:
+			continue
+		default:
+			sp.AddComparison(term.Comparison, term.Value)
+		}
+		if err := spg.Merge(sp, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+pushDownEpoch sets the query range from the Epoch predicates in the group. The
+range can include the bounds of exclusive predicates, the WHERE expression is
+still evaluated on the rows read.
+*/
+func (spg StaticPredicateGroup) pushDownEpoch(q epochRanger) error {
+	if sp, ok := spg["Epoch"]; ok {
+		if sp.ContentsEnum.IsSet(EQUALITY) {
+			val, err := io.GetValueAsInt64(sp.equal)
+			if err != nil {
+				return fmt.Errorf("Non date predicate found for Epoch")
+			}
+			q.SetStart(val)
+			q.SetEnd(val)
+		}
+		if sp.ContentsEnum.IsSet(MINBOUND) {
+			val, err := io.GetValueAsInt64(sp.min)
+			if err != nil {
+				return fmt.Errorf("Non date predicate found for Epoch")
+			}
+			q.SetStart(val)
+		}
+		if sp.ContentsEnum.IsSet(MAXBOUND) {
+			val, err := io.GetValueAsInt64(sp.max)
+			if err != nil {
+				return fmt.Errorf("Non date predicate found for Epoch")
+			}
+			q.SetEnd(val)
 		}
 	}
 	return nil
@@ -755,7 +647,7 @@ func (sp *StaticPredicate) AddComparison(op io.ComparisonOperatorEnum,
 type AliasedIdentifier struct {
 	IsPrimary, IsAliased, IsFunctionCall bool
 	PrimaryName, Alias                   string
-	RuntimeExpression                    *ExpressionParse
+	RuntimeExpression                    *ArithmeticExpression
 	FunctionCall                         *FunctionCallReference
 }
 
@@ -768,8 +660,8 @@ func NewAliasedIdentifier(name ...string) (ai *AliasedIdentifier) {
 	return ai
 }
 
-func (ai *AliasedIdentifier) AddRuntimeExpression(ep *ExpressionParse) {
-	ai.RuntimeExpression = ep
+func (ai *AliasedIdentifier) AddRuntimeExpression(ae *ArithmeticExpression) {
+	ai.RuntimeExpression = ae
 }
 func (ai *AliasedIdentifier) AddFunctionCall(fc *FunctionCallReference) {
 	ai.FunctionCall = fc
@@ -786,6 +678,9 @@ func (ai *AliasedIdentifier) String() (out string) {
 		buffer.WriteString(fmt.Sprintf("Primary Name: %s ", ai.PrimaryName))
 	} else {
 		buffer.WriteString("Runtime Expression: ")
+		if ai.RuntimeExpression != nil {
+			buffer.WriteString(ai.RuntimeExpression.String() + " ")
+		}
 	}
 	if ai.IsAliased {
 		buffer.WriteString(fmt.Sprintf("Alias: %s ", ai.Alias))
@@ -793,6 +688,19 @@ func (ai *AliasedIdentifier) String() (out string) {
 	return buffer.String()
 }
 
+/*
+OutputName returns the name of the result column for this identifier
+*/
+func (ai *AliasedIdentifier) OutputName() string {
+	switch {
+	case ai.IsAliased:
+		return ai.Alias
+	case ai.RuntimeExpression != nil:
+		return ai.RuntimeExpression.String()
+	}
+	return ai.PrimaryName
+}
+
 func SourceValidator(sourceDSV []io.DataShape, selectList []*AliasedIdentifier) (validates bool,
 	missing, keepList, projectionList []string, err error) {
 	if selectList == nil {
@@ -802,6 +710,7 @@ func SourceValidator(sourceDSV []io.DataShape, selectList []*AliasedIdentifier)
 		Given a source's DataShapes, verify that the target ID list is found within it
 	*/
 	// Get target names from identifiers
+	var expressionIDs, expressionNames []string
 	for _, id := range selectList {
 		switch {
 		case id.IsFunctionCall:
@@ -818,13 +727,24 @@ func SourceValidator(sourceDSV []io.DataShape, selectList []*AliasedIdentifier)
 			}
 		case id.IsPrimary:
 			keepList = append(keepList, id.PrimaryName)
+		case id.RuntimeExpression != nil:
+			/*
+				Expression results are added to the source under their output
+				name, only the columns they use have to be in the source
+			*/
+			expressionIDs = append(expressionIDs, id.RuntimeExpression.Columns()...)
+			expressionNames = append(expressionNames, id.OutputName())
+			keepList = append(keepList, id.OutputName())
 		}
 	}
 	sourceNames := io.GetNamesFromDSV(sourceDSV)
-	targetNamesSet, err := io.NewAnySet(keepList)
+	targetNamesSet, err := io.NewAnySet(append(expressionIDs, keepList...))
 	if err != nil {
 		return false, nil, nil, nil, fmt.Errorf("Unable to build set for target")
 	}
+	for _, name := range expressionNames {
+		targetNamesSet.Del(name)
+	}
 	i_missingIDs := targetNamesSet.Subtract(sourceNames)
 	var missingIDs []string
 	if i_missingIDs != nil {
@@ -837,7 +757,7 @@ func SourceValidator(sourceDSV []io.DataShape, selectList []*AliasedIdentifier)
 		Find the list of names in the source not needed by the target
 	*/
 	sourceNamesSet, _ := io.NewAnySet(sourceNames)
-	projectionList = sourceNamesSet.Subtract(keepList).([]string)
+	projectionList = sourceNamesSet.Subtract(append(expressionIDs, keepList...)).([]string)
 
 	return true, nil, keepList, projectionList, nil
 }
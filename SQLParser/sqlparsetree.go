@@ -670,9 +670,8 @@ type GroupingElementParse struct {
 }
 
 func NewGroupingElementParse(node antlr.Tree) (term *GroupingElementParse) {
-	ictx := node.GetChild(0)
 	term = new(GroupingElementParse)
-	switch ctx := ictx.(type) {
+	switch ctx := node.(type) {
 	case *parser.SingleGroupingSetContext:
 		term.groupingExp = NewGroupingExpressionsParse(ctx.GroupingExpressions())
 	case *parser.RollupContext:
@@ -841,7 +840,9 @@ type JoinCriteriaParse struct {
 func NewJoinCriteriaParse(node antlr.Tree) (term *JoinCriteriaParse) {
 	ctx := node.(*parser.JoinCriteriaContext)
 	term = new(JoinCriteriaParse)
-	term.onExpression = NewBooleanExpressionParse(ctx.BooleanExpression())
+	if ctx.BooleanExpression() != nil {
+		term.onExpression = NewBooleanExpressionParse(ctx.BooleanExpression())
+	}
 	for _, cctx := range ctx.AllIdentifier() {
 		term.identifiers = append(term.identifiers, NewIDParse(cctx))
 	}
@@ -1020,8 +1021,8 @@ func NewBooleanExpressionParse(node antlr.Tree) (term *BooleanExpressionParse) {
 	for {
 		switch ctx := node.(type) {
 		case *parser.LogicalNotContext:
-			term.IsNot = true
-			node = node.GetChild(0) // Iterate over child node
+			term.IsNot = !term.IsNot
+			node = ctx.BooleanExpression() // Iterate over child node
 		case *parser.LogicalBinaryContext:
 			term.right = NewExpressionParse(ctx.GetRight())
 			switch ctx.GetOperator().GetText() {
@@ -1409,6 +1410,7 @@ func NewPrimaryExpressionParse(node antlr.Tree) (term *PrimaryExpressionParse) {
 		term.primaryType = NULL_LITERAL
 	case *parser.ParameterContext:
 		term.primaryType = PARAMETER
+		term.payload = ctx.GetStart().GetTokenIndex()
 	case *parser.StringLiteralContext:
 		term.primaryType = STRING_LITERAL
 		term.payload = ctx.STRING().GetText()
@@ -2221,6 +2223,9 @@ func NewIDParse(node antlr.Tree) (term *IDParse) {
 	case *parser.BackQuotedIdentifierContext:
 		term.name = ctx.BACKQUOTED_IDENTIFIER().GetText()
 		term.name = term.name[1 : len(term.name)-1]
+	case *parser.QuotedIdentifierAlternativeContext:
+		term.name = ctx.QUOTED_IDENTIFIER().GetText()
+		term.name = strings.Replace(term.name[1:len(term.name)-1], "\"\"", "\"", -1)
 	case *parser.NonReservedIdentifierContext:
 		term.AddChild(NewNonReservedParse(ctx.NonReserved()))
 	}
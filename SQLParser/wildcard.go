@@ -0,0 +1,212 @@
+package SQLParser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/planner"
+	"github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+keyColumns are the virtual string columns of a wildcard table, one for each
+category of the table key
+*/
+var keyColumns = []string{"Symbol", "Timeframe", "AttributeGroup"}
+
+func isKeyColumn(name string) bool {
+	for _, col := range keyColumns {
+		if name == col {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isWildcardTable returns true if any item of the table name is a "*" wildcard
+*/
+func isWildcardTable(name string) bool {
+	for _, item := range strings.Split(name, "/") {
+		if item == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+keyLiteral returns the value of a string literal compared to a key column
+*/
+func keyLiteral(literal *Literal) (string, error) {
+	if literal.Type != STRING_LITERAL {
+		return "", fmt.Errorf("Key columns can only be compared to strings")
+	}
+	value := literal.Value.(string)
+	return value[1 : len(value)-1], nil // Strip the quotes
+}
+
+/*
+keyRestriction returns the items a key column is restricted to by the static
+predicates, if any. The list can be a superset of the matching items, the
+predicates are still applied to the results.
+*/
+func (spg StaticPredicateGroup) keyRestriction(name string) (items []string, ok bool) {
+	sp, ok := spg[name]
+	if !ok {
+		return nil, false
+	}
+	switch {
+	case sp.ContentsEnum.IsSet(EQUALITY):
+		value, _ := sp.equal.(string)
+		return []string{value}, true
+	case sp.ContentsEnum.IsSet(INLIST):
+		for _, item := range sp.inlist {
+			value, _ := item.(string)
+			items = append(items, value)
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+/*
+materializeWildcard reads all keys matching a wildcard table name into one
+result, with the key items added as the Symbol, Timeframe and AttributeGroup
+columns. The wildcards are narrowed by the predicates on those columns before
+the query is parsed, so no file of an excluded key is opened. The planner
+needs a single timeframe per query, so there is one query per timeframe.
+*/
+func (sr *SelectRelation) materializeWildcard(ctx context.Context, table string) (outputColumnSeries *io.ColumnSeries, err error) {
+	items := strings.Split(table, "/")
+	if len(items) != len(keyColumns) {
+		return nil, fmt.Errorf("Table name must match \"one/two/three\" for three directory levels")
+	}
+	d := executor.ThisInstance.CatalogDir
+
+	restrictions := make(map[string][]string, len(keyColumns))
+	for i, cat := range keyColumns {
+		if items[i] != "*" {
+			restrictions[cat] = strings.Split(items[i], ",")
+		} else if list, ok := sr.StaticPredicates.keyRestriction(cat); ok {
+			restrictions[cat] = list
+		}
+	}
+	for _, list := range restrictions {
+		if len(list) == 0 {
+			// The IN lists have no item in common, nothing can match
+			return nil, fmt.Errorf("No results returned from query")
+		}
+	}
+	timeframes := restrictions["Timeframe"]
+	if _, ok := restrictions["Timeframe"]; !ok {
+		for tf := range d.GatherCategoriesAndItems()["Timeframe"] {
+			timeframes = append(timeframes, tf)
+		}
+		sort.Strings(timeframes)
+	}
+
+	/*
+		The WHERE expression is evaluated while reading, unless it needs the
+		key columns which are only added afterwards
+	*/
+	pushDown := sr.WherePredicate != nil
+	if pushDown {
+		for _, col := range sr.WherePredicate.Columns() {
+			if isKeyColumn(col) {
+				pushDown = false
+			}
+		}
+	}
+
+	var keys []io.TimeBucketKey
+	csm := io.NewColumnSeriesMap()
+	for _, tf := range timeframes {
+		q := planner.NewQuery(d)
+		q.SetContext(ctx)
+		for _, cat := range keyColumns {
+			if cat == "Timeframe" {
+				q.AddRestriction(cat, tf)
+				continue
+			}
+			for _, item := range restrictions[cat] {
+				q.AddRestriction(cat, item)
+			}
+		}
+		if err = sr.StaticPredicates.pushDownEpoch(q); err != nil {
+			return nil, err
+		}
+		if pushDown {
+			q.AddColumnQual(sr.WherePredicate.Eval)
+		}
+		parsed, err := q.Parse()
+		if err != nil {
+			if parsed != nil && len(parsed.QualifiedFiles) == 0 {
+				continue // No keys with this timeframe
+			}
+			return nil, err
+		}
+		scanner, err := executor.NewReader(parsed)
+		if err != nil {
+			return nil, err
+		}
+		tfcsm, _, err := scanner.Read()
+		if err != nil {
+			return nil, err
+		}
+		for key, cs := range tfcsm {
+			keys = append(keys, key)
+			csm[key] = cs
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	/*
+		Concatenate the results in key order, the key items become columns
+	*/
+	outputColumnSeries = io.NewColumnSeries()
+	var names []string
+	for _, key := range keys {
+		cs := csm[key]
+		if len(names) == 0 {
+			names = cs.GetColumnNames()
+			for _, name := range names {
+				outputColumnSeries.AddColumn(name,
+					reflect.MakeSlice(reflect.TypeOf(cs.GetColumn(name)), 0, 0).Interface())
+			}
+			for _, cat := range keyColumns {
+				outputColumnSeries.AddColumn(cat, []string{})
+			}
+		} else if !reflect.DeepEqual(names, cs.GetColumnNames()) {
+			return nil, fmt.Errorf("Keys matching %s do not share the same columns", table)
+		}
+		for _, name := range names {
+			col := reflect.AppendSlice(
+				reflect.ValueOf(outputColumnSeries.GetColumn(name)),
+				reflect.ValueOf(cs.GetColumn(name)))
+			outputColumnSeries.Replace(name, col.Interface())
+		}
+		for _, cat := range keyColumns {
+			col := outputColumnSeries.GetColumn(cat).([]string)
+			item := key.GetItemInCategory(cat)
+			for i := 0; i < cs.Len(); i++ {
+				col = append(col, item)
+			}
+			outputColumnSeries.Replace(cat, col)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No results returned from query")
+	}
+
+	if !pushDown {
+		if err = sr.filter(outputColumnSeries); err != nil {
+			return nil, err
+		}
+	}
+	return outputColumnSeries, nil
+}
@@ -206,9 +206,7 @@ func (d *Directory) GatherTimeBucketInfo() []*io.TimeBucketInfo {
 }
 
 func (d *Directory) GetLatestTimeBucketInfoFromKey(key *io.TimeBucketKey) (fi *io.TimeBucketInfo, err error) {
-	path := key.GetPathToYearFiles(d.pathToItemName)
-	fullFilePath := path + "/1970.bin" // Put a dummy file at the end of the path
-	subDir, err := d.GetOwningSubDirectory(fullFilePath)
+	subDir, err := d.GetSubDirectoryFromKey(key)
 	if err != nil {
 		return nil, err
 	}
@@ -273,6 +271,8 @@ func (subDir *Directory) AddFile(newYear int16) (finfo_p *io.TimeBucketInfo, err
 
 	newFileInfo := finfoTemplate.GetDeepCopy()
 	newFileInfo.Year = newYear
+	// The template may be a compacted file, new files are always dense
+	newFileInfo.SetFormat(io.DENSE)
 	// Create a new filename for the new file
 	subDir.RLock()
 	newFileInfo.Path = path.Join(subDir.pathToItemName, strconv.Itoa(int(newYear))+".bin")
@@ -291,6 +291,45 @@ func (subDir *Directory) AddFile(newYear int16) (finfo_p *io.TimeBucketInfo, err
 	return newFileInfo, nil
 }
 
+func (subDir *Directory) RemoveFile(year int16) (err error) {
+	// Must be thread-safe for WRITE access
+	/*
+	 Removes the primary storage file for the provided year from this directory
+	 The last file is never removed, as the directory needs one as the template for new years
+	 !!! NOTE !!! This should be called from the subdirectory that "owns" the file
+	*/
+	subDir.Lock()
+	defer subDir.Unlock()
+	filePath := path.Join(subDir.pathToItemName, strconv.Itoa(int(year))+".bin")
+	if _, ok := subDir.datafile[filePath]; !ok {
+		return NotFoundError(filePath)
+	}
+	if len(subDir.datafile) == 1 {
+		return fmt.Errorf("Can not remove the last file %s of the directory", filePath)
+	}
+	if err = os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(io.PresencePath(filePath))
+	delete(subDir.datafile, filePath)
+	return nil
+}
+
+func (subDir *Directory) UpdateFile(finfo *io.TimeBucketInfo) (err error) {
+	// Must be thread-safe for WRITE access
+	/*
+	 Replaces the TimeBucketInfo of a primary storage file of this directory, after its header changed
+	 !!! NOTE !!! This should be called from the subdirectory that "owns" the file
+	*/
+	subDir.Lock()
+	defer subDir.Unlock()
+	if _, ok := subDir.datafile[finfo.Path]; !ok {
+		return NotFoundError(finfo.Path)
+	}
+	subDir.datafile[finfo.Path] = finfo
+	return nil
+}
+
 func (d *Directory) DirHasDataFiles() bool {
 	d.RLock()
 	defer d.RUnlock()
@@ -328,6 +367,18 @@ func (d *Directory) GetOwningSubDirectory(fullFilePath string) (subDir *Director
 	return nil, fmt.Errorf("Directory path %s not found in catalog", fullFilePath)
 }
 
+func (d *Directory) GetSubDirectoryFromKey(key *io.TimeBucketKey) (subDir *Directory, err error) {
+	// Must be thread-safe for READ access
+	// Returns the directory holding the year files of the bucket key
+	dirPath := key.GetPathToYearFiles(d.pathToItemName)
+	d.RLock()
+	defer d.RUnlock()
+	if dir, ok := d.directMap[dirPath]; ok {
+		return dir, nil
+	}
+	return nil, fmt.Errorf("Directory path %s not found in catalog", dirPath)
+}
+
 func (d *Directory) GetListOfSubDirs() (subDirList []*Directory) {
 	// For a single directory, return a list of subdirectories it contains
 	d.RLock()
@@ -628,9 +679,16 @@ func newTimeBucketInfoFromTemplate(newTimeBucketInfo *io.TimeBucketInfo) (err er
 	if err = io.WriteHeader(fp, newTimeBucketInfo); err != nil {
 		return UnableToWriteHeader(err.Error())
 	}
-	if err = fp.Truncate(io.FileSize(newTimeBucketInfo.GetTimeframe(), int(newTimeBucketInfo.Year), int(newTimeBucketInfo.GetRecordLength()))); err != nil {
+	fileSize := io.FileSize(newTimeBucketInfo.GetTimeframe(), int(newTimeBucketInfo.Year), int(newTimeBucketInfo.GetRecordLength()))
+	if err = fp.Truncate(fileSize); err != nil {
 		return UnableToCreateFile(err.Error())
 	}
+	if newTimeBucketInfo.GetRecordType() == io.FIXED {
+		slots := (fileSize - io.Headersize) / int64(newTimeBucketInfo.GetRecordLength())
+		if err = io.CreatePresence(newTimeBucketInfo.Path, slots); err != nil {
+			return UnableToCreateFile(err.Error())
+		}
+	}
 
 	return nil
 }
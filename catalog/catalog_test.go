@@ -298,3 +298,31 @@ func exists(path string) bool {
 	}
 	return true
 }
+
+func (s *TestSuite) TestRemoveFile(c *C) {
+	rootDir := c.MkDir()
+	MakeDummyCurrencyDir(rootDir, false, false)
+	d := NewDirectory(rootDir)
+	subDir, err := d.GetOwningSubDirectory(rootDir + "/EURUSD/1Min/OHLC/2001.bin")
+	c.Assert(err, IsNil)
+	years := len(subDir.GetTimeBucketInfoSlice())
+	c.Assert(years > 1, Equals, true)
+
+	c.Assert(subDir.RemoveFile(2001), IsNil)
+	_, err = os.Stat(rootDir + "/EURUSD/1Min/OHLC/2001.bin")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = d.PathToTimeBucketInfo(rootDir + "/EURUSD/1Min/OHLC/2001.bin")
+	c.Assert(err, FitsTypeOf, NotFoundError(""))
+	c.Assert(subDir.GetTimeBucketInfoSlice(), HasLen, years-1)
+
+	// missing file
+	c.Assert(subDir.RemoveFile(2001), FitsTypeOf, NotFoundError(""))
+
+	// the last file is kept
+	for _, tbi := range subDir.GetTimeBucketInfoSlice()[1:] {
+		c.Assert(subDir.RemoveFile(tbi.Year), IsNil)
+	}
+	last := subDir.GetTimeBucketInfoSlice()
+	c.Assert(last, HasLen, 1)
+	c.Assert(subDir.RemoveFile(last[0].Year), NotNil)
+}
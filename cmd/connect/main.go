@@ -1,9 +1,11 @@
 package connect
 
 import (
+	"crypto/tls"
 	"errors"
 
 	"github.com/dannyluong408/marketstore/cmd/connect/session"
+	"github.com/dannyluong408/marketstore/frontend/client"
 	. "github.com/dannyluong408/marketstore/utils/log"
 	"github.com/spf13/cobra"
 )
@@ -26,6 +28,15 @@ const (
 	dirFlag    = "dir"
 	defaultDir = ""
 	dirDesc    = "filesystem path of the directory containing database files when used in local mode"
+	// TLS.
+	tlsFlag     = "tls"
+	tlsDesc     = "connect to the remote instance over TLS"
+	tlsCAFlag   = "tls-ca"
+	tlsCADesc   = "PEM file of the CA to trust for the server certificate, instead of the system roots"
+	tlsCertFlag = "tls-cert"
+	tlsCertDesc = "PEM file of the client certificate, for servers requiring one"
+	tlsKeyFlag  = "tls-key"
+	tlsKeyDesc  = "PEM file of the client certificate key"
 )
 
 var (
@@ -44,11 +55,18 @@ var (
 	url string
 	// dir set via flag for local directory location.
 	dir string
+	// useTLS and the files set via flags for connecting over TLS.
+	useTLS                 bool
+	tlsCA, tlsCert, tlsKey string
 )
 
 func init() {
 	Cmd.Flags().StringVarP(&url, urlFlag, "u", defaultURL, urlDesc)
 	Cmd.Flags().StringVarP(&dir, dirFlag, "d", defaultDir, dirDesc)
+	Cmd.Flags().BoolVar(&useTLS, tlsFlag, false, tlsDesc)
+	Cmd.Flags().StringVar(&tlsCA, tlsCAFlag, "", tlsCADesc)
+	Cmd.Flags().StringVar(&tlsCert, tlsCertFlag, "", tlsCertDesc)
+	Cmd.Flags().StringVar(&tlsKey, tlsKeyFlag, "", tlsKeyDesc)
 }
 
 // validateArgs returns an error that prevents cmd execution if
@@ -76,7 +94,14 @@ func executeConnect(cmd *cobra.Command, args []string) error {
 
 	// Attempt remote mode.
 	if len(url) != 0 {
-		c, err = session.NewRemoteClient(url)
+		var tlsConfig *tls.Config
+		if useTLS || tlsCA != "" || tlsCert != "" {
+			tlsConfig, err = client.NewTLSConfig(tlsCA, tlsCert, tlsKey)
+			if err != nil {
+				return err
+			}
+		}
+		c, err = session.NewRemoteClient(url, tlsConfig)
 		if err != nil {
 			return err
 		}
@@ -6,6 +6,7 @@ package session
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -46,6 +47,8 @@ type Client struct {
 	mode mode
 	// url is the optional address of a db instance on a different machine.
 	url string
+	// tlsConfig is the optional TLS configuration of the remote client.
+	tlsConfig *tls.Config
 	// rc is the optional remote client.
 	rc *client.Client
 	// dir is the optional filesystem location of a local db instance.
@@ -61,8 +64,9 @@ func NewLocalClient(dir string) (c *Client, err error) {
 	return &Client{dir: dir, mode: local}, nil
 }
 
-// NewRemoteClient generates a new client struct.
-func NewRemoteClient(url string) (c *Client, err error) {
+// NewRemoteClient generates a new client struct, connecting
+// over TLS when tlsConfig is set.
+func NewRemoteClient(url string, tlsConfig *tls.Config) (c *Client, err error) {
 	// TODO: validate url using go core packages.
 	splits := strings.Split(url, ":")
 	if len(splits) != 2 {
@@ -70,8 +74,12 @@ func NewRemoteClient(url string) (c *Client, err error) {
 		return nil, errors.New(msg)
 	}
 	// build url.
-	url = "http://" + url
-	return &Client{url: url, mode: remote}, nil
+	if tlsConfig != nil {
+		url = "https://" + url
+	} else {
+		url = "http://" + url
+	}
+	return &Client{url: url, mode: remote, tlsConfig: tlsConfig}, nil
 }
 
 // Connect initializes a client connection.
@@ -86,6 +94,7 @@ func (c *Client) Connect() error {
 	if err != nil {
 		return err
 	}
+	client.TLSConfig = c.tlsConfig
 	c.rc = client
 
 	// Success.
@@ -159,6 +168,8 @@ EVAL:
 			c.create(line)
 		case strings.HasPrefix(line, "\\destroy"):
 			c.destroy(line)
+		case strings.HasPrefix(line, "\\alter"):
+			c.alter(line)
 		case strings.HasPrefix(line, "\\getinfo"):
 			c.getinfo(line)
 		case strings.HasPrefix(line, "\\help") || strings.HasPrefix(line, "\\?"):
@@ -193,6 +204,7 @@ func newReader() (*readline.Instance, error) {
 		readline.PcItem("\\show"),
 		readline.PcItem("\\load"),
 		readline.PcItem("\\create"),
+		readline.PcItem("\\alter"),
 		readline.PcItem("\\trim"),
 		readline.PcItem("\\help"),
 		readline.PcItem("\\exit"),
@@ -304,6 +316,8 @@ func printResult(queryText string, cs *dbio.ColumnSeries, optionalFile ...string
 				case reflect.Uint8:
 					val := col.([]byte)[i]
 					element = strconv.FormatInt(int64(val), 10)
+				case reflect.String:
+					element = col.([]string)[i]
 				}
 				element = fmt.Sprintf("%-10s", element)
 			}
@@ -355,6 +369,8 @@ func formatHeader(cs *dbio.ColumnSeries, printChar string) string {
 			appendChars(10)
 		case reflect.Uint8:
 			appendChars(10)
+		case reflect.String:
+			appendChars(10)
 		}
 	}
 	return buffer.String()
@@ -135,6 +135,45 @@ func (c *Client) destroy(line string) {
 	fmt.Printf("Successfully removed catalog entry for key: %s\n", args[0])
 }
 
+// alter changes the columns of the bucket of a provided key
+func (c *Client) alter(line string) {
+	args := strings.Split(line, " ")
+	args = args[1:] // chop off the first word which should be "alter"
+	if len(args) != 2 {
+		fmt.Println("Need a schema key and a row data shape, see \"\\help alter\"")
+		return
+	}
+
+	req := frontend.AlterSchemaRequest{Key: args[0], DataShapes: args[1]}
+	reqs := &frontend.MultiAlterSchemaRequest{
+		Requests: []frontend.AlterSchemaRequest{req},
+	}
+	responses := &frontend.MultiServerResponse{}
+	var err error
+	if c.mode == local {
+		ds := frontend.DataService{}
+		err = ds.AlterSchema(nil, reqs, responses)
+	} else {
+		var respI interface{}
+		respI, err = c.rc.DoRPC("AlterSchema", reqs)
+		if respI != nil {
+			responses = respI.(*frontend.MultiServerResponse)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed with error: %s\n", err.Error())
+		return
+	}
+
+	for _, resp := range responses.Responses {
+		if len(resp.Error) != 0 {
+			fmt.Printf("Failed with error: %s\n", resp.Error)
+			return
+		}
+	}
+	fmt.Printf("Successfully altered the data shape of bucket %s\n", args[0])
+}
+
 func (c *Client) GetBucketInfo(key io.TimeBucketKey) (resp *frontend.GetInfoResponse, err error) {
 	req := frontend.KeyRequest{Key: key.String()}
 	reqs := &frontend.MultiKeyRequest{
@@ -21,7 +21,7 @@ func (c *Client) functionHelp(line string) {
 		fmt.Println(`
 		Usage: \help command_name
 
-		Available commands: o, timing, show, trim, gaps, load, create, destroy, feed`)
+		Available commands: o, timing, show, trim, gaps, load, create, destroy, alter, feed`)
 
 	case "o":
 		fmt.Println(`
@@ -45,7 +45,8 @@ func (c *Client) functionHelp(line string) {
 
 			>> \show TSLA/1Min/OHLCV 2016-09-15 2016-09-16
 
-	trim: removes the data in the date range from the DB
+	trim: removes the data in the date range from the DB, or everything after
+	      the start time if there is no end time
 	show: displays data in the date range
 	gaps: finds gaps in data in the date range`)
 
@@ -107,6 +108,24 @@ func (c *Client) functionHelp(line string) {
 		number of rows:
 			<row-type> = variable`)
 
+	case "alter":
+		fmt.Println(`
+		The alter command changes the columns of a timebucket, rewriting the records of every year.
+		Syntax:
+			>> \alter <partial-schema-key> <row-data-shape>
+		Example: We add a VWAP column to the 1 minute candles of TSLA:
+			>> \alter TSLA/1Min/OHLCV Open,High,Low,Close/float32:Volume/int32:VWAP/float64
+
+		where:
+
+		<row-data-shape>: All the columns of the bucket after the change, in the format of
+			the create command. The columns are matched by name to the current ones:
+		- a new column is filled with zeros in the existing records
+		- a column left out is dropped with its data
+		- a column given another type has its values converted, a narrower type truncates
+
+		Writes to the database wait until the bucket is rewritten.`)
+
 	default:
 		fmt.Printf("No help available for %s\n", helpKey)
 	}
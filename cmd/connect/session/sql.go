@@ -1,11 +1,11 @@
 package session
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/dannyluong408/marketstore/SQLParser"
-	"github.com/dannyluong408/marketstore/frontend"
 	"github.com/dannyluong408/marketstore/utils/io"
 )
 
@@ -46,7 +46,7 @@ func localSQL(line string) (cs *io.ColumnSeries, err error) {
 	if err != nil {
 		return nil, err
 	}
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -54,20 +54,5 @@ func localSQL(line string) (cs *io.ColumnSeries, err error) {
 }
 
 func (c *Client) remoteSQL(line string) (cs *io.ColumnSeries, err error) {
-	req := frontend.QueryRequest{
-		IsSQLStatement: true,
-		SQLStatement:   line,
-	}
-	args := &frontend.MultiQueryRequest{Requests: []frontend.QueryRequest{req}}
-
-	resp, err := c.rc.DoRPC("Query", args)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, sub := range *resp.(*io.ColumnSeriesMap) {
-		cs = sub
-		break
-	}
-	return cs, err
+	return c.rc.SQL(line)
 }
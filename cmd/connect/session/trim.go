@@ -2,39 +2,60 @@ package session
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
-	"github.com/dannyluong408/marketstore/executor"
-	"github.com/dannyluong408/marketstore/utils/io"
-	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/dannyluong408/marketstore/frontend"
+	"github.com/dannyluong408/marketstore/planner"
 )
 
 // trim removes the data in the date range from the db.
 func (c *Client) trim(line string) {
-	Log(INFO, "Trimming...")
 	args := strings.Split(line, " ")
-	if len(args) < 3 {
-		fmt.Println("Not enough arguments - need \"trim key date\"")
+	args = args[1:] // chop off the first word which should be "trim"
+	if len(args) < 2 {
+		fmt.Println("Not enough arguments, see \"\\help trim\" ")
 		return
 	}
-	trimDate, err := parseTime(args[len(args)-1])
+	tbk, start, end := c.parseQueryArgs(args)
+	if tbk == nil {
+		fmt.Println("Could not parse arguments, see \"\\help trim\" ")
+		return
+	}
+
+	epochEnd := planner.MaxEpoch
+	if end != nil {
+		epochEnd = end.Unix()
+	}
+	req := frontend.DeleteRequest{
+		Key:        tbk.String(),
+		EpochStart: start.Unix(),
+		EpochEnd:   epochEnd,
+	}
+	reqs := &frontend.MultiDeleteRequest{
+		Requests: []frontend.DeleteRequest{req},
+	}
+	responses := &frontend.MultiServerResponse{}
+	var err error
+	if c.mode == local {
+		ds := frontend.DataService{}
+		err = ds.Delete(nil, reqs, responses)
+	} else {
+		var respI interface{}
+		respI, err = c.rc.DoRPC("Delete", reqs)
+		if respI != nil {
+			responses = respI.(*frontend.MultiServerResponse)
+		}
+	}
 	if err != nil {
-		Log(ERROR, "Failed to parse trim date - Error: %v", trimDate)
-	}
-	fInfos := executor.ThisInstance.CatalogDir.GatherTimeBucketInfo()
-	for _, info := range fInfos {
-		if info.Year == int16(trimDate.Year()) {
-			offset := io.TimeToOffset(trimDate, info.GetTimeframe(), info.GetRecordLength())
-			fp, err := os.OpenFile(info.Path, os.O_CREATE|os.O_RDWR, 0600)
-			if err != nil {
-				Log(ERROR, "Failed to open file %v - Error: %v", info.Path, err)
-				continue
-			}
-			fp.Seek(offset, os.SEEK_SET)
-			zeroes := make([]byte, io.FileSize(info.GetTimeframe(), int(info.Year), int(info.GetRecordLength()))-offset)
-			fp.Write(zeroes)
-			fp.Close()
+		fmt.Printf("Failed with error: %s\n", err.Error())
+		return
+	}
+
+	for _, resp := range responses.Responses {
+		if len(resp.Error) != 0 {
+			fmt.Printf("Failed with error: %s\n", resp.Error)
+			return
 		}
 	}
+	fmt.Printf("Successfully trimmed key: %s\n", args[0])
 }
@@ -16,11 +16,16 @@ import (
 	"github.com/dannyluong408/marketstore/frontend"
 	"github.com/dannyluong408/marketstore/frontend/stream"
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/dannyluong408/marketstore/utils/stats"
 )
 
 // This is the launcher for all marketstore services
 
+// configFile is the path of the YAML configuration, re-read on SIGHUP
+var configFile string
+
 func init() {
 	utils.InstanceConfig.StartTime = time.Now()
 	configFlag := flag.String("config", "mkts.yml", "MarketStore YAML configuration file")
@@ -39,7 +44,8 @@ func init() {
 	flag.Lookup("logtostderr").Value.Set("true")
 
 	if configFlag != nil {
-		data, err := ioutil.ReadFile(*configFlag)
+		configFile = *configFlag
+		data, err := ioutil.ReadFile(configFile)
 		if err != nil {
 			Log(FATAL, "Failed to read configuration file - Error: %v", err)
 		}
@@ -47,11 +53,14 @@ func init() {
 		if err != nil {
 			Log(FATAL, "Failed to parse configuration file - Error: %v", err)
 		}
+		if err = auth.Initialize(utils.InstanceConfig.APIKeys); err != nil {
+			Log(FATAL, "Failed to set up API keys - Error: %v", err)
+		}
 	} else {
 		Log(FATAL, "No configuration file provided.")
 	}
 
-	sigChannel := make(chan os.Signal)
+	sigChannel := make(chan os.Signal, 1)
 	go func() {
 		for sig := range sigChannel {
 			switch sig {
@@ -64,11 +73,15 @@ func init() {
 				Log(INFO, "Waiting a grace period of %v to shutdown...", utils.InstanceConfig.StopGracePeriod)
 				time.Sleep(utils.InstanceConfig.StopGracePeriod)
 				shutdown()
+			case syscall.SIGHUP:
+				Log(INFO, "Reloading triggers and bgworkers due to SIGHUP request")
+				reload()
 			}
 		}
 	}()
 	signal.Notify(sigChannel, syscall.SIGUSR1)
 	signal.Notify(sigChannel, syscall.SIGINT)
+	signal.Notify(sigChannel, syscall.SIGHUP)
 
 	Log(INFO, "Initializing MarketStore...")
 }
@@ -80,6 +93,8 @@ func main() {
 
 	Log(INFO, "Launching rpc data server...")
 	go http.Handle("/rpc", server)
+	go http.HandleFunc("/query_stream", frontend.QueryStreamHandler)
+	go http.Handle("/metrics", auth.Middleware(http.HandlerFunc(stats.Handler)))
 
 	Log(INFO, "Initializing websocket...")
 	stream.Initialize()
@@ -90,7 +105,7 @@ func main() {
 	RunBgWorkers()
 
 	Log(INFO, "Launching heartbeat service...")
-	go frontend.Heartbeat(utils.InstanceConfig.ListenPort)
+	frontend.Heartbeat()
 
 	Log(INFO, "Enabling Query Access...")
 	atomic.StoreUint32(&frontend.Queryable, 1)
@@ -98,13 +113,55 @@ func main() {
 	/*
 		Running tcp listener mux
 	*/
+	tlsConfig, err := utils.InstanceConfig.TLSConfig()
+	if err != nil {
+		Log(FATAL, "Failed to set up TLS - Error: %s", err)
+	}
 	Log(INFO, "Launching tcp listener for all services...")
-	if err := http.ListenAndServe(utils.InstanceConfig.ListenPort, nil); err != nil {
+	srv := &http.Server{
+		Addr:      utils.InstanceConfig.ListenPort,
+		ConnState: server.ConnState, // Drops the prepared statements of closed connections
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil {
+		Log(INFO, "Serving over TLS...")
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		Log(FATAL, "Failed to start server - Error: %s", err)
 	}
 }
 
+// reload re-reads the configuration file and applies its trigger and bgworker
+// settings, the other settings only take effect on restart
+func reload() {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		Log(ERROR, "Failed to read configuration file - Error: %v", err)
+		return
+	}
+	var config utils.MktsConfig
+	if err = config.Parse(data); err != nil {
+		Log(ERROR, "Failed to parse configuration file - Error: %v", err)
+		return
+	}
+	ReloadPlugins(&config)
+	if err = executor.SetRetention(config.Retention); err != nil {
+		Log(ERROR, "Failed to set retention policies - Error: %v", err)
+		return
+	}
+	if err = executor.SetVariableWrites(config.VariableWrites); err != nil {
+		Log(ERROR, "Failed to set variable write modes - Error: %v", err)
+		return
+	}
+	utils.InstanceConfig.Retention = config.Retention
+	utils.InstanceConfig.VariableWrites = config.VariableWrites
+}
+
 func shutdown() {
+	StopBgWorkers()
 	executor.ThisInstance.ShutdownPending = true
 	executor.ThisInstance.WALWg.Wait()
 	Log(INFO, "Exiting...")
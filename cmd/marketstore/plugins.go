@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
 	"github.com/golang/glog"
 
+	// Registers the contrib triggers and bgworkers compiled into the binary
+	_ "github.com/dannyluong408/marketstore/contrib/builtin"
 	"github.com/dannyluong408/marketstore/executor"
 	"github.com/dannyluong408/marketstore/plugins"
 	"github.com/dannyluong408/marketstore/plugins/bgworker"
@@ -10,59 +17,177 @@ import (
 	"github.com/dannyluong408/marketstore/utils"
 )
 
+// bgWorkerStopTimeout bounds the wait for bgworkers to stop on reload and shutdown
+const bgWorkerStopTimeout = 30 * time.Second
+
+// loadedTriggers keeps the setting each trigger was loaded from, for reloads
+var loadedTriggers = map[*trigger.TriggerMatcher]*utils.TriggerSetting{}
+
 func InitializeTriggers() {
 	glog.Info("InitializeTriggers")
 	config := utils.InstanceConfig
 	theInstance := executor.ThisInstance
+	var matchers []*trigger.TriggerMatcher
 	for _, triggerSetting := range config.Triggers {
 		glog.Infof("triggerSetting = %v", triggerSetting)
 		tmatcher := NewTriggerMatcher(triggerSetting)
 		if tmatcher != nil {
-			theInstance.TriggerMatchers = append(
-				theInstance.TriggerMatchers, tmatcher)
+			matchers = append(matchers, tmatcher)
+			loadedTriggers[tmatcher] = triggerSetting
 		}
 	}
+	theInstance.SetTriggerMatchers(matchers)
 }
 
 func NewTriggerMatcher(ts *utils.TriggerSetting) *trigger.TriggerMatcher {
-	loader, err := plugins.NewSymbolLoader(ts.Module)
+	trig, err := NewTrigger(ts)
 	if err != nil {
-		glog.Errorf("Unable to open plugin for trigger in %s: %v", ts.Module, err)
+		glog.Errorf("Failed to create trigger: %v", err)
 		return nil
 	}
+	tmatcher := trigger.NewMatcher(trig, ts.On)
+	tmatcher.QueueDepth = ts.QueueDepth
+	tmatcher.DropWhenFull = ts.QueuePolicy == "drop"
+	return tmatcher
+}
+
+// NewTrigger creates the trigger of a setting, from a trigger compiled into the
+// binary if one is registered under the module name, or else from the module
+// loaded as a .so plugin
+func NewTrigger(ts *utils.TriggerSetting) (trigger.Trigger, error) {
+	if newFunc, ok := trigger.Lookup(ts.Module); ok {
+		trig, err := newFunc(ts.Config)
+		if err != nil {
+			return nil, fmt.Errorf("Error returned while creating trigger %s: %v", ts.Module, err)
+		}
+		return trig, nil
+	}
+	loader, err := plugins.NewSymbolLoader(ts.Module)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open plugin for trigger in %s: %v", ts.Module, err)
+	}
 	trig, err := trigger.Load(loader, ts.Config)
 	if err != nil {
-		glog.Errorf("Error returned while creating a trigger: %v", err)
-		return nil
+		return nil, fmt.Errorf("Error returned while creating a trigger: %v", err)
 	}
-	return trigger.NewMatcher(trig, ts.On)
+	return trig, nil
 }
 
 func RunBgWorkers() {
 	glog.Info("InitializeBgWorkers")
 	config := utils.InstanceConfig
 	for _, bgWorkerSetting := range config.BgWorkers {
-		glog.Infof("bgWorkerSetting = %v", bgWorkerSetting)
-		bgWorker := NewBgWorker(bgWorkerSetting)
-		if bgWorker != nil {
-			// we should probably keep track of this process status
-			// and may want to kill it or get info.  utils.Process may help
-			// but will figure it out later.
-			glog.Infof("Start running BgWorker %s...", bgWorkerSetting.Name)
-			go bgWorker.Run()
-		}
+		startBgWorker(bgWorkerSetting)
 	}
 }
 
-func NewBgWorker(s *utils.BgWorkerSetting) bgworker.BgWorker {
-	loader, err := plugins.NewSymbolLoader(s.Module)
+func startBgWorker(s *utils.BgWorkerSetting) {
+	glog.Infof("bgWorkerSetting = %v", s)
+	bgWorker, err := NewBgWorker(s)
 	if err != nil {
-		glog.Errorf("Unable to open plugin for bgworker in %s: %v", s.Module, err)
-		return nil
+		glog.Errorf("Failed to create bgworker: %v", err)
+		return
 	}
-	bgWorker, err := bgworker.Load(loader, s.Config)
+	glog.Infof("Start running BgWorker %s...", s.Name)
+	bgworker.Start(s.Name, bgWorker, func() (bgworker.BgWorker, error) {
+		return NewBgWorker(s)
+	})
+}
+
+// NewBgWorker creates the bgworker of a setting, from a bgworker compiled into
+// the binary if one is registered under the module name, or else from the
+// module loaded as a .so plugin
+func NewBgWorker(s *utils.BgWorkerSetting) (bgworker.BgWorker, error) {
+	if newFunc, ok := bgworker.Lookup(s.Module); ok {
+		return newFunc(s.Config)
+	}
+	loader, err := plugins.NewSymbolLoader(s.Module)
 	if err != nil {
-		glog.Errorf("Failed to create bgworker: %v", err)
+		return nil, fmt.Errorf("Unable to open plugin for bgworker in %s: %v", s.Module, err)
+	}
+	return bgworker.Load(loader, s.Config)
+}
+
+// StopBgWorkers stops the bgworkers before shutdown, so that none is left
+// writing while the WAL is flushed for the last time
+func StopBgWorkers() {
+	ctx, cancel := context.WithTimeout(context.Background(), bgWorkerStopTimeout)
+	defer cancel()
+	for _, err := range bgworker.StopAll(ctx) {
+		glog.Errorf("Failed to stop bgworker: %v", err)
+	}
+}
+
+// ReloadPlugins applies the trigger and bgworker settings of a re-parsed
+// config.  Unchanged triggers and bgworkers keep running as they are, removed
+// ones are dropped and stopped, and new ones are started.  A changed bgworker
+// is stopped and started again with its new setting.
+func ReloadPlugins(config *utils.MktsConfig) {
+	glog.Info("ReloadPlugins")
+	reloadTriggers(config.Triggers)
+	utils.InstanceConfig.Triggers = config.Triggers
+	utils.InstanceConfig.BgWorkers = reloadBgWorkers(config.BgWorkers)
+}
+
+func reloadTriggers(settings []*utils.TriggerSetting) {
+	theInstance := executor.ThisInstance
+	kept := map[*trigger.TriggerMatcher]*utils.TriggerSetting{}
+	var matchers []*trigger.TriggerMatcher
+	for _, ts := range settings {
+		var tmatcher *trigger.TriggerMatcher
+		for old, oldSetting := range loadedTriggers {
+			if _, reused := kept[old]; !reused && reflect.DeepEqual(oldSetting, ts) {
+				tmatcher = old
+				break
+			}
+		}
+		if tmatcher == nil {
+			glog.Infof("Loading trigger %s on %s", ts.Module, ts.On)
+			if tmatcher = NewTriggerMatcher(ts); tmatcher == nil {
+				continue
+			}
+		}
+		matchers = append(matchers, tmatcher)
+		kept[tmatcher] = ts
+	}
+	for old, oldSetting := range loadedTriggers {
+		if _, ok := kept[old]; !ok {
+			glog.Infof("Dropping trigger %s on %s", oldSetting.Module, oldSetting.On)
+		}
+	}
+	theInstance.SetTriggerMatchers(matchers)
+	loadedTriggers = kept
+}
+
+// reloadBgWorkers returns the settings the bgworkers now run with, which keep
+// the current setting of those that could not be stopped
+func reloadBgWorkers(settings []*utils.BgWorkerSetting) (effective []*utils.BgWorkerSetting) {
+	next := map[string]*utils.BgWorkerSetting{}
+	for _, s := range settings {
+		next[s.Name] = s
+	}
+	for _, s := range utils.InstanceConfig.BgWorkers {
+		if reflect.DeepEqual(next[s.Name], s) && bgworker.IsRunning(s.Name) {
+			delete(next, s.Name) // Unchanged and running
+			effective = append(effective, s)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), bgWorkerStopTimeout)
+		err := bgworker.Stop(ctx, s.Name)
+		cancel()
+		if err != nil {
+			glog.Errorf("Keeping bgworker %s as it is: %v", s.Name, err)
+			delete(next, s.Name)
+			effective = append(effective, s)
+			continue
+		}
+		glog.Infof("Stopped BgWorker %s", s.Name)
+	}
+	for _, s := range settings {
+		if _, ok := next[s.Name]; ok {
+			startBgWorker(s)
+			effective = append(effective, s)
+		}
 	}
-	return bgWorker
+	return effective
 }
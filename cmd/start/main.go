@@ -15,7 +15,9 @@ import (
 	"github.com/dannyluong408/marketstore/frontend"
 	"github.com/dannyluong408/marketstore/frontend/stream"
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/dannyluong408/marketstore/utils/stats"
 	"github.com/spf13/cobra"
 )
 
@@ -66,8 +68,13 @@ func executeStart(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse configuration file error: %v", err.Error())
 	}
 
+	// Set up API keys, authentication is disabled without any.
+	if err = auth.Initialize(utils.InstanceConfig.APIKeys); err != nil {
+		return fmt.Errorf("failed to set up api keys error: %v", err.Error())
+	}
+
 	// Spawn a goroutine and listen for a signal.
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	go func() {
 		for s := range signalChan {
 			switch s {
@@ -80,11 +87,15 @@ func executeStart(cmd *cobra.Command, args []string) error {
 				Log(INFO, "waiting a grace period of %v to shutdown...", utils.InstanceConfig.StopGracePeriod)
 				time.Sleep(utils.InstanceConfig.StopGracePeriod)
 				shutdown()
+			case syscall.SIGHUP:
+				Log(INFO, "reloading triggers and bgworkers due to SIGHUP request")
+				reload()
 			}
 		}
 	}()
 	signal.Notify(signalChan, syscall.SIGUSR1)
 	signal.Notify(signalChan, syscall.SIGINT)
+	signal.Notify(signalChan, syscall.SIGHUP)
 
 	// Initialize marketstore services.
 	// --------------------------------
@@ -99,6 +110,12 @@ func executeStart(cmd *cobra.Command, args []string) error {
 	Log(INFO, "launching rpc data server...")
 	go http.Handle("/rpc", server)
 
+	// Set chunked query stream handler.
+	go http.HandleFunc("/query_stream", frontend.QueryStreamHandler)
+
+	// Set metrics handler.
+	go http.Handle("/metrics", auth.Middleware(http.HandlerFunc(stats.Handler)))
+
 	// Set websocket handler.
 	Log(INFO, "initializing websocket...")
 	stream.Initialize()
@@ -110,21 +127,64 @@ func executeStart(cmd *cobra.Command, args []string) error {
 
 	// Start heartbeat.
 	Log(INFO, "launching heartbeat service...")
-	go frontend.Heartbeat(utils.InstanceConfig.ListenPort)
+	frontend.Heartbeat()
 
 	Log(INFO, "enabling query access...")
 	atomic.StoreUint32(&frontend.Queryable, 1)
 
+	tlsConfig, err := utils.InstanceConfig.TLSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to set up tls error: %v", err.Error())
+	}
+
 	// Serve.
 	Log(INFO, "launching tcp listener for all services...")
-	if err := http.ListenAndServe(utils.InstanceConfig.ListenPort, nil); err != nil {
+	srv := &http.Server{
+		Addr:      utils.InstanceConfig.ListenPort,
+		ConnState: server.ConnState, // Drops the prepared statements of closed connections
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil {
+		Log(INFO, "serving over tls...")
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to start server - error: %s", err.Error())
 	}
 
 	return nil
 }
 
+// reload re-reads the configuration file and applies its trigger and bgworker
+// settings, the other settings only take effect on restart
+func reload() {
+	data, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		Log(ERROR, "failed to read configuration file error: %v", err)
+		return
+	}
+	var config utils.MktsConfig
+	if err = config.Parse(data); err != nil {
+		Log(ERROR, "failed to parse configuration file error: %v", err)
+		return
+	}
+	ReloadPlugins(&config)
+	if err = executor.SetRetention(config.Retention); err != nil {
+		Log(ERROR, "failed to set retention policies error: %v", err)
+		return
+	}
+	if err = executor.SetVariableWrites(config.VariableWrites); err != nil {
+		Log(ERROR, "failed to set variable write modes error: %v", err)
+		return
+	}
+	utils.InstanceConfig.Retention = config.Retention
+	utils.InstanceConfig.VariableWrites = config.VariableWrites
+}
+
 func shutdown() {
+	StopBgWorkers()
 	executor.ThisInstance.ShutdownPending = true
 	executor.ThisInstance.WALWg.Wait()
 	Log(INFO, "exiting...")
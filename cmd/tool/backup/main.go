@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/dannyluong408/marketstore/frontend"
+	"github.com/dannyluong408/marketstore/frontend/client"
+	"github.com/spf13/cobra"
+)
+
+const (
+	usage   = "backup"
+	short   = "Write a snapshot of a running database"
+	long    = "This command has a running instance write a consistent snapshot of its database to a directory on the server, without stopping it"
+	example = "marketstore tool backup --url http://localhost:5993 --dir <name>"
+
+	// Flag descriptions.
+	urlDesc     = "set the address of the instance at \"http://hostname:port\""
+	dirDesc     = "set the snapshot directory, under the backup_directory of the server, it must not exist yet"
+	apiKeyDesc  = "set the api key, needing the admin permission on \"*/*/*\" when the server has api_keys configured"
+	tlsCADesc   = "PEM file of the CA to trust for the server certificate, instead of the system roots"
+	tlsCertDesc = "PEM file of the client certificate, for servers requiring one"
+	tlsKeyDesc  = "PEM file of the client certificate key"
+
+	// Flag defaults.
+	defaultURL = "http://localhost:5993"
+)
+
+var (
+	// Cmd is the backup command.
+	Cmd = &cobra.Command{
+		Use:     usage,
+		Short:   short,
+		Long:    long,
+		Aliases: []string{"snapshot"},
+		Example: example,
+		RunE:    executeBackup,
+	}
+
+	// Available flags.
+	url, dir, apiKey       string
+	tlsCA, tlsCert, tlsKey string
+)
+
+func init() {
+	// Parse flags.
+	Cmd.Flags().StringVarP(&url, "url", "u", defaultURL, urlDesc)
+	Cmd.Flags().StringVarP(&dir, "dir", "d", "", dirDesc)
+	Cmd.MarkFlagRequired("dir")
+	Cmd.Flags().StringVar(&apiKey, "api-key", "", apiKeyDesc)
+	Cmd.Flags().StringVar(&tlsCA, "tls-ca", "", tlsCADesc)
+	Cmd.Flags().StringVar(&tlsCert, "tls-cert", "", tlsCertDesc)
+	Cmd.Flags().StringVar(&tlsKey, "tls-key", "", tlsKeyDesc)
+}
+
+func executeBackup(cmd *cobra.Command, args []string) error {
+	cl, err := client.NewClient(url)
+	if err != nil {
+		return err
+	}
+	cl.APIKey = apiKey
+	if tlsCA != "" || tlsCert != "" {
+		var tlsConfig *tls.Config
+		if tlsConfig, err = client.NewTLSConfig(tlsCA, tlsCert, tlsKey); err != nil {
+			return err
+		}
+		cl.TLSConfig = tlsConfig
+	}
+
+	resp, err := cl.DoRPC("Backup", &frontend.BackupRequest{Dir: dir})
+	if err != nil {
+		return err
+	}
+	result := resp.(*frontend.BackupResponse)
+	fmt.Printf("Snapshot of %d files (%d bytes) written to %s\n", result.Files, result.Bytes, result.Dir)
+	return nil
+}
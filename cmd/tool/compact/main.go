@@ -0,0 +1,104 @@
+package compact
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	usage   = "compact"
+	short   = "Convert past year files to the compressed columnar format"
+	long    = "This command converts the FIXED year files of past years to the compressed columnar format, which only stores the written records. The files stay readable as before, and are expanded back when written to. The VARIABLE year files of all years are rewritten without the payloads orphaned by rewrites and deletes. The server must be stopped."
+	example = "marketstore tool compact --dir <path> --before 2018"
+
+	// Flag descriptions.
+	rootDirPathDesc = "set filesystem path of the directory containing the files to convert"
+	beforeDesc      = "convert the files of years before this one, default is the current year"
+	expandDesc      = "convert the compressed files back to the dense format instead, default is false"
+)
+
+var (
+	// Cmd is the compact command.
+	Cmd = &cobra.Command{
+		Use:     usage,
+		Short:   short,
+		Long:    long,
+		Example: example,
+		RunE:    executeCompact,
+	}
+
+	// Available flags.
+	rootDirPath string
+	before      int
+	expand      bool
+)
+
+func init() {
+	// Parse flags.
+	Cmd.Flags().StringVarP(&rootDirPath, "dir", "d", "", rootDirPathDesc)
+	Cmd.MarkFlagRequired("dir")
+	Cmd.Flags().IntVar(&before, "before", time.Now().Year(), beforeDesc)
+	Cmd.Flags().BoolVar(&expand, "expand", false, expandDesc)
+}
+
+func executeCompact(cmd *cobra.Command, args []string) error {
+	var files int
+	var sizeBefore, sizeAfter int64
+	err := filepath.Walk(filepath.Clean(rootDirPath), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".bin" {
+			return err
+		}
+		tbi, err := io.ReadTimeBucketInfo(path)
+		if err != nil {
+			return err
+		}
+		if tbi.GetRecordType() == io.VARIABLE && !expand {
+			b, a, err := executor.ReclaimFile(path)
+			if err != nil {
+				return err
+			}
+			files++
+			sizeBefore += b
+			sizeAfter += a
+			return nil
+		}
+		if int(tbi.Year) >= before {
+			return nil
+		}
+		if expand {
+			expanded, err := executor.ExpandFile(path)
+			if err == nil && expanded {
+				files++
+			}
+			return err
+		}
+		if tbi.GetRecordType() != io.FIXED || tbi.GetFormat() == io.COLUMNAR {
+			Log(INFO, "Skipping %s", path)
+			return nil
+		}
+		b, a, err := executor.CompactFile(path)
+		if err != nil {
+			return err
+		}
+		files++
+		sizeBefore += b
+		sizeAfter += a
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if expand {
+		fmt.Printf("Expanded %d files\n", files)
+	} else {
+		fmt.Printf("Compacted %d files from %d to %d bytes\n", files, sizeBefore, sizeAfter)
+	}
+	return nil
+}
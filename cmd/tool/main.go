@@ -1,7 +1,10 @@
 package tool
 
 import (
+	"github.com/dannyluong408/marketstore/cmd/tool/backup"
+	"github.com/dannyluong408/marketstore/cmd/tool/compact"
 	"github.com/dannyluong408/marketstore/cmd/tool/integrity"
+	"github.com/dannyluong408/marketstore/cmd/tool/restore"
 	"github.com/dannyluong408/marketstore/cmd/tool/wal"
 	"github.com/spf13/cobra"
 )
@@ -19,7 +22,7 @@ var (
 		Use:        usage,
 		Short:      short,
 		Long:       long,
-		SuggestFor: []string{"wal", "integrity"},
+		SuggestFor: []string{"wal", "integrity", "backup", "restore", "compact"},
 		Example:    example,
 	}
 )
@@ -27,4 +30,7 @@ var (
 func init() {
 	Cmd.AddCommand(integrity.Cmd)
 	Cmd.AddCommand(wal.Cmd)
+	Cmd.AddCommand(backup.Cmd)
+	Cmd.AddCommand(restore.Cmd)
+	Cmd.AddCommand(compact.Cmd)
 }
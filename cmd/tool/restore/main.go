@@ -0,0 +1,64 @@
+package restore
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/spf13/cobra"
+)
+
+const (
+	usage   = "restore"
+	short   = "Restore a database from a snapshot"
+	long    = "This command verifies the headers of a snapshot written by the backup tool and copies it into a root directory, which must be empty, for a stopped instance to start from"
+	example = "marketstore tool restore --snapshot <path> --dir <path>"
+
+	// Flag descriptions.
+	snapshotDesc = "set the snapshot directory to restore"
+	rootDirDesc  = "set the root directory to restore into, it must be empty or not exist yet"
+	verifyDesc   = "only verify the snapshot, default is false"
+)
+
+var (
+	// Cmd is the restore command.
+	Cmd = &cobra.Command{
+		Use:     usage,
+		Short:   short,
+		Long:    long,
+		Example: example,
+		RunE:    executeRestore,
+	}
+
+	// Available flags.
+	snapshotDir, rootDir string
+	verifyOnly           bool
+)
+
+func init() {
+	// Parse flags.
+	Cmd.Flags().StringVarP(&snapshotDir, "snapshot", "s", "", snapshotDesc)
+	Cmd.MarkFlagRequired("snapshot")
+	Cmd.Flags().StringVarP(&rootDir, "dir", "d", "", rootDirDesc)
+	Cmd.Flags().BoolVar(&verifyOnly, "verify", false, verifyDesc)
+}
+
+func executeRestore(cmd *cobra.Command, args []string) error {
+	snapshotDir = filepath.Clean(snapshotDir)
+	if verifyOnly {
+		if err := executor.VerifySnapshot(snapshotDir); err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot %s is valid\n", snapshotDir)
+		return nil
+	}
+	if rootDir == "" {
+		return fmt.Errorf("the --dir flag is required to restore")
+	}
+	files, size, err := executor.RestoreSnapshot(snapshotDir, filepath.Clean(rootDir))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d files (%d bytes) into %s\n", files, size, rootDir)
+	return nil
+}
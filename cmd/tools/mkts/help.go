@@ -62,7 +62,7 @@ func processHelp(line string) {
 		fmt.Println(`
 		usage: help command_name
 
-		Available commands: show, trim, gaps, load, create, feed`)
+		Available commands: show, trim, gaps, load, create, alter, feed`)
 
 	case "create":
 		fmt.Println(`
@@ -98,6 +98,29 @@ func processHelp(line string) {
 
 			<row type> = variable`)
 
+	case "alter":
+		fmt.Println(`
+		Syntax:
+
+			>> \alter <key> <row data shape spec>
+
+		- Example: We add a VWAP column to the 1 minute candles of TSLA:
+
+			>> \alter TSLA/1Min/OHLCV Open,High,Low,Close/float32:Volume/int32:VWAP/float64
+
+		where:
+
+		<key>: The bucket to alter, with or without its category key
+
+		<row data shape spec>: All the columns of the bucket after the change, in the format
+			of the create command. The columns are matched by name to the current ones:
+			- a new column is filled with zeros in the existing records
+			- a column left out is dropped with its data
+			- a column given another type has its values converted, a narrower type truncates
+
+		Each year file of the bucket is rewritten, which can take a while on large buckets.
+		Writes to the database wait until it is done.`)
+
 	default:
 		fmt.Printf("	No help available for %s...\n", helpKey)
 	}
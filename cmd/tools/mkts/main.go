@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"flag"
@@ -46,6 +47,7 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("\\show"),
 	readline.PcItem("\\load"),
 	readline.PcItem("\\create"),
+	readline.PcItem("\\alter"),
 	readline.PcItem("\\trim"),
 	readline.PcItem("\\help"),
 	readline.PcItem("\\exit"),
@@ -125,6 +127,8 @@ func main() {
 			processLoad(line)
 		case strings.HasPrefix(line, "\\create"):
 			processCreate(line)
+		case strings.HasPrefix(line, "\\alter"):
+			processAlter(line)
 		case strings.HasPrefix(line, "\\help") || strings.HasPrefix(line, "\\?"):
 			processHelp(line)
 		case line == "help":
@@ -176,7 +180,7 @@ func processSQLLocal(line string) (cs *ColumnSeries, err error) {
 	if err != nil {
 		return nil, err
 	}
-	cs, err = es.Materialize()
+	cs, err = es.Materialize(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -277,6 +281,53 @@ func processCreate(line string) {
 	fmt.Printf("Successfully created a new catalog entry: %s\n", tbk.GetItemKey())
 }
 
+func processAlter(line string) {
+	args := strings.Split(line, " ")
+	if len(args) != 3 {
+		fmt.Println("Need a key and a row data shape spec, see \"\\help alter\" ")
+		return
+	}
+	tbk := NewTimeBucketKeyFromString(args[1])
+	if tbk == nil {
+		fmt.Println("Key is not in proper format, see \"\\help alter\" ")
+		return
+	}
+	dsv, err := DataShapesFromInputString(args[2])
+	if err != nil {
+		return
+	}
+
+	if localMode {
+		err = executor.AlterSchema(tbk, dsv)
+	} else {
+		err = processAlterRemote(args[1], args[2])
+	}
+	if err != nil {
+		fmt.Printf("Error: Alter of %s failed: %s\n", tbk.GetItemKey(), err.Error())
+		return
+	}
+	fmt.Printf("Successfully altered the data shapes of %s\n", tbk.GetItemKey())
+}
+
+func processAlterRemote(key, dataShapes string) error {
+	req := frontend.AlterSchemaRequest{Key: key, DataShapes: dataShapes}
+	args := &frontend.MultiAlterSchemaRequest{Requests: []frontend.AlterSchemaRequest{req}}
+	cl, err := client.NewClient(baseURL)
+	if err != nil {
+		return err
+	}
+	resp, err := cl.DoRPC("AlterSchema", args)
+	if err != nil {
+		return err
+	}
+	for _, sub := range resp.(*frontend.MultiServerResponse).Responses {
+		if len(sub.Error) != 0 {
+			return errors.New(sub.Error)
+		}
+	}
+	return nil
+}
+
 func processTrim(line string) {
 	Log(INFO, "Trimming...")
 	args := strings.Split(line, " ")
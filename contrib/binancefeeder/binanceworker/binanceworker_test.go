@@ -1,4 +1,4 @@
-package main
+package binanceworker
 
 import (
 	"encoding/json"
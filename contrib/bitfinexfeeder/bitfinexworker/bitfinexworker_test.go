@@ -1,10 +1,10 @@
-package main
+package bitfinexworker
 
 import (
 	"encoding/json"
 	"testing"
 
-	"github.com/alpacahq/marketstore/plugins/bgworker"
+	"github.com/dannyluong408/marketstore/plugins/bgworker"
 	. "gopkg.in/check.v1"
 )
 
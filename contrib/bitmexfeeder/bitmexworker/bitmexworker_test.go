@@ -1,4 +1,4 @@
-package main
+package bitmexworker
 
 import (
 	"encoding/json"
@@ -0,0 +1,19 @@
+// Package builtin links the contrib triggers and bgworkers into the binary
+// importing it, so that they can be configured by name without building the
+// .so plugin modules.
+//
+// 	triggers:
+// 	  - module: ondiskagg
+// 	    on: "*/1Min/OHLCV"
+//
+// The triggers are always included.  The feeders pull in the client library
+// of their upstream API, and are only included when building with the
+// "feeders" tag.
+//
+// 	$ go install -tags feeders ./...
+package builtin
+
+import (
+	_ "github.com/dannyluong408/marketstore/contrib/ondiskagg/aggtrigger"
+	_ "github.com/dannyluong408/marketstore/contrib/stream/streamtrigger"
+)
@@ -0,0 +1,13 @@
+//go:build feeders
+// +build feeders
+
+package builtin
+
+import (
+	_ "github.com/dannyluong408/marketstore/contrib/binancefeeder/binanceworker"
+	_ "github.com/dannyluong408/marketstore/contrib/bitfinexfeeder/bitfinexworker"
+	_ "github.com/dannyluong408/marketstore/contrib/bitmexfeeder/bitmexworker"
+	_ "github.com/dannyluong408/marketstore/contrib/gdaxfeeder/gdaxworker"
+	_ "github.com/dannyluong408/marketstore/contrib/polygon/polygonworker"
+	_ "github.com/dannyluong408/marketstore/contrib/slait/slaitworker"
+)
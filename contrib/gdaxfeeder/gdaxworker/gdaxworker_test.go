@@ -1,4 +1,4 @@
-package main
+package gdaxworker
 
 import (
 	"encoding/json"
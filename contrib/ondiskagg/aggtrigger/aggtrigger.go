@@ -9,7 +9,7 @@
 //
 // Example:
 // 	triggers:
-// 	  - module: ondiskagg.so
+// 	  - module: ondiskagg
 // 	    on: */1Min/OHLCV
 // 	    config:
 // 	      filter: "nasdaq"
@@ -21,6 +21,9 @@
 //
 // destinations are downsample target time windows.  Optionally, if filter
 // is set to "nasdaq", it filters the scan data by NASDAQ market hours.
+//
+// Importing this package registers the trigger as "ondiskagg".  The module
+// can also be built as ondiskagg.so from the parent directory.
 package aggtrigger
 
 import (
@@ -58,9 +61,15 @@ type OnDiskAggTrigger struct {
 }
 
 var _ trigger.Trigger = &OnDiskAggTrigger{}
+var _ trigger.DeleteTrigger = &OnDiskAggTrigger{}
+var _ trigger.EventTrigger = &OnDiskAggTrigger{}
 
 var loadError = errors.New("plugin load error")
 
+func init() {
+	trigger.Register("ondiskagg", NewTrigger)
+}
+
 func recast(config map[string]interface{}) *AggTriggerConfig {
 	data, _ := json.Marshal(config)
 	ret := AggTriggerConfig{}
@@ -183,6 +192,90 @@ Query:
 	return
 }
 
+// FireDelete implements trigger.DeleteTrigger. The aggregates overlapping
+// the deleted range are removed and recomputed from the remaining base data.
+func (s *OnDiskAggTrigger) FireDelete(keyPath string, startIndex, endIndex int64) {
+	elements := strings.Split(keyPath, "/")
+	tf := utils.NewTimeframe(elements[1])
+	fileName := elements[len(elements)-1]
+	year, _ := strconv.Atoi(strings.Replace(fileName, ".bin", "", 1))
+	tbk := io.NewTimeBucketKey(strings.Join(elements[:len(elements)-1], "/"))
+
+	head := io.IndexToTime(startIndex, tf.Duration, int16(year))
+	tail := io.IndexToTime(endIndex, tf.Duration, int16(year))
+
+	// the cached base data may hold the deleted records
+	s.aggCache.Delete(tbk.String())
+
+	cDir := executor.ThisInstance.CatalogDir
+	for _, dest := range s.destinations {
+		aggTbk := io.NewTimeBucketKeyFromString(elements[0] + "/" + dest.String + "/" + elements[2])
+		if _, err := cDir.GetLatestTimeBucketInfoFromKey(aggTbk); err != nil {
+			// nothing has been aggregated yet
+			continue
+		}
+
+		window := utils.CandleDurationFromString(dest.String)
+		start := window.Truncate(head)
+		end := window.Ceil(tail).Add(-time.Second)
+
+		if err := executor.DeleteRange(aggTbk, start, end); err != nil {
+			glog.Errorf(
+				"failed to delete %v aggregates (%v)",
+				aggTbk.String(),
+				err)
+			return
+		}
+	}
+
+	window := utils.CandleDurationFromString(s.destinations.UpperBound().String)
+
+	csm, err := s.query(tbk, window, head, tail)
+	if err != nil || csm == nil {
+		// the whole window may have been deleted
+		return
+	}
+
+	if cs := (*csm)[*tbk]; cs != nil && cs.Len() > 0 {
+		s.write(tbk, cs, tail, head, elements)
+	}
+}
+
+// FireEvent implements trigger.EventTrigger.  Writes and range deletes are
+// handled by Fire and FireDelete, and destroying the base bucket destroys
+// its aggregates as well.
+func (s *OnDiskAggTrigger) FireEvent(ev trigger.Event) {
+	switch ev.Type {
+	case trigger.Write:
+		s.Fire(ev.KeyPath, ev.Records)
+	case trigger.DeleteRange:
+		s.FireDelete(ev.KeyPath, ev.StartIndex, ev.EndIndex)
+	case trigger.Destroy:
+		s.destroy(ev.KeyPath)
+	}
+}
+
+func (s *OnDiskAggTrigger) destroy(itemKey string) {
+	elements := strings.Split(itemKey, "/")
+	tbk := io.NewTimeBucketKey(itemKey)
+	s.aggCache.Delete(tbk.String())
+
+	cDir := executor.ThisInstance.CatalogDir
+	for _, dest := range s.destinations {
+		aggTbk := io.NewTimeBucketKeyFromString(elements[0] + "/" + dest.String + "/" + elements[2])
+		if _, err := cDir.GetLatestTimeBucketInfoFromKey(aggTbk); err != nil {
+			// nothing has been aggregated
+			continue
+		}
+		if err := executor.DestroyTimeBucket(aggTbk); err != nil {
+			glog.Errorf(
+				"failed to destroy %v aggregates (%v)",
+				aggTbk.String(),
+				err)
+		}
+	}
+}
+
 func (s *OnDiskAggTrigger) write(
 	tbk *io.TimeBucketKey,
 	cs *io.ColumnSeries,
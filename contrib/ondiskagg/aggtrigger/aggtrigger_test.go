@@ -209,4 +209,11 @@ func (t *TestSuite) TestFire(c *C) {
 	c.Assert(t1.Equal(time.Date(2017, 12, 14, 0, 0, 0, 0, utils.InstanceConfig.Timezone)), Equals, true)
 	t2 := time.Unix(cs1D.GetEpoch()[1], 0).In(utils.InstanceConfig.Timezone)
 	c.Assert(t2.Equal(time.Date(2017, 12, 15, 0, 0, 0, 0, utils.InstanceConfig.Timezone)), Equals, true)
+
+	// destroying the base bucket destroys the aggregates
+	trig.(trigger.EventTrigger).FireEvent(trigger.Event{Type: trigger.Destroy, KeyPath: "TEST/1Min/OHLC"})
+	_, err = catalogDir.GetLatestTimeBucketInfoFromKey(tbk5)
+	c.Check(err, NotNil)
+	_, err = catalogDir.GetLatestTimeBucketInfoFromKey(tbk1D)
+	c.Check(err, NotNil)
 }
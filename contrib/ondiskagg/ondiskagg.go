@@ -1,4 +1,4 @@
-// This is a shim package for buiding a plugin module wrapping
+// This is a shim package for building a plugin module wrapping
 // the importable aggtrigger package.  For more details, see aggtrigger.
 package main
 
@@ -1,4 +1,4 @@
-package main
+package slaitworker
 
 import (
 	"encoding/json"
@@ -71,6 +71,20 @@ func (s *Shelf) Store(tbk *io.TimeBucketKey, data interface{}, deadline time.Tim
 	s.m[tbk] = p
 }
 
+// Remove stops and removes the packages stored for the TimeBucketKey, so that
+// the handler is not executed on them anymore
+func (s *Shelf) Remove(tbk *io.TimeBucketKey) {
+	s.Lock()
+	defer s.Unlock()
+
+	for key, p := range s.m {
+		if *key == *tbk {
+			p.Stop()
+			delete(s.m, key)
+		}
+	}
+}
+
 // Package is a data entry with a context to ensure async
 // execution or cancellation if necessary
 type Package struct {
@@ -95,6 +95,30 @@ func (s *ShelfTestSuite) TestShelf(c *C) {
 	}
 }
 
+func (s *ShelfTestSuite) TestRemove(c *C) {
+	expC := make(chan io.TimeBucketKey, 2)
+	h := NewShelfHandler(func(tbk io.TimeBucketKey, data interface{}) error {
+		expC <- tbk
+		return nil
+	})
+
+	shelf := NewShelf(h)
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+	shelf.Store(io.NewTimeBucketKey("AAPL/5Min/OHLCV"), genColumns(), deadline)
+	shelf.Store(io.NewTimeBucketKey("TSLA/5Min/OHLCV"), genColumns(), deadline)
+
+	// removed by an equal key, not only the stored one
+	shelf.Remove(io.NewTimeBucketKey("AAPL/5Min/OHLCV"))
+
+	c.Assert(<-expC, Equals, *io.NewTimeBucketKey("TSLA/5Min/OHLCV"))
+	select {
+	case tbk := <-expC:
+		c.Fatalf("removed package for %v expired", tbk)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func genColumns() map[string]interface{} {
 	return map[string]interface{}{
 		"Open":   float32(1.0),
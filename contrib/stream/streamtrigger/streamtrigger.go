@@ -1,8 +1,13 @@
+// Package streamtrigger implements a trigger pushing the written data through
+// the streaming interface.  Importing this package registers the trigger as
+// "stream".  The module can also be built as stream.so from the parent
+// directory.
 package streamtrigger
 
 import (
 	"encoding/json"
 	"errors"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -27,6 +32,10 @@ var _ trigger.Trigger = &StreamTrigger{}
 
 var loadError = errors.New("plugin load error")
 
+func init() {
+	trigger.Register("stream", NewTrigger)
+}
+
 func recast(config map[string]interface{}) *StreamTriggerConfig {
 	data, _ := json.Marshal(config)
 	ret := StreamTriggerConfig{}
@@ -54,6 +63,7 @@ type StreamTrigger struct {
 }
 
 var _ trigger.Trigger = &StreamTrigger{}
+var _ trigger.EventTrigger = &StreamTrigger{}
 
 func maxInt64(values []int64) int64 {
 	max := values[0]
@@ -141,6 +151,20 @@ func (s *StreamTrigger) Fire(keyPath string, records []trigger.Record) {
 	}
 }
 
+// FireEvent streams the writes like Fire.  Deletes and destroys drop the
+// aggregates of the bucket waiting on the shelf, as they may no longer
+// exist by the time they would be pushed.
+func (s *StreamTrigger) FireEvent(ev trigger.Event) {
+	switch ev.Type {
+	case trigger.Write:
+		s.Fire(ev.KeyPath, ev.Records)
+	case trigger.DeleteRange:
+		s.shelf.Remove(io.NewTimeBucketKey(path.Dir(ev.KeyPath)))
+	case trigger.Destroy:
+		s.shelf.Remove(io.NewTimeBucketKey(ev.KeyPath))
+	}
+}
+
 // ColumnSeriesForPayload extracts the single row from the column
 // series that is queried by the trigger, to prepare it for a
 // streaming payload.
@@ -2,13 +2,16 @@ package executor
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -537,6 +540,885 @@ func (s *TestSuite) TestAddSymbolThenWrite(c *C) {
 	}
 }
 
+func (s *TestSuite) TestDeleteRange(c *C) {
+	d := ThisInstance.CatalogDir
+	tbk := NewTimeBucketKey("DEL/1Min/OHLCV")
+	dsv := NewDataShapeVector(
+		[]string{"Open", "High", "Low", "Close", "Volume"},
+		[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+	)
+	tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+		"Test", 2016, dsv, FIXED)
+	err := d.AddTimeBucket(tbk, tbinfo)
+	c.Assert(err, IsNil)
+	tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+	c.Assert(err, IsNil)
+
+	base := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	row := OHLCVtest{0, 100., 200., 300., 400., 1000}
+	buffer, _ := Serialize([]byte{}, row)
+	for ii := 0; ii < 10; ii++ {
+		w.WriteRecords([]time.Time{base.Add(time.Duration(ii) * time.Minute)}, buffer)
+	}
+	err = ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe)
+	c.Assert(err, IsNil)
+
+	read := func() []int64 {
+		q := NewQuery(d)
+		q.AddTargetKey(tbk)
+		q.SetRange(base.Unix(), base.Add(time.Hour).Unix())
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk].GetEpoch()
+	}
+	c.Assert(read(), HasLen, 10)
+
+	// end before start is rejected
+	err = DeleteRange(tbk, base.Add(time.Minute), base)
+	c.Assert(err, NotNil)
+
+	// remove minutes 2 through 5, the bounds are inclusive
+	err = DeleteRange(tbk, base.Add(90*time.Second), base.Add(5*time.Minute))
+	c.Assert(err, IsNil)
+	epochs := read()
+	c.Assert(epochs, HasLen, 6)
+	c.Assert(epochs[1], Equals, base.Add(time.Minute).Unix())
+	c.Assert(epochs[2], Equals, base.Add(6*time.Minute).Unix())
+
+	// deleting an already empty range is a no-op
+	err = DeleteRange(tbk, base.Add(2*time.Minute), base.Add(5*time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(read(), HasLen, 6)
+
+	// a removed slot can be written again
+	w.WriteRecords([]time.Time{base.Add(3 * time.Minute)}, buffer)
+	err = ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe)
+	c.Assert(err, IsNil)
+	c.Assert(read(), HasLen, 7)
+
+	// a write still queued to an empty slot of the range is removed too
+	w.WriteRecords([]time.Time{base.Add(4 * time.Minute)}, buffer)
+	c.Assert(DeleteRange(tbk, base.Add(3*time.Minute), base.Add(4*time.Minute)), IsNil)
+	c.Assert(read(), HasLen, 6)
+
+	// a delete of more runs than a transaction group holds is written in
+	// several, without going through the TransactionPipe
+	var times []time.Time
+	var data []byte
+	for ii := 0; ii < deleteBatchWrites+100; ii++ {
+		times = append(times, base.Add(time.Duration(2*ii)*time.Minute))
+		data = append(data, buffer...)
+	}
+	w.WriteRecords(times, data)
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+	last := times[len(times)-1]
+	c.Assert(DeleteRange(tbk, base, last), IsNil)
+	c.Assert(ThisInstance.TXNPipe.writeChannel, HasLen, 0)
+	q := NewQuery(d)
+	q.AddTargetKey(tbk)
+	q.SetRange(base.Unix(), last.Unix())
+	pr, err := q.Parse()
+	c.Assert(err, IsNil)
+	rd, err := NewReader(pr)
+	c.Assert(err, IsNil)
+	csm, _, err := rd.Read()
+	c.Assert(err, IsNil)
+	c.Assert(csm[*tbk].Len(), Equals, 0)
+}
+
+func (s *TestSuite) TestExpireData(c *C) {
+	d := ThisInstance.CatalogDir
+	tbk := NewTimeBucketKey("EXP/1Min/OHLCV")
+	dsv := NewDataShapeVector(
+		[]string{"Open", "High", "Low", "Close", "Volume"},
+		[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+	)
+	tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+		"Test", 2014, dsv, FIXED)
+	err := d.AddTimeBucket(tbk, tbinfo)
+	c.Assert(err, IsNil)
+	tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+	c.Assert(err, IsNil)
+
+	base := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	row := OHLCVtest{0, 100., 200., 300., 400., 1000}
+	buffer, _ := Serialize([]byte{}, row)
+	for _, year := range []int{2014, 2015} {
+		w.WriteRecords([]time.Time{time.Date(year, 6, 1, 0, 0, 0, 0, time.UTC)}, buffer)
+	}
+	for ii := 0; ii < 10; ii++ {
+		w.WriteRecords([]time.Time{base.Add(time.Duration(ii) * time.Minute)}, buffer)
+	}
+	err = ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe)
+	c.Assert(err, IsNil)
+
+	read := func() []int64 {
+		q := NewQuery(d)
+		q.AddTargetKey(tbk)
+		q.SetRange(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), base.AddDate(1, 0, 0).Unix())
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk].GetEpoch()
+	}
+	c.Assert(read(), HasLen, 12)
+
+	// no policy matches the bucket
+	c.Assert(SetRetention([]*utils.RetentionSetting{{On: "EXP/1D/*", Period: time.Hour}}), IsNil)
+	c.Assert(ExpireData(base.AddDate(1, 0, 0)), IsNil)
+	c.Assert(read(), HasLen, 12)
+
+	// the first matching policy applies
+	c.Assert(SetRetention([]*utils.RetentionSetting{
+		{On: "EXP/*/*", Period: 24 * time.Hour},
+		{On: "EXP/1Min/*", Period: time.Hour},
+	}), IsNil)
+	defer SetRetention(nil)
+	c.Assert(ExpireData(base.Add(24*time.Hour+5*time.Minute)), IsNil)
+	epochs := read()
+	c.Assert(epochs, HasLen, 5)
+	c.Assert(epochs[0], Equals, base.Add(5*time.Minute).Unix())
+	for _, year := range []int{2014, 2015} {
+		_, err = os.Stat(filepath.Join(tbk.GetPathToYearFiles(s.Rootdir), fmt.Sprintf("%d.bin", year)))
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	// the next passes clear the records from the first one left, with or
+	// without the presence bitmap of the file
+	tbi, err = d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	firstIndex := TimeToIndex(base.Add(5*time.Minute), tbi.GetTimeframe())
+	index, ok, err := firstRecordIndex(tbi, firstIndex+100)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(index, Equals, firstIndex)
+	c.Assert(os.Rename(PresencePath(tbi.Path), PresencePath(tbi.Path)+".moved"), IsNil)
+	index, ok, err = firstRecordIndex(tbi, firstIndex+100)
+	c.Assert(os.Rename(PresencePath(tbi.Path)+".moved", PresencePath(tbi.Path)), IsNil)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(index, Equals, firstIndex)
+	_, ok, err = firstRecordIndex(tbi, firstIndex-1)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	// the latest file is kept for new writes once all of its data expired
+	c.Assert(ExpireData(base.AddDate(1, 0, 2)), IsNil)
+	c.Assert(read(), HasLen, 0)
+	tbi, err = d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	c.Assert(tbi.Year, Equals, int16(2016))
+}
+
+func (s *TestSuite) TestSnapshot(c *C) {
+	d := ThisInstance.CatalogDir
+	tbk := NewTimeBucketKey("SNAP/1Min/OHLCV")
+	dsv := NewDataShapeVector(
+		[]string{"Open", "High", "Low", "Close", "Volume"},
+		[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+	)
+	tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+		"Test", 2016, dsv, FIXED)
+	err := d.AddTimeBucket(tbk, tbinfo)
+	c.Assert(err, IsNil)
+	tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+	c.Assert(err, IsNil)
+
+	// the pending writes are part of the snapshot
+	base := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	row := OHLCVtest{0, 100., 200., 300., 400., 1000}
+	buffer, _ := Serialize([]byte{}, row)
+	for ii := 0; ii < 10; ii++ {
+		w.WriteRecords([]time.Time{base.Add(time.Duration(ii) * time.Minute)}, buffer)
+	}
+
+	_, _, err = Snapshot(filepath.Join(s.Rootdir, "snap"))
+	c.Assert(err, ErrorMatches, "Snapshot directory .* is inside the root directory .*")
+	snapDir := filepath.Join(c.MkDir(), "snap")
+	files, size, err := Snapshot(snapDir)
+	c.Assert(err, IsNil)
+	c.Assert(files > 0, Equals, true)
+	c.Assert(size > 0, Equals, true)
+	_, _, err = Snapshot(snapDir)
+	c.Assert(err, ErrorMatches, "Snapshot directory .* already exists")
+	walFiles, _ := filepath.Glob(filepath.Join(snapDir, "*.walfile"))
+	c.Assert(walFiles, HasLen, 0)
+	c.Assert(VerifySnapshot(snapDir), IsNil)
+
+	rootDir := filepath.Join(c.MkDir(), "mktsdb")
+	restored, _, err := RestoreSnapshot(snapDir, rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(restored, Equals, files)
+	_, _, err = RestoreSnapshot(snapDir, rootDir)
+	c.Assert(err, ErrorMatches, "Root directory .* is not empty")
+
+	rd := NewDirectory(rootDir)
+	q := NewQuery(rd)
+	q.AddTargetKey(tbk)
+	q.SetRange(base.Unix(), base.Add(time.Hour).Unix())
+	pr, err := q.Parse()
+	c.Assert(err, IsNil)
+	reader, err := NewReader(pr)
+	c.Assert(err, IsNil)
+	csm, _, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(csm[*tbk].GetEpoch(), HasLen, 10)
+
+	// a year file whose header does not match its name fails verification
+	yearFile := filepath.Join(tbk.GetPathToYearFiles(snapDir), "2016.bin")
+	err = os.Rename(yearFile, filepath.Join(tbk.GetPathToYearFiles(snapDir), "2017.bin"))
+	c.Assert(err, IsNil)
+	c.Assert(VerifySnapshot(snapDir), ErrorMatches, "Header year 2016 does not match the file name .*")
+	_, _, err = RestoreSnapshot(snapDir, c.MkDir())
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestCompactFile(c *C) {
+	d := ThisInstance.CatalogDir
+	tbk := NewTimeBucketKey("COMP/1Min/OHLCV")
+	dsv := NewDataShapeVector(
+		[]string{"Open", "High", "Low", "Close", "Volume"},
+		[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+	)
+	tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+		"Test", 2016, dsv, FIXED)
+	err := d.AddTimeBucket(tbk, tbinfo)
+	c.Assert(err, IsNil)
+	tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+	c.Assert(err, IsNil)
+
+	// enough records for several chunks, with gaps
+	base := time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC)
+	for ii := 0; ii < 3*ColumnarChunkRecords; ii++ {
+		row := OHLCVtest{0, float32(ii) / 4, float32(ii) + 1, 100., float32(ii % 7), int32(1000 - ii)}
+		buffer, _ := Serialize([]byte{}, row)
+		w.WriteRecords([]time.Time{base.Add(time.Duration(ii+ii/5) * time.Minute)}, buffer)
+	}
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+
+	read := func(start, end time.Time, direction DirectionEnum, limit int) *ColumnSeries {
+		q := NewQuery(d)
+		q.AddTargetKey(tbk)
+		q.SetRange(start.Unix(), end.Unix())
+		if limit != 0 {
+			q.SetRowLimit(direction, limit)
+		}
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk]
+	}
+	mid := base.Add(5000 * time.Minute)
+	end := base.AddDate(0, 1, 0)
+	queries := []func() *ColumnSeries{
+		func() *ColumnSeries { return read(base, end, FIRST, 0) },
+		func() *ColumnSeries { return read(mid, end, FIRST, 100) },
+		func() *ColumnSeries { return read(base, mid, LAST, 100) },
+		func() *ColumnSeries { return read(base, end, LAST, 5000) },
+	}
+	var dense []*ColumnSeries
+	for _, query := range queries {
+		dense = append(dense, query())
+	}
+	c.Assert(dense[0].Len(), Equals, 3*ColumnarChunkRecords)
+
+	path := tbi.Path
+	before, after, err := CompactFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(after < before/10, Equals, true)
+	tbi, err = ReadTimeBucketInfo(path)
+	c.Assert(err, IsNil)
+	c.Assert(tbi.GetFormat(), Equals, COLUMNAR)
+	for i, query := range queries {
+		cs := query()
+		c.Assert(cs.Len(), Equals, dense[i].Len())
+		for _, name := range []string{"Epoch", "Open", "High", "Low", "Close", "Volume"} {
+			c.Assert(cs.GetByName(name), DeepEquals, dense[i].GetByName(name))
+		}
+	}
+
+	// deleting expands the file back
+	c.Assert(DeleteRange(tbk, base, mid), IsNil)
+	tbi, err = ReadTimeBucketInfo(path)
+	c.Assert(err, IsNil)
+	c.Assert(tbi.GetFormat(), Equals, DENSE)
+	var remaining int
+	for _, epoch := range dense[0].GetEpoch() {
+		if epoch > mid.Unix() {
+			remaining++
+		}
+	}
+	epochs := read(base, end, FIRST, 0).GetEpoch()
+	c.Assert(epochs, HasLen, remaining)
+	c.Assert(epochs[0] > mid.Unix(), Equals, true)
+
+	// and so does writing
+	_, _, err = CompactFile(path)
+	c.Assert(err, IsNil)
+	row := OHLCVtest{0, 1., 2., 3., 4., 5}
+	buffer, _ := Serialize([]byte{}, row)
+	w.WriteRecords([]time.Time{base}, buffer)
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+	c.Assert(read(base, end, FIRST, 0).Len(), Equals, len(epochs)+1)
+}
+
+func (s *TestSuite) TestPresence(c *C) {
+	d := ThisInstance.CatalogDir
+	tbk := NewTimeBucketKey("PRES/1Min/OHLCV")
+	dsv := NewDataShapeVector(
+		[]string{"Open", "High", "Low", "Close", "Volume"},
+		[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+	)
+	tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+		"Test", 2016, dsv, FIXED)
+	err := d.AddTimeBucket(tbk, tbinfo)
+	c.Assert(err, IsNil)
+	tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(PresencePath(tbi.Path))
+	c.Assert(err, IsNil)
+	w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+	c.Assert(err, IsNil)
+
+	// a zero row, and sparse rows far apart
+	base := time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{base, base.Add(time.Minute), base.AddDate(0, 3, 0), base.AddDate(0, 9, 0)}
+	for i, t := range times {
+		row := OHLCVtest{0, float32(i), 0, 0, 0, 0}
+		buffer, _ := Serialize([]byte{}, row)
+		w.WriteRecords([]time.Time{t}, buffer)
+	}
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+
+	indexOf := func(t time.Time) int64 { return TimeToIndex(t, time.Minute) }
+	runs := func() [][2]int64 {
+		p, err := ReadPresence(tbi.Path, 1, indexOf(base.AddDate(0, 10, 0)))
+		c.Assert(err, IsNil)
+		return p.Runs(1, indexOf(base.AddDate(0, 10, 0)), 0)
+	}
+	c.Assert(runs(), DeepEquals, [][2]int64{
+		{indexOf(times[0]), indexOf(times[1])},
+		{indexOf(times[2]), indexOf(times[2])},
+		{indexOf(times[3]), indexOf(times[3])},
+	})
+
+	read := func(direction DirectionEnum, limit int) []int64 {
+		q := NewQuery(d)
+		q.AddTargetKey(tbk)
+		q.SetRange(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), base.AddDate(0, 10, 0).Unix())
+		q.SetRowLimit(direction, limit)
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk].GetEpoch()
+	}
+	c.Assert(read(FIRST, 10), DeepEquals, []int64{times[0].Unix(), times[1].Unix(), times[2].Unix(), times[3].Unix()})
+	c.Assert(read(FIRST, 3), DeepEquals, []int64{times[0].Unix(), times[1].Unix(), times[2].Unix()})
+	c.Assert(read(LAST, 2), DeepEquals, []int64{times[2].Unix(), times[3].Unix()})
+
+	// deletes clear the bits
+	c.Assert(DeleteRange(tbk, times[2], times[2]), IsNil)
+	c.Assert(runs(), DeepEquals, [][2]int64{
+		{indexOf(times[0]), indexOf(times[1])},
+		{indexOf(times[3]), indexOf(times[3])},
+	})
+	c.Assert(read(LAST, 2), DeepEquals, []int64{times[1].Unix(), times[3].Unix()})
+
+	// files without a bitmap are scanned in full, and get one on the next write
+	c.Assert(os.Remove(PresencePath(tbi.Path)), IsNil)
+	c.Assert(read(FIRST, 10), HasLen, 3)
+	buffer, _ := Serialize([]byte{}, OHLCVtest{0, 1, 2, 3, 4, 5})
+	w.WriteRecords([]time.Time{times[2]}, buffer)
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+	c.Assert(runs(), HasLen, 3)
+	c.Assert(read(FIRST, 10), HasLen, 4)
+}
+
+func (s *TestSuite) TestPresenceFullYear(c *C) {
+	// The bitmap of a 1ms bucket has a bit for each of the 31.6 billion slots
+	// of the year, it is never read whole
+	tbk := NewTimeBucketKey("MILLI/1ms/PRICE")
+	base := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{
+		base.Add(time.Millisecond),
+		base.Add(2 * time.Millisecond),
+		base.AddDate(0, 6, 0).Add(500 * time.Millisecond),
+		base.AddDate(1, 0, 0).Add(-time.Millisecond),
+	}
+	epochs, nanos := make([]int64, len(times)), make([]int32, len(times))
+	prices := make([]float32, len(times))
+	for i, t := range times {
+		epochs[i], nanos[i], prices[i] = t.Unix(), int32(t.Nanosecond()), float32(i)
+	}
+	cs := NewColumnSeries()
+	cs.AddColumn("Epoch", epochs)
+	cs.AddColumn("Price", prices)
+	cs.AddColumn("Nanoseconds", nanos)
+	csm := NewColumnSeriesMap()
+	csm.AddColumnSeries(*tbk, cs)
+	c.Assert(WriteCSM(csm, false), IsNil)
+	c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+	// Not left for the other tests to copy
+	defer ThisInstance.CatalogDir.RemoveTimeBucket(tbk)
+
+	read := func(direction DirectionEnum, limit int) []float32 {
+		q := NewQuery(ThisInstance.CatalogDir)
+		q.AddTargetKey(tbk)
+		q.SetRange(base.Unix(), base.AddDate(1, 0, 0).Unix())
+		q.SetRowLimit(direction, limit)
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk].GetByName("Price").([]float32)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	c.Assert(read(FIRST, 10), DeepEquals, prices)
+	c.Assert(read(LAST, 1), DeepEquals, prices[3:])
+	runtime.ReadMemStats(&after)
+	c.Assert(after.TotalAlloc-before.TotalAlloc < 64<<20, Equals, true)
+}
+
+func (s *TestSuite) TestColumnQual(c *C) {
+	d := ThisInstance.CatalogDir
+	base := time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC)
+	// keeps the rows with an even Volume
+	evenVolume := func(cs *ColumnSeries) ([]bool, error) {
+		volume := cs.GetByName("Volume").([]int32)
+		keep := make([]bool, len(volume))
+		for i, v := range volume {
+			keep[i] = v%2 == 0
+		}
+		return keep, nil
+	}
+	for _, rt := range []EnumRecordType{FIXED, VARIABLE} {
+		tbk := NewTimeBucketKey(fmt.Sprintf("CQ%d/1Min/OHLCV", rt))
+		dsv := NewDataShapeVector(
+			[]string{"Open", "High", "Low", "Close", "Volume"},
+			[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+		)
+		tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+			"Test", 2016, dsv, rt)
+		err := d.AddTimeBucket(tbk, tbinfo)
+		c.Assert(err, IsNil)
+		tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+		c.Assert(err, IsNil)
+		w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+		c.Assert(err, IsNil)
+		for ii := 0; ii < 10; ii++ {
+			row := OHLCVtest{0, 100., 200., 300., 400., int32(ii)}
+			buffer, _ := Serialize([]byte{}, row)
+			w.WriteRecords([]time.Time{base.Add(time.Duration(ii) * time.Minute)}, buffer)
+		}
+		err = ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe)
+		c.Assert(err, IsNil)
+
+		q := NewQuery(d)
+		q.AddTargetKey(tbk)
+		q.SetRange(base.Unix(), base.Add(time.Hour).Unix())
+		q.AddColumnQual(evenVolume)
+		pr, err := q.Parse()
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		cs := csm[*tbk]
+		c.Assert(cs.GetByName("Volume"), DeepEquals, []int32{0, 2, 4, 6, 8})
+		if rt == FIXED {
+			c.Assert(cs.GetEpoch()[1], Equals, base.Add(2*time.Minute).Unix())
+		} else {
+			c.Assert(cs.GetByName("Nanoseconds"), HasLen, 5)
+		}
+	}
+}
+
+func (s *TestSuite) TestSubSecond(c *C) {
+	tbk := NewTimeBucketKey("TICK/100ms/PRICE")
+	base := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	times := make([]time.Time, 12)
+	epochs, nanos := make([]int64, 12), make([]int32, 12)
+	prices := make([]float32, 12)
+	for i := range times {
+		times[i] = base.Add(time.Duration(i) * 200 * time.Millisecond)
+		epochs[i], nanos[i] = times[i].Unix(), int32(times[i].Nanosecond())
+		prices[i] = float32(i)
+	}
+	cs := NewColumnSeries()
+	cs.AddColumn("Epoch", epochs)
+	cs.AddColumn("Price", prices)
+	cs.AddColumn("Nanoseconds", nanos)
+	csm := NewColumnSeriesMap()
+	csm.AddColumnSeries(*tbk, cs)
+	c.Assert(WriteCSM(csm, false), IsNil)
+
+	read := func(pr *ParseResult, err error) *ColumnSeries {
+		c.Assert(err, IsNil)
+		rd, err := NewReader(pr)
+		c.Assert(err, IsNil)
+		csm, _, err := rd.Read()
+		c.Assert(err, IsNil)
+		return csm[*tbk]
+	}
+	q := NewQuery(ThisInstance.CatalogDir)
+	q.AddTargetKey(tbk)
+	q.SetRange(base.Unix(), base.Add(2*time.Second).Unix())
+	result := read(q.Parse())
+	c.Assert(result.GetEpoch(), DeepEquals, epochs)
+	c.Assert(result.GetByName("Nanoseconds"), DeepEquals, nanos)
+	c.Assert(result.GetByName("Price"), DeepEquals, prices)
+
+	// The range ends within the end second, and the limit counts records
+	q = NewQuery(ThisInstance.CatalogDir)
+	q.AddTargetKey(tbk)
+	q.SetStartTime(times[1])
+	q.SetEndTime(times[9].Add(-time.Millisecond))
+	q.SetRowLimit(LAST, 3)
+	result = read(q.Parse())
+	c.Assert(result.GetByName("Price"), DeepEquals, prices[6:9])
+	c.Assert(result.GetTime()[0].Equal(times[6]), Equals, true)
+
+	// A cursor resumes within a second
+	qc, err := NewQueryCursor(context.Background(), tbk, base.Unix(), base.Add(time.Hour).Unix(), 5, nil)
+	c.Assert(err, IsNil)
+	var streamed []float32
+	for {
+		batch, err := qc.Next()
+		c.Assert(err, IsNil)
+		if len(batch) == 0 {
+			break
+		}
+		streamed = append(streamed, batch[*tbk].GetByName("Price").([]float32)...)
+	}
+	c.Assert(streamed, DeepEquals, prices)
+
+	// Variable records keep their nanoseconds in the interval ticks instead
+	tbi := NewTimeBucketInfo(*utils.TimeframeFromString("100ms"), s.Rootdir, "Test", 2016,
+		cs.GetDataShapes(), VARIABLE)
+	c.Assert(CreateTimeBucket(NewTimeBucketKey("VTICK/100ms/PRICE"), tbi), NotNil)
+}
+
+func (s *TestSuite) TestVariableWrites(c *C) {
+	d := ThisInstance.CatalogDir
+	base := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	c.Assert(SetVariableWrites([]*utils.VariableWriteSetting{{On: "UPS/1Min/OHLCV", Mode: "upsert"}}), IsNil)
+	defer SetVariableWrites(nil)
+
+	// writes the rows of volume v at base plus the offset, and returns the volumes read back
+	writer := func(key string) (write func(offset time.Duration, v int32), read func() []int32) {
+		tbk := NewTimeBucketKey(key)
+		dsv := NewDataShapeVector(
+			[]string{"Open", "High", "Low", "Close", "Volume"},
+			[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+		)
+		tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+			"Test", 2016, dsv, VARIABLE)
+		c.Assert(d.AddTimeBucket(tbk, tbinfo), IsNil)
+		tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+		c.Assert(err, IsNil)
+		w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+		c.Assert(err, IsNil)
+		write = func(offset time.Duration, v int32) {
+			buffer, _ := Serialize([]byte{}, OHLCVtest{0, 1, 2, 3, 4, v})
+			w.WriteRecords([]time.Time{base.Add(offset)}, buffer)
+			c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+		}
+		read = func() []int32 {
+			q := NewQuery(d)
+			q.AddTargetKey(tbk)
+			q.SetRange(base.Unix(), base.Add(time.Hour).Unix())
+			pr, err := q.Parse()
+			c.Assert(err, IsNil)
+			rd, err := NewReader(pr)
+			c.Assert(err, IsNil)
+			csm, _, err := rd.Read()
+			c.Assert(err, IsNil)
+			return csm[*tbk].GetByName("Volume").([]int32)
+		}
+		return write, read
+	}
+
+	// a late row is read in place, after relocating its interval
+	write, read := writer("APP/1Min/OHLCV")
+	write(10*time.Second, 1)
+	write(70*time.Second, 2)
+	write(5*time.Second, 3)
+	write(10*time.Second, 4)
+	c.Assert(read(), DeepEquals, []int32{3, 1, 4, 2})
+
+	// a row at the time of another replaces it
+	write, read = writer("UPS/1Min/OHLCV")
+	write(10*time.Second, 1)
+	write(20*time.Second, 2)
+	write(10*time.Second, 5)
+	c.Assert(read(), DeepEquals, []int32{5, 2})
+	write(70*time.Second, 3)
+	write(20*time.Second, 6)
+	write(5*time.Second, 7)
+	c.Assert(read(), DeepEquals, []int32{7, 5, 6, 3})
+
+	// the payloads left behind by the relocations are reclaimed
+	path := NewTimeBucketKey("UPS/1Min/OHLCV").GetPathToYearFiles(s.Rootdir) + "/2016.bin"
+	before, after, err := ReclaimFile(path)
+	c.Assert(err, IsNil)
+	varRecLen := int64(4*4 + 4 + 4)
+	c.Assert(before-after, Equals, 2*varRecLen)
+	c.Assert(read(), DeepEquals, []int32{7, 5, 6, 3})
+	before, after, err = ReclaimFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(before, Equals, after)
+
+	// the payloads of the deleted intervals are reclaimed
+	c.Assert(DeleteRange(NewTimeBucketKey("UPS/1Min/OHLCV"), base, base.Add(30*time.Second)), IsNil)
+	c.Assert(read(), DeepEquals, []int32{3})
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, after-3*varRecLen)
+}
+
+func (s *TestSuite) TestAlterSchema(c *C) {
+	d := ThisInstance.CatalogDir
+	base := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{base.AddDate(-1, 0, 0), base, base.Add(time.Minute)}
+	shapes, err := DataShapesFromInputString("Open,Close/float32:Volume/float64:VWAP/float64")
+	c.Assert(err, IsNil)
+
+	for _, recordType := range []EnumRecordType{FIXED, VARIABLE} {
+		tbk := NewTimeBucketKey(fmt.Sprintf("ALT%v/1Min/OHLCV", recordType))
+		dsv := NewDataShapeVector(
+			[]string{"Open", "High", "Low", "Close", "Volume"},
+			[]EnumElementType{FLOAT32, FLOAT32, FLOAT32, FLOAT32, INT32},
+		)
+		tbinfo := NewTimeBucketInfo(*utils.TimeframeFromString("1Min"), tbk.GetPathToYearFiles(s.Rootdir),
+			"Test", 2016, dsv, recordType)
+		c.Assert(d.AddTimeBucket(tbk, tbinfo), IsNil)
+		tbi, err := d.GetLatestTimeBucketInfoFromKey(tbk)
+		c.Assert(err, IsNil)
+		w, err := NewWriter(tbi, ThisInstance.TXNPipe, d)
+		c.Assert(err, IsNil)
+		for i, t := range times {
+			buffer, _ := Serialize([]byte{}, OHLCVtest{0, float32(i), 2, 3, float32(i) + 0.5, int32(100*i + 100)})
+			w.WriteRecords([]time.Time{t}, buffer)
+		}
+		c.Assert(ThisInstance.WALFile.flushToWAL(ThisInstance.TXNPipe), IsNil)
+		if recordType == FIXED {
+			// A compacted past year stays compacted
+			_, _, err = CompactFile(tbk.GetPathToYearFiles(s.Rootdir) + "/2015.bin")
+			c.Assert(err, IsNil)
+		}
+
+		c.Assert(AlterSchema(tbk, shapes), IsNil)
+		dbDSV, err := d.GetDataShapes(tbk)
+		c.Assert(err, IsNil)
+		c.Assert(len(dbDSV), Equals, 4)
+		for i, shape := range dbDSV {
+			c.Assert(strings.EqualFold(shape.Name, shapes[i].Name), Equals, true)
+			c.Assert(shape.Type, Equals, shapes[i].Type)
+		}
+
+		// reads the records of the hour from start
+		read := func(start time.Time) *ColumnSeries {
+			q := NewQuery(d)
+			q.AddTargetKey(tbk)
+			q.SetRange(start.Unix(), start.Add(time.Hour).Unix())
+			pr, err := q.Parse()
+			c.Assert(err, IsNil)
+			rd, err := NewReader(pr)
+			c.Assert(err, IsNil)
+			csm, _, err := rd.Read()
+			c.Assert(err, IsNil)
+			return csm[*tbk]
+		}
+		cs := read(times[0])
+		c.Assert(cs.GetEpoch(), DeepEquals, []int64{times[0].Unix()})
+		c.Assert(cs.GetByName("Volume"), DeepEquals, []float64{100})
+		cs = read(base)
+		c.Assert(cs.GetEpoch(), DeepEquals, []int64{times[1].Unix(), times[2].Unix()})
+		c.Assert(cs.GetByName("Open"), DeepEquals, []float32{1, 2})
+		c.Assert(cs.GetByName("Close"), DeepEquals, []float32{1.5, 2.5})
+		c.Assert(cs.GetByName("Volume"), DeepEquals, []float64{200, 300})
+		c.Assert(cs.GetByName("VWAP"), DeepEquals, []float64{0, 0})
+		c.Assert(cs.Exists("High"), Equals, false)
+	}
+
+	// A column can not change between a number and a bool
+	tbk := NewTimeBucketKey("ALTFIXED/1Min/OHLCV")
+	shapes, err = DataShapesFromInputString("Open,Close/float32:Volume/bool:VWAP/float64")
+	c.Assert(err, IsNil)
+	c.Assert(AlterSchema(tbk, shapes), NotNil)
+	dbDSV, err := d.GetDataShapes(tbk)
+	c.Assert(err, IsNil)
+	c.Assert(dbDSV[2].Type, Equals, FLOAT64)
+	tmpFiles, err := filepath.Glob(tbk.GetPathToYearFiles(s.Rootdir) + "/*.tmp")
+	c.Assert(err, IsNil)
+	c.Assert(tmpFiles, HasLen, 0)
+
+	// A year file failing to be replaced restores the ones replaced before it
+	subDir, err := d.GetSubDirectoryFromKey(tbk)
+	c.Assert(err, IsNil)
+	tbis := subDir.GetTimeBucketInfoSlice()
+	c.Assert(tbis, HasLen, 2)
+	blocker := tbis[1].Path + ".orig"
+	c.Assert(os.MkdirAll(filepath.Join(blocker, "in-the-way"), 0700), IsNil)
+	shapes, err = DataShapesFromInputString("Open,Close/float64:Volume/float64")
+	c.Assert(err, IsNil)
+	c.Assert(AlterSchema(tbk, shapes), NotNil)
+	c.Assert(os.RemoveAll(blocker), IsNil)
+	for _, tbi := range tbis {
+		onDisk, err := ReadTimeBucketInfo(tbi.Path)
+		c.Assert(err, IsNil)
+		c.Assert(onDisk.GetDataShapes(), DeepEquals, dbDSV)
+	}
+	dbDSV2, err := d.GetDataShapes(tbk)
+	c.Assert(err, IsNil)
+	c.Assert(dbDSV2, DeepEquals, dbDSV)
+	leftovers, err := filepath.Glob(tbk.GetPathToYearFiles(s.Rootdir) + "/*.bin.*")
+	c.Assert(err, IsNil)
+	c.Assert(leftovers, HasLen, 0)
+	journal := filepath.Join(ThisInstance.RootDir, schemaJournal)
+	_, err = os.Stat(journal)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// A crash before the change is committed is undone on startup
+	path := tbis[1].Path
+	former, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.Rename(path, path+".orig"), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte("rewritten"), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(tbis[0].Path+".tmp", []byte("rewritten"), 0600), IsNil)
+	c.Assert(writeSchemaJournal(journal, false, []string{tbis[0].Path, path}), IsNil)
+	c.Assert(RecoverSchemaChange(ThisInstance.RootDir), IsNil)
+	restored, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(restored, former), Equals, true)
+	leftovers, err = filepath.Glob(tbk.GetPathToYearFiles(s.Rootdir) + "/*.bin.*")
+	c.Assert(err, IsNil)
+	c.Assert(leftovers, HasLen, 0)
+	_, err = os.Stat(journal)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// and one committed is completed
+	c.Assert(ioutil.WriteFile(path+".orig", []byte("former"), 0600), IsNil)
+	c.Assert(writeSchemaJournal(journal, true, []string{tbis[0].Path, path}), IsNil)
+	c.Assert(RecoverSchemaChange(ThisInstance.RootDir), IsNil)
+	restored, err = ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(restored, former), Equals, true)
+	leftovers, err = filepath.Glob(tbk.GetPathToYearFiles(s.Rootdir) + "/*.bin.*")
+	c.Assert(err, IsNil)
+	c.Assert(leftovers, HasLen, 0)
+	c.Assert(RecoverSchemaChange(ThisInstance.RootDir), IsNil)
+}
+
+func (s *TestSuite) TestQueryCursor(c *C) {
+	tbk := NewTimeBucketKey("EURUSD,USDJPY/1H/OHLC")
+	start := time.Date(2001, time.December, 30, 0, 0, 0, 0, time.UTC).Unix()
+	end := time.Date(2002, time.January, 3, 0, 0, 0, 0, time.UTC).Unix()
+
+	q := NewQuery(s.DataDirectory)
+	q.AddTargetKey(tbk)
+	q.SetRange(start, end)
+	pr, err := q.Parse()
+	c.Assert(err, IsNil)
+	rd, err := NewReader(pr)
+	c.Assert(err, IsNil)
+	csm, _, err := rd.Read()
+	c.Assert(err, IsNil)
+	c.Assert(len(csm), Equals, 2)
+
+	// Batches cover the whole result in order, across the year boundary
+	qc, err := NewQueryCursor(context.Background(), tbk, start, end, 10, nil)
+	c.Assert(err, IsNil)
+	epochs := make(map[TimeBucketKey][]int64)
+	var batches int
+	for {
+		batch, err := qc.Next()
+		c.Assert(err, IsNil)
+		if len(batch) == 0 {
+			break
+		}
+		batches++
+		for key, cs := range batch {
+			c.Assert(cs.Len() <= 10, Equals, true)
+			epochs[key] = append(epochs[key], cs.GetEpoch()...)
+		}
+	}
+	for key, cs := range csm {
+		c.Assert(epochs[key], DeepEquals, cs.GetEpoch())
+		c.Assert(batches, Equals, (cs.Len()+9)/10)
+	}
+
+	// A new cursor resumes from the positions of another
+	qc, err = NewQueryCursor(context.Background(), tbk, start, end, 10, nil)
+	c.Assert(err, IsNil)
+	_, err = qc.Next()
+	c.Assert(err, IsNil)
+	resumed, err := NewQueryCursor(context.Background(), tbk, start, end, 10, qc.Positions())
+	c.Assert(err, IsNil)
+	batch, err := resumed.Next()
+	c.Assert(err, IsNil)
+	for key, cs := range batch {
+		c.Assert(cs.GetEpoch()[0], Equals, csm[key].GetEpoch()[10])
+	}
+}
+
+func (s *TestSuite) TestQueryCancel(c *C) {
+	tbk := NewTimeBucketKey("EURUSD/1Min/OHLC")
+
+	// A query with a cancelled context does not parse
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q := NewQuery(s.DataDirectory)
+	q.SetContext(ctx)
+	q.AddTargetKey(tbk)
+	_, err := q.Parse()
+	c.Assert(err, Equals, context.Canceled)
+
+	// A read stops at the next file chunk once the context is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var records int
+	q = NewQuery(s.DataDirectory)
+	q.SetContext(ctx)
+	q.AddTargetKey(tbk)
+	q.AddTimeQual(func(epoch int64) bool {
+		records++
+		cancel()
+		return true
+	})
+	pr, err := q.Parse()
+	c.Assert(err, IsNil)
+	rd, err := NewReader(pr)
+	c.Assert(err, IsNil)
+	_, _, err = rd.Read()
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(records <= RecordsPerRead, Equals, true)
+}
+
 func (s *TestSuite) TestWriter(c *C) {
 	tgc := ThisInstance.TXNPipe
 	dataItemKey := "TEST/1Min/OHLCV"
@@ -0,0 +1,33 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/dannyluong408/marketstore/plugins/trigger"
+	. "github.com/dannyluong408/marketstore/utils/io"
+)
+
+// CreateTimeBucket adds the bucket tbk described by tbi to the catalog, and
+// notifies the triggers implementing trigger.EventTrigger. Sub-second
+// timeframes are only supported for FIXED records.
+func CreateTimeBucket(tbk *TimeBucketKey, tbi *TimeBucketInfo) error {
+	if tbi.GetRecordType() == VARIABLE && tbi.IsSubSecond() {
+		return fmt.Errorf("Timeframe %v of %s is under one second, which VARIABLE records do not support",
+			tbi.GetTimeframe(), tbk.String())
+	}
+	if err := ThisInstance.CatalogDir.AddTimeBucket(tbk, tbi); err != nil {
+		return err
+	}
+	dispatchBucket(trigger.Create, tbk.GetItemKey())
+	return nil
+}
+
+// DestroyTimeBucket removes the bucket tbk and all its data from the catalog,
+// and notifies the triggers implementing trigger.EventTrigger.
+func DestroyTimeBucket(tbk *TimeBucketKey) error {
+	if err := ThisInstance.CatalogDir.RemoveTimeBucket(tbk); err != nil {
+		return err
+	}
+	dispatchBucket(trigger.Destroy, tbk.GetItemKey())
+	return nil
+}
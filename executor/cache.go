@@ -27,6 +27,7 @@ type TransactionPipe struct {
 	tgID         int64              // Current transaction group ID
 	writeChannel chan *WriteCommand // Channel for write commands
 	flushChannel chan chan struct{} // Channel for flush request
+	pauseChannel chan func()        // Channel for work run while the WAL writer is paused
 }
 
 // NewTransactionPipe creates a new transaction pipe that channels all
@@ -36,6 +37,7 @@ func NewTransactionPipe() *TransactionPipe {
 	// Allocate the write channel with enough depth to allow all conceivable writers concurrent access
 	tgc.writeChannel = make(chan *WriteCommand, WriteChannelCommandDepth)
 	tgc.flushChannel = make(chan chan struct{}, WriteChannelCommandDepth)
+	tgc.pauseChannel = make(chan func())
 	tgc.NewTGID()
 	return tgc
 }
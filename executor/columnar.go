@@ -0,0 +1,219 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+)
+
+/*
+CompactFile rewrites the FIXED year file at path in the COLUMNAR format, which
+keeps only the written records, compressed column by column. COLUMNAR files are
+meant for past years that are no longer written to: the reader reads them like
+DENSE ones, but a write to one first expands it back to DENSE. The server must
+not be running on the file. It returns the size of the file before and after.
+*/
+func CompactFile(path string) (before, after int64, err error) {
+	tbi, err := ReadTimeBucketInfo(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if tbi.GetRecordType() != FIXED {
+		return 0, 0, fmt.Errorf("Only FIXED files can be compacted, %s is %v", path, tbi.GetRecordType())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if tbi.GetFormat() == COLUMNAR {
+		return info.Size(), info.Size(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	recordLen := int64(tbi.GetRecordLength())
+	var records []byte
+	buffer := make([]byte, RecordsPerRead*recordLen)
+	for offset := int64(Headersize); offset < info.Size(); offset += int64(len(buffer)) {
+		n, err := f.ReadAt(buffer, offset)
+		if err != nil && n == 0 {
+			return 0, 0, err
+		}
+		for i := int64(0); i+recordLen <= int64(n); i += recordLen {
+			if ToInt64(buffer[i:]) != 0 {
+				records = append(records, buffer[i:i+recordLen]...)
+			}
+		}
+	}
+
+	tbi.SetFormat(COLUMNAR)
+	body := EncodeColumnar(records, int(recordLen), tbi.GetElementTypes())
+	if err = replaceFile(path, tbi, func(out *os.File) error {
+		_, err := out.Write(body)
+		return err
+	}); err != nil {
+		return 0, 0, err
+	}
+	// Only DENSE files have a presence bitmap
+	os.Remove(PresencePath(path))
+	return info.Size(), int64(Headersize + len(body)), nil
+}
+
+/*
+ExpandFile rewrites the COLUMNAR year file at path back in the DENSE format, so
+that it can be written to. It does nothing to a DENSE file, and returns whether
+the file was expanded.
+*/
+func ExpandFile(path string) (expanded bool, err error) {
+	tbi, err := ReadTimeBucketInfo(path)
+	if err != nil || tbi.GetFormat() != COLUMNAR {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	records, err := readColumnarRecords(context.Background(), f, tbi, 1, math.MaxInt64)
+	if err != nil {
+		return false, err
+	}
+
+	recordLen := tbi.GetRecordLength()
+	fileSize := FileSize(tbi.GetTimeframe(), int(tbi.Year), int(recordLen))
+	b, err := newPresenceBuilder(path, (fileSize-Headersize)/int64(recordLen))
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < len(records); i += int(recordLen) {
+		if err = b.set(ToInt64(records[i:])); err != nil {
+			b.abort()
+			return false, err
+		}
+	}
+	if err = b.commit(); err != nil {
+		return false, err
+	}
+	tbi.SetFormat(DENSE)
+	if err = replaceFile(path, tbi, func(out *os.File) error {
+		if err := out.Truncate(fileSize); err != nil {
+			return err
+		}
+		for i := 0; i < len(records); i += int(recordLen) {
+			record := records[i : i+int(recordLen)]
+			if _, err := out.WriteAt(record, IndexToOffset(ToInt64(record), recordLen)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	Log(INFO, "Expanded compacted file %s for writing", path)
+	return true, nil
+}
+
+// expandIfColumnar expands the year file at path if it is COLUMNAR, before it
+// is written to. A missing file is left for the caller to handle.
+func expandIfColumnar(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	format, err := ReadFileFormat(f)
+	f.Close()
+	if err != nil || format != COLUMNAR {
+		return err
+	}
+	_, err = ExpandFile(path)
+	return err
+}
+
+// replaceFile atomically replaces the file at path with one holding the header
+// of tbi followed by what write puts after it
+func replaceFile(path string, tbi *TimeBucketInfo, write func(out *os.File) error) error {
+	tmpPath, err := writeTempFile(path, tbi, write)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// writeTempFile writes the replacement of the file at path, holding the header
+// of tbi followed by what write puts after it, next to it and returns its path
+func writeTempFile(path string, tbi *TimeBucketInfo, write func(out *os.File) error) (tmpPath string, err error) {
+	tmpPath = path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = WriteHeader(out, tbi)
+	if err == nil {
+		err = write(out)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// readColumnarRecords returns the records of the COLUMNAR year file f whose
+// indexes are between firstIndex and lastIndex (inclusive), as they are stored
+// in a DENSE file
+func readColumnarRecords(ctx context.Context, f *os.File, tbi *TimeBucketInfo,
+	firstIndex, lastIndex int64) (records []byte, err error) {
+	chunks, err := ReadColumnarDirectory(f)
+	if err != nil {
+		return nil, fmt.Errorf("Reading the chunks of %s: %v", f.Name(), err)
+	}
+	for _, chunk := range chunks {
+		if chunk.LastIndex < firstIndex || chunk.FirstIndex > lastIndex {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if records, err = decodeColumnarChunk(records, f, tbi, chunk); err != nil {
+			return nil, err
+		}
+	}
+	recordLen := int(tbi.GetRecordLength())
+	for len(records) > 0 && ToInt64(records) < firstIndex {
+		records = records[recordLen:]
+	}
+	for len(records) > 0 && ToInt64(records[len(records)-recordLen:]) > lastIndex {
+		records = records[:len(records)-recordLen]
+	}
+	return records, nil
+}
+
+func decodeColumnarChunk(records []byte, f *os.File, tbi *TimeBucketInfo, chunk ColumnarChunk) ([]byte, error) {
+	data := make([]byte, chunk.Length)
+	if _, err := f.ReadAt(data, chunk.Offset); err != nil {
+		return nil, fmt.Errorf("Reading a chunk of %s: %v", f.Name(), err)
+	}
+	records, err := DecodeColumnarChunk(records, data, int(tbi.GetRecordLength()), tbi.GetElementTypes())
+	if err != nil {
+		return nil, fmt.Errorf("Decoding a chunk of %s: %v", f.Name(), err)
+	}
+	return records, nil
+}
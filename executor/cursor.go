@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dannyluong408/marketstore/planner"
+	. "github.com/dannyluong408/marketstore/utils/io"
+)
+
+/*
+QueryCursor reads the result of a forward range query in batches, so a large
+result is never held in memory as a whole. Each batch has at most BatchSize
+records for each key, for variable length data a record is an interval which
+can hold several rows.
+
+The position of a key is the epoch of the last row returned for it, in
+nanoseconds for keys with sub-second timeframes. A cursor created with the
+positions of another one resumes where that one stopped.
+*/
+type QueryCursor struct {
+	BatchSize  int
+	ctx        context.Context
+	start, end int64
+	keys       []TimeBucketKey // Keys that can have rows left, in key order
+	timeframes map[TimeBucketKey]time.Duration
+	positions  map[TimeBucketKey]int64
+}
+
+func NewQueryCursor(ctx context.Context, tbk *TimeBucketKey, start, end int64, batchSize int,
+	positions map[TimeBucketKey]int64) (qc *QueryCursor, err error) {
+	q := planner.NewQuery(ThisInstance.CatalogDir)
+	q.SetContext(ctx)
+	q.AddTargetKey(tbk)
+	q.SetRange(start, end)
+	pr, err := q.Parse()
+	if err != nil {
+		return nil, err
+	}
+	qc = &QueryCursor{
+		BatchSize:  batchSize,
+		ctx:        ctx,
+		start:      start,
+		end:        end,
+		timeframes: make(map[TimeBucketKey]time.Duration),
+		positions:  make(map[TimeBucketKey]int64),
+	}
+	for _, qf := range pr.QualifiedFiles {
+		if _, ok := qc.timeframes[qf.Key]; !ok {
+			qc.timeframes[qf.Key] = qf.File.GetTimeframe()
+			qc.keys = append(qc.keys, qf.Key)
+		}
+	}
+	sort.Slice(qc.keys, func(i, j int) bool { return qc.keys[i].String() < qc.keys[j].String() })
+	for key, epoch := range positions {
+		qc.positions[key] = epoch
+	}
+	return qc, nil
+}
+
+/*
+Next returns the next batch of rows for each key, the result is empty once all
+rows have been returned
+*/
+func (qc *QueryCursor) Next() (csm ColumnSeriesMap, err error) {
+	csm = NewColumnSeriesMap()
+	var remaining []TimeBucketKey
+	for _, key := range qc.keys {
+		start := time.Unix(qc.start, 0)
+		if last, ok := qc.positions[key]; ok {
+			start = nextInterval(last, qc.timeframes[key])
+		}
+		if start.Unix() > qc.end {
+			continue
+		}
+		tbk := key
+		q := planner.NewQuery(ThisInstance.CatalogDir)
+		q.SetContext(qc.ctx)
+		q.AddTargetKey(&tbk)
+		q.SetStartTime(start)
+		q.SetEnd(qc.end)
+		q.SetRowLimit(FIRST, qc.BatchSize)
+		pr, err := q.Parse()
+		if err != nil {
+			if ctxErr := qc.ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			continue // No files left in the range
+		}
+		rd, err := NewReader(pr)
+		if err != nil {
+			return nil, err
+		}
+		result, _, err := rd.Read()
+		if err != nil {
+			return nil, err
+		}
+		cs := result[key]
+		if cs == nil || cs.Len() == 0 {
+			continue
+		}
+		csm[key] = cs
+		if qc.timeframes[key] < time.Second {
+			qc.positions[key] = cs.GetTime()[cs.Len()-1].UnixNano()
+		} else {
+			epochs := cs.GetEpoch()
+			qc.positions[key] = epochs[len(epochs)-1]
+		}
+		// Fewer rows than the limit means the scan reached the end
+		if cs.Len() >= qc.BatchSize {
+			remaining = append(remaining, key)
+		}
+	}
+	qc.keys = remaining
+	return csm, nil
+}
+
+/*
+Positions returns the position of each key, the epoch of the last row returned
+for it
+*/
+func (qc *QueryCursor) Positions() map[TimeBucketKey]int64 {
+	positions := make(map[TimeBucketKey]int64, len(qc.positions))
+	for key, epoch := range qc.positions {
+		positions[key] = epoch
+	}
+	return positions
+}
+
+/*
+nextInterval returns the start of the interval after the one holding the
+position, a range starting inside an interval would read it again
+*/
+func nextInterval(position int64, tf time.Duration) time.Time {
+	t := time.Unix(position, 0)
+	if tf < time.Second {
+		t = time.Unix(0, position)
+	}
+	t = ToSystemTimezone(t)
+	return IndexToTime(TimeToIndex(t, tf)+1, tf, int16(t.Year()))
+}
@@ -0,0 +1,262 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dannyluong408/marketstore/catalog"
+	"github.com/dannyluong408/marketstore/utils"
+	. "github.com/dannyluong408/marketstore/utils/io"
+)
+
+type deletedRange struct {
+	keyPath              string
+	startIndex, endIndex int64
+}
+
+const (
+	// Bounds of the transaction groups of zeroing writes of a delete
+	deleteBatchWrites = 1 << 16
+	deleteBatchBytes  = 32 << 20
+)
+
+// zeroWrites collects the zeroing writes of a delete and writes them in
+// bounded transaction groups. It runs while the WAL writer is paused, so the
+// writes go to the WAL file and the primary files directly rather than through
+// the TransactionPipe, which nothing drains meanwhile.
+type zeroWrites struct {
+	commands []*WriteCommand
+	size     int
+}
+
+func (zw *zeroWrites) add(wc *WriteCommand) error {
+	zw.commands = append(zw.commands, wc)
+	zw.size += len(wc.Data)
+	if len(zw.commands) == deleteBatchWrites || zw.size >= deleteBatchBytes {
+		return zw.flush()
+	}
+	return nil
+}
+
+func (zw *zeroWrites) flush() error {
+	if len(zw.commands) == 0 {
+		return nil
+	}
+	err := ThisInstance.WALFile.writeTG(ThisInstance.TXNPipe, zw.commands)
+	zw.commands, zw.size = zw.commands[:0], 0
+	return err
+}
+
+// DeleteRange removes the records of the bucket tbk whose epochs fall between
+// start and end (inclusive), across every year file in that range. Records are
+// removed at interval granularity, so for VARIABLE buckets every record in an
+// interval that starts within the range is removed. The cleared slots are
+// written through the WAL as zeroed writes, in bounded transaction groups, which
+// makes the delete as crash safe as any other write. The pending writes are
+// flushed before the files are scanned, and the writes issued meanwhile are
+// queued after the zeroed ones, so that none is left behind in the range. The payloads of the cleared VARIABLE
+// intervals are reclaimed afterwards. Triggers implementing
+// trigger.DeleteTrigger are notified once the data is gone from the primary
+// store.
+func DeleteRange(tbk *TimeBucketKey, start, end time.Time) (err error) {
+	return deleteRange(tbk, start, end, true)
+}
+
+// deleteRange is DeleteRange, notifying the triggers only if notify is set
+func deleteRange(tbk *TimeBucketKey, start, end time.Time, notify bool) (err error) {
+	if end.Before(start) {
+		return fmt.Errorf("delete range end %v is before start %v", end, start)
+	}
+	subDir, err := ThisInstance.CatalogDir.GetSubDirectoryFromKey(tbk)
+	if err != nil {
+		return err
+	}
+	start = ToSystemTimezone(start)
+	end = ToSystemTimezone(end)
+
+	var deleted []deletedRange
+	var reclaimed []string
+	ThisInstance.WALFile.RunPaused(func() {
+		deleted, reclaimed, err = deleteFromBucket(subDir, start, end)
+	})
+	if err != nil || len(deleted) == 0 {
+		return err
+	}
+	if len(reclaimed) != 0 {
+		// The cleared index entries left their payloads orphaned
+		ThisInstance.WALFile.RunPaused(func() {
+			for _, path := range reclaimed {
+				if _, _, err = ReclaimFile(path); err != nil {
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !notify {
+		return nil
+	}
+	for _, dr := range deleted {
+		dispatchDeleted(dr)
+	}
+	return nil
+}
+
+// deleteFromBucket writes the zeroing writes of the records of the bucket
+// directory subDir between start and end. It returns the ranges cleared, and
+// the paths of the VARIABLE year files among them.
+func deleteFromBucket(subDir *catalog.Directory, start, end time.Time) (deleted []deletedRange, variable []string, err error) {
+	zw := &zeroWrites{}
+	tbis := subDir.GetTimeBucketInfoSlice()
+	sort.Slice(tbis, func(i, j int) bool { return tbis[i].Year < tbis[j].Year })
+	for _, tbi := range tbis {
+		if tbi.Year < int16(start.Year()) || tbi.Year > int16(end.Year()) {
+			continue
+		}
+		tf := tbi.GetTimeframe()
+		firstIndex := TimeToIndex(yearStart(tbi.Year), tf)
+		if tbi.Year == int16(start.Year()) {
+			firstIndex = TimeToIndex(start, tf)
+			// The record at this index begins before the range
+			if IndexToTime(firstIndex, tf, tbi.Year).Before(start) {
+				firstIndex++
+			}
+		}
+		lastIndex := TimeToIndex(yearStart(tbi.Year+1).Add(-time.Nanosecond), tf)
+		if tbi.Year == int16(end.Year()) {
+			lastIndex = TimeToIndex(end, tf)
+		}
+		dr, err := deleteFromFile(zw, tbi, firstIndex, lastIndex)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dr != nil {
+			deleted = append(deleted, *dr)
+			if tbi.GetRecordType() == VARIABLE {
+				variable = append(variable, tbi.Path)
+			}
+		}
+	}
+	if err = zw.flush(); err != nil {
+		return nil, nil, err
+	}
+	return deleted, variable, nil
+}
+
+// deleteFromFile scans the slots between firstIndex and lastIndex of a single
+// year file and adds a zeroing write for every run of occupied slots to zw. It
+// returns the range of indexes that held data, or nil if there was none.
+func deleteFromFile(zw *zeroWrites, tbi *TimeBucketInfo, firstIndex, lastIndex int64) (dr *deletedRange, err error) {
+	recordLen := int64(tbi.GetRecordLength())
+	fileSize := FileSize(tbi.GetTimeframe(), int(tbi.Year), int(recordLen))
+	if firstIndex < 1 {
+		firstIndex = 1
+	}
+	if maxIndex := (fileSize - Headersize) / recordLen; lastIndex > maxIndex {
+		lastIndex = maxIndex
+	}
+	if lastIndex < firstIndex {
+		return nil, nil
+	}
+
+	fp, err := os.OpenFile(tbi.Path, os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	keyPath := ThisInstance.WALFile.FullPathToWALKey(tbi.Path)
+	// The writes only read their data, so the runs share one zero buffer
+	zeros := make([]byte, RecordsPerRead*recordLen)
+	var runStart, runLen int64
+	flushRun := func() error {
+		if runLen == 0 {
+			return nil
+		}
+		// A zero index in the first 8 bytes marks the slots as empty
+		wc := &WriteCommand{
+			RecordType: tbi.GetRecordType(),
+			WALKeyPath: keyPath,
+			Offset:     IndexToOffset(runStart, int32(recordLen)),
+			Index:      0,
+			Data:       zeros[:runLen*recordLen-8],
+		}
+		runLen = 0
+		return zw.add(wc)
+	}
+
+	// occupied adds the slot at index to the run of slots to clear
+	occupied := func(index int64) error {
+		if dr == nil {
+			dr = &deletedRange{keyPath: keyPath, startIndex: index}
+		}
+		dr.endIndex = index
+		if runLen == 0 {
+			runStart = index
+		}
+		runLen++
+		if runLen == RecordsPerRead {
+			return flushRun()
+		}
+		return nil
+	}
+
+	if format, err := ReadFileFormat(fp); err == nil && format == COLUMNAR {
+		// The write of the cleared slots expands the file back
+		records, err := readColumnarRecords(context.Background(), fp, tbi, firstIndex, lastIndex)
+		if err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < int64(len(records)); i += recordLen {
+			index := ToInt64(records[i:])
+			if runLen != 0 && index != runStart+runLen {
+				if err = flushRun(); err != nil {
+					return nil, err
+				}
+			}
+			if err = occupied(index); err != nil {
+				return nil, err
+			}
+		}
+		if err = flushRun(); err != nil {
+			return nil, err
+		}
+		return dr, nil
+	}
+
+	buffer := make([]byte, RecordsPerRead*recordLen)
+	for index := firstIndex; index <= lastIndex; {
+		n := lastIndex - index + 1
+		if n > RecordsPerRead {
+			n = RecordsPerRead
+		}
+		chunk := buffer[:n*recordLen]
+		if _, err = fp.ReadAt(chunk, IndexToOffset(index, int32(recordLen))); err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < n; i++ {
+			if ToInt64(chunk[i*recordLen:]) == 0 {
+				err = flushRun()
+			} else {
+				err = occupied(index + i)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		index += n
+	}
+	if err = flushRun(); err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
+
+func yearStart(year int16) time.Time {
+	return time.Date(int(year), time.January, 1, 0, 0, 0, 0, utils.InstanceConfig.Timezone)
+}
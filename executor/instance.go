@@ -23,6 +23,21 @@ type InstanceMetadata struct {
 	ShutdownPending bool
 	WALBypass       bool
 	TriggerMatchers []*trigger.TriggerMatcher
+	triggerMu       sync.RWMutex
+}
+
+// SetTriggerMatchers replaces the triggers fired on writes, e.g. on a config reload
+func (im *InstanceMetadata) SetTriggerMatchers(matchers []*trigger.TriggerMatcher) {
+	im.triggerMu.Lock()
+	defer im.triggerMu.Unlock()
+	im.TriggerMatchers = matchers
+}
+
+// GetTriggerMatchers returns the triggers fired on writes
+func (im *InstanceMetadata) GetTriggerMatchers() []*trigger.TriggerMatcher {
+	im.triggerMu.RLock()
+	defer im.triggerMu.RUnlock()
+	return im.TriggerMatchers
 }
 
 func NewInstanceSetup(relRootDir string, options ...bool) {
@@ -57,11 +72,21 @@ func NewInstanceSetup(relRootDir string, options ...bool) {
 	}
 	ThisInstance.InstanceID = time.Now().UTC().UnixNano()
 	ThisInstance.RootDir = rootDir
+	// Settle a change of data shapes interrupted by a crash
+	if err = RecoverSchemaChange(rootDir); err != nil {
+		Log(FATAL, "Unable to recover the change of data shapes: %v", err)
+	}
 	// Initialize a global catalog
 	if initCatalog {
 		ThisInstance.CatalogDir = catalog.NewDirectory(rootDir)
 	}
 	ThisInstance.WALBypass = WALBypass
+	if err = SetRetention(utils.InstanceConfig.Retention); err != nil {
+		Log(ERROR, "Unable to set the retention policies: %v", err)
+	}
+	if err = SetVariableWrites(utils.InstanceConfig.VariableWrites); err != nil {
+		Log(ERROR, "Unable to set the variable write modes: %v", err)
+	}
 	if initWALCache {
 		// Allocate a new WALFile and cache
 		if WALBypass {
@@ -77,6 +102,7 @@ func NewInstanceSetup(relRootDir string, options ...bool) {
 			// Startup the WAL and Primary cache flushers
 			go ThisInstance.WALFile.SyncWAL(500*time.Millisecond, 5*time.Minute, utils.InstanceConfig.WALRotateInterval)
 			ThisInstance.WALWg.Add(1)
+			go runRetention(retentionInterval)
 		}
 	}
 }
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"github.com/dannyluong408/marketstore/utils/stats"
+)
+
+var (
+	walFlushDuration = stats.NewHistogram(
+		"marketstore_wal_flush_duration_seconds",
+		"Time to commit a transaction group to the WAL and the primary files",
+		stats.DefaultBuckets)
+	walTGSize = stats.NewHistogram(
+		"marketstore_wal_tg_size_bytes",
+		"Serialized size of the transaction groups committed to the WAL",
+		stats.ExponentialBuckets(1024, 4, 10))
+	triggerFires = stats.NewCounter(
+		"marketstore_trigger_fires_total",
+		"Number of times triggers were fired, by trigger condition",
+		"on")
+	triggerPanics = stats.NewCounter(
+		"marketstore_trigger_panics_total",
+		"Number of trigger panics recovered, by trigger condition",
+		"on")
+	triggerQueued = stats.NewGauge(
+		"marketstore_trigger_queued",
+		"Trigger fires waiting in the queues, by trigger condition",
+		"on")
+	triggerDrops = stats.NewCounter(
+		"marketstore_trigger_drops_total",
+		"Number of trigger fires dropped from full queues, by trigger condition",
+		"on")
+	triggerLag = stats.NewHistogram(
+		"marketstore_trigger_lag_seconds",
+		"Time trigger fires waited in their queue before running, by trigger condition",
+		stats.DefaultBuckets,
+		"on")
+	retentionRemovedFiles = stats.NewCounter(
+		"marketstore_retention_removed_files_total",
+		"Number of year files removed by the retention policies")
+	_ = stats.NewGaugeFunc(
+		"marketstore_write_channel_depth",
+		"Write commands queued in the transaction pipe",
+		func() float64 {
+			if ThisInstance == nil || ThisInstance.TXNPipe == nil {
+				return 0
+			}
+			return float64(len(ThisInstance.TXNPipe.writeChannel))
+		})
+	_ = stats.NewGaugeFunc(
+		"marketstore_write_channel_capacity",
+		"Write commands the transaction pipe can queue",
+		func() float64 { return WriteChannelCommandDepth })
+)
@@ -0,0 +1,174 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+)
+
+/*
+updatePresence updates the presence bitmap of the FIXED year file at path for
+the writes just made to it, setting the bits of the written records and clearing
+the ones of the slots cleared by deletes. A file without a bitmap, written
+before they were added, gets one built from its records instead.
+*/
+func updatePresence(path string, writes []offsetIndexBuffer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	recordLen, err := ReadRecordLength(f)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(PresencePath(path)); os.IsNotExist(err) {
+		return buildPresence(f, int64(recordLen))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	slots := (info.Size() - Headersize) / int64(recordLen)
+
+	// The bits are updated a window at a time, in the order of the writes so
+	// that the last of them to a slot wins. A delete clears a run of slots.
+	var p *Presence
+	for _, buffer := range writes {
+		first := (buffer.Offset()-Headersize)/int64(recordLen) + 1
+		last := first + (int64(len(buffer.IndexAndPayload()))+int64(recordLen)-1)/int64(recordLen) - 1
+		if p == nil || first < p.FirstIndex || last >= p.FirstIndex+int64(len(p.Bits))*8 {
+			if p != nil {
+				if err = p.Write(path); err != nil {
+					return err
+				}
+			}
+			end := first + PresenceWindow - 1
+			if end > slots {
+				end = slots
+			}
+			if end < last {
+				end = last
+			}
+			if p, err = ReadPresence(path, first, end); err != nil {
+				return err
+			}
+		}
+		for index := first; index <= last; index++ {
+			p.Set(index, buffer.Index() != 0)
+		}
+	}
+	if p == nil {
+		return nil
+	}
+	return p.Write(path)
+}
+
+// buildPresence builds the presence bitmap of the DENSE FIXED year file f from
+// the records it holds
+func buildPresence(f *os.File, recordLen int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	slots := (info.Size() - Headersize) / recordLen
+	b, err := newPresenceBuilder(f.Name(), slots)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, RecordsPerRead*recordLen)
+	for index := int64(1); index <= slots; index += RecordsPerRead {
+		n, err := f.ReadAt(buffer, IndexToOffset(index, int32(recordLen)))
+		if err != nil && n == 0 {
+			b.abort()
+			return err
+		}
+		for i := int64(0); i+recordLen <= int64(n); i += recordLen {
+			if ToInt64(buffer[i:]) != 0 {
+				if err = b.set(index + i/recordLen); err != nil {
+					b.abort()
+					return err
+				}
+			}
+		}
+	}
+	Log(INFO, "Built the presence bitmap of %s", f.Name())
+	return b.commit()
+}
+
+/*
+presenceBuilder writes a new presence bitmap for all the slots of the year file
+at path, from the indexes of its records in increasing order. The bitmap is
+written a window at a time next to the current one, which it replaces at once.
+*/
+type presenceBuilder struct {
+	path string
+	fp   *os.File
+	p    *Presence
+}
+
+func newPresenceBuilder(path string, slots int64) (*presenceBuilder, error) {
+	fp, err := os.OpenFile(PresencePath(path)+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = fp.Truncate((slots + 7) / 8); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return nil, err
+	}
+	return &presenceBuilder{path: path, fp: fp}, nil
+}
+
+// set marks the slot at index, past the ones marked before, as holding a record
+func (b *presenceBuilder) set(index int64) error {
+	if b.p != nil && index < b.p.FirstIndex {
+		return fmt.Errorf("Building the presence bitmap of %s: index %d is out of order", b.path, index)
+	}
+	if b.p == nil || index >= b.p.FirstIndex+int64(len(b.p.Bits))*8 {
+		if err := b.writeWindow(); err != nil {
+			return err
+		}
+		b.p = NewPresence(index, index+PresenceWindow-1)
+	}
+	b.p.Set(index, true)
+	return nil
+}
+
+func (b *presenceBuilder) writeWindow() error {
+	if b.p == nil {
+		return nil
+	}
+	// The bitmap is not extended past the slots of the file
+	bits := b.p.Bits
+	info, err := b.fp.Stat()
+	if err != nil {
+		return err
+	}
+	if end := info.Size() - (b.p.FirstIndex-1)/8; end < int64(len(bits)) {
+		bits = bits[:end]
+	}
+	_, err = b.fp.WriteAt(bits, (b.p.FirstIndex-1)/8)
+	return err
+}
+
+// commit replaces the presence bitmap of the year file with the new one
+func (b *presenceBuilder) commit() error {
+	if err := b.writeWindow(); err != nil {
+		b.abort()
+		return err
+	}
+	if err := b.fp.Close(); err != nil {
+		os.Remove(b.fp.Name())
+		return err
+	}
+	return os.Rename(b.fp.Name(), PresencePath(b.path))
+}
+
+func (b *presenceBuilder) abort() {
+	b.fp.Close()
+	os.Remove(b.fp.Name())
+}
@@ -19,6 +19,9 @@ func (r *reader) readSecondStage(bufMeta []bufferMeta) (rb []byte, err error) {
 		the target data into the resultBuffer
 	*/
 	for _, md := range bufMeta {
+		if err = r.pr.GetContext().Err(); err != nil {
+			return nil, err
+		}
 		file := md.FullPath
 		indexBuffer := md.Data
 
@@ -51,6 +54,9 @@ func (r *reader) readSecondStage(bufMeta []bufferMeta) (rb []byte, err error) {
 				return nil, err
 			}
 
+			// Records written late to the interval may be out of order
+			buffer = sortByTicks(buffer, md.VarRecLen)
+
 			// Loop over the variable records and prepend the index time to each
 			numVarRecords := len(buffer) / md.VarRecLen
 			rbTemp := make([]byte, numVarRecords*(md.VarRecLen+8)) // Add the extra space for epoch
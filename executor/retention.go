@@ -0,0 +1,179 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dannyluong408/marketstore/utils"
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/gobwas/glob"
+)
+
+// retentionInterval is how often the retention policies are applied
+const retentionInterval = time.Hour
+
+type retentionRule struct {
+	on     glob.Glob
+	period time.Duration
+}
+
+var retention = struct {
+	sync.Mutex
+	rules []retentionRule
+}{}
+
+// SetRetention replaces the retention policies, e.g. on a config reload.  The
+// first setting whose key glob matches a bucket applies to it.
+func SetRetention(settings []*utils.RetentionSetting) error {
+	rules := make([]retentionRule, 0, len(settings))
+	for _, s := range settings {
+		g, err := glob.Compile(s.On, '/')
+		if err != nil {
+			return fmt.Errorf("Invalid retention key %s: %v", s.On, err)
+		}
+		rules = append(rules, retentionRule{on: g, period: s.Period})
+	}
+	retention.Lock()
+	defer retention.Unlock()
+	retention.rules = rules
+	return nil
+}
+
+/*
+ExpireData removes the data older than the retention period of every bucket
+with a retention policy, as of now. Year files entirely before the cutoff are
+removed from the disk and the catalog, except for the latest one which is kept
+for new writes, and the expired records of the remaining files are cleared
+through the WAL like a DeleteRange. Expiry does not fire the triggers, so e.g.
+aggregates kept longer than the data they were made from stay in place.
+*/
+func ExpireData(now time.Time) (err error) {
+	retention.Lock()
+	defer retention.Unlock()
+	if len(retention.rules) == 0 {
+		return nil
+	}
+	cDir := ThisInstance.CatalogDir
+	buckets := map[string][]*TimeBucketInfo{}
+	for _, tbi := range cDir.GatherTimeBucketInfo() {
+		key, relErr := filepath.Rel(cDir.GetPath(), filepath.Dir(tbi.Path))
+		if relErr != nil {
+			continue
+		}
+		buckets[key] = append(buckets[key], tbi)
+	}
+	for key, tbis := range buckets {
+		for _, rule := range retention.rules {
+			if !rule.on.Match(key) {
+				continue
+			}
+			if bucketErr := expireBucket(key, tbis, now.Add(-rule.period)); bucketErr != nil {
+				Log(ERROR, "Failed to expire the data of %s: %v", key, bucketErr)
+				err = bucketErr
+			}
+			break
+		}
+	}
+	return err
+}
+
+func expireBucket(key string, tbis []*TimeBucketInfo, cutoff time.Time) error {
+	cutoff = ToSystemTimezone(cutoff)
+	sort.Slice(tbis, func(i, j int) bool { return tbis[i].Year < tbis[j].Year })
+	subDir, err := ThisInstance.CatalogDir.GetOwningSubDirectory(tbis[0].Path)
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for kept < len(tbis)-1 && tbis[kept].Year < int16(cutoff.Year()) {
+		if err = subDir.RemoveFile(tbis[kept].Year); err != nil {
+			return err
+		}
+		Log(INFO, "Removed expired file %s", tbis[kept].Path)
+		retentionRemovedFiles.Inc()
+		kept++
+	}
+
+	// The expired records of the kept file are cleared from its first one, as
+	// the passes before cleared the ones ahead of it
+	tbi := tbis[kept]
+	end := cutoff.Add(-time.Nanosecond)
+	if int16(end.Year()) < tbi.Year {
+		return nil
+	}
+	tf := tbi.GetTimeframe()
+	lastIndex := TimeToIndex(yearStart(tbi.Year+1).Add(-time.Nanosecond), tf)
+	if int16(end.Year()) == tbi.Year {
+		lastIndex = TimeToIndex(end, tf)
+	}
+	first, ok, err := firstRecordIndex(tbi, lastIndex)
+	if err != nil || !ok {
+		return err
+	}
+	return deleteRange(NewTimeBucketKey(key), IndexToTime(first, tf, tbi.Year), end, false)
+}
+
+/*
+firstRecordIndex returns the index of the first record of the year file of tbi
+up to lastIndex, ok is false if there is none. The presence bitmap of the file
+is used when it has one, otherwise the slots are read until a record is found.
+*/
+func firstRecordIndex(tbi *TimeBucketInfo, lastIndex int64) (index int64, ok bool, err error) {
+	recordLen := int64(tbi.GetRecordLength())
+	fileSize := FileSize(tbi.GetTimeframe(), int(tbi.Year), int(recordLen))
+	if maxIndex := (fileSize - Headersize) / recordLen; lastIndex > maxIndex {
+		lastIndex = maxIndex
+	}
+	if tbi.GetRecordType() == FIXED {
+		err = ReadPresentRuns(tbi.Path, 1, lastIndex, 0, false, func(first, last int64) bool {
+			index, ok = first, true
+			return false
+		})
+		if !os.IsNotExist(err) {
+			return index, ok, err
+		}
+	}
+
+	fp, err := os.Open(tbi.Path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer fp.Close()
+	if format, err := ReadFileFormat(fp); err == nil && format == COLUMNAR {
+		// The delete only reads the records of a columnar file
+		return 1, true, nil
+	}
+	buffer := make([]byte, RecordsPerRead*recordLen)
+	for first := int64(1); first <= lastIndex; first += RecordsPerRead {
+		n := lastIndex - first + 1
+		if n > RecordsPerRead {
+			n = RecordsPerRead
+		}
+		chunk := buffer[:n*recordLen]
+		if _, err = fp.ReadAt(chunk, IndexToOffset(first, int32(recordLen))); err != nil {
+			return 0, false, err
+		}
+		for i := int64(0); i < n; i++ {
+			if ToInt64(chunk[i*recordLen:]) != 0 {
+				return first + i, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// runRetention applies the retention policies every interval until shutdown
+func runRetention(interval time.Duration) {
+	for !ThisInstance.ShutdownPending {
+		if err := ExpireData(time.Now()); err != nil {
+			Log(ERROR, "Failed to apply the retention policies: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
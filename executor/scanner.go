@@ -1,6 +1,8 @@
 package executor
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -38,14 +40,26 @@ func (iofp *ioFilePlan) GetFileYear() int16 {
 	return iofp.tbi.Year
 }
 
+// indexRange returns the indexes of the first and last records fully within
+// the range of the plan
+func (iofp *ioFilePlan) indexRange(recordLen int32) (firstIndex, lastIndex int64) {
+	firstIndex = (iofp.Offset-Headersize)/int64(recordLen) + 1
+	lastIndex = (iofp.Offset + iofp.Length - Headersize) / int64(recordLen)
+	return firstIndex, lastIndex
+}
+
 type ioplan struct {
 	FilePlan          []*ioFilePlan
 	PrevFilePlan      []*ioFilePlan
 	RecordLen         int32
 	RecordType        EnumRecordType
 	VariableRecordLen int
-	Limit             *planner.RowLimit
-	TimeQuals         []planner.TimeQualFunc
+	// Length of the rows of FIXED records with a sub-second timeframe, zero for
+	// others. Their epochs are packed in nanoseconds until splitNanoseconds.
+	SubSecondRowLen int32
+	Limit           *planner.RowLimit
+	TimeQuals       []planner.TimeQualFunc
+	Context         context.Context // Checked between file chunks to stop cancelled reads
 }
 
 func NewIOPlan(fl SortedFileList, pr *planner.ParseResult) (iop *ioplan, err error) {
@@ -53,6 +67,10 @@ func NewIOPlan(fl SortedFileList, pr *planner.ParseResult) (iop *ioplan, err err
 	iop.FilePlan = make([]*ioFilePlan, 0)
 	iop.PrevFilePlan = make([]*ioFilePlan, 0)
 	iop.Limit = pr.Limit
+	iop.Context = pr.GetContext()
+	if err = iop.Context.Err(); err != nil {
+		return nil, err
+	}
 	/*
 		At this point we have a date unconstrained group of sorted files
 		We will do two things here:
@@ -77,6 +95,9 @@ func NewIOPlan(fl SortedFileList, pr *planner.ParseResult) (iop *ioplan, err err
 			iop.RecordLen = file.File.GetRecordLength()
 			iop.RecordType = file.File.GetRecordType()
 			iop.VariableRecordLen = int(file.File.GetVariableRecordLength())
+			if iop.RecordType == FIXED && file.File.IsSubSecond() {
+				iop.SubSecondRowLen = file.File.GetSubSecondRowLength()
+			}
 		} else {
 			// check that we're reading the same recordlength across all files, return err if not
 			if file.File.GetRecordLength() != iop.RecordLen {
@@ -102,15 +123,15 @@ func NewIOPlan(fl SortedFileList, pr *planner.ParseResult) (iop *ioplan, err err
 			*/
 			// Set the starting and ending indices based on the range
 			if file.File.Year == pr.Range.StartYear {
-				startOffset = EpochToOffset(
-					pr.Range.Start,
+				startOffset = TimeToOffset(
+					time.Unix(pr.Range.Start, int64(pr.Range.StartNanos)),
 					file.File.GetTimeframe(),
 					file.File.GetRecordLength(),
 				)
 			}
 			if file.File.Year == pr.Range.EndYear {
-				endOffset = EpochToOffset(
-					pr.Range.End,
+				endOffset = TimeToOffset(
+					time.Unix(pr.Range.End, int64(pr.Range.EndNanos)),
 					file.File.GetTimeframe(),
 					file.File.GetRecordLength()) + int64(file.File.GetRecordLength())
 			}
@@ -212,6 +233,9 @@ func (r *reader) Read() (csm ColumnSeriesMap, tPrevMap map[TimeBucketKey]int64,
 	dsMap := r.pr.GetDataShapes()
 	rlMap := r.pr.GetRowLen()
 	for key, iop := range r.IOPMap {
+		if err = iop.Context.Err(); err != nil {
+			return nil, nil, err
+		}
 		cat := catMap[key]
 		rt := rtMap[key]
 		rlen := rlMap[key]
@@ -222,11 +246,36 @@ func (r *reader) Read() (csm ColumnSeriesMap, tPrevMap map[TimeBucketKey]int64,
 		tPrevMap[key] = tPrev
 		rs := NewRowSeries(key, tPrev, buffer, dsMap[key], rlen, cat, rt)
 		key, cs := rs.ToColumnSeries()
+		if err = r.applyColumnQuals(cs); err != nil {
+			return nil, nil, err
+		}
 		csm[key] = cs
 	}
 	return csm, tPrevMap, err
 }
 
+/*
+applyColumnQuals removes the rows of cs rejected by any of the column qualifiers
+*/
+func (r *reader) applyColumnQuals(cs *ColumnSeries) error {
+	if len(r.pr.ColumnQuals) == 0 || cs.Len() == 0 {
+		return nil
+	}
+	removalBitmap := make([]bool, cs.Len())
+	for _, columnQual := range r.pr.ColumnQuals {
+		keep, err := columnQual(cs)
+		if err != nil {
+			return err
+		}
+		for i, k := range keep {
+			if !k {
+				removalBitmap[i] = true
+			}
+		}
+	}
+	return cs.RestrictViaBitmap(removalBitmap)
+}
+
 /*
 bufferMeta stores an indirect index to variable length data records. It's used to read the actual data in a second pass.
 */
@@ -292,6 +341,9 @@ func (r *reader) read(iop *ioplan) (resultBuffer []byte, tPrev int64, err error)
 				iop.RecordLen,
 				limitBytes,
 				readBuffer)
+			if ctxErr := iop.Context.Err(); ctxErr != nil {
+				return nil, 0, ctxErr
+			}
 			if iop.RecordType == VARIABLE {
 				// If we've added data to the buffer from this file, record it for possible later use
 				if len(resultBuffer) > dataLen {
@@ -328,7 +380,7 @@ func (r *reader) read(iop *ioplan) (resultBuffer []byte, tPrev int64, err error)
 				if finished {
 					if bytesRead != 0 {
 						// We found a record, let's grab the tPrev time from it
-						tPrev = int64(binary.LittleEndian.Uint64(tPrevBuff[0:]))
+						tPrev = iop.epochSeconds(int64(binary.LittleEndian.Uint64(tPrevBuff[0:])))
 					}
 					break
 				} else if err != nil {
@@ -397,7 +449,7 @@ func (r *reader) read(iop *ioplan) (resultBuffer []byte, tPrev int64, err error)
 
 		if GatherTprev {
 			if len(resultBuffer) > 0 {
-				tPrev = int64(binary.LittleEndian.Uint64(resultBuffer[0:]))
+				tPrev = iop.epochSeconds(int64(binary.LittleEndian.Uint64(resultBuffer[0:])))
 				// Chop off the first record
 				resultBuffer = resultBuffer[iop.RecordLen:]
 				if iop.RecordType == VARIABLE {
@@ -421,29 +473,66 @@ func (r *reader) read(iop *ioplan) (resultBuffer []byte, tPrev int64, err error)
 			return nil, 0, err
 		}
 	}
+	if iop.SubSecondRowLen != 0 {
+		resultBuffer = splitNanoseconds(resultBuffer, iop.RecordLen, iop.SubSecondRowLen)
+	}
 
 	return resultBuffer, tPrev, err
 }
 
+// epochSeconds converts an epoch packed by the readers of the plan to seconds
+func (iop *ioplan) epochSeconds(epoch int64) int64 {
+	if iop.SubSecondRowLen != 0 {
+		return time.Unix(0, epoch).Unix()
+	}
+	return epoch
+}
+
+/*
+splitNanoseconds repacks the records of a sub-second FIXED bucket, whose epochs
+are packed in nanoseconds, in rows of rowLen bytes holding the epoch in seconds,
+the fields without the alignment padding, and the nanoseconds within the second
+as a trailing INT32 column.
+*/
+func splitNanoseconds(buffer []byte, recordLen, rowLen int32) []byte {
+	fieldsEnd := int(rowLen) - 4
+	rows := make([]byte, 0, len(buffer)/int(recordLen)*int(rowLen))
+	for i := 0; i+int(recordLen) <= len(buffer); i += int(recordLen) {
+		t := time.Unix(0, ToInt64(buffer[i:]))
+		row := len(rows)
+		rows = append(rows, buffer[i:i+fieldsEnd]...)
+		rows = append(rows, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(rows[row:], uint64(t.Unix()))
+		binary.LittleEndian.PutUint32(rows[row+fieldsEnd:], uint32(t.Nanosecond()))
+	}
+	return rows
+}
+
 type ioExec struct {
 	plan *ioplan
 }
 
 func (ex *ioExec) packingReader(packedBuffer *[]byte, f io.ReadSeeker, buffer []byte,
-	maxRead int64, fp *ioFilePlan) error {
+	maxRead int64, maxPacked int32, fp *ioFilePlan) error {
 	// Reads data from file f positioned after the header
 	// Will read records of size recordsize, decoding the index value to determine if this is a null or valid record
 	// The output is a buffer "packedBuffer" that contains only valid records
 	// The index value is converted to a UNIX Epoch timestamp based on the basetime and intervalsecs
 	// buffer is the temporary buffer to store read content from file, and indicates the maximum size to read
 	// maxRead limits the number of bytes to be read from the file
+	// maxPacked limits the size of packedBuffer, so a limited scan stops reading once it has enough records
 	// Exit conditions:
 	// ==> leftbytes <= 0
+	// ==> len(packedBuffer) >= maxPacked
+	// ==> the context of the plan is done, returning its error
 
 	recordSize := ex.plan.RecordLen
 
 	var totalRead int64
 	for {
+		if err := ex.plan.Context.Err(); err != nil {
+			return err
+		}
 		n, _ := f.Read(buffer)
 
 		nn := int64(n)
@@ -468,9 +557,10 @@ func (ex *ioExec) packingReader(packedBuffer *[]byte, f io.ReadSeeker, buffer []
 			curpos := i * int64(recordSize)
 			index := int64(binary.LittleEndian.Uint64(buffer[curpos:]))
 			if index != 0 {
-				// Convert the index to a UNIX timestamp (seconds from epoch)
-				index = IndexToTime(index, fp.tbi.GetTimeframe(), fp.GetFileYear()).Unix()
-				if !ex.checkTimeQuals(index) {
+				// Convert the index to a UNIX timestamp, see indexToEpoch
+				var epoch int64
+				index, epoch = ex.indexToEpoch(index, fp)
+				if !ex.checkTimeQuals(epoch) {
 					continue
 				}
 				idxpos := len(*packedBuffer)
@@ -486,6 +576,9 @@ func (ex *ioExec) packingReader(packedBuffer *[]byte, f io.ReadSeeker, buffer []
 					}
 					fp.seekingLast = false
 				}
+				if int64(len(*packedBuffer)) >= int64(maxPacked) {
+					return nil
+				}
 			}
 		}
 		if leftBytes <= 0 {
@@ -510,15 +603,45 @@ func (ex *ioExec) readForward(finalBuffer []byte, fp *ioFilePlan, recordLen, byt
 	}
 	defer f.Close()
 
-	if _, err = f.Seek(fp.Offset, os.SEEK_SET); err != nil {
-		Log(ERROR, "Read: seeking in %s\n%s", filePath, err)
-		return finalBuffer, false, err
-	}
+	var runErr error // Of reading the regions holding records
+	if format, err := ReadFileFormat(f); err == nil && format == COLUMNAR {
+		packed, err := ex.readColumnar(f, fp, bytesToRead-int32(len(finalBuffer)), false)
+		if err != nil {
+			Log(ERROR, "Read: reading data from %s\n%s", filePath, err)
+			return finalBuffer, false, err
+		}
+		finalBuffer = append(finalBuffer, packed...)
+	} else if ok, bitmapErr := ex.presentRuns(fp, RecordsPerRead, false, func(first, last int64) bool {
+		// Read only the regions holding records
+		if _, runErr = f.Seek(IndexToOffset(first, recordLen), os.SEEK_SET); runErr != nil {
+			Log(ERROR, "Read: seeking in %s\n%s", filePath, runErr)
+			return false
+		}
+		length := (last - first + 1) * int64(recordLen)
+		if runErr = ex.packingReader(&finalBuffer, f, readBuffer, length, bytesToRead, fp); runErr != nil {
+			Log(ERROR, "Read: reading data from %s\n%s", filePath, runErr)
+			return false
+		}
+		return int32(len(finalBuffer)) < bytesToRead
+	}); ok {
+		if bitmapErr != nil {
+			Log(ERROR, "Read: reading the presence bitmap of %s\n%s", filePath, bitmapErr)
+			return finalBuffer, false, bitmapErr
+		}
+		if runErr != nil {
+			return finalBuffer, false, runErr
+		}
+	} else {
+		if _, err = f.Seek(fp.Offset, os.SEEK_SET); err != nil {
+			Log(ERROR, "Read: seeking in %s\n%s", filePath, err)
+			return finalBuffer, false, err
+		}
 
-	if err = ex.packingReader(&finalBuffer, f, readBuffer, fp.Length, fp); err != nil {
-		Log(ERROR, "Read: reading data from %s\n%s", filePath, err)
-		return finalBuffer, false, err
+		if err = ex.packingReader(&finalBuffer, f, readBuffer, fp.Length, bytesToRead, fp); err != nil {
+			Log(ERROR, "Read: reading data from %s\n%s", filePath, err)
+			return finalBuffer, false, err
 
+		}
 	}
 	//			fmt.Printf("Length of final buffer: %d\n",len(finalBuffer))
 	if int32(len(finalBuffer)) >= bytesToRead {
@@ -534,9 +657,7 @@ func (ex *ioExec) readBackward(finalBuffer []byte, fp *ioFilePlan,
 	result []byte, finished bool, bytesRead int32, err error) {
 
 	filePath := fp.FullPath
-	beginPos := fp.Offset
 
-	maxToBuffer := int32(len(readBuffer))
 	if finalBuffer == nil {
 		finalBuffer = make([]byte, bytesToRead, bytesToRead)
 	}
@@ -548,13 +669,71 @@ func (ex *ioExec) readBackward(finalBuffer []byte, fp *ioFilePlan,
 	}
 	defer f.Close()
 
+	if format, err := ReadFileFormat(f); err == nil && format == COLUMNAR {
+		if fileBuffer, err = ex.readColumnar(f, fp, bytesToRead, true); err != nil {
+			Log(ERROR, "Read: reading data from %s\n%s", filePath, err)
+			return nil, false, 0, err
+		}
+		numRead := int32(len(fileBuffer))
+		bytesRead = numRead
+		if numRead <= bytesToRead {
+			bytesToRead -= numRead
+			copy(finalBuffer[bytesToRead:], fileBuffer)
+		} else {
+			copy(finalBuffer, fileBuffer[numRead-bytesToRead:])
+			bytesToRead = 0
+		}
+		return finalBuffer, bytesToRead == 0, bytesRead, nil
+	}
+
+	var runErr error // Of reading the regions holding records
+	if ok, bitmapErr := ex.presentRuns(fp, RecordsPerRead, true, func(first, last int64) bool {
+		// Read only the regions holding records, from the last
+		narrowed := *fp
+		narrowed.Offset = IndexToOffset(first, recordLen)
+		narrowed.Length = (last - first + 1) * int64(recordLen)
+		var n int32
+		n, bytesToRead, runErr = ex.scanBackward(f, &narrowed, finalBuffer, bytesToRead, readBuffer, fileBuffer)
+		fp.seekingLast = narrowed.seekingLast
+		bytesRead += n
+		return runErr == nil && bytesToRead > 0
+	}); ok {
+		if bitmapErr != nil {
+			Log(ERROR, "Read: reading the presence bitmap of %s\n%s", filePath, bitmapErr)
+			return nil, false, 0, bitmapErr
+		}
+		if runErr != nil {
+			return nil, false, 0, runErr
+		}
+		return finalBuffer, bytesToRead == 0, bytesRead, nil
+	}
+
+	bytesRead, bytesToRead, err = ex.scanBackward(f, fp, finalBuffer, bytesToRead, readBuffer, fileBuffer)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	return finalBuffer, bytesToRead == 0, bytesRead, nil
+}
+
+/*
+scanBackward reads the records within the range of fp from the DENSE file f
+from the end, into the end of the first bytesToRead bytes of finalBuffer. It
+returns the number of bytes read and of bytes left to fill.
+*/
+func (ex *ioExec) scanBackward(f *os.File, fp *ioFilePlan, finalBuffer []byte, bytesToRead int32,
+	readBuffer []byte, fileBuffer []byte) (bytesRead, bytesLeft int32, err error) {
+
+	filePath := fp.FullPath
+	beginPos := fp.Offset
+	maxToBuffer := int32(len(readBuffer))
+
 	// Seek to the right end of the search set
 	f.Seek(beginPos+fp.Length, os.SEEK_SET)
 	// Seek backward one buffer size (max)
 	maxToRead, curpos, err := seekBackward(f, maxToBuffer, beginPos)
 	if err != nil {
 		Log(ERROR, "Read: seeking within %s\n%s", filePath, err)
-		return nil, false, 0, err
+		return 0, bytesToRead, err
 	}
 
 	for {
@@ -563,10 +742,10 @@ func (ex *ioExec) readBackward(finalBuffer []byte, fp *ioFilePlan,
 		if err = ex.packingReader(
 			&fileBuffer,
 			f, readBuffer,
-			maxToRead, fp); err != nil {
+			maxToRead, math.MaxInt32, fp); err != nil {
 
 			Log(ERROR, "Read: reading data from %s\n%s", filePath, err)
-			return nil, false, 0, err
+			return 0, bytesToRead, err
 		}
 
 		numRead := int32(len(fileBuffer))
@@ -596,17 +775,97 @@ func (ex *ioExec) readBackward(finalBuffer []byte, fp *ioFilePlan,
 			// Exit the read operation if we get here with an error
 			if err != nil {
 				Log(ERROR, "Read: seeking within %s\n%s", filePath, err)
-				return nil, false, 0, err
+				return 0, bytesToRead, err
 			}
 		} else {
 			break
 		}
 
 	}
-	if bytesToRead == 0 {
-		return finalBuffer, true, bytesRead, nil
+	return bytesRead, bytesToRead, nil
+}
+
+/*
+readColumnar returns the records of the COLUMNAR file f within the range of fp,
+packed as packingReader does for DENSE files. It stops once it has maxPacked
+bytes of them, scanning from the end of the range if backward is set.
+*/
+func (ex *ioExec) readColumnar(f *os.File, fp *ioFilePlan, maxPacked int32, backward bool) ([]byte, error) {
+	recordLen := int(ex.plan.RecordLen)
+	firstIndex, lastIndex := fp.indexRange(ex.plan.RecordLen)
+	chunks, err := ReadColumnarDirectory(f)
+	if err != nil {
+		return nil, err
+	}
+	var selected []ColumnarChunk
+	for _, chunk := range chunks {
+		if chunk.LastIndex >= firstIndex && chunk.FirstIndex <= lastIndex {
+			selected = append(selected, chunk)
+		}
+	}
+	if backward {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+
+	var parts [][]byte
+	var total int
+	for _, chunk := range selected {
+		if err = ex.plan.Context.Err(); err != nil {
+			return nil, err
+		}
+		records, err := decodeColumnarChunk(nil, f, fp.tbi, chunk)
+		if err != nil {
+			return nil, err
+		}
+		// Pack in place, converting the indexes to epochs
+		packed := records[:0]
+		for i := 0; i < len(records); i += recordLen {
+			index := ToInt64(records[i:])
+			if index < firstIndex || index > lastIndex {
+				continue
+			}
+			packedEpoch, epoch := ex.indexToEpoch(index, fp)
+			if !ex.checkTimeQuals(epoch) {
+				continue
+			}
+			idxpos := len(packed)
+			packed = append(packed, records[i:i+recordLen]...)
+			binary.LittleEndian.PutUint64(packed[idxpos:], uint64(packedEpoch))
+		}
+		parts = append(parts, packed)
+		if total += len(packed); total >= int(maxPacked) {
+			break
+		}
+	}
+	if backward {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+/*
+presentRuns calls fn with the runs of records within the range of fp from the
+presence bitmap of its file, from the last if backward is set, until it returns
+false, merging the runs separated by at most minGap empty slots. It returns false if the file has no bitmap, e.g.
+as it predates them, and the error reading the bitmap otherwise.
+*/
+func (ex *ioExec) presentRuns(fp *ioFilePlan, minGap int64, backward bool, fn func(first, last int64) bool) (bool, error) {
+	if ex.plan.RecordType != FIXED {
+		return false, nil
+	}
+	firstIndex, lastIndex := fp.indexRange(ex.plan.RecordLen)
+	if lastIndex < firstIndex {
+		return false, nil
 	}
-	return finalBuffer, false, bytesRead, nil
+	err := ReadPresentRuns(fp.FullPath, firstIndex, lastIndex, minGap, backward, fn)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, err
 }
 
 func seekBackward(f io.Seeker, relative_offset int32, lowerBound int64) (seekAmt int64, curpos int64, err error) {
@@ -631,6 +890,20 @@ func seekBackward(f io.Seeker, relative_offset int32, lowerBound int64) (seekAmt
 	return seekAmt, curpos, nil
 }
 
+/*
+indexToEpoch converts the index of a record of fp to the epoch packed in its
+place, and to the epoch in seconds the time qualifiers are checked against. The
+packed epoch is in nanoseconds for sub-second timeframes, so that the
+nanoseconds are kept until splitNanoseconds.
+*/
+func (ex *ioExec) indexToEpoch(index int64, fp *ioFilePlan) (packed, epoch int64) {
+	t := IndexToTime(index, fp.tbi.GetTimeframe(), fp.GetFileYear())
+	if ex.plan.SubSecondRowLen != 0 {
+		return t.UnixNano(), t.Unix()
+	}
+	return t.Unix(), t.Unix()
+}
+
 func (ex *ioExec) checkTimeQuals(epoch int64) bool {
 	if len(ex.plan.TimeQuals) > 0 {
 		for _, timeQual := range ex.plan.TimeQuals {
@@ -0,0 +1,400 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/dannyluong408/marketstore/catalog"
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+)
+
+// schemaChange is held by the writes while they are checked against the data
+// shapes of their buckets and queued, and by AlterSchema while they change
+var schemaChange sync.RWMutex
+
+/*
+AlterSchema changes the data shapes of the bucket tbk to shapes, rewriting the
+header and the records of each of its year files. The columns of shapes are
+matched to the current ones by name: a new column is filled with zeros, a
+column left out is dropped, and a column of another type is converted like a Go
+numeric conversion, so a narrower type truncates. The records of sub-second and
+VARIABLE buckets keep their time.
+
+The pending writes are flushed and the primary files checkpointed first, so
+that none is replayed from the WAL with the former shapes, and the writes
+issued meanwhile wait until it is done. The year files are all rewritten
+before any of them is replaced, and either all or none of them are, even
+across a crash. Buckets aggregated from tbk keep their shapes.
+*/
+func AlterSchema(tbk *TimeBucketKey, shapes []DataShape) (err error) {
+	shapes = withoutEpoch(shapes)
+	if err = validateShapes(shapes); err != nil {
+		return err
+	}
+	subDir, err := ThisInstance.CatalogDir.GetSubDirectoryFromKey(tbk)
+	if err != nil {
+		return err
+	}
+	schemaChange.Lock()
+	defer schemaChange.Unlock()
+	ThisInstance.WALFile.RunPaused(func() {
+		err = alterBucket(subDir, shapes)
+	})
+	if err != nil {
+		return err
+	}
+	Log(INFO, "Altered the data shapes of %s", tbk.GetItemKey())
+	return nil
+}
+
+func withoutEpoch(shapes []DataShape) (out []DataShape) {
+	for _, shape := range shapes {
+		if shape.Name != "Epoch" {
+			out = append(out, shape)
+		}
+	}
+	return out
+}
+
+func validateShapes(shapes []DataShape) error {
+	if len(shapes) == 0 {
+		return fmt.Errorf("A bucket needs at least one column besides the Epoch")
+	}
+	for i, shape := range shapes {
+		switch shape.Type {
+		case NONE, STRING, EPOCH:
+			return fmt.Errorf("Column %s can not be of type %v", shape.Name, shape.Type)
+		}
+		for _, other := range shapes[:i] {
+			if strings.EqualFold(shape.Name, other.Name) {
+				return fmt.Errorf("Column %s is given more than once", shape.Name)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+alterBucket rewrites the year files of the bucket directory subDir with shapes
+and updates their catalog entries. The rewritten files are all written before
+any is replaced, and the former ones are kept until all are, so that a failure
+restores them. The change is recorded in the schema journal meanwhile, for a
+crash to be settled on the next startup.
+*/
+func alterBucket(subDir *catalog.Directory, shapes []DataShape) (err error) {
+	tbis := subDir.GetTimeBucketInfoSlice()
+	if len(tbis) == 0 {
+		return catalog.SubdirectoryDoesNotContainFiles(subDir.GetPath())
+	}
+	altered := make([]*TimeBucketInfo, 0, len(tbis))
+	paths := make([]string, 0, len(tbis))
+	defer func() {
+		if err != nil {
+			for _, path := range paths {
+				os.Remove(path + ".tmp")
+			}
+		}
+	}()
+	for _, tbi := range tbis {
+		alteredTbi, err := alterFile(tbi.Path, shapes)
+		if err != nil {
+			return err
+		}
+		altered = append(altered, alteredTbi)
+		paths = append(paths, tbi.Path)
+	}
+
+	journal := filepath.Join(ThisInstance.RootDir, schemaJournal)
+	if err = writeSchemaJournal(journal, false, paths); err != nil {
+		return err
+	}
+	var moved int
+	for _, path := range paths {
+		if err = os.Rename(path, path+".orig"); err == nil {
+			moved++
+			err = os.Rename(path+".tmp", path)
+		}
+		if err != nil {
+			Log(ERROR, "Failed to replace %s, restoring the former data shapes of %s - Error: %v",
+				path, subDir.GetPath(), err)
+			if undoErr := settleSchemaChange(journal, false, paths[:moved]); undoErr != nil {
+				Log(ERROR, "Failed to restore the former data shapes of %s, the schema journal is kept - Error: %v",
+					subDir.GetPath(), undoErr)
+			}
+			return err
+		}
+	}
+	if err = writeSchemaJournal(journal, true, paths); err != nil {
+		if undoErr := settleSchemaChange(journal, false, paths); undoErr != nil {
+			Log(ERROR, "Failed to restore the former data shapes of %s, the schema journal is kept - Error: %v",
+				subDir.GetPath(), undoErr)
+		}
+		return err
+	}
+	if err = settleSchemaChange(journal, true, paths); err != nil {
+		// The new files are in place, what is left is settled on startup
+		Log(ERROR, "Failed to remove the former year files of %s - Error: %v", subDir.GetPath(), err)
+	}
+	for _, tbi := range altered {
+		if err := subDir.UpdateFile(tbi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaJournal is the file of the root directory recording the change of data
+// shapes in progress, written before the first year file is replaced
+const schemaJournal = "schema.journal"
+
+/*
+writeSchemaJournal atomically writes the schema journal at path, listing the
+year files being replaced and whether all of them have been
+*/
+func writeSchemaJournal(path string, committed bool, paths []string) error {
+	state := "pending"
+	if committed {
+		state = "committed"
+	}
+	out, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteString(state + "\n" + strings.Join(paths, "\n") + "\n")
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+	}
+	return err
+}
+
+/*
+settleSchemaChange ends the change of data shapes of the year files at paths:
+if committed it removes the former files kept next to them, otherwise it puts
+them back in place. It then removes the rewritten files left and the schema
+journal. Settling a change again does nothing more.
+*/
+func settleSchemaChange(journal string, committed bool, paths []string) error {
+	for _, path := range paths {
+		if committed {
+			if err := os.Remove(path + ".orig"); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		} else if _, err := os.Stat(path + ".orig"); err == nil {
+			if err = os.Rename(path+".orig", path); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path + ".tmp"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+RecoverSchemaChange settles the change of data shapes interrupted by a crash,
+recorded in the schema journal of the root directory rootDir. A change not
+committed is undone, so the files of a bucket never mix data shapes. It is
+called on startup, before the catalog is loaded.
+*/
+func RecoverSchemaChange(rootDir string) error {
+	journal := filepath.Join(rootDir, schemaJournal)
+	contents, err := ioutil.ReadFile(journal)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	committed := lines[0] == "committed"
+	if err = settleSchemaChange(journal, committed, lines[1:]); err != nil {
+		return fmt.Errorf("Settling the schema journal %s: %v", journal, err)
+	}
+	if committed {
+		Log(INFO, "Completed the interrupted change of data shapes of %d year files", len(lines)-1)
+	} else {
+		Log(INFO, "Undid the interrupted change of data shapes of %d year files", len(lines)-1)
+	}
+	return nil
+}
+
+// alterFile writes the year file at path rewritten with shapes next to it, and
+// returns the TimeBucketInfo of its header
+func alterFile(path string, shapes []DataShape) (altered *TimeBucketInfo, err error) {
+	tbi, err := ReadTimeBucketInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := newRecordConverter(tbi.GetDataShapes(), shapes)
+	if err != nil {
+		return nil, fmt.Errorf("Altering %s: %v", path, err)
+	}
+	altered = tbi.GetDeepCopy()
+	altered.SetDataShapes(shapes)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var write func(out *os.File) error
+	switch {
+	case tbi.GetRecordType() == VARIABLE:
+		fromLen, toLen := int(tbi.GetVariableRecordLength()), int(altered.GetVariableRecordLength())
+		write = func(out *os.File) error {
+			_, err := copyPayloads(out, f, tbi, func(payload []byte) []byte {
+				records := make([]byte, len(payload)/fromLen*toLen)
+				for i, j := 0, 0; i < len(payload); i, j = i+fromLen, j+toLen {
+					conv.convert(records[j:j+toLen-4], payload[i:i+fromLen-4])
+					copy(records[j+toLen-4:j+toLen], payload[i+fromLen-4:i+fromLen]) // The interval ticks
+				}
+				return records
+			})
+			return err
+		}
+	case tbi.GetFormat() == COLUMNAR:
+		records, err := readColumnarRecords(context.Background(), f, tbi, 1, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		fromLen, toLen := int(tbi.GetRecordLength()), int(altered.GetRecordLength())
+		converted := make([]byte, len(records)/fromLen*toLen)
+		conv.convertFixed(converted, records, fromLen, toLen)
+		body := EncodeColumnar(converted, toLen, altered.GetElementTypes())
+		write = func(out *os.File) error {
+			_, err := out.Write(body)
+			return err
+		}
+	default:
+		write = func(out *os.File) error {
+			return alterDenseRecords(out, f, tbi, altered, conv)
+		}
+	}
+	if _, err = writeTempFile(path, altered, write); err != nil {
+		return nil, err
+	}
+	return altered, nil
+}
+
+// alterDenseRecords writes the records of the DENSE FIXED year file f described
+// by tbi to out, converted to the record length of altered. The empty regions
+// of f are left unwritten. The slots are the same, and so is the presence bitmap.
+func alterDenseRecords(out, f *os.File, tbi, altered *TimeBucketInfo, conv *recordConverter) error {
+	fromLen, toLen := int64(tbi.GetRecordLength()), int64(altered.GetRecordLength())
+	if err := out.Truncate(FileSize(altered.GetTimeframe(), int(altered.Year), int(toLen))); err != nil {
+		return err
+	}
+	fileSize := FileSize(tbi.GetTimeframe(), int(tbi.Year), int(fromLen))
+	buffer := make([]byte, RecordsPerRead*fromLen)
+	converted := make([]byte, RecordsPerRead*toLen)
+	for offset := int64(Headersize); offset < fileSize; offset += int64(len(buffer)) {
+		n, err := f.ReadAt(buffer, offset)
+		if err != nil && n == 0 {
+			return err
+		}
+		records := buffer[:int64(n)/fromLen*fromLen]
+		for i := range converted {
+			converted[i] = 0
+		}
+		if !conv.convertFixed(converted, records, int(fromLen), int(toLen)) {
+			continue
+		}
+		slot := (offset - Headersize) / fromLen
+		if _, err = out.WriteAt(converted[:int64(len(records))/fromLen*toLen], Headersize+slot*toLen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type columnConversion struct {
+	from, to         int // Offsets of the column in the records
+	fromType, toType EnumElementType
+}
+
+// recordConverter converts the fields of records from one set of data shapes
+// to another
+type recordConverter struct {
+	columns []columnConversion
+}
+
+func newRecordConverter(from, to []DataShape) (*recordConverter, error) {
+	conv := new(recordConverter)
+	toOffset := 0
+	for _, toShape := range to {
+		fromOffset := 0
+		for _, fromShape := range from {
+			if !strings.EqualFold(fromShape.Name, toShape.Name) {
+				fromOffset += fromShape.Len()
+				continue
+			}
+			if fromShape.Type != toShape.Type && !(isNumeric(fromShape.Type) && isNumeric(toShape.Type)) {
+				return nil, fmt.Errorf("Column %s of type %v can not be converted to %v",
+					toShape.Name, fromShape.Type, toShape.Type)
+			}
+			conv.columns = append(conv.columns, columnConversion{
+				from: fromOffset, to: toOffset, fromType: fromShape.Type, toType: toShape.Type,
+			})
+			break
+		}
+		toOffset += toShape.Len()
+	}
+	return conv, nil
+}
+
+func isNumeric(elType EnumElementType) bool {
+	switch elType.Kind() {
+	case reflect.Float32, reflect.Float64,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// convert writes the fields of the record src into the zeroed record dst
+func (conv *recordConverter) convert(dst, src []byte) {
+	for _, col := range conv.columns {
+		if col.fromType == col.toType {
+			copy(dst[col.to:col.to+col.toType.Size()], src[col.from:col.from+col.fromType.Size()])
+			continue
+		}
+		value := reflect.NewAt(col.fromType.TypeOf(), unsafe.Pointer(&src[col.from])).Elem()
+		reflect.NewAt(col.toType.TypeOf(), unsafe.Pointer(&dst[col.to])).Elem().Set(value.Convert(col.toType.TypeOf()))
+	}
+}
+
+// convertFixed converts the FIXED records in src into the zeroed dst, keeping
+// their index and skipping the empty ones. It returns whether any was converted.
+func (conv *recordConverter) convertFixed(dst, src []byte, fromLen, toLen int) (converted bool) {
+	for i, j := 0, 0; i+fromLen <= len(src); i, j = i+fromLen, j+toLen {
+		if ToInt64(src[i:]) == 0 {
+			continue
+		}
+		copy(dst[j:j+8], src[i:i+8])
+		conv.convert(dst[j+8:j+toLen], src[i+8:i+fromLen])
+		converted = true
+	}
+	return converted
+}
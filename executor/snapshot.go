@@ -0,0 +1,211 @@
+package executor
+
+import (
+	"fmt"
+	goio "io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+)
+
+/*
+Snapshot copies the database into dir, which must not exist yet, as a
+consistent snapshot that RestoreSnapshot can restore. The pending writes are
+flushed and the primary files checkpointed first, and the writes issued while
+the files are copied are held back in the TransactionPipe until it is done.
+The files are copied rather than hard-linked, as the year files are updated in
+place. WAL files are left out, the checkpoint makes them unnecessary. It
+returns the number of files and bytes copied.
+*/
+func Snapshot(dir string) (files int, size int64, err error) {
+	rootDir := ThisInstance.RootDir
+	if dir, err = filepath.Abs(dir); err != nil {
+		return 0, 0, err
+	}
+	if isWithin(rootDir, dir) {
+		return 0, 0, fmt.Errorf("Snapshot directory %s is inside the root directory %s", dir, rootDir)
+	}
+	if _, err = os.Stat(dir); err == nil {
+		return 0, 0, fmt.Errorf("Snapshot directory %s already exists", dir)
+	}
+	ThisInstance.WALFile.RunPaused(func() {
+		files, size, err = copyTree(rootDir, dir)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return 0, 0, err
+	}
+	Log(INFO, "Snapshot of %d files (%d bytes) written to %s", files, size, dir)
+	return files, size, nil
+}
+
+/*
+VerifySnapshot checks that dir holds a loadable catalog: every directory has
+its category_name, and every year file has a readable header matching its name
+and size, with the same data shape as the other years of its bucket.
+*/
+func VerifySnapshot(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == "metadata.db" {
+			return filepath.SkipDir // Not part of the catalog
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(path, "category_name")); err != nil {
+			return fmt.Errorf("Directory %s has no category_name: %v", path, err)
+		}
+		yearFiles, err := filepath.Glob(filepath.Join(path, "*.bin"))
+		if err != nil {
+			return err
+		}
+		var first *TimeBucketInfo
+		for _, yearFile := range yearFiles {
+			tbi, err := verifyYearFile(yearFile)
+			if err != nil {
+				return err
+			}
+			if first == nil {
+				first = tbi
+			} else if !sameShape(first, tbi) {
+				return fmt.Errorf("Header of %s does not match the one of %s", yearFile, first.Path)
+			}
+		}
+		return nil
+	})
+}
+
+/*
+RestoreSnapshot verifies the snapshot in dir and copies it into rootDir, which
+must be empty or not exist yet. The server must not be running on rootDir. It
+returns the number of files and bytes copied.
+*/
+func RestoreSnapshot(dir, rootDir string) (files int, size int64, err error) {
+	if err = VerifySnapshot(dir); err != nil {
+		return 0, 0, err
+	}
+	if entries, err := ioutil.ReadDir(rootDir); err == nil && len(entries) != 0 {
+		return 0, 0, fmt.Errorf("Root directory %s is not empty", rootDir)
+	}
+	return copyTree(dir, rootDir)
+}
+
+func verifyYearFile(path string) (*TimeBucketInfo, error) {
+	tbi, err := ReadTimeBucketInfo(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the header of %s: %v", path, err)
+	}
+	year, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".bin"))
+	if err != nil || int16(year) != tbi.Year {
+		return nil, fmt.Errorf("Header year %d does not match the file name %s", tbi.Year, path)
+	}
+	if tbi.GetVersion() != FileinfoVersion {
+		return nil, fmt.Errorf("Unsupported version %d in the header of %s", tbi.GetVersion(), path)
+	}
+	recordType := tbi.GetRecordType()
+	if tbi.GetTimeframe() <= 0 || tbi.GetNelements() <= 0 || (recordType != FIXED && recordType != VARIABLE) {
+		return nil, fmt.Errorf("Invalid header in %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if tbi.GetFormat() == COLUMNAR {
+		if info.Size() < Headersize+8 {
+			return nil, fmt.Errorf("Size %d of compacted file %s is too short", info.Size(), path)
+		}
+		return tbi, nil
+	}
+	// Records of VARIABLE buckets are appended past the end of the index
+	expected := FileSize(tbi.GetTimeframe(), int(tbi.Year), int(tbi.GetRecordLength()))
+	if info.Size() < expected || (recordType == FIXED && info.Size() != expected) {
+		return nil, fmt.Errorf("Size %d of %s does not match its header, expected %d", info.Size(), path, expected)
+	}
+	return tbi, nil
+}
+
+func sameShape(a, b *TimeBucketInfo) bool {
+	if a.GetTimeframe() != b.GetTimeframe() || a.GetRecordType() != b.GetRecordType() ||
+		a.GetRecordLength() != b.GetRecordLength() || a.GetNelements() != b.GetNelements() {
+		return false
+	}
+	names, types := b.GetElementNames(), b.GetElementTypes()
+	for i, name := range a.GetElementNames() {
+		if name != names[i] || a.GetElementTypes()[i] != types[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// copyTree copies the directories and files under src to dst, except WAL files
+func copyTree(src, dst string) (files int, size int64, err error) {
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed by a destroy or retention since listed
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0770)
+		case !info.Mode().IsRegular() || filepath.Ext(path) == ".walfile":
+			return nil
+		}
+		n, err := copyFile(path, target, info.Mode())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		files++
+		size += n
+		return nil
+	})
+	return files, size, err
+}
+
+func copyFile(src, dst string, mode os.FileMode) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return 0, err
+	}
+	n, err := goio.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// isWithin returns true if path is dir or under it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
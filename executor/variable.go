@@ -0,0 +1,230 @@
+package executor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dannyluong408/marketstore/utils"
+	. "github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/gobwas/glob"
+)
+
+// VariableWriteMode is how a write to an interval of a VARIABLE bucket is
+// merged with the records already in it
+type VariableWriteMode int
+
+const (
+	// APPEND adds the records of the write to the ones of the interval
+	APPEND VariableWriteMode = iota
+	// UPSERT replaces the records of the interval having the same time as a
+	// record of the write, and adds the others
+	UPSERT
+)
+
+type variableWriteRule struct {
+	on   glob.Glob
+	mode VariableWriteMode
+}
+
+var variableWrites = struct {
+	sync.RWMutex
+	rules []variableWriteRule
+}{}
+
+// SetVariableWrites replaces the write modes of the VARIABLE buckets, e.g. on a
+// config reload. The first setting whose key glob matches a bucket applies to
+// it, the others append.
+func SetVariableWrites(settings []*utils.VariableWriteSetting) error {
+	rules := make([]variableWriteRule, 0, len(settings))
+	for _, s := range settings {
+		g, err := glob.Compile(s.On, '/')
+		if err != nil {
+			return fmt.Errorf("Invalid variable writes key %s: %v", s.On, err)
+		}
+		rule := variableWriteRule{on: g}
+		switch s.Mode {
+		case "append":
+			rule.mode = APPEND
+		case "upsert":
+			rule.mode = UPSERT
+		default:
+			return fmt.Errorf("Invalid variable writes mode %s for %s", s.Mode, s.On)
+		}
+		rules = append(rules, rule)
+	}
+	variableWrites.Lock()
+	defer variableWrites.Unlock()
+	variableWrites.rules = rules
+	return nil
+}
+
+// variableWriteMode returns the write mode of the bucket of the year file at
+// path
+func variableWriteMode(path string) VariableWriteMode {
+	variableWrites.RLock()
+	defer variableWrites.RUnlock()
+	if len(variableWrites.rules) == 0 || ThisInstance == nil {
+		return APPEND
+	}
+	key, err := filepath.Rel(ThisInstance.RootDir, filepath.Dir(path))
+	if err != nil {
+		return APPEND
+	}
+	for _, rule := range variableWrites.rules {
+		if rule.on.Match(key) {
+			return rule.mode
+		}
+	}
+	return APPEND
+}
+
+// recordTicks returns the interval ticks in the trailer of a VARIABLE record
+func recordTicks(record []byte) uint32 {
+	return binary.LittleEndian.Uint32(record[len(record)-4:])
+}
+
+/*
+sortByTicks returns the VARIABLE records of an interval in buffer ordered by
+their interval ticks, i.e. by time. Records with the same time keep their order,
+so that the last written of them comes last. Late records are stored after the
+ones written before them, this puts them back in place.
+*/
+func sortByTicks(buffer []byte, varRecLen int) []byte {
+	n := len(buffer) / varRecLen
+	record := func(i int) []byte { return buffer[i*varRecLen : (i+1)*varRecLen] }
+	sorted := true
+	for i := 1; i < n && sorted; i++ {
+		sorted = recordTicks(record(i-1)) <= recordTicks(record(i))
+	}
+	if sorted {
+		return buffer
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return recordTicks(record(order[i])) < recordTicks(record(order[j]))
+	})
+	out := make([]byte, 0, n*varRecLen)
+	for _, i := range order {
+		out = append(out, record(i)...)
+	}
+	return out
+}
+
+/*
+mergeVariableRecords merges the records of a write to an interval with the ones
+already in it, in time order. With UPSERT only the last record of those with the
+same time is kept, the one of the write if it has one.
+*/
+func mergeVariableRecords(existing, data []byte, varRecLen int, mode VariableWriteMode) []byte {
+	merged := make([]byte, 0, len(existing)+len(data))
+	merged = append(append(merged, existing...), data...)
+	merged = sortByTicks(merged, varRecLen)
+	if mode != UPSERT {
+		return merged
+	}
+	out := merged[:0]
+	for i := 0; i < len(merged); i += varRecLen {
+		record := merged[i : i+varRecLen]
+		next := i + varRecLen
+		if next < len(merged) && recordTicks(merged[next:next+varRecLen]) == recordTicks(record) {
+			continue // Superseded by a later record with the same time
+		}
+		out = append(out, record...)
+	}
+	return out
+}
+
+/*
+ReclaimFile rewrites the VARIABLE year file at path without the orphaned
+payloads, left behind when the records of an interval are relocated to the end
+of the file or deleted. The payloads are laid out in index order after the index
+area. The server must not be running on the file, or its writes must be paused
+as DeleteRange does. It returns the size of the file before and after.
+*/
+func ReclaimFile(path string) (before, after int64, err error) {
+	tbi, err := ReadTimeBucketInfo(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if tbi.GetRecordType() != VARIABLE {
+		return 0, 0, fmt.Errorf("Only VARIABLE files have payloads to reclaim, %s is %v", path, tbi.GetRecordType())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	err = replaceFile(path, tbi, func(out *os.File) (err error) {
+		after, err = copyPayloads(out, f, tbi, nil)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	Log(INFO, "Reclaimed %d bytes of %s", info.Size()-after, path)
+	return info.Size(), after, nil
+}
+
+/*
+copyPayloads copies the index area of the VARIABLE year file f described by
+tbi to out, with the payloads it points at laid out in index order after it.
+Each payload is passed through convert first, unless it is nil. It returns the
+size of out.
+*/
+func copyPayloads(out, f *os.File, tbi *TimeBucketInfo, convert func(payload []byte) []byte) (size int64, err error) {
+	const entryLen = 24 // {Index, Offset, Len}
+	indexEnd := FileSize(tbi.GetTimeframe(), int(tbi.Year), entryLen)
+	if err = out.Truncate(indexEnd); err != nil {
+		return 0, err
+	}
+	size = indexEnd
+	buffer := make([]byte, RecordsPerRead*entryLen)
+	for offset := int64(Headersize); offset < indexEnd; offset += int64(len(buffer)) {
+		// The payloads follow the index area
+		entries := buffer
+		if left := indexEnd - offset; left < int64(len(entries)) {
+			entries = entries[:left]
+		}
+		n, err := f.ReadAt(entries, offset)
+		if err != nil && n == 0 {
+			return 0, err
+		}
+		entries = entries[:n]
+		for i := 0; i+entryLen <= n; i += entryLen {
+			entry := entries[i : i+entryLen]
+			if ToInt64(entry) == 0 {
+				continue
+			}
+			payload := make([]byte, ToInt64(entry[16:]))
+			if _, err := f.ReadAt(payload, ToInt64(entry[8:])); err != nil {
+				return 0, fmt.Errorf("Reading a payload of %s: %v", f.Name(), err)
+			}
+			if convert != nil {
+				payload = convert(payload)
+			}
+			if _, err := out.WriteAt(payload, size); err != nil {
+				return 0, err
+			}
+			binary.LittleEndian.PutUint64(entry[8:], uint64(size))
+			binary.LittleEndian.PutUint64(entry[16:], uint64(len(payload)))
+			size += int64(len(payload))
+		}
+		if _, err := out.WriteAt(entries, offset); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
@@ -185,6 +185,10 @@ func (cfp *CachedFP) GetFP(fileName string) (fp *os.File, err error) {
 		return cfp.fp, nil
 	} else if len(cfp.fileName) != 0 {
 		cfp.fp.Close()
+		cfp.fileName, cfp.fp = "", nil
+	}
+	if err = expandIfColumnar(fileName); err != nil {
+		return nil, err
 	}
 	cfp.fp, err = os.OpenFile(fileName, os.O_RDWR, 0700)
 	if err != nil {
@@ -211,9 +215,6 @@ func (wf *WALFileType) flushToWAL(tgc *TransactionPipe) (err error) {
 
 	defer dispatchRecords()
 
-	WALBypass := ThisInstance.WALBypass
-	//WALBypass = true // Bypass all writing to the WAL File, leaving the writes to the primary
-
 	// Count of WT Sets in this TG as of now
 	if tgc == nil {
 		return nil
@@ -225,6 +226,22 @@ func (wf *WALFileType) flushToWAL(tgc *TransactionPipe) (err error) {
 		tgc.NewTGID()
 		return nil
 	}
+	commands := make([]*WriteCommand, WTCount)
+	for i := range commands {
+		commands[i] = <-tgc.writeChannel
+	}
+	return wf.writeTG(tgc, commands)
+}
+
+// writeTG writes commands as one transaction group to the WAL file, then to
+// the primary files
+func (wf *WALFileType) writeTG(tgc *TransactionPipe, commands []*WriteCommand) (err error) {
+	WALBypass := ThisInstance.WALBypass
+	//WALBypass = true // Bypass all writing to the WAL File, leaving the writes to the primary
+
+	WTCount := len(commands)
+	flushStart := time.Now()
+	defer func() { walFlushDuration.Observe(time.Since(flushStart).Seconds()) }()
 
 	if !WALBypass {
 		if !wf.CanWrite("WriteTG") {
@@ -244,8 +261,8 @@ func (wf *WALFileType) flushToWAL(tgc *TransactionPipe) (err error) {
 	/*
 		This loop serializes write transactions from the channel for writing to disk
 	*/
-	for i := 0; i < WTCount; i++ {
-		command := <-tgc.writeChannel
+	for i, command := range commands {
+		commands[i] = nil // for GC
 		TG_Serialized, _ = io.Serialize(TG_Serialized, int8(command.RecordType))
 		TG_Serialized, _ = io.Serialize(TG_Serialized, int16(len(command.WALKeyPath)))
 		TG_Serialized, _ = io.Serialize(TG_Serialized, command.WALKeyPath)
@@ -263,6 +280,7 @@ func (wf *WALFileType) flushToWAL(tgc *TransactionPipe) (err error) {
 			fileRecordTypes[keyPath] = command.RecordType
 		}
 	}
+	walTGSize.Observe(float64(len(TG_Serialized)))
 	if !WALBypass {
 		// Serialize the size of the buffer into another buffer
 		TGLen_Serialized, _ = io.Serialize(TGLen_Serialized, int64(len(TG_Serialized)))
@@ -296,7 +314,10 @@ func (wf *WALFileType) flushToWAL(tgc *TransactionPipe) (err error) {
 			return err
 		}
 		for i, buffer := range writes {
-			appendRecord(keyPath, trigger.Record(buffer.IndexAndPayload()))
+			// A zero index is a cleared slot from a delete, not a written record
+			if buffer.Index() != 0 {
+				appendRecord(keyPath, trigger.Record(buffer.IndexAndPayload()))
+			}
 			writes[i] = nil // for GC
 		}
 		writesPerFile[keyPath] = nil // for GC
@@ -312,18 +333,27 @@ func (wf *WALFileType) writePrimary(keyPath string, writes []offsetIndexBuffer,
 	}
 	const batchThreshold = 100
 	var fp WriteAtCloser
-	var err error
+	// Compacted files are expanded back to be written to
+	err := expandIfColumnar(fullPath)
+	if err != nil {
+		glog.Errorf("cannot expand compacted file %s for write: %v", fullPath, err)
+		return err
+	}
 	if recordType == io.FIXED && len(writes) >= batchThreshold {
 		fp, err = buffile.New(fullPath)
 	} else {
 		fp, err = os.OpenFile(fullPath, os.O_RDWR, 0700)
 	}
+	if os.IsNotExist(err) {
+		// The file was removed by retention or a destroy after the write was queued
+		glog.Warningf("skipping write to removed file %s", fullPath)
+		return nil
+	}
 	if err != nil {
 		// this is critical, in fact, since tx has been committed
 		glog.Errorf("cannot open file %s for write: %v", fullPath, err)
 		return err
 	}
-	defer fp.Close()
 
 	for _, buffer := range writes {
 		switch recordType {
@@ -333,10 +363,21 @@ func (wf *WALFileType) writePrimary(keyPath string, writes []offsetIndexBuffer,
 			err = WriteBufferToFileIndirect(fp.(*os.File), buffer)
 		}
 		if err != nil {
+			fp.Close()
 			glog.Errorf("failed to write committed data: %v", err)
 			return err
 		}
 	}
+	if err = fp.Close(); err != nil {
+		glog.Errorf("failed to write committed data: %v", err)
+		return err
+	}
+	if recordType == io.FIXED {
+		if err = updatePresence(fullPath, writes); err != nil {
+			glog.Errorf("failed to update the presence bitmap of %s: %v", fullPath, err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -625,6 +666,7 @@ func (wf *WALFileType) replayTGData(TG_Serialized []byte) (err error) {
 	if int(WTCount) != 0 {
 		cfp := NewCachedFP() // Cached open file pointer
 		defer cfp.Close()
+		fixedWrites := map[string][]offsetIndexBuffer{}
 		for i := 0; i < int(WTCount); i++ {
 			RecordType := int(io.ToInt8(TG_Serialized[cursor : cursor+1]))
 			cursor += 1
@@ -636,14 +678,22 @@ func (wf *WALFileType) replayTGData(TG_Serialized []byte) (err error) {
 			cursor += 4
 			fullPath := wf.WALKeyToFullPath(WALKeyPath)
 			fp, err := cfp.GetFP(fullPath)
+			if os.IsNotExist(err) {
+				// The file has been removed since, by retention or a destroy
+				Log(WARNING, "Skipping replay of a write to removed file %s", fullPath)
+				cursor += 8 + 8 + dataLen
+				continue
+			}
 			if err != nil {
 				return err
 			}
 			switch io.EnumRecordType(RecordType) {
 			case io.FIXED:
-				if err = WriteBufferToFile(fp, TG_Serialized[cursor:cursor+8+8+dataLen]); err != nil {
+				buffer := offsetIndexBuffer(TG_Serialized[cursor : cursor+8+8+dataLen])
+				if err = WriteBufferToFile(fp, buffer); err != nil {
 					return err
 				}
+				fixedWrites[fullPath] = append(fixedWrites[fullPath], buffer)
 			case io.VARIABLE:
 				if err = WriteBufferToFileIndirect(fp, TG_Serialized[cursor:cursor+8+8+dataLen]); err != nil {
 					return err
@@ -653,6 +703,11 @@ func (wf *WALFileType) replayTGData(TG_Serialized []byte) (err error) {
 			}
 			cursor += 8 + 8 + dataLen
 		}
+		for fullPath, writes := range fixedWrites {
+			if err = updatePresence(fullPath, writes); err != nil {
+				return err
+			}
+		}
 		wf.lastCommittedTGID = TGID
 		wf.createCheckpoint()
 	}
@@ -810,6 +865,12 @@ func (wf *WALFileType) SyncWAL(WALRefresh, PrimaryRefresh time.Duration, walRota
 					Log(FATAL, err.Error())
 				}
 				f <- struct{}{}
+			case f := <-ThisInstance.TXNPipe.pauseChannel:
+				if err := wf.flushToWAL(ThisInstance.TXNPipe); err != nil {
+					Log(FATAL, err.Error())
+				}
+				wf.createCheckpoint()
+				f()
 			case <-tickerCheck.C:
 				queued := len(ThisInstance.TXNPipe.writeChannel)
 				if float64(queued)/float64(chanCap) >= 0.8 {
@@ -857,3 +918,21 @@ func (wf *WALFileType) RequestFlush() {
 	ThisInstance.TXNPipe.flushChannel <- f
 	<-f
 }
+
+// RunPaused flushes the pending writes and checkpoints the primary files, then
+// runs f while no more writes reach them.  Writes issued meanwhile queue up in
+// the TransactionPipe, and are flushed once f returns.
+func (wf *WALFileType) RunPaused(f func()) {
+	if !haveWALWriter {
+		wf.flushToWAL(ThisInstance.TXNPipe)
+		wf.createCheckpoint()
+		f()
+		return
+	}
+	done := make(chan struct{})
+	ThisInstance.TXNPipe.pauseChannel <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
@@ -149,68 +149,81 @@ type IndirectRecordInfo struct {
 	Index, Offset, Len int64
 }
 
+/*
+WriteBufferToFileIndirect writes the payload of a VARIABLE write and points the
+indirect record info of its interval at it. A write to an interval whose payload
+ends the file extends it in place. Otherwise the records already in the interval
+are merged with the write according to the write mode of the bucket, and the
+merged payload is rewritten in place if it ends the file, or else at the end of
+the file, leaving the former one orphaned until ReclaimFile.
+*/
 func WriteBufferToFileIndirect(fp *os.File, buffer offsetIndexBuffer) (err error) {
-	// TODO: Incorporate previously written data into new writes - requires re-read of existing into buffer
-	/*
-		Here we write the data payload of the buffer to the end of the data file
-	*/
-
 	primaryOffset := buffer.Offset() // Offset to storage of indirect record info
 	index := buffer.Index()
-	dataToBeWritten := buffer.Payload()
-	dataLen := int64(len(dataToBeWritten))
-
-	/*
-		Write the data at the end of the file
-	*/
-	endOfFileOffset, _ := fp.Seek(0, os.SEEK_END)
-	_, err = fp.Write(dataToBeWritten)
-	if err != nil {
-		return err
+	if index == 0 {
+		/*
+			A zero index clears the indirect record info, the payload is left orphaned
+		*/
+		return WriteBufferToFile(fp, buffer)
 	}
+	dataToBeWritten := buffer.Payload()
 
 	/*
-		Now we write or update the index record
 		First we read the file at the index location to see if this is an incremental write
 	*/
-	fp.Seek(primaryOffset, os.SEEK_SET)
 	idBuf := make([]byte, 24) // {Index, Offset, Len}
-	_, err = fp.Read(idBuf)
+	if _, err = fp.ReadAt(idBuf, primaryOffset); err != nil {
+		return err
+	}
+	currentRecInfo := SwapSliceByte(idBuf, IndirectRecordInfo{}).([]IndirectRecordInfo)[0]
+	endOfFileOffset, err := fp.Seek(0, os.SEEK_END)
 	if err != nil {
 		return err
 	}
 
-	currentRecInfo := SwapSliceByte(idBuf, IndirectRecordInfo{}).([]IndirectRecordInfo)[0]
 	/*
 		The default is a new write at the end of the file
 	*/
-	targetRecInfo := IndirectRecordInfo{Index: index, Offset: endOfFileOffset, Len: dataLen}
-
-	/*
-		If this is a continuation write, we adjust the targetRecInfo accordingly
-	*/
+	writeOffset := endOfFileOffset
+	targetRecInfo := IndirectRecordInfo{Index: index, Offset: endOfFileOffset, Len: int64(len(dataToBeWritten))}
 	if currentRecInfo.Index != 0 { // If the index from the file is 0, this is a new write
-		cursor := currentRecInfo.Offset + currentRecInfo.Len
-		if endOfFileOffset == cursor {
+		atEnd := currentRecInfo.Offset+currentRecInfo.Len == endOfFileOffset
+		mode := variableWriteMode(fp.Name())
+		if atEnd && mode == APPEND {
 			// Incremental write
 			targetRecInfo.Len += currentRecInfo.Len
 			targetRecInfo.Offset = currentRecInfo.Offset
+		} else {
+			varRecLen, err := ReadVariableRecordLength(fp)
+			if err != nil {
+				return err
+			}
+			existing := make([]byte, currentRecInfo.Len)
+			if _, err = fp.ReadAt(existing, currentRecInfo.Offset); err != nil {
+				return err
+			}
+			dataToBeWritten = mergeVariableRecords(existing, dataToBeWritten, int(varRecLen), mode)
+			targetRecInfo.Len = int64(len(dataToBeWritten))
+			if atEnd {
+				writeOffset, targetRecInfo.Offset = currentRecInfo.Offset, currentRecInfo.Offset
+				// An upsert can leave fewer records than there were
+				if err = fp.Truncate(currentRecInfo.Offset + targetRecInfo.Len); err != nil {
+					return err
+				}
+			}
 		}
 	}
+	if _, err = fp.WriteAt(dataToBeWritten, writeOffset); err != nil {
+		return err
+	}
 
 	/*
 		Write the indirect record info at the primaryOffset
 	*/
 	odata := []int64{targetRecInfo.Index, targetRecInfo.Offset, targetRecInfo.Len}
 	obuf := SwapSliceData(odata, byte(0)).([]byte)
-
-	fp.Seek(-24, os.SEEK_CUR)
-	_, err = fp.Write(obuf)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	_, err = fp.WriteAt(obuf, primaryOffset)
+	return err
 }
 
 // WriteCSM writs ColumnSeriesMap csm to each destination file, and flush it to the disk,
@@ -220,6 +233,9 @@ func WriteBufferToFileIndirect(fp *os.File, buffer offsetIndexBuffer) (err error
 // not already exist for the given ColumnSeriesMap based on its TimeBucketKey.
 func WriteCSM(csm io.ColumnSeriesMap, isVariableLength bool) (err error) {
 	cDir := ThisInstance.CatalogDir
+	// The data shapes checked must not change until the writes are queued
+	schemaChange.RLock()
+	defer schemaChange.RUnlock()
 	for tbk, cs := range csm {
 		tf, err := tbk.GetTimeFrame()
 		if err != nil {
@@ -230,7 +246,8 @@ func WriteCSM(csm io.ColumnSeriesMap, isVariableLength bool) (err error) {
 			Prepare data for writing
 		*/
 		times := cs.GetTime()
-		if isVariableLength {
+		// The nanoseconds are in the times, sub-second fixed records keep them in their index
+		if isVariableLength || tf.Duration < time.Second {
 			cs.Remove("Nanoseconds")
 		}
 		rs := cs.ToRowSeries(tbk)
@@ -258,7 +275,7 @@ func WriteCSM(csm io.ColumnSeriesMap, isVariableLength bool) (err error) {
 			/*
 				Verify there is an available TimeBucket for the destination
 			*/
-			if err := cDir.AddTimeBucket(&tbk, tbi); err != nil {
+			if err := CreateTimeBucket(&tbk, tbi); err != nil {
 				// If File Exists error, ignore it, otherwise return the error
 				if !strings.Contains(err.Error(), "Can not overwrite file") && !strings.Contains(err.Error(), "file exists") {
 					return err
@@ -1,31 +1,39 @@
 package executor
 
 import (
+	"path"
+	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/dannyluong408/marketstore/plugins/trigger"
+	"github.com/dannyluong408/marketstore/utils"
 )
 
 var (
 	once      sync.Once
-	c         chan writtenRecords
+	c         chan trigger.Event
 	done      chan struct{}
 	m         map[string][]trigger.Record
 	triggerWg sync.WaitGroup
+	// fireSlots bounds the number of triggers firing at once
+	fireSlots chan struct{}
+	queuesMu  sync.Mutex
+	queues    = map[queueKey]*triggerQueue{}
 )
 
-type writtenRecords struct {
-	key     string
-	records []trigger.Record
-}
-
 func setup() {
-	c = make(chan writtenRecords, WriteChannelCommandDepth)
+	c = make(chan trigger.Event, WriteChannelCommandDepth)
 	done = make(chan struct{})
+	workers := utils.InstanceConfig.TriggerWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	fireSlots = make(chan struct{}, workers)
 	go run()
 }
 
@@ -43,31 +51,159 @@ func appendRecord(keyPath string, record []byte) {
 // run in a separate goroutine and recovers from panics in the triggers.
 func dispatchRecords() {
 	for key, records := range m {
-		c <- writtenRecords{key: key, records: records}
+		c <- trigger.Event{Type: trigger.Write, KeyPath: key, Records: records}
 	}
 	m = nil // for GC
 }
 
+// dispatchDeleted notifies the triggers of the range removed from a file.
+func dispatchDeleted(dr deletedRange) {
+	once.Do(setup)
+	c <- trigger.Event{
+		Type:       trigger.DeleteRange,
+		KeyPath:    dr.keyPath,
+		StartIndex: dr.startIndex,
+		EndIndex:   dr.endIndex,
+	}
+}
+
+// dispatchBucket notifies the triggers of a bucket being created or
+// destroyed, itemKey is like "AAPL/1Min/OHLCV".
+func dispatchBucket(t trigger.EventType, itemKey string) {
+	once.Do(setup)
+	c <- trigger.Event{Type: t, KeyPath: itemKey}
+}
+
 func run() {
 	defer func() { done <- struct{}{} }()
-	for wr := range c {
-		for _, tmatcher := range ThisInstance.TriggerMatchers {
-			if tmatcher.Match(wr.key) {
-				triggerWg.Add(1)
-				go fire(tmatcher.Trigger, wr.key, wr.records)
+	for ev := range c {
+		for _, tmatcher := range ThisInstance.GetTriggerMatchers() {
+			if tmatcher.Match(ev.KeyPath) && trigger.Accepts(tmatcher.Trigger, ev.Type) {
+				enqueue(tmatcher, ev)
 			}
 		}
 	}
 }
 
-func fire(trig trigger.Trigger, key string, records []trigger.Record) {
+type queueKey struct {
+	matcher *trigger.TriggerMatcher
+	bucket  string
+}
+
+// bucketOf returns the bucket of the key path of an event, without the year file
+func bucketOf(keyPath string) string {
+	if strings.HasSuffix(keyPath, ".bin") {
+		return path.Dir(keyPath)
+	}
+	return keyPath
+}
+
+/*
+triggerQueue holds the fires of a trigger for one bucket, which a goroutine
+runs in order while there are any. The queues are only ever added to by run,
+so the events of a bucket reach them in the order they happened.
+*/
+type triggerQueue struct {
+	queueKey
+	events  chan queuedEvent
+	active  bool // A goroutine is draining events, guarded by queuesMu
+	senders int  // Sends blocked on a full queue, guarded by queuesMu
+}
+
+type queuedEvent struct {
+	trigger.Event
+	queued time.Time
+}
+
+func enqueue(tmatcher *trigger.TriggerMatcher, event trigger.Event) {
+	qk := queueKey{matcher: tmatcher, bucket: bucketOf(event.KeyPath)}
+	ev := queuedEvent{Event: event, queued: time.Now()}
+	triggerWg.Add(1)
+	triggerQueued.Add(1, tmatcher.On)
+
+	queuesMu.Lock()
+	q, ok := queues[qk]
+	if !ok {
+		depth := tmatcher.QueueDepth
+		if depth <= 0 {
+			depth = trigger.DefaultQueueDepth
+		}
+		q = &triggerQueue{queueKey: qk, events: make(chan queuedEvent, depth)}
+		queues[qk] = q
+	}
+	select {
+	case q.events <- ev:
+		q.start()
+		queuesMu.Unlock()
+		return
+	default:
+	}
+	if tmatcher.DropWhenFull {
+		queuesMu.Unlock()
+		triggerDrops.Inc(tmatcher.On)
+		triggerQueued.Add(-1, tmatcher.On)
+		triggerWg.Done()
+		return
+	}
+	q.start()
+	q.senders++
+	queuesMu.Unlock()
+	// Blocks the writes behind this one until the trigger catches up. The
+	// drainer can run dry meanwhile, but keeps the queue registered for the
+	// events to follow, and the queue is restarted once the send is done.
+	q.events <- ev
+	queuesMu.Lock()
+	q.senders--
+	q.start()
+	queuesMu.Unlock()
+}
+
+// start makes sure a goroutine drains the queue, queuesMu must be held
+func (q *triggerQueue) start() {
+	if !q.active {
+		q.active = true
+		go q.drain()
+	}
+}
+
+func (q *triggerQueue) drain() {
+	for {
+		queuesMu.Lock()
+		select {
+		case ev := <-q.events:
+			queuesMu.Unlock()
+			q.fire(ev)
+			continue
+		default:
+		}
+		q.active = false
+		if queues[q.queueKey] == q && q.senders == 0 {
+			delete(queues, q.queueKey)
+		}
+		queuesMu.Unlock()
+		return
+	}
+}
+
+func (q *triggerQueue) fire(ev queuedEvent) {
+	on := q.matcher.On
+	fireSlots <- struct{}{}
+	defer func() { <-fireSlots }()
+	triggerQueued.Add(-1, on)
+	triggerLag.Observe(time.Since(ev.queued).Seconds(), on)
+	fire(q.matcher.Trigger, on, ev.Event)
+}
+
+func fire(trig trigger.Trigger, on string, ev trigger.Event) {
+	triggerFires.Inc(on)
 	defer func() {
 		triggerWg.Done()
 		if r := recover(); r != nil {
+			triggerPanics.Inc(on)
 			glog.Errorf("recovering from %v\n%s", r, string(debug.Stack()))
 		}
 	}()
-	trig.Fire(key, records)
+	trigger.FireEvent(trig, ev)
 }
 
 // FinishAndWait closes the writtenIndexes channel, and waits
@@ -1,6 +1,10 @@
 package executor
 
 import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/dannyluong408/marketstore/plugins/trigger"
@@ -61,3 +65,164 @@ func (s *WrittenIndexesTests) TestWrittenIndexes(c *C) {
 
 	FinishAndWait()
 }
+
+func (s *WrittenIndexesTests) TestTriggerMetrics(c *C) {
+	fires := triggerFires.Value("TEST/1Min/OHLCV")
+	panics := triggerPanics.Value("TEST/1Min/OHLCV")
+
+	triggerWg.Add(1)
+	fire(&FakeTrigger{toPanic: true}, "TEST/1Min/OHLCV",
+		trigger.Event{Type: trigger.Write, KeyPath: "TEST/1Min/OHLCV/2017.bin"})
+	c.Check(triggerFires.Value("TEST/1Min/OHLCV"), Equals, fires+1)
+	c.Check(triggerPanics.Value("TEST/1Min/OHLCV"), Equals, panics+1)
+}
+
+// orderTrigger records the first byte of the records of each fire, once
+// release lets it go
+type orderTrigger struct {
+	sync.Mutex
+	started chan struct{}
+	release chan struct{}
+	fired   []byte
+}
+
+func (t *orderTrigger) Fire(keyPath string, records []trigger.Record) {
+	t.started <- struct{}{}
+	<-t.release
+	t.Lock()
+	defer t.Unlock()
+	t.fired = append(t.fired, records[0][0])
+}
+
+func (s *WrittenIndexesTests) TestTriggerQueue(c *C) {
+	once.Do(setup)
+	fire := func(tm *trigger.TriggerMatcher, i int) {
+		enqueue(tm, trigger.Event{
+			Type:    trigger.Write,
+			KeyPath: "TEST/1Min/OHLCV/2017.bin",
+			Records: []trigger.Record{{byte(i)}},
+		})
+	}
+
+	// A full queue drops the new fires with the drop policy
+	t := &orderTrigger{started: make(chan struct{}, 10), release: make(chan struct{})}
+	tm := trigger.NewMatcher(t, "DROP/1Min/OHLCV")
+	tm.QueueDepth = 2
+	tm.DropWhenFull = true
+	drops := triggerDrops.Value(tm.On)
+	lags := triggerLag.Count(tm.On)
+	fire(tm, 1)
+	<-t.started
+	for i := 2; i <= 5; i++ {
+		fire(tm, i)
+	}
+	c.Assert(triggerDrops.Value(tm.On), Equals, drops+2)
+	c.Assert(triggerQueued.Value(tm.On), Equals, float64(2))
+	close(t.release)
+	triggerWg.Wait()
+	c.Assert(t.fired, DeepEquals, []byte{1, 2, 3})
+	c.Assert(triggerQueued.Value(tm.On), Equals, float64(0))
+	c.Assert(triggerLag.Count(tm.On), Equals, lags+3)
+
+	// The block policy waits for the trigger, and fires in write order
+	t = &orderTrigger{started: make(chan struct{}, 100), release: make(chan struct{})}
+	close(t.release)
+	tm = trigger.NewMatcher(t, "BLOCK/1Min/OHLCV")
+	tm.QueueDepth = 3
+	var expected []byte
+	for i := 0; i < 100; i++ {
+		fire(tm, i)
+		expected = append(expected, byte(i))
+	}
+	triggerWg.Wait()
+	c.Assert(t.fired, DeepEquals, expected)
+
+	// A queue of one blocks on every fire, which still run one at a time
+	ct := &concurrencyTrigger{}
+	tm = trigger.NewMatcher(ct, "BLOCK1/1Min/OHLCV")
+	tm.QueueDepth = 1
+	expected = nil
+	for i := 0; i < 2000; i++ {
+		fire(tm, i)
+		expected = append(expected, byte(i))
+	}
+	triggerWg.Wait()
+	c.Assert(ct.fired, DeepEquals, expected)
+	c.Assert(atomic.LoadInt32(&ct.overlaps), Equals, int32(0))
+
+	// A drainer running dry before a blocked send lands keeps the queue
+	// registered, so the events after it do not get a drainer of their own
+	qk := queueKey{matcher: tm, bucket: "TEST/1Min/OHLCV"}
+	q := &triggerQueue{queueKey: qk, events: make(chan queuedEvent, 1), active: true, senders: 1}
+	queuesMu.Lock()
+	queues[qk] = q
+	queuesMu.Unlock()
+	q.drain()
+	queuesMu.Lock()
+	c.Assert(queues[qk], Equals, q)
+	c.Assert(q.active, Equals, false)
+	q.senders--
+	queuesMu.Unlock()
+	q.drain()
+	queuesMu.Lock()
+	_, ok := queues[qk]
+	queuesMu.Unlock()
+	c.Assert(ok, Equals, false)
+}
+
+// concurrencyTrigger records the first byte of the records of each fire, and
+// counts the fires that overlapped another
+type concurrencyTrigger struct {
+	running  int32
+	overlaps int32
+	fired    []byte
+}
+
+func (t *concurrencyTrigger) Fire(keyPath string, records []trigger.Record) {
+	if atomic.AddInt32(&t.running, 1) != 1 {
+		atomic.AddInt32(&t.overlaps, 1)
+	}
+	runtime.Gosched()
+	t.fired = append(t.fired, records[0][0])
+	atomic.AddInt32(&t.running, -1)
+}
+
+// eventTrigger records the types of the events it is fired with
+type eventTrigger struct {
+	FakeTrigger
+	events chan trigger.Event
+}
+
+func (t *eventTrigger) FireEvent(ev trigger.Event) {
+	t.events <- ev
+}
+
+func (s *WrittenIndexesTests) TestBucketEvents(c *C) {
+	et := &eventTrigger{events: make(chan trigger.Event, 10)}
+	plain := &FakeTrigger{fireC: make(chan struct{}, 10)}
+	ThisInstance.SetTriggerMatchers([]*trigger.TriggerMatcher{
+		trigger.NewMatcher(et, "EVENT/1Min/OHLCV"),
+		trigger.NewMatcher(plain, "EVENT/1Min/OHLCV"),
+	})
+	defer ThisInstance.SetTriggerMatchers(nil)
+
+	once.Do(setup)
+	dispatchBucket(trigger.Create, "EVENT/1Min/OHLCV")
+	appendRecord("EVENT/1Min/OHLCV/2017.bin", []byte{1})
+	dispatchRecords()
+	dispatchBucket(trigger.Destroy, "EVENT/1Min/OHLCV")
+
+	// The events of a bucket are fired in order, writes to a year file included
+	var types []trigger.EventType
+	for i := 0; i < 3; i++ {
+		ev := <-et.events
+		c.Assert(ev.KeyPath, Matches, "EVENT/1Min/OHLCV.*")
+		types = append(types, ev.Type)
+	}
+	c.Assert(types, DeepEquals, []trigger.EventType{trigger.Create, trigger.Write, trigger.Destroy})
+	c.Assert(et.calledWith, HasLen, 0)
+
+	// A trigger without FireEvent is only told about the write
+	triggerWg.Wait()
+	c.Assert(plain.calledWith, HasLen, 1)
+}
@@ -0,0 +1,77 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
+)
+
+/*
+	Backup: Writes a consistent snapshot of the database to a directory on the server
+*/
+type BackupRequest struct {
+	// Dir is the snapshot directory under the backup_directory of the server,
+	// it must not exist yet
+	Dir string `msgpack:"dir"`
+}
+
+type BackupResponse struct {
+	Dir   string `msgpack:"dir"`
+	Files int    `msgpack:"files"`
+	Bytes int64  `msgpack:"bytes"`
+}
+
+func (s *DataService) Backup(r *http.Request, req *BackupRequest, response *BackupResponse) (err error) {
+	if err = principalOf(r).Check(auth.ADMIN, auth.AllKeys); err != nil {
+		return err
+	}
+	dir, err := backupDir(req.Dir)
+	if err != nil {
+		return err
+	}
+	files, size, err := executor.Snapshot(dir)
+	if err != nil {
+		return err
+	}
+	response.Dir = dir
+	response.Files = files
+	response.Bytes = size
+	return nil
+}
+
+/*
+backupDir resolves the snapshot directory of a backup request under the
+configured backup_directory, refusing the paths leading out of it
+*/
+func backupDir(dir string) (string, error) {
+	root := utils.InstanceConfig.BackupDirectory
+	if root == "" {
+		return "", fmt.Errorf("Backups are disabled, no backup_directory is configured")
+	}
+	if dir == "" {
+		return "", fmt.Errorf("No snapshot directory given")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("Snapshot directory %s can not contain \"..\"", dir)
+		}
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Clean(dir)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Snapshot directory %s is not under the backup_directory %s", dir, root)
+	}
+	return path, nil
+}
@@ -0,0 +1,44 @@
+package frontend
+
+import (
+	"path/filepath"
+
+	"github.com/dannyluong408/marketstore/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerTestSuite) TestBackup(c *C) {
+	service := &DataService{}
+	service.Init()
+
+	var response BackupResponse
+	err := service.Backup(nil, &BackupRequest{Dir: "snap"}, &response)
+	c.Assert(err, ErrorMatches, "Backups are disabled.*")
+
+	backupRoot := c.MkDir()
+	utils.InstanceConfig.BackupDirectory = backupRoot
+	defer func() { utils.InstanceConfig.BackupDirectory = "" }()
+
+	for _, dir := range []string{
+		"",
+		".",
+		"../snap",
+		"snaps/../../snap",
+		filepath.Join(backupRoot, "..", "snap"),
+		filepath.Join(filepath.Dir(backupRoot), "snap"),
+		s.Rootdir,
+	} {
+		err = service.Backup(nil, &BackupRequest{Dir: dir}, &response)
+		c.Assert(err, NotNil, Commentf("dir %q", dir))
+	}
+
+	err = service.Backup(nil, &BackupRequest{Dir: "snap"}, &response)
+	c.Assert(err, IsNil)
+	c.Assert(response.Dir, Equals, filepath.Join(backupRoot, "snap"))
+	c.Assert(response.Files > 0, Equals, true)
+
+	err = service.Backup(nil, &BackupRequest{Dir: filepath.Join(backupRoot, "full", "snap")}, &response)
+	c.Assert(err, IsNil)
+	c.Assert(response.Dir, Equals, filepath.Join(backupRoot, "full", "snap"))
+}
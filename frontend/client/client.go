@@ -2,15 +2,21 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
+	goio "io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dannyluong408/marketstore/frontend"
 	"github.com/dannyluong408/marketstore/frontend/stream"
+	"github.com/dannyluong408/marketstore/utils"
 	"github.com/dannyluong408/marketstore/utils/io"
 	"github.com/dannyluong408/marketstore/utils/rpc/msgpack2"
 	"github.com/golang/glog"
@@ -20,6 +26,14 @@ import (
 
 type Client struct {
 	BaseURL string
+	// APIKey is sent with every request when set, for servers with api_keys configured
+	APIKey string
+	// TLSConfig is used to reach an https:// BaseURL when set, e.g. to trust
+	// a private CA or to present a client certificate
+	TLSConfig *tls.Config
+
+	httpOnce   sync.Once
+	httpClient *http.Client
 }
 
 // NewClient intializes a new MarketStore RPC client
@@ -42,37 +56,12 @@ func (cl *Client) DoRPC(functionName string, args interface{}) (response interfa
 		return nil, fmt.Errorf("args must be non-nil - have: args: %v\n",
 			args)
 	}
-	message, err := msgpack2.EncodeClientRequest("DataService."+functionName, args)
-	if err != nil {
-		return nil, err
-	}
-	reqURL := cl.BaseURL + "/rpc"
-	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(message))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-msgpack")
-	client := new(http.Client)
-	resp, err := client.Do(req)
+	resp, err := cl.post(functionName, args)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Handle any error in the RPC call
-	if resp.StatusCode != 200 {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		var errText string
-		if err != nil {
-			errText = err.Error()
-		} else {
-			if bodyBytes != nil {
-				errText = string(bodyBytes)
-			}
-		}
-		return nil, fmt.Errorf("response error (%d): %s", resp.StatusCode, errText)
-	}
-
 	// Unpack and format the response from the RPC call
 	switch functionName {
 	case "GetInfo":
@@ -83,7 +72,7 @@ func (cl *Client) DoRPC(functionName string, args interface{}) (response interfa
 		}
 		return result, nil
 
-	case "Create", "Destroy":
+	case "Create", "Destroy", "Delete", "Deallocate", "AlterSchema":
 		result := &frontend.MultiServerResponse{}
 		err = msgpack2.DecodeClientResponse(resp.Body, result)
 		if err != nil {
@@ -91,7 +80,23 @@ func (cl *Client) DoRPC(functionName string, args interface{}) (response interfa
 		}
 		return result, nil
 
-	case "Query", "SQLStatement":
+	case "Backup":
+		result := &frontend.BackupResponse{}
+		err = msgpack2.DecodeClientResponse(resp.Body, result)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+
+	case "Prepare":
+		result := &frontend.MultiPrepareResponse{}
+		err = msgpack2.DecodeClientResponse(resp.Body, result)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+
+	case "Query", "SQLStatement", "Execute":
 		result := &frontend.MultiQueryResponse{}
 		err = msgpack2.DecodeClientResponse(resp.Body, result)
 		if err != nil {
@@ -115,6 +120,147 @@ func (cl *Client) DoRPC(functionName string, args interface{}) (response interfa
 	return nil, nil
 }
 
+// post sends an RPC request to MarketStore's API, and returns the response if
+// its status is OK
+func (cl *Client) post(functionName string, args interface{}) (*http.Response, error) {
+	message, err := msgpack2.EncodeClientRequest("DataService."+functionName, args)
+	if err != nil {
+		return nil, err
+	}
+	reqURL := cl.BaseURL + "/rpc"
+	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-msgpack")
+	cl.setAuthorization(req.Header)
+	resp, err := cl.getHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Handle any error in the RPC call
+	if resp.StatusCode != 200 {
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		var errText string
+		if err != nil {
+			errText = err.Error()
+		} else {
+			if bodyBytes != nil {
+				errText = string(bodyBytes)
+			}
+		}
+		resp.Body.Close()
+		return nil, fmt.Errorf("response error (%d): %s", resp.StatusCode, errText)
+	}
+	return resp, nil
+}
+
+/*
+SQL runs a SQL statement and returns its result in order. The result of a
+wildcard table comes split by key, its rows are put back together in the order
+of the start index of the keys, with the key items as columns.
+*/
+func (cl *Client) SQL(statement string) (cs *io.ColumnSeries, err error) {
+	args := &frontend.MultiQueryRequest{
+		Requests: []frontend.QueryRequest{{IsSQLStatement: true, SQLStatement: statement}},
+	}
+	resp, err := cl.post("Query", args)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &frontend.MultiQueryResponse{}
+	if err = msgpack2.DecodeClientResponse(resp.Body, result); err != nil {
+		return nil, err
+	}
+	if len(result.Responses) == 0 || result.Responses[0].Result == nil {
+		return nil, fmt.Errorf("No result returned for %s", statement)
+	}
+	nmds := result.Responses[0].Result
+	var keys []string
+	for tbkStr := range nmds.StartIndex {
+		keys = append(keys, tbkStr)
+	}
+	sort.Slice(keys, func(i, j int) bool { return nmds.StartIndex[keys[i]] < nmds.StartIndex[keys[j]] })
+	for _, tbkStr := range keys {
+		sub := io.NewColumnSeries()
+		if length := nmds.Lengths[tbkStr]; length > 0 {
+			if sub, err = nmds.ToColumnSeries(nmds.StartIndex[tbkStr], length); err != nil {
+				return nil, err
+			}
+		}
+		if tbk := io.NewTimeBucketKeyFromString(tbkStr); tbk.GetCatKey() != "SQL" {
+			for _, cat := range tbk.GetCategories() {
+				items := make([]string, sub.Len())
+				for i := range items {
+					items[i] = tbk.GetItemInCategory(cat)
+				}
+				sub.AddColumn(cat, items)
+			}
+		}
+		if cs == nil {
+			cs = sub
+			continue
+		}
+		for _, name := range cs.GetColumnNames() {
+			col := reflect.AppendSlice(
+				reflect.ValueOf(cs.GetColumn(name)),
+				reflect.ValueOf(sub.GetColumn(name)))
+			cs.Replace(name, col.Interface())
+		}
+	}
+	return cs, nil
+}
+
+// QueryStream runs a query over the chunked query stream endpoint, calling
+// handler with each batch of results and the cursor to resume after it.
+func (cl *Client) QueryStream(req *frontend.QueryStreamRequest,
+	handler func(csm *io.ColumnSeriesMap, cursor map[string]int64) error) (err error) {
+	message, err := msgpack.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", cl.BaseURL+"/query_stream", bytes.NewBuffer(message))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-msgpack")
+	cl.setAuthorization(httpReq.Header)
+	resp, err := cl.getHTTPClient().Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("response error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	dec := msgpack.NewDecoder(resp.Body)
+	for {
+		var chunk frontend.QueryStreamChunk
+		if err = dec.Decode(&chunk); err != nil {
+			if err == goio.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(chunk.Error) != 0 {
+			return fmt.Errorf("query stream error: %s", chunk.Error)
+		}
+		result := &frontend.MultiQueryResponse{
+			Responses: []frontend.QueryResponse{{Result: chunk.Result}},
+		}
+		csm, err := result.ToColumnSeriesMap()
+		if err != nil {
+			return err
+		}
+		if err = handler(csm, chunk.Cursor); err != nil {
+			return err
+		}
+	}
+}
+
 func ColumnSeriesFromResult(shapes []io.DataShape, columns map[string]interface{}) (cs *io.ColumnSeries, err error) {
 	cs = io.NewColumnSeries()
 	for _, shape := range shapes {
@@ -135,6 +281,55 @@ func ColumnSeriesFromResult(shapes []io.DataShape, columns map[string]interface{
 	return cs, nil
 }
 
+/*
+NewTLSConfig builds the TLS configuration of a client trusting the
+certificates of caFile, or the system roots when empty, and presenting the
+certificate in certFile and keyFile when set, for servers requiring mTLS
+*/
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := utils.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("both a certificate and a key are needed for a client certificate")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (cl *Client) getHTTPClient() *http.Client {
+	cl.httpOnce.Do(func() {
+		if cl.TLSConfig == nil {
+			cl.httpClient = http.DefaultClient
+			return
+		}
+		cl.httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: cl.TLSConfig,
+			},
+		}
+	})
+	return cl.httpClient
+}
+
+func (cl *Client) setAuthorization(header http.Header) {
+	if cl.APIKey != "" {
+		header.Set("Authorization", "Bearer "+cl.APIKey)
+	}
+}
+
 // Subscribe to the marketstore websocket interface with a
 // message handler, a set of streams and cancel channel.
 func (cl *Client) Subscribe(
@@ -143,9 +338,17 @@ func (cl *Client) Subscribe(
 	streams ...string) (done <-chan struct{}, err error) {
 
 	u, _ := url.Parse(cl.BaseURL + "/ws")
-	u.Scheme = "ws"
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	header := http.Header{}
+	cl.setAuthorization(header)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = cl.TLSConfig
+	conn, _, err := dialer.Dial(u.String(), header)
 
 	if err != nil {
 		return nil, err
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dannyluong408/marketstore/plugins/bgworker"
 	"github.com/dannyluong408/marketstore/utils"
 	. "github.com/dannyluong408/marketstore/utils/log"
 )
@@ -17,15 +18,17 @@ type HeartbeatMessage struct {
 	Version string `json:"version"`
 	GitHash string `json:"git_hash"`
 	Uptime  string `json:"uptime"`
+	// BgWorkers is the state of each bgworker
+	BgWorkers []bgworker.Status `json:"bgworkers,omitempty"`
 }
 
 func init() {
 	Queryable = uint32(0)
 }
 
-func Heartbeat(address string) {
+// Heartbeat serves /heartbeat on the listener shared with the other services
+func Heartbeat() {
 	http.HandleFunc("/heartbeat", handler)
-	http.ListenAndServe(address, nil)
 }
 
 func handler(rw http.ResponseWriter, r *http.Request) {
@@ -35,10 +38,11 @@ func handler(rw http.ResponseWriter, r *http.Request) {
 		// queryable
 		rw.WriteHeader(http.StatusOK)
 		err := json.NewEncoder(rw).Encode(HeartbeatMessage{
-			Status:  "queryable",
-			Version: utils.Tag,
-			GitHash: utils.GitHash,
-			Uptime:  uptime,
+			Status:    "queryable",
+			Version:   utils.Tag,
+			GitHash:   utils.GitHash,
+			Uptime:    uptime,
+			BgWorkers: bgworker.Statuses(),
 		})
 		if err != nil {
 			Log(ERROR, "Failed to write heartbeat message - Error: %v", err)
@@ -47,10 +51,11 @@ func handler(rw http.ResponseWriter, r *http.Request) {
 		// not queryable
 		rw.WriteHeader(http.StatusServiceUnavailable)
 		err := json.NewEncoder(rw).Encode(HeartbeatMessage{
-			Status:  "not queryable",
-			Version: utils.Tag,
-			GitHash: utils.GitHash,
-			Uptime:  uptime,
+			Status:    "not queryable",
+			Version:   utils.Tag,
+			GitHash:   utils.GitHash,
+			Uptime:    uptime,
+			BgWorkers: bgworker.Statuses(),
 		})
 		if err != nil {
 			Log(ERROR, "Failed to write heartbeat message - Error: %v", err)
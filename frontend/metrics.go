@@ -0,0 +1,80 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dannyluong408/marketstore/utils/io"
+	"github.com/dannyluong408/marketstore/utils/stats"
+	rpc "github.com/gorilla/rpc/v2"
+)
+
+var (
+	rpcDuration = stats.NewHistogram(
+		"marketstore_rpc_duration_seconds",
+		"Time to answer an RPC call, including encoding the response",
+		stats.DefaultBuckets,
+		"method")
+	rpcErrors = stats.NewCounter(
+		"marketstore_rpc_errors_total",
+		"Number of RPC calls that returned an error",
+		"method")
+	rowsReturned = stats.NewCounter(
+		"marketstore_rows_returned_total",
+		"Number of rows returned by queries",
+		"method")
+	bytesReturned = stats.NewCounter(
+		"marketstore_bytes_returned_total",
+		"Number of response body bytes written",
+		"method")
+)
+
+type rpcMetricsKey struct{}
+
+/*
+rpcMetrics follows one RPC call, the method is only known once the codec has
+read the request so it is observed in the after hook of the server
+*/
+type rpcMetrics struct {
+	http.ResponseWriter
+	start   time.Time
+	written int
+}
+
+func (rm *rpcMetrics) Write(b []byte) (int, error) {
+	n, err := rm.ResponseWriter.Write(b)
+	rm.written += n
+	return n, err
+}
+
+func withRPCMetrics(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	rm := &rpcMetrics{ResponseWriter: w, start: time.Now()}
+	return rm, r.WithContext(context.WithValue(r.Context(), rpcMetricsKey{}, rm))
+}
+
+func observeRPC(i *rpc.RequestInfo) {
+	rm, ok := i.Request.Context().Value(rpcMetricsKey{}).(*rpcMetrics)
+	if !ok {
+		return
+	}
+	rpcDuration.Observe(time.Since(rm.start).Seconds(), i.Method)
+	bytesReturned.Add(float64(rm.written), i.Method)
+	if i.Error != nil {
+		rpcErrors.Inc(i.Method)
+	}
+}
+
+/*
+countRows adds the rows of a query response to the rows returned by method
+*/
+func countRows(method string, nmds *io.NumpyMultiDataset) {
+	if nmds == nil {
+		return
+	}
+	var rows int
+	for _, length := range nmds.Lengths {
+		rows += length
+	}
+	rowsReturned.Add(float64(rows), method)
+}
@@ -0,0 +1,52 @@
+package frontend
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/dannyluong408/marketstore/utils/rpc/msgpack2"
+	"github.com/dannyluong408/marketstore/utils/stats"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerTestSuite) TestMetrics(c *C) {
+	server, _ := NewServer()
+	calls := rpcDuration.Count("DataService.Query")
+	errors := rpcErrors.Value("DataService.Query")
+	rows := rowsReturned.Value("DataService.Query")
+
+	call := func(req *MultiQueryRequest) {
+		body, err := msgpack2.EncodeClientRequest("DataService.Query", req)
+		c.Assert(err, IsNil)
+		r := httptest.NewRequest("POST", "/rpc", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", "application/x-msgpack")
+		server.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	call(&MultiQueryRequest{
+		Requests: []QueryRequest{
+			NewQueryRequestBuilder("USDJPY/1D/OHLC").LimitRecordCount(10).End(),
+		},
+	})
+	call(&MultiQueryRequest{
+		Requests: []QueryRequest{{IsSQLStatement: true, SQLStatement: "SELECT"}},
+	})
+	c.Assert(rpcDuration.Count("DataService.Query"), Equals, calls+2)
+	c.Assert(rpcErrors.Value("DataService.Query"), Equals, errors+1)
+	c.Assert(rowsReturned.Value("DataService.Query"), Equals, rows+10)
+	c.Assert(bytesReturned.Value("DataService.Query") > 0, Equals, true)
+
+	w := httptest.NewRecorder()
+	stats.Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	out := w.Body.String()
+	for _, line := range []string{
+		`marketstore_rpc_duration_seconds_count{method="DataService.Query"}`,
+		`marketstore_rows_returned_total{method="DataService.Query"}`,
+		"# TYPE marketstore_write_channel_depth gauge",
+		"marketstore_write_channel_capacity 1e+06",
+	} {
+		c.Assert(strings.Contains(out, line), Equals, true, Commentf("missing %s", line))
+	}
+}
@@ -0,0 +1,183 @@
+package frontend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/dannyluong408/marketstore/SQLParser"
+	"github.com/dannyluong408/marketstore/utils"
+)
+
+// maxPreparedStatements limits the statements prepared on one connection
+const maxPreparedStatements = 1024
+
+type PrepareRequest struct {
+	SQLStatement string `msgpack:"sql_statement"`
+}
+
+type MultiPrepareRequest struct {
+	Requests []PrepareRequest `msgpack:"requests"`
+}
+
+type PrepareResponse struct {
+	// Handle of the statement, valid on the connection that prepared it
+	Handle        uint64 `msgpack:"handle"`
+	NumParameters int    `msgpack:"num_parameters"`
+}
+
+type MultiPrepareResponse struct {
+	Responses []PrepareResponse `msgpack:"responses"`
+}
+
+type ExecuteRequest struct {
+	Handle uint64 `msgpack:"handle"`
+	// Values of the ? parameters, in statement order
+	Parameters []interface{} `msgpack:"parameters"`
+}
+
+type MultiExecuteRequest struct {
+	Requests []ExecuteRequest `msgpack:"requests"`
+}
+
+type DeallocateRequest struct {
+	Handle uint64 `msgpack:"handle"`
+}
+
+type MultiDeallocateRequest struct {
+	Requests []DeallocateRequest `msgpack:"requests"`
+}
+
+/*
+statementCache holds the prepared statements of each client connection, a
+connection is identified by its remote address
+*/
+type statementCache struct {
+	sync.Mutex
+	nextHandle  uint64
+	connections map[string]map[uint64]*SQLParser.PreparedStatement
+}
+
+var preparedStatements = &statementCache{
+	connections: make(map[string]map[uint64]*SQLParser.PreparedStatement),
+}
+
+func connectionKey(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	return r.RemoteAddr
+}
+
+func (sc *statementCache) add(conn string, ps *SQLParser.PreparedStatement) (handle uint64, err error) {
+	sc.Lock()
+	defer sc.Unlock()
+	statements, ok := sc.connections[conn]
+	if !ok {
+		statements = make(map[uint64]*SQLParser.PreparedStatement)
+		sc.connections[conn] = statements
+	}
+	if len(statements) >= maxPreparedStatements {
+		return 0, fmt.Errorf("Too many prepared statements on this connection, deallocate some first")
+	}
+	sc.nextHandle++
+	statements[sc.nextHandle] = ps
+	return sc.nextHandle, nil
+}
+
+func (sc *statementCache) get(conn string, handle uint64) (*SQLParser.PreparedStatement, error) {
+	sc.Lock()
+	defer sc.Unlock()
+	ps, ok := sc.connections[conn][handle]
+	if !ok {
+		return nil, fmt.Errorf("Unknown statement handle %d, statements are prepared per connection", handle)
+	}
+	return ps, nil
+}
+
+func (sc *statementCache) remove(conn string, handle uint64) {
+	sc.Lock()
+	defer sc.Unlock()
+	delete(sc.connections[conn], handle)
+}
+
+func (sc *statementCache) removeConnection(conn string) {
+	sc.Lock()
+	defer sc.Unlock()
+	delete(sc.connections, conn)
+}
+
+/*
+ConnState drops the prepared statements of closed connections, it is meant to
+be set as the ConnState hook of the http.Server
+*/
+func (s *RpcServer) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		preparedStatements.removeConnection(conn.RemoteAddr().String())
+	}
+}
+
+func (s *DataService) Prepare(r *http.Request, reqs *MultiPrepareRequest, response *MultiPrepareResponse) (err error) {
+	for _, req := range reqs.Requests {
+		ps, err := SQLParser.NewPreparedStatement(req.SQLStatement)
+		if err != nil {
+			return err
+		}
+		handle, err := preparedStatements.add(connectionKey(r), ps)
+		if err != nil {
+			return err
+		}
+		response.Responses = append(response.Responses,
+			PrepareResponse{
+				Handle:        handle,
+				NumParameters: ps.NumParameters(),
+			})
+	}
+	return nil
+}
+
+func (s *DataService) Execute(r *http.Request, reqs *MultiExecuteRequest, response *MultiQueryResponse) (err error) {
+	ctx, cancel := queryContext(r)
+	defer cancel()
+	defer func() { err = queryTimeoutError(ctx, err) }()
+	response.Version = utils.GitHash
+	response.Timezone = utils.InstanceConfig.Timezone.String()
+	for _, req := range reqs.Requests {
+		ps, err := preparedStatements.get(connectionKey(r), req.Handle)
+		if err != nil {
+			return err
+		}
+		es, err := ps.Bind(req.Parameters...)
+		if err != nil {
+			return err
+		}
+		cs, err := es.Materialize(ctx)
+		if err != nil {
+			return err
+		}
+		nmds, err := sqlResultToNumpy(ps.SQLStatement, cs)
+		if err != nil {
+			return err
+		}
+		countRows("DataService.Execute", nmds)
+		response.Responses = append(response.Responses,
+			QueryResponse{
+				nmds,
+			})
+	}
+	return nil
+}
+
+func (s *DataService) Deallocate(r *http.Request, reqs *MultiDeallocateRequest, response *MultiServerResponse) (err error) {
+	for _, req := range reqs.Requests {
+		preparedStatements.remove(connectionKey(r), req.Handle)
+		response.Responses = append(response.Responses,
+			ServerResponse{
+				"",
+				utils.GitHash,
+			})
+	}
+	return nil
+}
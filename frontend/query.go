@@ -1,8 +1,11 @@
 package frontend
 
 import (
+	"context"
 	"math"
 	"net/http"
+	"path/filepath"
+	"reflect"
 	"sync/atomic"
 	"time"
 
@@ -14,6 +17,7 @@ import (
 	"github.com/dannyluong408/marketstore/executor"
 	"github.com/dannyluong408/marketstore/planner"
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	"github.com/dannyluong408/marketstore/utils/io"
 	"github.com/dannyluong408/marketstore/utils/log"
 )
@@ -84,6 +88,9 @@ func (resp *MultiQueryResponse) ToColumnSeriesMap() (*io.ColumnSeriesMap, error)
 }
 
 func (s *DataService) Query(r *http.Request, reqs *MultiQueryRequest, response *MultiQueryResponse) (err error) {
+	ctx, cancel := queryContext(r)
+	defer cancel()
+	defer func() { err = queryTimeoutError(ctx, err) }()
 	response.Version = utils.GitHash
 	response.Timezone = utils.InstanceConfig.Timezone.String()
 	for _, req := range reqs.Requests {
@@ -97,19 +104,15 @@ func (s *DataService) Query(r *http.Request, reqs *MultiQueryRequest, response *
 			if err != nil {
 				return err
 			}
-			cs, err := es.Materialize()
+			cs, err := es.Materialize(ctx)
 			if err != nil {
 				return err
 			}
-			nds, err := io.NewNumpyDataset(cs)
-			if err != nil {
-				return err
-			}
-			tbk := io.NewTimeBucketKeyFromString(req.SQLStatement + ":SQL")
-			nmds, err := io.NewNumpyMultiDataset(nds, *tbk)
+			nmds, err := sqlResultToNumpy(req.SQLStatement, cs)
 			if err != nil {
 				return err
 			}
+			countRows("DataService.Query", nmds)
 			response.Responses = append(response.Responses,
 				QueryResponse{
 					nmds,
@@ -158,6 +161,7 @@ func (s *DataService) Query(r *http.Request, reqs *MultiQueryRequest, response *
 			start := io.ToSystemTimezone(time.Unix(epochStart, 0))
 			stop := io.ToSystemTimezone(time.Unix(epochEnd, 0))
 			csm, tpm, err := executeQuery(
+				ctx,
 				dest,
 				start, stop,
 				limitRecordCount, limitFromStart,
@@ -182,21 +186,11 @@ func (s *DataService) Query(r *http.Request, reqs *MultiQueryRequest, response *
 			/*
 				Separate each TimeBucket from the result and compose a NumpyMultiDataset
 			*/
-			var nmds *io.NumpyMultiDataset
-			for tbk, cs := range csm {
-				nds, err := io.NewNumpyDataset(cs)
-				if err != nil {
-					return err
-				}
-				if nmds == nil {
-					nmds, err = io.NewNumpyMultiDataset(nds, tbk)
-					if err != nil {
-						return err
-					}
-				} else {
-					nmds.Append(cs, tbk)
-				}
+			nmds, err := columnSeriesMapToNumpy(csm)
+			if err != nil {
+				return err
 			}
+			countRows("DataService.Query", nmds)
 
 			/*
 				Append the NumpyMultiDataset to the MultiResponse
@@ -225,8 +219,21 @@ func (s *DataService) ListSymbols(r *http.Request, args *ListSymbolsArgs, respon
 	if atomic.LoadUint32(&Queryable) == 0 {
 		return queryableError
 	}
+	// The client only sees the symbols of the buckets it may read
+	principal := principalOf(r)
+	readable := make(map[string]bool)
+	if principal != nil {
+		for _, tbi := range executor.ThisInstance.CatalogDir.GatherTimeBucketInfo() {
+			key, err := filepath.Rel(executor.ThisInstance.RootDir, filepath.Dir(tbi.Path))
+			if err == nil && principal.Allowed(auth.READ, key) {
+				readable[io.NewTimeBucketKey(key).GetItemInCategory("Symbol")] = true
+			}
+		}
+	}
 	for symbol := range executor.ThisInstance.CatalogDir.GatherCategoriesAndItems()["Symbol"] {
-		response.Results = append(response.Results, symbol)
+		if principal == nil || readable[symbol] {
+			response.Results = append(response.Results, symbol)
+		}
 	}
 	return err
 }
@@ -235,10 +242,131 @@ func (s *DataService) ListSymbols(r *http.Request, args *ListSymbolsArgs, respon
 Utility functions
 */
 
-func executeQuery(tbk *io.TimeBucketKey, start, end time.Time, LimitRecordCount int,
+/*
+sqlResultToNumpy packs a SQL result into a NumpyMultiDataset. The string key
+columns of a wildcard table can not be sent as numpy columns, so a result
+having them is split into one dataset per key, in the order of their rows. The
+client gets the result back in order by following the start index of the keys,
+so a result interleaving the rows of several keys, as an ORDER BY across keys
+does, is refused. Other results are keyed on the statement itself.
+*/
+func sqlResultToNumpy(stmt string, cs *io.ColumnSeries) (nmds *io.NumpyMultiDataset, err error) {
+	var cats []string
+	var keyCols [][]string
+	for _, cat := range []string{"Symbol", "Timeframe", "AttributeGroup"} {
+		if col, ok := cs.GetByName(cat).([]string); ok {
+			cats = append(cats, cat)
+			keyCols = append(keyCols, col)
+			cs.Remove(cat)
+		}
+	}
+
+	/*
+		Gather the rows of each key, keys are kept in order of first appearance
+	*/
+	var keys []string
+	keyRows := make(map[string][]int)
+	items := make([]string, len(keyCols))
+	for i := 0; i < cs.Len(); i++ {
+		for j, col := range keyCols {
+			items[j] = col[i]
+		}
+		key := strings.Join(items, "/")
+		if _, ok := keyRows[key]; !ok {
+			keys = append(keys, key)
+		} else if keys[len(keys)-1] != key {
+			return nil, fmt.Errorf("The rows of %s are interleaved with the ones of other keys, "+
+				"which can not be returned in order: order by %s first", key, strings.Join(cats, ", "))
+		}
+		keyRows[key] = append(keyRows[key], i)
+	}
+	if len(keys) == 0 {
+		nds, err := io.NewNumpyDataset(cs)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewNumpyMultiDataset(nds, *io.NewTimeBucketKeyFromString(stmt + ":SQL"))
+	}
+
+	for _, key := range keys {
+		keyCS := io.NewColumnSeries()
+		for _, name := range cs.GetColumnNames() {
+			col := reflect.ValueOf(cs.GetColumn(name))
+			keyCol := reflect.MakeSlice(col.Type(), 0, len(keyRows[key]))
+			for _, i := range keyRows[key] {
+				keyCol = reflect.Append(keyCol, col.Index(i))
+			}
+			keyCS.AddColumn(name, keyCol.Interface())
+		}
+		tbk := io.NewTimeBucketKey(key, strings.Join(cats, "/"))
+		if nmds == nil {
+			nds, err := io.NewNumpyDataset(keyCS)
+			if err != nil {
+				return nil, err
+			}
+			if nmds, err = io.NewNumpyMultiDataset(nds, *tbk); err != nil {
+				return nil, err
+			}
+		} else if err = nmds.Append(keyCS, *tbk); err != nil {
+			return nil, err
+		}
+	}
+	return nmds, nil
+}
+
+/*
+queryContext returns the context of a query, it is cancelled when the client
+disconnects or when the query runs longer than the configured query_timeout
+*/
+func queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	if timeout := utils.InstanceConfig.QueryTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+/*
+queryTimeoutError replaces the error of a query stopped by the query_timeout
+with one telling the client why
+*/
+func queryTimeoutError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Query cancelled after exceeding the query_timeout of %v",
+			utils.InstanceConfig.QueryTimeout)
+	}
+	return err
+}
+
+/*
+columnSeriesMapToNumpy packs each TimeBucket of a result into one NumpyMultiDataset
+*/
+func columnSeriesMapToNumpy(csm io.ColumnSeriesMap) (nmds *io.NumpyMultiDataset, err error) {
+	for tbk, cs := range csm {
+		nds, err := io.NewNumpyDataset(cs)
+		if err != nil {
+			return nil, err
+		}
+		if nmds == nil {
+			nmds, err = io.NewNumpyMultiDataset(nds, tbk)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			nmds.Append(cs, tbk)
+		}
+	}
+	return nmds, nil
+}
+
+func executeQuery(ctx context.Context, tbk *io.TimeBucketKey, start, end time.Time, LimitRecordCount int,
 	LimitFromStart bool) (io.ColumnSeriesMap, map[io.TimeBucketKey]int64, error) {
 
 	query := planner.NewQuery(executor.ThisInstance.CatalogDir)
+	query.SetContext(ctx)
 
 	/*
 		Alter timeframe inside key to ensure it matches a queryable TF
@@ -1,6 +1,10 @@
 package frontend
 
 import (
+	"context"
+	"strings"
+
+	"github.com/dannyluong408/marketstore/utils"
 	"github.com/dannyluong408/marketstore/utils/io"
 	"github.com/dannyluong408/marketstore/utils/test"
 
@@ -9,6 +13,7 @@ import (
 	"fmt"
 
 	"math"
+	"net/http"
 
 	. "gopkg.in/check.v1"
 )
@@ -252,6 +257,164 @@ func (s *ServerTestSuite) TestQueryMulti(c *C) {
 	c.Assert(t, Equals, tref)
 }
 
+func (s *ServerTestSuite) TestQuerySQLWildcard(c *C) {
+	service := &DataService{}
+	service.Init()
+
+	args := &MultiQueryRequest{
+		Requests: []QueryRequest{
+			{
+				IsSQLStatement: true,
+				SQLStatement: `SELECT Epoch, Close, Symbol FROM "*/1D/OHLC" ` +
+					`WHERE Symbol IN ('USDJPY', 'EURUSD') AND Epoch < '2000-01-10';`,
+			},
+		},
+	}
+
+	var response MultiQueryResponse
+	if err := service.Query(nil, args, &response); err != nil {
+		c.Fatalf("error returned: %s", err)
+	}
+
+	// The key columns are sent as the keys of the result
+	csm, err := response.Responses[0].Result.ToColumnSeriesMap()
+	c.Assert(err, IsNil)
+	c.Assert(len(csm), Equals, 2)
+	usdjpy := csm[*io.NewTimeBucketKey("USDJPY", "Symbol")]
+	c.Assert(usdjpy, NotNil)
+	c.Assert(usdjpy.GetColumnNames(), DeepEquals, []string{"Epoch", "Close"})
+	c.Assert(usdjpy.Len(), Equals, 8)
+	c.Assert(csm[*io.NewTimeBucketKey("NZDUSD", "Symbol")], IsNil)
+
+	// The keys are sent in the order of their rows
+	query := func(orderBy string) (*io.NumpyMultiDataset, error) {
+		var response MultiQueryResponse
+		err := service.Query(nil, &MultiQueryRequest{Requests: []QueryRequest{{
+			IsSQLStatement: true,
+			SQLStatement: `SELECT Epoch, Close, Symbol FROM "*/1D/OHLC" ` +
+				`WHERE Symbol IN ('USDJPY', 'EURUSD') AND Epoch < '2000-01-10' ORDER BY ` + orderBy + `;`,
+		}}}, &response)
+		if err != nil {
+			return nil, err
+		}
+		return response.Responses[0].Result, nil
+	}
+	nmds, err := query("Symbol DESC, Epoch")
+	c.Assert(err, IsNil)
+	c.Assert(nmds.StartIndex["USDJPY:Symbol"], Equals, 0)
+	c.Assert(nmds.StartIndex["EURUSD:Symbol"], Equals, 8)
+
+	// and refused when they are interleaved
+	_, err = query("Epoch")
+	c.Assert(err, ErrorMatches, "The rows of .* are interleaved with the ones of other keys.*")
+}
+
+func (s *ServerTestSuite) TestQuerySQLExpression(c *C) {
+	service := &DataService{}
+	service.Init()
+
+	args := &MultiQueryRequest{
+		Requests: []QueryRequest{
+			{
+				IsSQLStatement: true,
+				SQLStatement: "SELECT Epoch, (High-Low)/Close AS range_pct, Close*2 AS double " +
+					"FROM `USDJPY/1D/OHLC` WHERE Epoch < '2000-01-10';",
+			},
+		},
+	}
+
+	var response MultiQueryResponse
+	if err := service.Query(nil, args, &response); err != nil {
+		c.Fatalf("error returned: %s", err)
+	}
+
+	csm, err := response.Responses[0].Result.ToColumnSeriesMap()
+	c.Assert(err, IsNil)
+	c.Assert(len(csm), Equals, 1)
+	for _, cs := range csm {
+		c.Assert(cs.GetColumnNames(), DeepEquals, []string{"Epoch", "range_pct", "double"})
+		c.Assert(cs.Len(), Equals, 8)
+		c.Assert(cs.GetByName("double"), FitsTypeOf, []float32{})
+	}
+}
+
+func (s *ServerTestSuite) TestPrepareExecute(c *C) {
+	service := &DataService{}
+	service.Init()
+
+	prepareArgs := &MultiPrepareRequest{
+		Requests: []PrepareRequest{
+			{SQLStatement: `SELECT Epoch, Close, Symbol FROM "*/1D/OHLC" WHERE Symbol = ? AND Epoch < ?;`},
+		},
+	}
+	var prepared MultiPrepareResponse
+	err := service.Prepare(nil, prepareArgs, &prepared)
+	c.Assert(err, IsNil)
+	c.Assert(prepared.Responses[0].NumParameters, Equals, 2)
+	handle := prepared.Responses[0].Handle
+
+	args := &MultiExecuteRequest{
+		Requests: []ExecuteRequest{
+			{Handle: handle, Parameters: []interface{}{"USDJPY", int64(947462400)}}, // 2000-01-10
+			{Handle: handle, Parameters: []interface{}{"EURUSD", int64(947462400)}},
+		},
+	}
+	var response MultiQueryResponse
+	err = service.Execute(nil, args, &response)
+	c.Assert(err, IsNil)
+	c.Assert(response.Responses, HasLen, 2)
+	csm, err := response.ToColumnSeriesMap()
+	c.Assert(err, IsNil)
+	c.Assert((*csm)[*io.NewTimeBucketKey("USDJPY", "Symbol")].Len(), Equals, 8)
+	c.Assert((*csm)[*io.NewTimeBucketKey("EURUSD", "Symbol")].Len(), Equals, 8)
+
+	// Handles are only valid on the connection that prepared them
+	r := &http.Request{RemoteAddr: "127.0.0.1:50000"}
+	err = service.Execute(r, args, &MultiQueryResponse{})
+	c.Assert(err, NotNil)
+
+	var deallocated MultiServerResponse
+	err = service.Deallocate(nil, &MultiDeallocateRequest{
+		Requests: []DeallocateRequest{{Handle: handle}},
+	}, &deallocated)
+	c.Assert(err, IsNil)
+	err = service.Execute(nil, args, &MultiQueryResponse{})
+	c.Assert(err, NotNil)
+}
+
+func (s *ServerTestSuite) TestQueryCancel(c *C) {
+	service := &DataService{}
+	service.Init()
+
+	args := &MultiQueryRequest{
+		Requests: []QueryRequest{
+			NewQueryRequestBuilder("USDJPY/1Min/OHLC").End(),
+			{
+				IsSQLStatement: true,
+				SQLStatement:   "SELECT * FROM `USDJPY/1Min/OHLC`;",
+			},
+		},
+	}
+
+	// A disconnected client cancels the query
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := (&http.Request{}).WithContext(ctx)
+	for _, req := range args.Requests {
+		err := service.Query(r, &MultiQueryRequest{Requests: []QueryRequest{req}}, &MultiQueryResponse{})
+		c.Assert(err, Equals, context.Canceled)
+	}
+
+	// So does the query_timeout
+	utils.InstanceConfig.QueryTimeout = time.Nanosecond
+	defer func() { utils.InstanceConfig.QueryTimeout = 0 }()
+	for _, req := range args.Requests {
+		err := service.Query(nil, &MultiQueryRequest{Requests: []QueryRequest{req}}, &MultiQueryResponse{})
+		c.Assert(err, NotNil)
+		c.Assert(strings.Contains(err.Error(), "query_timeout"), Equals, true)
+	}
+}
+
 func (s *ServerTestSuite) TestListSymbols(c *C) {
 	service := &DataService{}
 	service.Init()
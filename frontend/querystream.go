@@ -0,0 +1,168 @@
+package frontend
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/utils/auth"
+	"github.com/dannyluong408/marketstore/utils/io"
+	. "github.com/dannyluong408/marketstore/utils/log"
+	msgpack "github.com/vmihailenco/msgpack"
+)
+
+// defaultStreamBatchSize is the number of records read per key for each chunk
+const defaultStreamBatchSize = 10000
+
+// queryStreamMethod labels the metrics of query streams
+const queryStreamMethod = "query_stream"
+
+// This is the request body of the query stream endpoint.
+type QueryStreamRequest struct {
+	// Destination is <symbol>/<timeframe>/<attributegroup>
+	Destination string `msgpack:"destination"`
+	// This is not usually set, defaults to Symbol/Timeframe/AttributeGroup
+	KeyCategory string `msgpack:"key_category,omitempty"`
+	// Lower time predicate (i.e. index >= start) in unix epoch second
+	EpochStart *int64 `msgpack:"epoch_start,omitempty"`
+	// Upper time predicate (i.e. index <= end) in unix epoch second
+	EpochEnd *int64 `msgpack:"epoch_end,omitempty"`
+	// Maximum number of records per TimeBucketKey in each chunk
+	BatchSize int `msgpack:"batch_size,omitempty"`
+	// Cursor of the last chunk received, to resume an interrupted stream
+	Cursor map[string]int64 `msgpack:"cursor,omitempty"`
+}
+
+// QueryStreamChunk is one message of the query stream response.
+type QueryStreamChunk struct {
+	Result *io.NumpyMultiDataset `msgpack:"result"`
+	// Epoch of the last row sent for each TimeBucketKey, in nanoseconds for
+	// sub-second timeframes
+	Cursor map[string]int64 `msgpack:"cursor"`
+	Error  string           `msgpack:"error,omitempty"`
+}
+
+/*
+QueryStreamHandler answers a query with a chunked response of msgpack encoded
+QueryStreamChunks, each holding the next batch of rows. A batch is read only
+after the previous one is written, so a slow client slows down the scan
+instead of the server buffering the result.
+*/
+func QueryStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadUint32(&Queryable) == 0 {
+		http.Error(w, queryableError.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="marketstore"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported by the connection", http.StatusInternalServerError)
+		return
+	}
+	var req QueryStreamRequest
+	if err := msgpack.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	epochStart := int64(0)
+	epochEnd := int64(math.MaxInt64)
+	if req.EpochStart != nil {
+		epochStart = *req.EpochStart
+	}
+	if req.EpochEnd != nil {
+		epochEnd = *req.EpochEnd
+	}
+	batchSize := req.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultStreamBatchSize
+	}
+	positions, err := cursorPositions(req.Cursor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The stream is cancelled when the client disconnects, or by the query_timeout
+	ctx, cancel := queryContext(r)
+	defer cancel()
+	cursor, err := executor.NewQueryCursor(
+		auth.NewContext(ctx, principal),
+		io.NewTimeBucketKey(req.Destination, req.KeyCategory),
+		epochStart, epochEnd,
+		batchSize, positions,
+	)
+	if err != nil {
+		http.Error(w, queryTimeoutError(ctx, err).Error(), http.StatusBadRequest)
+		return
+	}
+
+	rm := &rpcMetrics{ResponseWriter: w, start: time.Now()}
+	defer func() {
+		rpcDuration.Observe(time.Since(rm.start).Seconds(), queryStreamMethod)
+		bytesReturned.Add(float64(rm.written), queryStreamMethod)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-msgpack")
+	enc := msgpack.NewEncoder(rm)
+	for r.Context().Err() == nil {
+		var chunk QueryStreamChunk
+		csm, err := cursor.Next()
+		if err == nil {
+			if len(csm) == 0 {
+				return // All rows sent
+			}
+			chunk.Result, err = columnSeriesMapToNumpy(csm)
+			countRows(queryStreamMethod, chunk.Result)
+		}
+		if err != nil {
+			// The status is already sent, the error goes in the stream
+			chunk.Error = queryTimeoutError(ctx, err).Error()
+		}
+		chunk.Cursor = make(map[string]int64)
+		for tbk, epoch := range cursor.Positions() {
+			chunk.Cursor[tbk.String()] = epoch
+		}
+		if err := enc.Encode(&chunk); err != nil {
+			Log(ERROR, "Failed to write query stream chunk - Error: %v", err)
+			return
+		}
+		flusher.Flush()
+		if len(chunk.Error) != 0 {
+			return
+		}
+	}
+}
+
+/*
+cursorPositions returns the positions of the cursor of a chunk sent by the
+client, whose keys must each have an item for every category.
+*/
+func cursorPositions(cursor map[string]int64) (map[io.TimeBucketKey]int64, error) {
+	positions := make(map[io.TimeBucketKey]int64, len(cursor))
+	for tbkStr, epoch := range cursor {
+		split := strings.Split(tbkStr, ":")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("Invalid cursor key %q, must be in the format items:categories", tbkStr)
+		}
+		items, categories := strings.Split(split[0], "/"), strings.Split(split[1], "/")
+		if len(items) != len(categories) {
+			return nil, fmt.Errorf("Invalid cursor key %q, must have an item for every category", tbkStr)
+		}
+		for _, elem := range append(items, categories...) {
+			if elem == "" {
+				return nil, fmt.Errorf("Invalid cursor key %q, has an empty item or category", tbkStr)
+			}
+		}
+		positions[*io.NewTimeBucketKey(split[0], split[1])] = epoch
+	}
+	return positions, nil
+}
@@ -0,0 +1,108 @@
+package frontend
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/dannyluong408/marketstore/utils"
+	msgpack "github.com/vmihailenco/msgpack"
+	. "gopkg.in/check.v1"
+)
+
+func streamQuery(c *C, url string, req *QueryStreamRequest) (chunks []QueryStreamChunk) {
+	body, err := msgpack.Marshal(req)
+	c.Assert(err, IsNil)
+	resp, err := http.Post(url, "application/x-msgpack", bytes.NewBuffer(body))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	dec := msgpack.NewDecoder(resp.Body)
+	for {
+		var chunk QueryStreamChunk
+		if err = dec.Decode(&chunk); err == io.EOF {
+			return chunks
+		}
+		c.Assert(err, IsNil)
+		chunks = append(chunks, chunk)
+	}
+}
+
+func (s *ServerTestSuite) TestQueryStream(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(QueryStreamHandler))
+	defer server.Close()
+
+	start, end := int64(946684800), int64(946684800+10*86400) // 2000-01-01 + 10 days
+	req := &QueryStreamRequest{
+		Destination: "USDJPY,EURUSD/1H/OHLC",
+		EpochStart:  &start,
+		EpochEnd:    &end,
+		BatchSize:   50,
+	}
+	chunks := streamQuery(c, server.URL, req)
+	c.Assert(len(chunks) > 1, Equals, true)
+
+	rows := make(map[string]int)
+	for _, chunk := range chunks {
+		c.Assert(chunk.Error, Equals, "")
+		for tbkStr, length := range chunk.Result.Lengths {
+			c.Assert(length <= 50, Equals, true)
+			rows[tbkStr] += length
+		}
+	}
+	c.Assert(len(rows), Equals, 2)
+	var total int
+	for _, n := range rows {
+		total += n
+	}
+
+	// Resuming after the first chunk returns the rest of the rows
+	req.Cursor = chunks[0].Cursor
+	resumed := streamQuery(c, server.URL, req)
+	c.Assert(len(resumed), Equals, len(chunks)-1)
+	var resumedTotal int
+	for _, chunk := range resumed {
+		for _, length := range chunk.Result.Lengths {
+			resumedTotal += length
+		}
+	}
+	var firstChunk int
+	for _, length := range chunks[0].Result.Lengths {
+		firstChunk += length
+	}
+	c.Assert(resumedTotal, Equals, total-firstChunk)
+
+	// Unknown keys are rejected before streaming
+	req = &QueryStreamRequest{Destination: "NOTHERE/1H/OHLC"}
+	body, _ := msgpack.Marshal(req)
+	resp, err := http.Post(server.URL, "application/x-msgpack", bytes.NewBuffer(body))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+
+	// So are malformed cursor keys
+	for _, key := range []string{"USDJPY", "USDJPY/1H:Symbol/Timeframe/AttributeGroup", "USDJPY//OHLC:Symbol/Timeframe/AttributeGroup"} {
+		req = &QueryStreamRequest{Destination: "USDJPY/1H/OHLC", Cursor: map[string]int64{key: start}}
+		body, _ = msgpack.Marshal(req)
+		resp, err = http.Post(server.URL, "application/x-msgpack", bytes.NewBuffer(body))
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	}
+
+	// The query_timeout stops the stream
+	utils.InstanceConfig.QueryTimeout = time.Nanosecond
+	defer func() { utils.InstanceConfig.QueryTimeout = 0 }()
+	req = &QueryStreamRequest{Destination: "USDJPY/1H/OHLC", EpochStart: &start, EpochEnd: &end}
+	body, _ = msgpack.Marshal(req)
+	resp, err = http.Post(server.URL, "application/x-msgpack", bytes.NewBuffer(body))
+	c.Assert(err, IsNil)
+	reply, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	c.Assert(strings.Contains(string(reply), "query_timeout"), Equals, true)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	. "github.com/dannyluong408/marketstore/utils/log"
 	"github.com/dannyluong408/marketstore/utils/rpc/msgpack2"
 	rpc "github.com/gorilla/rpc/v2"
@@ -26,7 +27,19 @@ type RpcServer struct {
 
 func (s *RpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("marketstore-version", utils.GitHash)
-	s.Server.ServeHTTP(w, r)
+	w, r = withRPCMetrics(w, r)
+	auth.Middleware(s.Server).ServeHTTP(w, r)
+}
+
+/*
+principalOf returns the authenticated client of a request, nil if it is allowed
+everything
+*/
+func principalOf(r *http.Request) *auth.Principal {
+	if r == nil {
+		return nil
+	}
+	return auth.FromContext(r.Context())
 }
 
 func NewServer() (*RpcServer, *DataService) {
@@ -36,6 +49,7 @@ func NewServer() (*RpcServer, *DataService) {
 	s.RegisterCodec(json2.NewCodec(), "application/json")
 	s.RegisterCodec(json2.NewCodec(), "application/json;charset=UTF-8")
 	s.RegisterCodec(msgpack2.NewCodec(), "application/x-msgpack")
+	s.RegisterAfterFunc(observeRPC)
 	service := new(DataService)
 	service.Init()
 	err := s.RegisterService(service, "")
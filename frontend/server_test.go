@@ -1,6 +1,9 @@
 package frontend
 
 import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -9,6 +12,10 @@ import (
 
 	"github.com/dannyluong408/marketstore/catalog"
 	"github.com/dannyluong408/marketstore/executor"
+	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
+	"github.com/dannyluong408/marketstore/utils/io"
+	"github.com/dannyluong408/marketstore/utils/rpc/msgpack2"
 	"github.com/dannyluong408/marketstore/utils/test"
 )
 
@@ -38,3 +45,87 @@ func (s *ServerTestSuite) TestNewServer(c *C) {
 	serv, _ := NewServer()
 	c.Check(serv.HasMethod("DataService.Query"), Equals, true)
 }
+
+func (s *ServerTestSuite) TestAuth(c *C) {
+	err := auth.Initialize([]*utils.APIKeySetting{
+		{
+			Name: "reader",
+			Key:  "readkey",
+			ACLs: []*utils.ACLSetting{{On: "USDJPY/*/*", Permissions: []string{"read"}}},
+		},
+		{
+			Name: "writer",
+			Key:  "writekey",
+			ACLs: []*utils.ACLSetting{{On: "*/*/*", Permissions: []string{"read", "write"}}},
+		},
+	})
+	c.Assert(err, IsNil)
+	defer auth.Initialize(nil)
+	server, _ := NewServer()
+
+	call := func(key, method string, args, reply interface{}) (int, error) {
+		body, err := msgpack2.EncodeClientRequest(method, args)
+		c.Assert(err, IsNil)
+		r := httptest.NewRequest("POST", "/rpc", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", "application/x-msgpack")
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		return w.Code, msgpack2.DecodeClientResponse(w.Body, reply)
+	}
+	query := func(dest string) *MultiQueryRequest {
+		return &MultiQueryRequest{
+			Requests: []QueryRequest{NewQueryRequestBuilder(dest).LimitRecordCount(5).End()},
+		}
+	}
+
+	code, _ := call("", "DataService.Query", query("USDJPY/1D/OHLC"), nil)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+	code, _ = call("badkey", "DataService.Query", query("USDJPY/1D/OHLC"), nil)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	var response MultiQueryResponse
+	_, err = call("readkey", "DataService.Query", query("USDJPY/1D/OHLC"), &response)
+	c.Assert(err, IsNil)
+	c.Assert(response.Responses[0].Result.Lengths["USDJPY/1D/OHLC:Symbol/Timeframe/AttributeGroup"], Equals, 5)
+
+	// Other keys are hidden from a multi-symbol query and refused alone
+	response = MultiQueryResponse{}
+	_, err = call("readkey", "DataService.Query", query("USDJPY,EURUSD/1D/OHLC"), &response)
+	c.Assert(err, IsNil)
+	c.Assert(response.Responses[0].Result.Lengths, HasLen, 1)
+	_, err = call("readkey", "DataService.Query", query("EURUSD/1D/OHLC"), &response)
+	c.Assert(err, ErrorMatches, "reader is not allowed to read EURUSD/1D/OHLC")
+
+	var destroyed MultiServerResponse
+	destroy := &MultiKeyRequest{Requests: []KeyRequest{{Key: "USDJPY/1D/OHLC"}}}
+	_, err = call("readkey", "DataService.Destroy", destroy, &destroyed)
+	c.Assert(err, IsNil)
+	c.Assert(destroyed.Responses[0].Error, Equals, "reader is not allowed to destroy USDJPY/1D/OHLC")
+
+	var symbols ListSymbolsResponse
+	_, err = call("readkey", "DataService.ListSymbols", &ListSymbolsArgs{}, &symbols)
+	c.Assert(err, IsNil)
+	c.Assert(symbols.Results, DeepEquals, []string{"USDJPY"})
+
+	// Writing to a new key creates its bucket
+	response = MultiQueryResponse{}
+	_, err = call("writekey", "DataService.Query", query("USDJPY/1D/OHLC"), &response)
+	c.Assert(err, IsNil)
+	data := response.Responses[0].Result
+	data.StartIndex = map[string]int{"NEWSYM/1D/OHLC:Symbol/Timeframe/AttributeGroup": 0}
+	data.Lengths = map[string]int{"NEWSYM/1D/OHLC:Symbol/Timeframe/AttributeGroup": 5}
+	var written MultiServerResponse
+	write := &MultiWriteRequest{Requests: []WriteRequest{{Data: data}}}
+	_, err = call("writekey", "DataService.Write", write, &written)
+	c.Assert(err, IsNil)
+	c.Assert(written.Responses, HasLen, 1)
+	c.Assert(written.Responses[0].Error, Equals, "writer is not allowed to create NEWSYM/1D/OHLC")
+	_, err = executor.ThisInstance.CatalogDir.GetSubDirectoryFromKey(io.NewTimeBucketKey("NEWSYM/1D/OHLC"))
+	c.Assert(err, NotNil)
+}
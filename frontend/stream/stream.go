@@ -19,9 +19,12 @@ package stream
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/dannyluong408/marketstore/utils/auth"
 	"github.com/dannyluong408/marketstore/utils/io"
 	"github.com/eapache/channels"
 	"github.com/gobwas/glob"
@@ -39,9 +42,21 @@ const (
 var catalog *Catalog
 var send *channels.InfiniteChannel
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin: checkOrigin,
+}
+
+// checkOrigin accepts any origin unless authentication is enabled, then a
+// browser has to connect from the server's own origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !auth.Enabled() || origin == "" {
 		return true
-	},
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
 }
 
 // Catalog maintains the set of active subscribers
@@ -77,16 +92,21 @@ func NewCatalog() *Catalog {
 // manage a given stream client
 type Subscriber struct {
 	sync.RWMutex
-	c       *websocket.Conn
-	done    chan struct{}
-	streams map[string]struct{}
+	c         *websocket.Conn
+	done      chan struct{}
+	streams   map[string]struct{}
+	principal *auth.Principal
 }
 
 // Subscribed matches the subscriber's subscribed streams
-// with the supplied timebucket key string.
+// with the supplied timebucket key string, the subscriber
+// only receives the keys it is allowed to read.
 func (s *Subscriber) Subscribed(itemKey string) bool {
 	s.RLock()
 	defer s.RUnlock()
+	if !s.principal.Allowed(auth.READ, itemKey) {
+		return false
+	}
 	for stream := range s.streams {
 		if g, err := glob.Compile(stream, '/'); err == nil {
 			if g.Match(itemKey) {
@@ -250,6 +270,18 @@ func Initialize() {
 // Handler hooks into the HTTP interface and handles the incoming
 // streaming requests, and upgrades the connection
 func Handler(w http.ResponseWriter, r *http.Request) {
+	// browsers can not set headers on a websocket, so the
+	// key can also be passed as the api_key query parameter
+	if key := r.URL.Query().Get("api_key"); key != "" && r.Header.Get("X-API-Key") == "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="marketstore"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	// upgrade the socket
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -259,8 +291,9 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// build the subscriber
 	s := &Subscriber{
-		c:    ws,
-		done: make(chan struct{}),
+		c:         ws,
+		done:      make(chan struct{}),
+		principal: principal,
 	}
 
 	if s.c != nil {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/dannyluong408/marketstore/executor"
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	"github.com/dannyluong408/marketstore/utils/io"
 )
 
@@ -40,6 +41,14 @@ func (s *DataService) Write(r *http.Request, reqs *MultiWriteRequest, response *
 			response.appendResponse(err)
 			continue
 		}
+		if err = authorizeKeys(r, auth.WRITE, csm); err != nil {
+			response.appendResponse(err)
+			continue
+		}
+		if err = authorizeNewKeys(r, csm); err != nil {
+			response.appendResponse(err)
+			continue
+		}
 		if err = executor.WriteCSM(csm, req.IsVariableLength); err != nil {
 			response.appendResponse(err)
 			continue
@@ -77,6 +86,10 @@ func (s *DataService) Create(r *http.Request, reqs *MultiCreateRequest, response
 			response.appendResponse(err)
 			continue
 		}
+		if err = principalOf(r).Check(auth.CREATE, tbk.GetItemKey()); err != nil {
+			response.appendResponse(err)
+			continue
+		}
 
 		dsv, err := io.DataShapesFromInputString(req.DataShapes)
 		if err != nil {
@@ -103,7 +116,7 @@ func (s *DataService) Create(r *http.Request, reqs *MultiCreateRequest, response
 		rt := io.EnumRecordTypeByName(rowType)
 		tbinfo := io.NewTimeBucketInfo(*tf, tbk.GetPathToYearFiles(rootDir), "Default", year, dsv, rt)
 
-		err = executor.ThisInstance.CatalogDir.AddTimeBucket(tbk, tbinfo)
+		err = executor.CreateTimeBucket(tbk, tbinfo)
 		if err != nil {
 			err = fmt.Errorf("creation of new catalog entry failed: %s", err.Error())
 			response.appendResponse(err)
@@ -150,6 +163,10 @@ func (s *DataService) GetInfo(r *http.Request, reqs *MultiKeyRequest, response *
 			response.appendResponse(nil, err)
 			continue
 		}
+		if err = principalOf(r).Check(auth.READ, tbk.GetItemKey()); err != nil {
+			response.appendResponse(nil, err)
+			continue
+		}
 
 		tbi, err := executor.ThisInstance.CatalogDir.GetLatestTimeBucketInfoFromKey(tbk)
 		if err != nil {
@@ -180,8 +197,12 @@ func (s *DataService) Destroy(r *http.Request, reqs *MultiKeyRequest, response *
 			response.appendResponse(err)
 			continue
 		}
+		if err = principalOf(r).Check(auth.DESTROY, tbk.GetItemKey()); err != nil {
+			response.appendResponse(err)
+			continue
+		}
 
-		err = executor.ThisInstance.CatalogDir.RemoveTimeBucket(tbk)
+		err = executor.DestroyTimeBucket(tbk)
 		if err != nil {
 			err = fmt.Errorf("removal of catalog entry failed: %s", err.Error())
 			response.appendResponse(err)
@@ -193,10 +214,131 @@ func (s *DataService) Destroy(r *http.Request, reqs *MultiKeyRequest, response *
 	return nil
 }
 
+/*
+	Delete: Removes the records in a time range from a bucket
+*/
+type DeleteRequest struct {
+	Key string `msgpack:"key"`
+	// Lower time bound (i.e. index >= start) in unix epoch second
+	EpochStart int64 `msgpack:"epoch_start"`
+	// Upper time bound (i.e. index <= end) in unix epoch second
+	EpochEnd int64 `msgpack:"epoch_end"`
+}
+type MultiDeleteRequest struct {
+	Requests []DeleteRequest `msgpack:"requests"`
+}
+
+func (s *DataService) Delete(r *http.Request, reqs *MultiDeleteRequest, response *MultiServerResponse) (err error) {
+	errorString := "key \"%s\" is not in proper format, should be like: TSLA/1Min/OHLCV"
+
+	for _, req := range reqs.Requests {
+		tbk := io.NewTimeBucketKeyFromString(req.Key)
+		if tbk == nil || len(tbk.GetItems()) != len(tbk.GetCategories()) {
+			err = fmt.Errorf(errorString, req.Key)
+			response.appendResponse(err)
+			continue
+		}
+		if err = principalOf(r).Check(auth.WRITE, tbk.GetItemKey()); err != nil {
+			response.appendResponse(err)
+			continue
+		}
+
+		start := io.ToSystemTimezone(time.Unix(req.EpochStart, 0))
+		end := io.ToSystemTimezone(time.Unix(req.EpochEnd, 0))
+		if err = executor.DeleteRange(tbk, start, end); err != nil {
+			err = fmt.Errorf("delete of %s failed: %s", req.Key, err.Error())
+			response.appendResponse(err)
+			continue
+		}
+		response.appendResponse(err)
+	}
+
+	return nil
+}
+
+/*
+	AlterSchema: Changes the columns of a bucket, rewriting its records
+*/
+type AlterSchemaRequest struct {
+	Key string `msgpack:"key"`
+	// DataShapes are all the columns of the bucket after the change, in the
+	// format of Create, e.g. "Open,High,Low,Close/float32:Volume/int32:VWAP/float64"
+	DataShapes string `msgpack:"data_shapes"`
+}
+type MultiAlterSchemaRequest struct {
+	Requests []AlterSchemaRequest `msgpack:"requests"`
+}
+
+func (s *DataService) AlterSchema(r *http.Request, reqs *MultiAlterSchemaRequest, response *MultiServerResponse) (err error) {
+	errorString := "key \"%s\" is not in proper format, should be like: TSLA/1Min/OHLCV"
+
+	for _, req := range reqs.Requests {
+		tbk := io.NewTimeBucketKeyFromString(req.Key)
+		if tbk == nil || len(tbk.GetItems()) != len(tbk.GetCategories()) {
+			err = fmt.Errorf(errorString, req.Key)
+			response.appendResponse(err)
+			continue
+		}
+		// Dropping or narrowing a column loses data
+		if err = principalOf(r).Check(auth.DESTROY, tbk.GetItemKey()); err != nil {
+			response.appendResponse(err)
+			continue
+		}
+
+		dsv, err := io.DataShapesFromInputString(req.DataShapes)
+		if err != nil {
+			response.appendResponse(err)
+			continue
+		}
+		if err = executor.AlterSchema(tbk, dsv); err != nil {
+			err = fmt.Errorf("alter of %s failed: %s", req.Key, err.Error())
+			response.appendResponse(err)
+			continue
+		}
+		response.appendResponse(err)
+	}
+
+	return nil
+}
+
 /*
 Utility functions
 */
 
+/*
+authorizeKeys checks the permission of the client of r on every key of csm
+*/
+func authorizeKeys(r *http.Request, perm auth.Permission, csm io.ColumnSeriesMap) error {
+	principal := principalOf(r)
+	for tbk := range csm {
+		if err := principal.Check(perm, tbk.GetItemKey()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+authorizeNewKeys checks that the client of r may create the buckets of the keys
+of csm not in the catalog yet, as writing to them creates them
+*/
+func authorizeNewKeys(r *http.Request, csm io.ColumnSeriesMap) error {
+	principal := principalOf(r)
+	if principal == nil {
+		return nil
+	}
+	for tbk := range csm {
+		tbk := tbk
+		if _, err := executor.ThisInstance.CatalogDir.GetSubDirectoryFromKey(&tbk); err == nil {
+			continue
+		}
+		if err := principal.Check(auth.CREATE, tbk.GetItemKey()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (mr *MultiServerResponse) appendResponse(err error) {
 	var errorText string
 	if err == nil {
@@ -1,6 +1,7 @@
 package planner
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -10,11 +11,16 @@ import (
 
 	. "github.com/dannyluong408/marketstore/catalog"
 	"github.com/dannyluong408/marketstore/utils"
+	"github.com/dannyluong408/marketstore/utils/auth"
 	. "github.com/dannyluong408/marketstore/utils/io"
 	. "github.com/dannyluong408/marketstore/utils/log"
 )
 
 type TimeQualFunc func(epoch int64) bool
+
+// ColumnQualFunc marks the rows of a read result to keep, it is applied after
+// any row limit
+type ColumnQualFunc func(cs *ColumnSeries) (keep []bool, err error)
 type RestrictionList map[string][]string                     //Key is category, items list is target
 func (r RestrictionList) GetRestrictionMap() RestrictionList { return r }
 func (r RestrictionList) AddRestriction(category string, item string) {
@@ -34,6 +40,9 @@ func NewRestrictionList() RestrictionList {
 type DateRange struct {
 	Start, End         int64
 	StartYear, EndYear int16
+	// Nanoseconds within the Start and End seconds, for buckets with sub-second
+	// timeframes. The range includes the whole End second unless set otherwise.
+	StartNanos, EndNanos int32
 }
 
 var MaxEpoch = time.Unix(1<<63-62135596801, 999999999).Unix()
@@ -45,6 +54,7 @@ func NewDateRange() *DateRange {
 	dr.StartYear = int16(ToSystemTimezone(time.Unix(dr.Start, 0)).Year())
 	dr.End = MaxEpoch
 	dr.EndYear = int16(ToSystemTimezone(time.Unix(dr.End, 0)).Year())
+	dr.EndNanos = int32(time.Second - 1)
 	return dr
 }
 
@@ -71,12 +81,25 @@ type ParseResult struct {
 	IntervalsPerDay int64
 	RootDir         string
 	TimeQuals       []TimeQualFunc
+	ColumnQuals     []ColumnQualFunc
+	// Context cancels the reads of the result, nil when it can not be cancelled
+	Context context.Context
 }
 
 func NewParseResult() *ParseResult {
 	return new(ParseResult)
 }
 
+/*
+GetContext returns the context of the query, a background context if none was set
+*/
+func (pr *ParseResult) GetContext() context.Context {
+	if pr.Context == nil {
+		return context.Background()
+	}
+	return pr.Context
+}
+
 func (pr *ParseResult) GetRowType() (rt map[TimeBucketKey]EnumRecordType) {
 	rt = make(map[TimeBucketKey]EnumRecordType)
 	for _, qf := range pr.QualifiedFiles {
@@ -120,6 +143,13 @@ func (pr *ParseResult) GetDataShapes() (dsv map[TimeBucketKey][]DataShape) {
 		types := []EnumElementType{INT64}
 		names = append(names, qf.File.GetElementNames()...)
 		types = append(types, qf.File.GetElementTypes()...)
+		/*
+			Sub-second fixed records are read with their nanoseconds, as variable ones are
+		*/
+		if qf.File.GetRecordType() == FIXED && qf.File.IsSubSecond() {
+			names = append(names, "Nanoseconds")
+			types = append(types, INT32)
+		}
 		dsv[qf.Key] = NewDataShapeVector(names, types)
 	}
 	return dsv
@@ -130,7 +160,11 @@ func (pr *ParseResult) GetRowLen() (rlenMap map[TimeBucketKey]int) {
 	for _, qf := range pr.QualifiedFiles {
 		switch qf.File.GetRecordType() {
 		case FIXED:
-			rlenMap[qf.Key] = int(qf.File.GetRecordLength())
+			if qf.File.IsSubSecond() {
+				rlenMap[qf.Key] = int(qf.File.GetSubSecondRowLength())
+			} else {
+				rlenMap[qf.Key] = int(qf.File.GetRecordLength())
+			}
 		case VARIABLE:
 			rlenMap[qf.Key] = int(qf.File.GetVariableRecordLength())
 		}
@@ -171,6 +205,8 @@ type query struct {
 	Limit       *RowLimit
 	DataDir     *Directory
 	TimeQuals   []TimeQualFunc
+	ColumnQuals []ColumnQualFunc
+	Context     context.Context
 }
 
 func NewQuery(d *Directory) *query {
@@ -204,6 +240,16 @@ func (q *query) SetStart(start int64) {
 	}
 	q.Range.Start = start
 	q.Range.StartYear = int16(ToSystemTimezone(time.Unix(start, 0)).Year())
+	q.Range.StartNanos = 0
+}
+
+/*
+SetStartTime sets the start of the range with nanosecond precision, for buckets
+with sub-second timeframes
+*/
+func (q *query) SetStartTime(start time.Time) {
+	q.SetStart(start.Unix())
+	q.Range.StartNanos = int32(start.Nanosecond())
 }
 
 func (q *query) SetEnd(end int64) {
@@ -212,6 +258,16 @@ func (q *query) SetEnd(end int64) {
 	}
 	q.Range.End = end
 	q.Range.EndYear = int16(ToSystemTimezone(time.Unix(end, 0)).Year())
+	q.Range.EndNanos = int32(time.Second - 1)
+}
+
+/*
+SetEndTime sets the end of the range with nanosecond precision, for buckets
+with sub-second timeframes
+*/
+func (q *query) SetEndTime(end time.Time) {
+	q.SetEnd(end.Unix())
+	q.Range.EndNanos = int32(end.Nanosecond())
 }
 
 func (q *query) AddRestriction(category string, item string) {
@@ -231,7 +287,52 @@ func (q *query) AddTimeQual(timeQual TimeQualFunc) {
 	q.TimeQuals = append(q.TimeQuals, timeQual)
 }
 
+func (q *query) AddColumnQual(columnQual ColumnQualFunc) {
+	q.ColumnQuals = append(q.ColumnQuals, columnQual)
+}
+
+/*
+authorize drops the files of the keys the principal of the query context is
+not allowed to read, it is an error if that leaves no files
+*/
+func (q *query) authorize(pr *ParseResult) error {
+	if q.Context == nil {
+		return nil
+	}
+	principal := auth.FromContext(q.Context)
+	if principal == nil {
+		return nil
+	}
+	allowed := pr.QualifiedFiles[:0]
+	var denied error
+	for _, qf := range pr.QualifiedFiles {
+		if err := principal.Check(auth.READ, qf.Key.GetItemKey()); err != nil {
+			denied = err
+			continue
+		}
+		allowed = append(allowed, qf)
+	}
+	pr.QualifiedFiles = allowed
+	if len(allowed) == 0 && denied != nil {
+		return denied
+	}
+	return nil
+}
+
+/*
+SetContext sets the context of the query, the parse and the reads of its result
+stop with the context's error once it is done
+*/
+func (q *query) SetContext(ctx context.Context) {
+	q.Context = ctx
+}
+
 func (q *query) Parse() (pr *ParseResult, err error) {
+	if q.Context != nil {
+		if err = q.Context.Err(); err != nil {
+			return nil, err
+		}
+	}
 	// Check to see that the categories in the query are present in the DB directory
 	CatList := q.DataDir.GatherCategoriesFromCache()
 	for key := range q.Restriction.GetRestrictionMap() {
@@ -291,6 +392,9 @@ func (q *query) Parse() (pr *ParseResult, err error) {
 		Recurse the directory to produce the QualifiedFiles set
 	*/
 	getFileList(q.DataDir, &pr.QualifiedFiles, "", "")
+	if err = q.authorize(pr); err != nil {
+		return pr, err
+	}
 	if len(pr.QualifiedFiles) == 0 {
 		return pr, fmt.Errorf("No files returned from query parse")
 	}
@@ -340,5 +444,7 @@ func (q *query) Parse() (pr *ParseResult, err error) {
 			utils.InstanceConfig.Timezone).Unix()
 	}
 	pr.TimeQuals = q.TimeQuals
+	pr.ColumnQuals = q.ColumnQuals
+	pr.Context = q.Context
 	return pr, nil
 }
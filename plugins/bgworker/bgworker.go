@@ -4,25 +4,85 @@
 //
 // Background workers run under the marketstore server by implementing the
 // interface, started at the very beginning of the server lifecycle before the
-// query interface is started, but internal state shuold be fledged. The server
-// does not handle panics that happen within the plugin.  A plugin can recover
-// from panics, but be careful not to screw the server state if touching
-// internal API.  It is often better to just let it go.
+// query interface is started, but internal state shuold be fledged. Run
+// returning means the bgworker is done, it is not run again.  A panic in Run
+// is taken as a crash; the server logs it and runs a newly created bgworker
+// after a backoff.  Be careful not to screw the server state if touching
+// internal API.
 //
 // Configuration is as follows.
 //  bgworkers:
 //    - module: xxxWorker.so
 //      name: datafeed
 //      config: <according to the plulgin>
+//
+// The module is either the name of a bgworker compiled into the binary, which
+// registers itself with Register, or a .so file built with -buildmode=plugin.
+//
+// A bgworker that also implements Stopper is stopped before the final WAL
+// flush on shutdown, and can be stopped while the server keeps running, e.g.
+// when it is removed from the config and the server is reloaded with SIGHUP.
+// One implementing HealthChecker reports its health in the heartbeat.
 package bgworker
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/dannyluong408/marketstore/utils/stats"
+)
+
+var (
+	// running reports which bgworkers are running, by name
+	running = stats.NewGauge(
+		"marketstore_bgworker_running",
+		"Set to 1 while a bgworker is running, 0 once its Run returned",
+		"name")
+	restarts = stats.NewCounter(
+		"marketstore_bgworker_restarts_total",
+		"Number of times a crashed bgworker was created and run again",
+		"name")
+)
+
+// Bounds of the wait before restarting a crashed bgworker, doubled on each
+// crash and reset once a bgworker ran for longer than MaxRestartBackoff
+var (
+	MinRestartBackoff = time.Second
+	MaxRestartBackoff = 5 * time.Minute
+)
 
 // BgWorker implements Run().  It will be running under a separate goroutine.
 type BgWorker interface {
 	Run()
 }
 
+// Stopper is optionally implemented by a BgWorker that can be stopped.  Run
+// should return soon after Stop does.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// HealthChecker is optionally implemented by a BgWorker to report problems
+// while it keeps running, e.g. a feeder failing to reach its upstream API.
+type HealthChecker interface {
+	Health() error
+}
+
+// Status is the state of a bgworker, as reported in the heartbeat
+type Status struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"`
+	Restarts int    `json:"restarts"`
+	// Error is the error of the HealthChecker while running, or of the last crash
+	Error string `json:"error,omitempty"`
+}
+
 // SymbolLoader is an interface to retrieve symbol object from plugin
 type SymbolLoader interface {
 	LoadSymbol(symbolName string) (interface{}, error)
@@ -42,3 +102,259 @@ func Load(loader SymbolLoader, config map[string]interface{}) (BgWorker, error)
 	}
 	return newFunc(config)
 }
+
+var registry = struct {
+	sync.RWMutex
+	newFuncs map[string]func(map[string]interface{}) (BgWorker, error)
+}{newFuncs: make(map[string]func(map[string]interface{}) (BgWorker, error))}
+
+// Register makes a bgworker compiled into the binary available under name,
+// which can then be given as the module of a bgworker in the config file
+// instead of a .so file.  It is meant to be called from the init function
+// of the bgworker package, and panics if name is already registered.
+func Register(name string, newFunc func(map[string]interface{}) (BgWorker, error)) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, dup := registry.newFuncs[name]; dup {
+		panic("bgworker: Register called twice for " + name)
+	}
+	registry.newFuncs[name] = newFunc
+}
+
+// Lookup returns the NewBgWorker function registered under name.
+func Lookup(name string) (newFunc func(map[string]interface{}) (BgWorker, error), ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	newFunc, ok = registry.newFuncs[name]
+	return newFunc, ok
+}
+
+type worker struct {
+	sync.Mutex
+	bgWorker BgWorker
+	running  bool
+	stopping bool
+	restarts int
+	lastErr  error
+	stop     chan struct{} // Closed by Stop, interrupts a restart backoff
+	done     chan struct{} // Closed once the bgworker is no longer run
+}
+
+var workers = struct {
+	sync.Mutex
+	byName map[string]*worker
+}{byName: make(map[string]*worker)}
+
+// Run runs bgWorker in the calling goroutine, reporting it as running under
+// name until its Run returns.  It is not restarted if it crashes.
+func Run(name string, bgWorker BgWorker) {
+	Supervise(name, bgWorker, nil)
+}
+
+/*
+Supervise runs bgWorker in the calling goroutine under name until it is
+stopped or its Run returns.  When it crashes, restart makes a new bgworker that
+is run after a backoff, unless restart is nil.
+*/
+func Supervise(name string, bgWorker BgWorker, restart func() (BgWorker, error)) {
+	register(name, bgWorker).supervise(name, restart)
+}
+
+// Start is Supervise in a new goroutine.  The bgworker is known under name
+// once Start returns, so that it can be stopped right away.
+func Start(name string, bgWorker BgWorker, restart func() (BgWorker, error)) {
+	w := register(name, bgWorker)
+	go w.supervise(name, restart)
+}
+
+// register adds a worker for bgWorker under name
+func register(name string, bgWorker BgWorker) *worker {
+	w := &worker{
+		bgWorker: bgWorker,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	workers.Lock()
+	workers.byName[name] = w
+	workers.Unlock()
+	return w
+}
+
+func (w *worker) supervise(name string, restart func() (BgWorker, error)) {
+	defer func() {
+		workers.Lock()
+		if workers.byName[name] == w {
+			delete(workers.byName, name)
+		}
+		workers.Unlock()
+		close(w.done)
+	}()
+
+	backoff := MinRestartBackoff
+	for {
+		started := time.Now()
+		err := w.runOnce(name)
+		w.Lock()
+		stopping := w.stopping
+		if !stopping {
+			w.lastErr = err
+		}
+		w.Unlock()
+		if stopping {
+			return
+		}
+		if err == nil {
+			glog.Infof("BgWorker %s finished", name)
+			return
+		}
+		if restart == nil {
+			glog.Errorf("BgWorker %s crashed: %v", name, err)
+			return
+		}
+		if time.Since(started) > MaxRestartBackoff {
+			backoff = MinRestartBackoff
+		}
+		var next BgWorker
+		for next == nil {
+			glog.Errorf("BgWorker %s crashed: %v, restarting in %v", name, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-w.stop:
+				return
+			}
+			if backoff *= 2; backoff > MaxRestartBackoff {
+				backoff = MaxRestartBackoff
+			}
+			if next, err = restart(); err != nil {
+				w.Lock()
+				w.lastErr = err
+				w.Unlock()
+			}
+		}
+		w.Lock()
+		if w.stopping {
+			w.Unlock()
+			return
+		}
+		w.bgWorker = next
+		w.restarts++
+		w.Unlock()
+		restarts.Inc(name)
+	}
+}
+
+// runOnce runs the current bgworker unless it is being stopped, recovering
+// from its panics
+func (w *worker) runOnce(name string) (err error) {
+	w.Lock()
+	if w.stopping {
+		w.Unlock()
+		return nil
+	}
+	bgWorker := w.bgWorker
+	w.running = true
+	w.Unlock()
+	running.Set(1, name)
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("recovering from %v\n%s", r, string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+		running.Set(0, name)
+		w.Lock()
+		w.running = false
+		w.Unlock()
+	}()
+	bgWorker.Run()
+	return nil
+}
+
+// IsRunning returns true until the bgworker named name is stopped, has
+// finished, or has crashed without being restarted
+func IsRunning(name string) bool {
+	workers.Lock()
+	defer workers.Unlock()
+	_, ok := workers.byName[name]
+	return ok
+}
+
+// Stop stops the bgworker named name and waits for its Run to return, or for
+// ctx to be done.  It fails for running bgworkers not implementing Stopper.
+func Stop(ctx context.Context, name string) error {
+	workers.Lock()
+	w, ok := workers.byName[name]
+	workers.Unlock()
+	if !ok {
+		return nil
+	}
+	w.Lock()
+	stopper, ok := w.bgWorker.(Stopper)
+	isRunning := w.running
+	if isRunning && !ok {
+		w.Unlock()
+		return fmt.Errorf("bgworker %s cannot be stopped, it does not implement Stop", name)
+	}
+	if !w.stopping {
+		w.stopping = true
+		close(w.stop)
+	}
+	w.Unlock()
+	if isRunning {
+		if err := stopper.Stop(ctx); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("bgworker %s did not return from Run after Stop: %v", name, ctx.Err())
+	}
+}
+
+// StopAll stops all the bgworkers, returning the errors of those that could not be
+func StopAll(ctx context.Context) (errs []error) {
+	workers.Lock()
+	names := make([]string, 0, len(workers.byName))
+	for name := range workers.byName {
+		names = append(names, name)
+	}
+	workers.Unlock()
+	for _, name := range names {
+		if err := Stop(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Statuses returns the state of the bgworkers, sorted by name
+func Statuses() []Status {
+	workers.Lock()
+	statuses := make([]Status, 0, len(workers.byName))
+	checkers := make(map[string]HealthChecker)
+	for name, w := range workers.byName {
+		w.Lock()
+		status := Status{Name: name, Running: w.running, Restarts: w.restarts}
+		if w.lastErr != nil {
+			status.Error = w.lastErr.Error()
+		}
+		if hc, ok := w.bgWorker.(HealthChecker); ok && w.running {
+			checkers[name] = hc
+		}
+		w.Unlock()
+		statuses = append(statuses, status)
+	}
+	workers.Unlock()
+	for i := range statuses {
+		if hc, ok := checkers[statuses[i].Name]; ok {
+			if err := hc.Health(); err != nil {
+				statuses[i].Error = err.Error()
+			} else {
+				statuses[i].Error = ""
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,147 @@
+package bgworker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dannyluong408/marketstore/plugins/bgworker"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+type blockingWorker struct {
+	stop chan struct{}
+}
+
+func (w *blockingWorker) Run() {
+	<-w.stop
+}
+
+type stoppableWorker struct {
+	blockingWorker
+}
+
+func (w *stoppableWorker) Stop(ctx context.Context) error {
+	close(w.stop)
+	return nil
+}
+
+func waitRunning(name string) bool {
+	for i := 0; i < 100; i++ {
+		if bgworker.IsRunning(name) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *TestSuite) TestStop(c *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	stoppable := &stoppableWorker{blockingWorker{stop: make(chan struct{})}}
+	go bgworker.Run("stoppable", stoppable)
+	c.Assert(waitRunning("stoppable"), Equals, true)
+	c.Assert(bgworker.Stop(ctx, "stoppable"), IsNil)
+	c.Assert(bgworker.IsRunning("stoppable"), Equals, false)
+
+	blocking := &blockingWorker{stop: make(chan struct{})}
+	go bgworker.Run("blocking", blocking)
+	c.Assert(waitRunning("blocking"), Equals, true)
+	c.Assert(bgworker.Stop(ctx, "blocking"), ErrorMatches, "bgworker blocking cannot be stopped.*")
+	c.Assert(bgworker.IsRunning("blocking"), Equals, true)
+	close(blocking.stop)
+
+	// Stopping a bgworker that is not running is a no-op
+	c.Assert(bgworker.Stop(ctx, "unknown"), IsNil)
+
+	// A started bgworker can be stopped before its Run is called
+	started := &stoppableWorker{blockingWorker{stop: make(chan struct{})}}
+	bgworker.Start("started", started, nil)
+	c.Assert(bgworker.IsRunning("started"), Equals, true)
+	c.Assert(bgworker.Stop(ctx, "started"), IsNil)
+	c.Assert(bgworker.IsRunning("started"), Equals, false)
+}
+
+type finishingWorker struct{}
+
+func (w *finishingWorker) Run() {}
+
+type panickingWorker struct{}
+
+func (w *panickingWorker) Run() {
+	panic("lost the feed")
+}
+
+type healthyWorker struct {
+	stoppableWorker
+}
+
+func (w *healthyWorker) Health() error {
+	return errors.New("upstream is down")
+}
+
+func (s *TestSuite) TestRestart(c *C) {
+	minBackoff := bgworker.MinRestartBackoff
+	bgworker.MinRestartBackoff = time.Millisecond
+	defer func() { bgworker.MinRestartBackoff = minBackoff }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	made := make(chan struct{})
+	restart := func() (bgworker.BgWorker, error) {
+		close(made)
+		return &healthyWorker{stoppableWorker{blockingWorker{stop: make(chan struct{})}}}, nil
+	}
+	go bgworker.Supervise("crashing", &panickingWorker{}, restart)
+	<-made
+	c.Assert(waitRunning("crashing"), Equals, true)
+
+	var status bgworker.Status
+	for i := 0; i < 100 && !status.Running; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, st := range bgworker.Statuses() {
+			if st.Name == "crashing" {
+				status = st
+			}
+		}
+	}
+	c.Assert(status.Running, Equals, true)
+	c.Assert(status.Restarts, Equals, 1)
+	c.Assert(status.Error, Equals, "upstream is down")
+
+	c.Assert(bgworker.StopAll(ctx), HasLen, 0)
+	c.Assert(bgworker.IsRunning("crashing"), Equals, false)
+
+	// A bgworker returning from Run is done, and is not run again
+	var remade int
+	bgworker.Supervise("finishing", &finishingWorker{}, func() (bgworker.BgWorker, error) {
+		remade++
+		return &finishingWorker{}, nil
+	})
+	c.Assert(remade, Equals, 0)
+	c.Assert(bgworker.IsRunning("finishing"), Equals, false)
+}
+
+func (s *TestSuite) TestRegister(c *C) {
+	bgworker.Register("blocking", func(config map[string]interface{}) (bgworker.BgWorker, error) {
+		return &blockingWorker{stop: make(chan struct{})}, nil
+	})
+	newFunc, ok := bgworker.Lookup("blocking")
+	c.Assert(ok, Equals, true)
+	w, err := newFunc(nil)
+	c.Assert(err, IsNil)
+	c.Assert(w, FitsTypeOf, &blockingWorker{})
+
+	_, ok = bgworker.Lookup("missing")
+	c.Assert(ok, Equals, false)
+}
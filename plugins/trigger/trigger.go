@@ -7,6 +7,9 @@
 // (appended or updated).  It is guaranteed that the new content has been written
 // on disk when Fire() is called, so it is safe to read it from disk.  Keep in mind
 // that the trigger might be called on the startup, due to the WAL recovery.
+// A trigger can optionally implement DeleteTrigger to be told about range deletes
+// as well, or EventTrigger to be told about every kind of change, including
+// buckets being created and destroyed.
 //
 // Triggers can be configured in the marketstore config file.
 //
@@ -15,8 +18,18 @@
 // 	    on: "*/1Min/OHLCV"
 // 	    config: <according to the plugin>
 //
+// The module is either the name of a trigger compiled into the binary, which
+// registers itself with Register, or a .so file built with -buildmode=plugin.
+//
 // The "on" value is matched with the file path to decide whether the trigger
 // is fired or not.  It can contain wildcard character "*".
+//
+// The fires of a trigger for one bucket are queued and run in order, one at a
+// time.  Up to queue_depth (100 by default) fires wait in the queue of a bucket,
+// and once it is full queue_policy decides whether the writes wait for the
+// trigger to catch up ("block", the default) or the new fires are dropped
+// ("drop").  The server option trigger_workers bounds how many fires run at
+// once across all triggers and buckets.
 // As of now, trigger fires only on the running state.  Trigger on WAL replay
 // may be added later.
 package trigger
@@ -25,6 +38,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dannyluong408/marketstore/utils/io"
@@ -39,6 +53,97 @@ type Trigger interface {
 	Fire(keyPath string, records []Record)
 }
 
+// DeleteTrigger is an optional interface a trigger plugin can implement
+// to be notified when records are removed by a range delete.
+type DeleteTrigger interface {
+	// FireDelete is called after the records between startIndex and
+	// endIndex (inclusive) of the file at keyPath have been removed.
+	// keyPath is the same form as in Fire.
+	FireDelete(keyPath string, startIndex, endIndex int64)
+}
+
+// EventType is the kind of change an Event describes.
+type EventType uint8
+
+const (
+	// Write is fired after records are written, like Fire
+	Write EventType = iota
+	// DeleteRange is fired after records are removed, like FireDelete
+	DeleteRange
+	// Destroy is fired after a bucket is removed with all its data
+	Destroy
+	// Create is fired after a bucket is created
+	Create
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Write:
+		return "write"
+	case DeleteRange:
+		return "delete_range"
+	case Destroy:
+		return "destroy"
+	case Create:
+		return "create"
+	}
+	return "unknown"
+}
+
+// Event is a change to the data of a bucket.
+type Event struct {
+	Type EventType
+	// KeyPath is the path of the year file for Write and DeleteRange, such as
+	// "AAPL/1Min/OHLCV/2017.bin", and of the bucket for Destroy and Create,
+	// such as "AAPL/1Min/OHLCV".  Both are relative to the catalog root.
+	KeyPath string
+	// Records are the records written by a Write.
+	Records []Record
+	// StartIndex and EndIndex are the inclusive bounds of a DeleteRange.
+	StartIndex, EndIndex int64
+}
+
+// EventTrigger is an optional interface a trigger plugin can implement to
+// be notified of every kind of change.  FireEvent is then called instead of
+// Fire and FireDelete, and the events of a bucket are fired in the order they
+// happened.
+type EventTrigger interface {
+	Trigger
+	FireEvent(ev Event)
+}
+
+// Accepts returns true if trig is notified of events of type t.  Every
+// trigger is notified of writes, the other events need DeleteTrigger or
+// EventTrigger.
+func Accepts(trig Trigger, t EventType) bool {
+	if _, ok := trig.(EventTrigger); ok {
+		return true
+	}
+	switch t {
+	case Write:
+		return true
+	case DeleteRange:
+		_, ok := trig.(DeleteTrigger)
+		return ok
+	}
+	return false
+}
+
+// FireEvent notifies trig of ev through the interface it implements, it has
+// to accept the event type.
+func FireEvent(trig Trigger, ev Event) {
+	if et, ok := trig.(EventTrigger); ok {
+		et.FireEvent(ev)
+		return
+	}
+	switch ev.Type {
+	case Write:
+		trig.Fire(ev.KeyPath, ev.Records)
+	case DeleteRange:
+		trig.(DeleteTrigger).FireDelete(ev.KeyPath, ev.StartIndex, ev.EndIndex)
+	}
+}
+
 // TriggerMatcher checks if the trigger should be fired or not.
 type TriggerMatcher struct {
 	Trigger Trigger
@@ -46,8 +151,17 @@ type TriggerMatcher struct {
 	// fire event.  It is the prefix of file path such as
 	// ""*/1Min/OHLC"
 	On string
+	// QueueDepth bounds the fires waiting for each bucket, DefaultQueueDepth
+	// when 0.  The fires for a bucket are run one at a time in write order.
+	QueueDepth int
+	// DropWhenFull drops the new fires of a bucket whose queue is full, instead
+	// of holding back the writes until the trigger catches up
+	DropWhenFull bool
 }
 
+// DefaultQueueDepth is the queue depth of a TriggerMatcher without one
+const DefaultQueueDepth = 100
+
 // SymbolLoader is an interface to retrieve symbol object from plugin
 type SymbolLoader interface {
 	LoadSymbol(symbolName string) (interface{}, error)
@@ -156,6 +270,32 @@ func Load(loader SymbolLoader, config map[string]interface{}) (Trigger, error) {
 	return newFunc(config)
 }
 
+var registry = struct {
+	sync.RWMutex
+	newFuncs map[string]func(map[string]interface{}) (Trigger, error)
+}{newFuncs: make(map[string]func(map[string]interface{}) (Trigger, error))}
+
+// Register makes a trigger compiled into the binary available under name,
+// which can then be given as the module of a trigger in the config file
+// instead of a .so file.  It is meant to be called from the init function
+// of the trigger package, and panics if name is already registered.
+func Register(name string, newFunc func(map[string]interface{}) (Trigger, error)) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, dup := registry.newFuncs[name]; dup {
+		panic("trigger: Register called twice for " + name)
+	}
+	registry.newFuncs[name] = newFunc
+}
+
+// Lookup returns the NewTrigger function registered under name.
+func Lookup(name string) (newFunc func(map[string]interface{}) (Trigger, error), ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	newFunc, ok = registry.newFuncs[name]
+	return newFunc, ok
+}
+
 // NewMatcher creates a new TriggerMatcher.
 func NewMatcher(trigger Trigger, on string) *TriggerMatcher {
 	return &TriggerMatcher{
@@ -103,3 +103,19 @@ func (s *TestSuite) TestRecordsToColumnSeries(c *C) {
 		c.Check(cs.GetEpoch()[i], Equals, testCS.GetEpoch()[i])
 	}
 }
+
+func (s *TestSuite) TestRegister(c *C) {
+	_, ok := Lookup("empty")
+	c.Assert(ok, Equals, false)
+
+	Register("empty", func(config map[string]interface{}) (Trigger, error) {
+		return &EmptyTrigger{}, nil
+	})
+	newFunc, ok := Lookup("empty")
+	c.Assert(ok, Equals, true)
+	trig, err := newFunc(nil)
+	c.Assert(err, IsNil)
+	c.Assert(trig, FitsTypeOf, &EmptyTrigger{})
+
+	c.Assert(func() { Register("empty", nil) }, PanicMatches, ".*empty")
+}
@@ -0,0 +1,206 @@
+// Package auth authenticates clients by API key and authorizes their access
+// to TimeBucketKeys.  Keys are configured in mkts.yml, each with a list of
+// ACLs granting permissions on the keys matching a glob, e.g.
+//
+//  api_keys:
+//    - name: feeder
+//      key: 6f1ed002ab5595859014ebf0951522d9
+//      acls:
+//        - on: "*/1Min/OHLCV"
+//          permissions: [read, write, create]
+//
+// A client sends its key as "Authorization: Bearer <key>" or in the X-API-Key
+// header.  Without any configured key authentication is disabled and every
+// request is allowed, as before.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dannyluong408/marketstore/utils"
+	"github.com/gobwas/glob"
+)
+
+type Permission uint8
+
+const (
+	_ Permission = iota
+	READ
+	WRITE
+	CREATE
+	DESTROY
+	// ADMIN grants server administration, e.g. backups, when granted on AllKeys
+	ADMIN
+)
+
+// AllKeys is the key checked for permissions covering the whole database
+const AllKeys = "*/*/*"
+
+var permissionNames = map[string]Permission{
+	"read":    READ,
+	"write":   WRITE,
+	"create":  CREATE,
+	"destroy": DESTROY,
+	"admin":   ADMIN,
+}
+
+func (p Permission) String() string {
+	for name, perm := range permissionNames {
+		if perm == p {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+var (
+	ErrNoCredentials      = errors.New("Authentication required, send an API key")
+	ErrInvalidCredentials = errors.New("Invalid API key")
+)
+
+type acl struct {
+	on          glob.Glob
+	permissions map[Permission]bool
+}
+
+/*
+Principal is an authenticated client. A nil Principal stands for a request
+with authentication disabled and is allowed everything.
+*/
+type Principal struct {
+	Name string
+	acls []acl
+}
+
+/*
+Allowed returns true if any of the ACLs of the principal grants the permission
+on the key, given as an item key like "AAPL/1Min/OHLCV"
+*/
+func (p *Principal) Allowed(perm Permission, key string) bool {
+	if p == nil {
+		return true
+	}
+	for _, a := range p.acls {
+		if a.permissions[perm] && a.on.Match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error if the principal is not allowed the permission on the key
+func (p *Principal) Check(perm Permission, key string) error {
+	if !p.Allowed(perm, key) {
+		return fmt.Errorf("%s is not allowed to %s %s", p.Name, perm, key)
+	}
+	return nil
+}
+
+var keys struct {
+	sync.RWMutex
+	principals map[[sha256.Size]byte]*Principal // By the hash of the API key
+}
+
+/*
+Initialize replaces the API keys accepted by the server with the settings,
+no settings disables authentication
+*/
+func Initialize(settings []*utils.APIKeySetting) error {
+	principals := make(map[[sha256.Size]byte]*Principal, len(settings))
+	for _, setting := range settings {
+		p := &Principal{Name: setting.Name}
+		for _, aclSetting := range setting.ACLs {
+			g, err := glob.Compile(aclSetting.On, '/')
+			if err != nil {
+				return fmt.Errorf("Invalid ACL pattern %s for %s: %s", aclSetting.On, setting.Name, err)
+			}
+			a := acl{on: g, permissions: make(map[Permission]bool)}
+			for _, name := range aclSetting.Permissions {
+				perm, ok := permissionNames[strings.ToLower(name)]
+				if !ok {
+					return fmt.Errorf("Unknown permission %s for %s, should be one of read, write, create, destroy or admin",
+						name, setting.Name)
+				}
+				a.permissions[perm] = true
+			}
+			p.acls = append(p.acls, a)
+		}
+		principals[sha256.Sum256([]byte(setting.Key))] = p
+	}
+	keys.Lock()
+	defer keys.Unlock()
+	keys.principals = principals
+	return nil
+}
+
+// Enabled returns true if clients have to authenticate
+func Enabled() bool {
+	keys.RLock()
+	defer keys.RUnlock()
+	return len(keys.principals) != 0
+}
+
+/*
+Authenticate returns the principal of the API key sent with the request, or
+nil when authentication is disabled
+*/
+func Authenticate(r *http.Request) (*Principal, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+	key := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		key = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	keys.RLock()
+	defer keys.RUnlock()
+	p, ok := keys.principals[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+/*
+Middleware authenticates the requests to next, rejecting unauthenticated ones
+and passing the principal of the others in the request context
+*/
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="marketstore"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+/*
+FromContext returns the principal of a request context, or nil for a context
+without one, which is allowed everything
+*/
+func FromContext(ctx context.Context) *Principal {
+	if ctx == nil {
+		return nil
+	}
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dannyluong408/marketstore/utils"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type AuthTestSuite struct{}
+
+var _ = Suite(&AuthTestSuite{})
+
+func (s *AuthTestSuite) TearDownTest(c *C) {
+	c.Assert(Initialize(nil), IsNil)
+}
+
+var testKeys = []*utils.APIKeySetting{
+	{
+		Name: "reader",
+		Key:  "readkey",
+		ACLs: []*utils.ACLSetting{
+			{On: "*/*/*", Permissions: []string{"read"}},
+		},
+	},
+	{
+		Name: "feeder",
+		Key:  "feedkey",
+		ACLs: []*utils.ACLSetting{
+			{On: "*/1Min/OHLCV", Permissions: []string{"Read", "write", "create"}},
+			{On: "BTC/*/*", Permissions: []string{"destroy"}},
+		},
+	},
+}
+
+func (s *AuthTestSuite) TestAuthenticate(c *C) {
+	r := httptest.NewRequest("POST", "/rpc", nil)
+
+	// Disabled without keys
+	c.Assert(Enabled(), Equals, false)
+	p, err := Authenticate(r)
+	c.Assert(err, IsNil)
+	c.Assert(p, IsNil)
+	c.Assert(p.Allowed(DESTROY, "AAPL/1Min/OHLCV"), Equals, true)
+
+	c.Assert(Initialize(testKeys), IsNil)
+	c.Assert(Enabled(), Equals, true)
+	_, err = Authenticate(r)
+	c.Assert(err, Equals, ErrNoCredentials)
+
+	r.Header.Set("Authorization", "Bearer wrongkey")
+	_, err = Authenticate(r)
+	c.Assert(err, Equals, ErrInvalidCredentials)
+
+	r.Header.Set("Authorization", "Bearer feedkey")
+	p, err = Authenticate(r)
+	c.Assert(err, IsNil)
+	c.Assert(p.Name, Equals, "feeder")
+
+	r = httptest.NewRequest("POST", "/rpc", nil)
+	r.Header.Set("X-API-Key", "readkey")
+	p, err = Authenticate(r)
+	c.Assert(err, IsNil)
+	c.Assert(p.Name, Equals, "reader")
+}
+
+func (s *AuthTestSuite) TestAllowed(c *C) {
+	c.Assert(Initialize(testKeys), IsNil)
+	r := httptest.NewRequest("POST", "/rpc", nil)
+	r.Header.Set("X-API-Key", "feedkey")
+	feeder, err := Authenticate(r)
+	c.Assert(err, IsNil)
+
+	c.Assert(feeder.Allowed(READ, "AAPL/1Min/OHLCV"), Equals, true)
+	c.Assert(feeder.Allowed(WRITE, "AAPL/1Min/OHLCV"), Equals, true)
+	c.Assert(feeder.Allowed(WRITE, "AAPL/1D/OHLCV"), Equals, false)
+	c.Assert(feeder.Allowed(DESTROY, "AAPL/1Min/OHLCV"), Equals, false)
+	c.Assert(feeder.Allowed(DESTROY, "BTC/1H/OHLCV"), Equals, true)
+	c.Assert(feeder.Check(WRITE, "AAPL/1D/OHLCV"), ErrorMatches, "feeder is not allowed to write AAPL/1D/OHLCV")
+
+	// The principal goes with the request context
+	ctx := NewContext(context.Background(), feeder)
+	c.Assert(FromContext(ctx), Equals, feeder)
+	c.Assert(FromContext(context.Background()), IsNil)
+}
+
+func (s *AuthTestSuite) TestInitializeErrors(c *C) {
+	err := Initialize([]*utils.APIKeySetting{
+		{Name: "bad", Key: "k", ACLs: []*utils.ACLSetting{{On: "*/*/*", Permissions: []string{"superuser"}}}},
+	})
+	c.Assert(err, ErrorMatches, "Unknown permission superuser for bad, should be one of .* or admin")
+	err = Initialize([]*utils.APIKeySetting{
+		{Name: "bad", Key: "k", ACLs: []*utils.ACLSetting{{On: "[*/*/*", Permissions: []string{"read"}}}},
+	})
+	c.Assert(err, ErrorMatches, "Invalid ACL pattern .*")
+}
+
+func (s *AuthTestSuite) TestMiddleware(c *C) {
+	c.Assert(Initialize(testKeys), IsNil)
+	var principal *Principal
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = FromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/rpc", nil))
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+	c.Assert(w.Header().Get("WWW-Authenticate"), Not(Equals), "")
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rpc", nil)
+	r.Header.Set("Authorization", "Bearer readkey")
+	handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(principal.Name, Equals, "reader")
+}
@@ -1,12 +1,17 @@
 package utils
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"runtime"
 	"strconv"
 	"time"
 
 	. "github.com/dannyluong408/marketstore/utils/log"
+	"github.com/gobwas/glob"
 	"gopkg.in/yaml.v2"
 )
 
@@ -17,9 +22,11 @@ func init() {
 }
 
 type TriggerSetting struct {
-	Module string
-	On     string
-	Config map[string]interface{}
+	Module      string
+	On          string
+	Config      map[string]interface{}
+	QueueDepth  int
+	QueuePolicy string
 }
 
 type BgWorkerSetting struct {
@@ -28,12 +35,41 @@ type BgWorkerSetting struct {
 	Config map[string]interface{}
 }
 
+// RetentionSetting expires the data of the buckets matching On once it is
+// older than Period
+type RetentionSetting struct {
+	On     string
+	Period time.Duration
+}
+
+// VariableWriteSetting sets how the writes to the VARIABLE buckets matching On
+// are merged with the records already in their intervals, Mode being "append"
+// or "upsert"
+type VariableWriteSetting struct {
+	On   string
+	Mode string
+}
+
+type ACLSetting struct {
+	On          string
+	Permissions []string
+}
+
+type APIKeySetting struct {
+	Name string
+	Key  string
+	ACLs []*ACLSetting
+}
+
 type MktsConfig struct {
 	RootDirectory     string
+	BackupDirectory   string
 	ListenPort        string
 	Timezone          *time.Location
 	Queryable         bool
 	StopGracePeriod   time.Duration
+	QueryTimeout      time.Duration
+	TriggerWorkers    int
 	WALRotateInterval int
 	EnableAdd         bool
 	EnableRemove      bool
@@ -41,49 +77,73 @@ type MktsConfig struct {
 	StartTime         time.Time
 	Triggers          []*TriggerSetting
 	BgWorkers         []*BgWorkerSetting
+	APIKeys           []*APIKeySetting
+	Retention         []*RetentionSetting
+	VariableWrites    []*VariableWriteSetting
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
 }
 
 func (m *MktsConfig) Parse(data []byte) error {
 	var err error
 	var aux struct {
 		RootDirectory     string `yaml:"root_directory"`
+		BackupDirectory   string `yaml:"backup_directory"`
 		ListenPort        string `yaml:"listen_port"`
 		Timezone          string `yaml:"timezone"`
 		LogLevel          string `yaml:"log_level"`
 		Queryable         string `yaml:"queryable"`
 		StopGracePeriod   int    `yaml:"stop_grace_period"`
+		QueryTimeout      int    `yaml:"query_timeout"`
+		TriggerWorkers    int    `yaml:"trigger_workers"`
 		WALRotateInterval int    `yaml:"wal_rotate_interval"`
 		EnableAdd         string `yaml:"enable_add"`
 		EnableRemove      string `yaml:"enable_remove"`
 		EnableLastKnown   string `yaml:"enable_last_known"`
 		Triggers          []struct {
-			Module string                 `yaml:"module"`
-			On     string                 `yaml:"on"`
-			Config map[string]interface{} `yaml:"config"`
+			Module      string                 `yaml:"module"`
+			On          string                 `yaml:"on"`
+			Config      map[string]interface{} `yaml:"config"`
+			QueueDepth  int                    `yaml:"queue_depth"`
+			QueuePolicy string                 `yaml:"queue_policy"`
 		} `yaml:"triggers"`
 		BgWorkers []struct {
 			Module string                 `yaml:"module"`
 			Name   string                 `yaml:"name"`
 			Config map[string]interface{} `yaml:"config"`
 		} `yaml:"bgworkers"`
+		APIKeys []struct {
+			Name string `yaml:"name"`
+			Key  string `yaml:"key"`
+			ACLs []struct {
+				On          string   `yaml:"on"`
+				Permissions []string `yaml:"permissions"`
+			} `yaml:"acls"`
+		} `yaml:"api_keys"`
+		Retention      yaml.MapSlice `yaml:"retention"`
+		VariableWrites yaml.MapSlice `yaml:"variable_writes"`
+		TLSCert        string        `yaml:"tls_cert"`
+		TLSKey         string        `yaml:"tls_key"`
+		TLSClientCA    string        `yaml:"tls_client_ca"`
 	}
 
 	if err := yaml.Unmarshal(data, &aux); err != nil {
 		return err
 	}
 	if aux.RootDirectory == "" {
-		Log(FATAL, "Invalid root directory.")
+		Log(ERROR, "Invalid root directory.")
 		return errors.New("Invalid root directory.")
 	}
 	if aux.ListenPort == "" {
-		Log(FATAL, "Invalid listen port.")
+		Log(ERROR, "Invalid listen port.")
 		return errors.New("Invalid listen port.")
 	}
 
 	// Giving "" to LoadLocation will be UTC anyway, which is our default too.
 	m.Timezone, err = time.LoadLocation(aux.Timezone)
 	if err != nil {
-		Log(FATAL, "Invalid timezone.")
+		Log(ERROR, "Invalid timezone.")
 		return errors.New("Invalid timezone")
 	}
 
@@ -115,6 +175,13 @@ func (m *MktsConfig) Parse(data []byte) error {
 	if aux.StopGracePeriod > 0 {
 		m.StopGracePeriod = time.Duration(aux.StopGracePeriod) * time.Second
 	}
+	if aux.QueryTimeout > 0 {
+		m.QueryTimeout = time.Duration(aux.QueryTimeout) * time.Second
+	}
+	m.TriggerWorkers = aux.TriggerWorkers
+	if m.TriggerWorkers <= 0 {
+		m.TriggerWorkers = runtime.NumCPU()
+	}
 	if aux.EnableAdd != "" {
 		enableAdd, err := strconv.ParseBool(aux.EnableAdd)
 		if err != nil {
@@ -144,14 +211,32 @@ func (m *MktsConfig) Parse(data []byte) error {
 			}
 		}
 	*/
+	if (aux.TLSCert == "") != (aux.TLSKey == "") {
+		return errors.New("Both tls_cert and tls_key are needed to serve over TLS")
+	}
+	if aux.TLSClientCA != "" && aux.TLSCert == "" {
+		return errors.New("tls_client_ca needs tls_cert and tls_key to be set")
+	}
+	m.TLSCert = aux.TLSCert
+	m.TLSKey = aux.TLSKey
+	m.TLSClientCA = aux.TLSClientCA
 	m.RootDirectory = aux.RootDirectory
+	m.BackupDirectory = aux.BackupDirectory
 	m.ListenPort = fmt.Sprintf(":%v", aux.ListenPort)
 
 	for _, trig := range aux.Triggers {
+		switch trig.QueuePolicy {
+		case "", "block", "drop":
+		default:
+			return fmt.Errorf("Unknown queue_policy %s for trigger %s on %s, should be block or drop",
+				trig.QueuePolicy, trig.Module, trig.On)
+		}
 		triggerSetting := &TriggerSetting{
-			Module: trig.Module,
-			On:     trig.On,
-			Config: trig.Config,
+			Module:      trig.Module,
+			On:          trig.On,
+			Config:      trig.Config,
+			QueueDepth:  trig.QueueDepth,
+			QueuePolicy: trig.QueuePolicy,
 		}
 		m.Triggers = append(m.Triggers, triggerSetting)
 	}
@@ -163,5 +248,99 @@ func (m *MktsConfig) Parse(data []byte) error {
 		}
 		m.BgWorkers = append(m.BgWorkers, bgWorkerSetting)
 	}
+	for _, ak := range aux.APIKeys {
+		if ak.Key == "" {
+			return fmt.Errorf("API key %s has no key", ak.Name)
+		}
+		apiKeySetting := &APIKeySetting{
+			Name: ak.Name,
+			Key:  ak.Key,
+		}
+		for _, acl := range ak.ACLs {
+			apiKeySetting.ACLs = append(apiKeySetting.ACLs, &ACLSetting{
+				On:          acl.On,
+				Permissions: acl.Permissions,
+			})
+		}
+		m.APIKeys = append(m.APIKeys, apiKeySetting)
+	}
+	for _, item := range aux.Retention {
+		on := fmt.Sprint(item.Key)
+		if _, err := glob.Compile(on, '/'); err != nil {
+			return fmt.Errorf("Invalid retention key %s: %v", on, err)
+		}
+		period, err := parsePeriod(fmt.Sprint(item.Value))
+		if err != nil || period <= 0 {
+			return fmt.Errorf("Invalid retention period %v for %s", item.Value, on)
+		}
+		m.Retention = append(m.Retention, &RetentionSetting{On: on, Period: period})
+	}
+	for _, item := range aux.VariableWrites {
+		on := fmt.Sprint(item.Key)
+		if _, err := glob.Compile(on, '/'); err != nil {
+			return fmt.Errorf("Invalid variable writes key %s: %v", on, err)
+		}
+		mode := fmt.Sprint(item.Value)
+		if mode != "append" && mode != "upsert" {
+			return fmt.Errorf("Invalid variable writes mode %v for %s, should be append or upsert", item.Value, on)
+		}
+		m.VariableWrites = append(m.VariableWrites, &VariableWriteSetting{On: on, Mode: mode})
+	}
 	return err
 }
+
+// parsePeriod parses a duration like time.ParseDuration, also accepting a
+// number of days or weeks, e.g. "30d" or "2w"
+func parsePeriod(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			n, err := strconv.Atoi(s[:len(s)-1])
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+/*
+TLSConfig returns the TLS configuration of the listener, or nil when serving
+plain HTTP. With a client CA, clients have to present a certificate it signed.
+*/
+func (m *MktsConfig) TLSConfig() (*tls.Config, error) {
+	if m.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(m.TLSCert, m.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the TLS certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if m.TLSClientCA != "" {
+		pool, err := LoadCertPool(m.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// LoadCertPool reads the PEM encoded certificates of a CA file
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No PEM certificate found in %s", caFile)
+	}
+	return pool, nil
+}
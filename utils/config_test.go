@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UtilsTestSuite) TestParseTLS(c *C) {
+	base := "root_directory: data\nlisten_port: 5993\n"
+
+	var m MktsConfig
+	c.Assert(m.Parse([]byte(base)), IsNil)
+	tlsConfig, err := m.TLSConfig()
+	c.Assert(err, IsNil)
+	c.Assert(tlsConfig, IsNil)
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"tls_cert: cert.pem\n")), ErrorMatches, "Both tls_cert and tls_key.*")
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"tls_client_ca: ca.pem\n")), ErrorMatches, "tls_client_ca needs.*")
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"tls_cert: /nonexistent/cert.pem\ntls_key: /nonexistent/key.pem\n")), IsNil)
+	c.Assert(m.TLSCert, Equals, "/nonexistent/cert.pem")
+	_, err = m.TLSConfig()
+	c.Assert(err, ErrorMatches, "Failed to load the TLS certificate.*")
+}
+
+func (s *UtilsTestSuite) TestParseRetention(c *C) {
+	base := "root_directory: data\nlisten_port: 5993\n"
+
+	var m MktsConfig
+	c.Assert(m.Parse([]byte(base+"retention:\n  \"*/1Sec/*\": 30d\n  \"AAPL/*/*\": 2w\n  \"*/*/*\": 36h\n")), IsNil)
+	c.Assert(m.Retention, HasLen, 3)
+	c.Assert(*m.Retention[0], Equals, RetentionSetting{On: "*/1Sec/*", Period: 30 * 24 * time.Hour})
+	c.Assert(*m.Retention[1], Equals, RetentionSetting{On: "AAPL/*/*", Period: 14 * 24 * time.Hour})
+	c.Assert(*m.Retention[2], Equals, RetentionSetting{On: "*/*/*", Period: 36 * time.Hour})
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"retention:\n  \"*/1Sec/*\": soon\n")), ErrorMatches, "Invalid retention period.*")
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"retention:\n  \"*/1Sec/*\": -1d\n")), ErrorMatches, "Invalid retention period.*")
+}
+
+func (s *UtilsTestSuite) TestParseVariableWrites(c *C) {
+	base := "root_directory: data\nlisten_port: 5993\n"
+
+	var m MktsConfig
+	c.Assert(m.Parse([]byte(base+"variable_writes:\n  \"*/1Min/TRADES\": upsert\n  \"*/*/*\": append\n")), IsNil)
+	c.Assert(m.VariableWrites, HasLen, 2)
+	c.Assert(*m.VariableWrites[0], Equals, VariableWriteSetting{On: "*/1Min/TRADES", Mode: "upsert"})
+	c.Assert(*m.VariableWrites[1], Equals, VariableWriteSetting{On: "*/*/*", Mode: "append"})
+
+	m = MktsConfig{}
+	c.Assert(m.Parse([]byte(base+"variable_writes:\n  \"*/1Min/TRADES\": replace\n")), ErrorMatches, "Invalid variable writes mode.*")
+}
@@ -1,6 +1,7 @@
 package io
 
 import (
+	"bytes"
 	"math"
 	"os"
 	"path/filepath"
@@ -561,3 +562,118 @@ func (s *TestSuite) TestApplyTimeQual(c *C) {
 
 	c.Assert(cs.ApplyTimeQual(tq).Len(), Equals, 0)
 }
+
+func (s *TestSuite) TestColumnar(c *C) {
+	types := []EnumElementType{FLOAT32, FLOAT64, INT32, INT64, BYTE, UINT64}
+	// index, float32, float64, int32, int64, byte, uint64 and padding
+	recordLen := AlignedSize(4+8+4+8+1+8) + 8
+	numRecords := ColumnarChunkRecords + 10
+	records := make([]byte, numRecords*recordLen)
+	index := int64(7)
+	for i := 0; i < numRecords; i++ {
+		r := records[i*recordLen:]
+		index += int64(1 + i%3)
+		*(*int64)(unsafe.Pointer(&r[0])) = index
+		f32 := float32(i) * 1.5
+		if i%100 == 0 {
+			f32 = float32(math.NaN())
+		}
+		*(*float32)(unsafe.Pointer(&r[8])) = f32
+		*(*float64)(unsafe.Pointer(&r[12])) = math.Sin(float64(i)) * 1e6
+		*(*int32)(unsafe.Pointer(&r[20])) = int32(i*i) - 5000
+		*(*int64)(unsafe.Pointer(&r[24])) = math.MinInt64 + int64(i)
+		r[32] = byte(i)
+		*(*uint64)(unsafe.Pointer(&r[33])) = math.MaxUint64 - uint64(i%2)
+	}
+
+	file := append(make([]byte, Headersize), EncodeColumnar(records, recordLen, types)...)
+	c.Assert(len(file)-Headersize < len(records), Equals, true)
+	chunks, err := ReadColumnarDirectory(bytes.NewReader(file))
+	c.Assert(err, IsNil)
+	c.Assert(chunks, HasLen, 2)
+	c.Assert(chunks[0].FirstIndex, Equals, int64(8))
+	c.Assert(chunks[1].LastIndex, Equals, index)
+
+	var decoded []byte
+	for _, chunk := range chunks {
+		decoded, err = DecodeColumnarChunk(decoded, file[chunk.Offset:chunk.Offset+chunk.Length], recordLen, types)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(decoded, DeepEquals, records)
+
+	chunk := chunks[1]
+	_, err = DecodeColumnarChunk(nil, file[chunk.Offset:chunk.Offset+chunk.Length-3], recordLen, types)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestPresence(c *C) {
+	path := filepath.Join(c.MkDir(), "2016.bin")
+	c.Assert(CreatePresence(path, 1000), IsNil)
+	info, err := os.Stat(PresencePath(path))
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(125))
+
+	p, err := ReadPresence(path, 10, 500)
+	c.Assert(err, IsNil)
+	c.Assert(p.FirstIndex, Equals, int64(9))
+	c.Assert(p.Runs(10, 500, 0), HasLen, 0)
+	for _, index := range []int64{10, 11, 12, 40, 43, 300} {
+		p.Set(index, true)
+	}
+	p.Set(11, false)
+	c.Assert(p.Write(path), IsNil)
+
+	p, err = ReadPresence(path, 1, 1000)
+	c.Assert(err, IsNil)
+	c.Assert(p.Has(10), Equals, true)
+	c.Assert(p.Has(11), Equals, false)
+	c.Assert(p.Runs(1, 1000, 0), DeepEquals, [][2]int64{{10, 10}, {12, 12}, {40, 40}, {43, 43}, {300, 300}})
+	c.Assert(p.Runs(1, 1000, 3), DeepEquals, [][2]int64{{10, 12}, {40, 43}, {300, 300}})
+	c.Assert(p.Runs(11, 299, math.MaxInt64), DeepEquals, [][2]int64{{12, 43}})
+
+	// slots past the end of the bitmap are empty
+	p, err = ReadPresence(path, 990, 1100)
+	c.Assert(err, IsNil)
+	c.Assert(p.Runs(990, 1100, 0), HasLen, 0)
+
+	_, err = ReadPresence(filepath.Join(c.MkDir(), "2016.bin"), 1, 10)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *TestSuite) TestReadPresentRuns(c *C) {
+	path := filepath.Join(c.MkDir(), "2016.bin")
+	slots := int64(3 * PresenceWindow)
+	c.Assert(CreatePresence(path, slots), IsNil)
+	// runs across words and windows
+	set := [][2]int64{{5, 5}, {60, 70}, {PresenceWindow - 2, PresenceWindow + 2}, {2*PresenceWindow + 100, slots}}
+	for _, run := range set {
+		p, err := ReadPresence(path, run[0], run[1])
+		c.Assert(err, IsNil)
+		for index := run[0]; index <= run[1]; index++ {
+			p.Set(index, true)
+		}
+		c.Assert(p.Write(path), IsNil)
+	}
+
+	read := func(first, last, minGap int64, backward bool, limit int) (runs [][2]int64) {
+		err := ReadPresentRuns(path, first, last, minGap, backward, func(first, last int64) bool {
+			runs = append(runs, [2]int64{first, last})
+			return len(runs) < limit
+		})
+		c.Assert(err, IsNil)
+		return runs
+	}
+	c.Assert(read(1, slots, 0, false, 10), DeepEquals, set)
+	c.Assert(read(1, slots, 0, true, 10), DeepEquals, [][2]int64{set[3], set[2], set[1], set[0]})
+	c.Assert(read(1, slots, 0, false, 2), DeepEquals, set[:2])
+	c.Assert(read(1, slots, 0, true, 1), DeepEquals, set[3:])
+	c.Assert(read(6, 65, 0, false, 10), DeepEquals, [][2]int64{{60, 65}})
+	c.Assert(read(66, PresenceWindow, 0, true, 10), DeepEquals, [][2]int64{{PresenceWindow - 2, PresenceWindow}, {66, 70}})
+	c.Assert(read(1, slots, 54, false, 10), DeepEquals, [][2]int64{{5, 70}, set[2], set[3]})
+	c.Assert(read(1, slots, 54, true, 10), DeepEquals, [][2]int64{set[3], set[2], {5, 70}})
+	c.Assert(read(1, slots, math.MaxInt64, true, 10), DeepEquals, [][2]int64{{5, slots}})
+
+	err := ReadPresentRuns(filepath.Join(c.MkDir(), "2016.bin"), 1, 10, 0, false,
+		func(first, last int64) bool { return true })
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,422 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	goio "io"
+	"math"
+	"math/bits"
+)
+
+// EnumFileFormat is the layout of the data following the header of a year file
+type EnumFileFormat int64
+
+const (
+	// DENSE files hold a record slot for every interval of the year
+	DENSE EnumFileFormat = iota
+	// COLUMNAR files hold only the written records, column by column in
+	// compressed chunks, and are read-only
+	COLUMNAR
+)
+
+// ColumnarChunkRecords is the number of records encoded together in a chunk of
+// a COLUMNAR file, the unit decoded by reads
+const ColumnarChunkRecords = 4096
+
+// columnarChunkEntrySize is the size of a ColumnarChunk in the chunk directory
+const columnarChunkEntrySize = 32
+
+/*
+ColumnarChunk locates a chunk of a COLUMNAR file. The body of the file follows
+the header with the number of chunks and a directory of one entry per chunk,
+then the chunks themselves in index order. A chunk holds the number of records,
+the delta-of-delta encoded record indexes, then each column prefixed with its
+length: floats are XOR encoded as in Facebook's Gorilla, 32 and 64 bit integers
+delta encoded as varints, and the other types stored as is.
+*/
+type ColumnarChunk struct {
+	FirstIndex int64 // Index of the first record of the chunk
+	LastIndex  int64 // Index of the last record of the chunk
+	Offset     int64 // Offset of the chunk from the start of the file
+	Length     int64
+}
+
+/*
+EncodeColumnar returns the body of a COLUMNAR file holding records, which are
+FIXED records of recordLen bytes made of the int64 index followed by the
+elements of types, in index order. The padding of the records is left out.
+*/
+func EncodeColumnar(records []byte, recordLen int, types []EnumElementType) []byte {
+	numRecords := len(records) / recordLen
+	numChunks := (numRecords + ColumnarChunkRecords - 1) / ColumnarChunkRecords
+	dirSize := 8 + numChunks*columnarChunkEntrySize
+	body := make([]byte, dirSize)
+	binary.LittleEndian.PutUint64(body, uint64(numChunks))
+	for i := 0; i < numChunks; i++ {
+		end := (i + 1) * ColumnarChunkRecords
+		if end > numRecords {
+			end = numRecords
+		}
+		chunkRecords := records[i*ColumnarChunkRecords*recordLen : end*recordLen]
+		chunk := ColumnarChunk{
+			FirstIndex: ToInt64(chunkRecords),
+			LastIndex:  ToInt64(chunkRecords[len(chunkRecords)-recordLen:]),
+			Offset:     int64(Headersize + len(body)),
+		}
+		body = encodeChunk(body, chunkRecords, recordLen, types)
+		chunk.Length = int64(Headersize+len(body)) - chunk.Offset
+		entry := body[8+i*columnarChunkEntrySize:]
+		binary.LittleEndian.PutUint64(entry, uint64(chunk.FirstIndex))
+		binary.LittleEndian.PutUint64(entry[8:], uint64(chunk.LastIndex))
+		binary.LittleEndian.PutUint64(entry[16:], uint64(chunk.Offset))
+		binary.LittleEndian.PutUint64(entry[24:], uint64(chunk.Length))
+	}
+	return body
+}
+
+// ReadColumnarDirectory reads the chunk directory of the COLUMNAR file r
+func ReadColumnarDirectory(r goio.ReaderAt) ([]ColumnarChunk, error) {
+	var buffer [8]byte
+	if _, err := r.ReadAt(buffer[:], Headersize); err != nil {
+		return nil, err
+	}
+	numChunks := int64(binary.LittleEndian.Uint64(buffer[:]))
+	if numChunks < 0 || numChunks > math.MaxInt32 {
+		return nil, fmt.Errorf("Invalid number of chunks %d", numChunks)
+	}
+	dir := make([]byte, numChunks*columnarChunkEntrySize)
+	if _, err := r.ReadAt(dir, Headersize+8); err != nil {
+		return nil, err
+	}
+	chunks := make([]ColumnarChunk, numChunks)
+	for i := range chunks {
+		entry := dir[i*columnarChunkEntrySize:]
+		chunks[i] = ColumnarChunk{
+			FirstIndex: int64(binary.LittleEndian.Uint64(entry)),
+			LastIndex:  int64(binary.LittleEndian.Uint64(entry[8:])),
+			Offset:     int64(binary.LittleEndian.Uint64(entry[16:])),
+			Length:     int64(binary.LittleEndian.Uint64(entry[24:])),
+		}
+	}
+	return chunks, nil
+}
+
+// DecodeColumnarChunk decodes the chunk data into FIXED records of recordLen
+// bytes, appending them to records
+func DecodeColumnarChunk(records, data []byte, recordLen int, types []EnumElementType) ([]byte, error) {
+	d := &chunkDecoder{data: data}
+	count := int(d.uvarint())
+	if d.err != nil || count <= 0 || count > ColumnarChunkRecords {
+		return nil, fmt.Errorf("Invalid chunk record count %d", count)
+	}
+	start := len(records)
+	records = append(records, make([]byte, count*recordLen)...)
+	chunk := records[start:]
+
+	var index, delta int64
+	for i := 0; i < count; i++ {
+		switch i {
+		case 0:
+			index = d.varint()
+		case 1:
+			delta = d.varint()
+			index += delta
+		default:
+			delta += d.varint()
+			index += delta
+		}
+		binary.LittleEndian.PutUint64(chunk[i*recordLen:], uint64(index))
+	}
+
+	offset := 8
+	for _, typ := range types {
+		column := d.bytes(int(d.uvarint()))
+		if d.err != nil {
+			return nil, d.err
+		}
+		var err error
+		switch typ {
+		case FLOAT32, FLOAT64:
+			err = decodeXOR(column, chunk, count, recordLen, offset, typ.Size())
+		case INT32, INT64, UINT32, UINT64:
+			err = decodeDelta(column, chunk, count, recordLen, offset, typ.Size())
+		default:
+			if len(column) != count*typ.Size() {
+				err = fmt.Errorf("Short %v column", typ)
+				break
+			}
+			for i := 0; i < count; i++ {
+				copy(chunk[i*recordLen+offset:], column[i*typ.Size():(i+1)*typ.Size()])
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += typ.Size()
+	}
+	return records, nil
+}
+
+func encodeChunk(buf, records []byte, recordLen int, types []EnumElementType) []byte {
+	count := len(records) / recordLen
+	buf = appendUvarint(buf, uint64(count))
+	var prev, prevDelta int64
+	for i := 0; i < count; i++ {
+		index := ToInt64(records[i*recordLen:])
+		switch i {
+		case 0:
+			buf = appendVarint(buf, index)
+		case 1:
+			prevDelta = index - prev
+			buf = appendVarint(buf, prevDelta)
+		default:
+			delta := index - prev
+			buf = appendVarint(buf, delta-prevDelta)
+			prevDelta = delta
+		}
+		prev = index
+	}
+
+	offset := 8
+	for _, typ := range types {
+		var column []byte
+		switch typ {
+		case FLOAT32, FLOAT64:
+			column = encodeXOR(records, count, recordLen, offset, typ.Size())
+		case INT32, INT64, UINT32, UINT64:
+			column = encodeDelta(records, count, recordLen, offset, typ.Size())
+		default:
+			for i := 0; i < count; i++ {
+				column = append(column, records[i*recordLen+offset:i*recordLen+offset+typ.Size()]...)
+			}
+		}
+		buf = appendUvarint(buf, uint64(len(column)))
+		buf = append(buf, column...)
+		offset += typ.Size()
+	}
+	return buf
+}
+
+// readUint reads the little endian unsigned integer of size 4 or 8 bytes at b
+func readUint(b []byte, size int) uint64 {
+	if size == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func writeUint(b []byte, size int, v uint64) {
+	if size == 4 {
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	} else {
+		binary.LittleEndian.PutUint64(b, v)
+	}
+}
+
+// toSigned sign-extends v of size bytes, so that deltas of negative values are small
+func toSigned(v uint64, size int) int64 {
+	if size == 4 {
+		return int64(int32(v))
+	}
+	return int64(v)
+}
+
+func encodeDelta(records []byte, count, recordLen, offset, size int) (column []byte) {
+	var prev int64
+	for i := 0; i < count; i++ {
+		v := toSigned(readUint(records[i*recordLen+offset:], size), size)
+		column = appendVarint(column, v-prev)
+		prev = v
+	}
+	return column
+}
+
+func decodeDelta(column, records []byte, count, recordLen, offset, size int) error {
+	d := &chunkDecoder{data: column}
+	var v int64
+	for i := 0; i < count; i++ {
+		v += d.varint()
+		writeUint(records[i*recordLen+offset:], size, uint64(v))
+	}
+	return d.err
+}
+
+/*
+encodeXOR encodes the float column at offset of the records, each value being
+stored as its XOR with the previous one: a 0 bit when they are equal, else a 1
+bit followed by either a 0 bit and the meaningful bits within the window of
+the previous value, or a 1 bit, the number of leading zeros in 5 bits, the
+number of meaningful bits in 6 bits and the meaningful bits.
+*/
+func encodeXOR(records []byte, count, recordLen, offset, size int) []byte {
+	w := &bitWriter{}
+	width := uint(size * 8)
+	var prev uint64
+	leading, trailing := uint(math.MaxUint8), uint(0)
+	for i := 0; i < count; i++ {
+		v := readUint(records[i*recordLen+offset:], size)
+		if i == 0 {
+			w.writeBits(v, width)
+			prev = v
+			continue
+		}
+		xor := v ^ prev
+		prev = v
+		if xor == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+		l := uint(bits.LeadingZeros64(xor)) - (64 - width)
+		t := uint(bits.TrailingZeros64(xor))
+		if l > 31 {
+			l = 31
+		}
+		if leading != math.MaxUint8 && l >= leading && t >= trailing {
+			w.writeBit(false)
+			w.writeBits(xor>>trailing, width-leading-trailing)
+			continue
+		}
+		leading, trailing = l, t
+		meaningful := width - l - t
+		w.writeBit(true)
+		w.writeBits(uint64(l), 5)
+		w.writeBits(uint64(meaningful&63), 6) // 64 meaningful bits are written as 0
+		w.writeBits(xor>>t, meaningful)
+	}
+	return w.buf
+}
+
+func decodeXOR(column, records []byte, count, recordLen, offset, size int) error {
+	r := &bitReader{buf: column}
+	width := uint(size * 8)
+	var v uint64
+	var leading, trailing uint
+	for i := 0; i < count; i++ {
+		switch {
+		case i == 0:
+			v = r.readBits(width)
+		case !r.readBit():
+			// Same as the previous value
+		case !r.readBit():
+			v ^= r.readBits(width-leading-trailing) << trailing
+		default:
+			leading = uint(r.readBits(5))
+			meaningful := uint(r.readBits(6))
+			if meaningful == 0 {
+				meaningful = 64
+			}
+			if leading+meaningful > width {
+				return fmt.Errorf("Invalid float window of %d bits", leading+meaningful)
+			}
+			trailing = width - leading - meaningful
+			v ^= r.readBits(meaningful) << trailing
+		}
+		if r.overflow {
+			return fmt.Errorf("Short float column")
+		}
+		writeUint(records[i*recordLen+offset:], size, v)
+	}
+	return nil
+}
+
+type bitWriter struct {
+	buf  []byte
+	free uint // Unused bits in the last byte of buf
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the n lowest bits of v, most significant first
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 0 {
+		n--
+		w.writeBit(v>>n&1 == 1)
+	}
+}
+
+type bitReader struct {
+	buf      []byte
+	pos      uint // Position in bits
+	overflow bool
+}
+
+func (r *bitReader) readBit() bool {
+	if r.pos >= uint(len(r.buf))*8 {
+		r.overflow = true
+		return false
+	}
+	bit := r.buf[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+	return bit
+}
+
+func (r *bitReader) readBits(n uint) (v uint64) {
+	for ; n > 0; n-- {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+type chunkDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *chunkDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *chunkDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *chunkDecoder) bytes(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		d.fail()
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *chunkDecoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("Truncated chunk")
+	}
+}
@@ -182,6 +182,38 @@ func GetElementType(datum interface{}) EnumElementType {
 	return NONE
 }
 
+/*
+PromoteElementTypes returns the type holding the result of arithmetic between
+values of the two types, or NONE if either is not numeric. Integers widen to
+INT64, FLOAT32 is kept unless combined with an integer wider than 16 bits,
+which it can not represent exactly.
+*/
+func PromoteElementTypes(left, right EnumElementType) EnumElementType {
+	isFloat := func(e EnumElementType) bool { return e == FLOAT32 || e == FLOAT64 }
+	isSmallInt := func(e EnumElementType) bool {
+		return e == BYTE || e == INT16 || e == UINT8 || e == UINT16
+	}
+	isInt := func(e EnumElementType) bool {
+		return isSmallInt(e) || e == INT32 || e == INT64 || e == EPOCH || e == UINT32 || e == UINT64
+	}
+	for _, e := range []EnumElementType{left, right} {
+		if !isFloat(e) && !isInt(e) {
+			return NONE
+		}
+	}
+	switch {
+	case left == FLOAT64 || right == FLOAT64:
+		return FLOAT64
+	case left == FLOAT32 && right == FLOAT32,
+		left == FLOAT32 && isSmallInt(right),
+		right == FLOAT32 && isSmallInt(left):
+		return FLOAT32
+	case isFloat(left) || isFloat(right):
+		return FLOAT64
+	}
+	return INT64
+}
+
 type CandleAttributes uint8
 
 /*
@@ -918,6 +918,18 @@ func (cs *ColumnSeries) RestrictViaBitmap(bitmap []bool) (err error) {
 			if err := cs.Replace(key, newCol); err != nil {
 				return err
 			}
+		case []string:
+			newCol := make([]string, bitmapValidLength)
+			var newColCursor int
+			for i, val := range bitmap {
+				if !val { // If the bitmap is true, remove the value
+					newCol[newColCursor] = col[i]
+					newColCursor++
+				}
+			}
+			if err := cs.Replace(key, newCol); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -344,6 +344,170 @@ func GenericComparison(left, right interface{},
 	return false, nil
 }
 
+/*
+VectorComparison evaluates (left op right) for every row of the column left.
+The right side is either a column of the same length or a single value.
+Integers are compared as int64 and other numerics as float64, strings can
+only be compared for (in)equality.
+*/
+func VectorComparison(left, right interface{},
+	op ComparisonOperatorEnum) (result []bool, err error) {
+	lv := reflect.ValueOf(left)
+	if lv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("Left side of a column comparison is not a column")
+	}
+	length := lv.Len()
+	rv := reflect.ValueOf(right)
+	if rv.Kind() == reflect.Slice {
+		if rv.Len() != length {
+			return nil, fmt.Errorf("Compared columns differ in length")
+		}
+	} else {
+		// Compare float32 columns at their own precision
+		if lv.Type().Elem().Kind() == reflect.Float32 && rv.Kind() == reflect.Float64 {
+			rv = reflect.ValueOf(float32(rv.Float()))
+		}
+		// Broadcast the single value to a column
+		col := reflect.MakeSlice(reflect.SliceOf(rv.Type()), length, length)
+		for i := 0; i < length; i++ {
+			col.Index(i).Set(rv)
+		}
+		rv = col
+	}
+	lk, rk := lv.Type().Elem().Kind(), rv.Type().Elem().Kind()
+
+	result = make([]bool, length)
+	switch {
+	case lk == reflect.String || rk == reflect.String:
+		if lk != rk {
+			return nil, fmt.Errorf("Can not compare a string to a number")
+		}
+		if op != EQ && op != NEQ {
+			return nil, fmt.Errorf("Strings can only be compared with = and !=")
+		}
+		l, r := left.([]string), rv.Interface().([]string)
+		for i := range result {
+			result[i] = (l[i] == r[i]) == (op == EQ)
+		}
+	case isIntegerKind(lk) && isIntegerKind(rk):
+		l, r := ColumnAsInt64(lv.Interface()), ColumnAsInt64(rv.Interface())
+		switch op {
+		case EQ:
+			for i := range result {
+				result[i] = l[i] == r[i]
+			}
+		case NEQ:
+			for i := range result {
+				result[i] = l[i] != r[i]
+			}
+		case LT:
+			for i := range result {
+				result[i] = l[i] < r[i]
+			}
+		case LTE:
+			for i := range result {
+				result[i] = l[i] <= r[i]
+			}
+		case GT:
+			for i := range result {
+				result[i] = l[i] > r[i]
+			}
+		case GTE:
+			for i := range result {
+				result[i] = l[i] >= r[i]
+			}
+		}
+	default:
+		l, err := ColumnAsFloat64(lv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		r, err := ColumnAsFloat64(rv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case EQ:
+			for i := range result {
+				result[i] = l[i] == r[i]
+			}
+		case NEQ:
+			for i := range result {
+				result[i] = l[i] != r[i]
+			}
+		case LT:
+			for i := range result {
+				result[i] = l[i] < r[i]
+			}
+		case LTE:
+			for i := range result {
+				result[i] = l[i] <= r[i]
+			}
+		case GT:
+			for i := range result {
+				result[i] = l[i] > r[i]
+			}
+		case GTE:
+			for i := range result {
+				result[i] = l[i] >= r[i]
+			}
+		}
+	}
+	return result, nil
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// ColumnAsInt64 returns the values of an integer column as int64
+func ColumnAsInt64(column interface{}) (out []int64) {
+	if i64, ok := column.([]int64); ok {
+		return i64
+	}
+	col := reflect.ValueOf(column)
+	out = make([]int64, col.Len())
+	switch col.Type().Elem().Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		for i := range out {
+			out[i] = int64(col.Index(i).Uint())
+		}
+	default:
+		for i := range out {
+			out[i] = col.Index(i).Int()
+		}
+	}
+	return out
+}
+
+// ColumnAsFloat64 returns the values of a numeric column as float64
+func ColumnAsFloat64(column interface{}) (out []float64, err error) {
+	switch c := column.(type) {
+	case []float64:
+		return c, nil
+	case []float32:
+		out = make([]float64, len(c))
+		for i, val := range c {
+			out[i] = float64(val)
+		}
+		return out, nil
+	}
+	col := reflect.ValueOf(column)
+	if !isIntegerKind(col.Type().Elem().Kind()) {
+		return nil, fmt.Errorf("Values of type %s are not numbers", col.Type().Elem())
+	}
+	out = make([]float64, col.Len())
+	for i, val := range ColumnAsInt64(column) {
+		out[i] = float64(val)
+	}
+	return out, nil
+}
+
 func GetValueAsFloat64(i_value interface{}) (val float64, err error) {
 	switch value := i_value.(type) {
 	case int:
@@ -2,6 +2,7 @@ package io
 
 import (
 	"bytes"
+	goio "io"
 	"os"
 	"sync"
 	"unsafe"
@@ -54,6 +55,7 @@ type TimeBucketInfo struct {
 	variableRecordLength int32 // In case of variable recordType, the sum of field lengths in elementTypes
 	elementNames         []string
 	elementTypes         []EnumElementType
+	format               EnumFileFormat
 
 	once sync.Once
 }
@@ -141,6 +143,7 @@ func (f *TimeBucketInfo) GetDeepCopy() *TimeBucketInfo {
 		recordType:           f.recordType,
 		recordLength:         f.recordLength,
 		variableRecordLength: f.variableRecordLength,
+		format:               f.format,
 	}
 	fcopy.elementNames = make([]string, len(f.elementNames))
 	fcopy.elementTypes = make([]EnumElementType, len(f.elementTypes))
@@ -186,6 +189,21 @@ func (f *TimeBucketInfo) GetIntervals() int64 {
 	return int64(utils.Day.Nanoseconds()) / int64(f.timeframe.Nanoseconds())
 }
 
+// IsSubSecond returns true if the timeframe of the given TimeBucketInfo is
+// under one second. The FIXED records of such buckets are read with a
+// Nanoseconds column, as their epochs are not whole seconds.
+func (f *TimeBucketInfo) IsSubSecond() bool {
+	return f.GetTimeframe() < time.Second
+}
+
+// GetSubSecondRowLength returns the length of a row read from the FIXED file
+// described by the given sub-second TimeBucketInfo: the Epoch, the fields
+// without the alignment padding, and the trailing Nanoseconds column
+func (f *TimeBucketInfo) GetSubSecondRowLength() int32 {
+	f.once.Do(f.initFromFile)
+	return int32(8 + f.getFieldRecordLength() + 4)
+}
+
 // GetNelements returns the number of elements (data fields) for a given
 // TimeBucketInfo.
 func (f *TimeBucketInfo) GetNelements() int32 {
@@ -233,6 +251,20 @@ func (f *TimeBucketInfo) GetElementTypes() []EnumElementType {
 	return f.elementTypes
 }
 
+// GetFormat returns the layout of the data in the file described by the given
+// TimeBucketInfo as of when its header was read
+func (f *TimeBucketInfo) GetFormat() EnumFileFormat {
+	f.once.Do(f.initFromFile)
+	return f.format
+}
+
+// SetFormat sets the layout of the data in the file described by the given
+// TimeBucketInfo, for the header written next
+func (f *TimeBucketInfo) SetFormat(format EnumFileFormat) {
+	f.once.Do(f.initFromFile)
+	f.format = format
+}
+
 // SetElementTypes sets the field types contained by the file described by
 // the given TimeBucketInfo
 func (f *TimeBucketInfo) SetElementTypes(newTypes []EnumElementType) error {
@@ -245,6 +277,19 @@ func (f *TimeBucketInfo) SetElementTypes(newTypes []EnumElementType) error {
 	return nil
 }
 
+// SetDataShapes sets the fields contained by the file described by the given
+// TimeBucketInfo, and the record lengths that follow from them, for the header
+// written next
+func (f *TimeBucketInfo) SetDataShapes(dsv []DataShape) {
+	f.once.Do(f.initFromFile)
+	f.elementTypes, f.elementNames = CreateShapesForTimeBucketInfo(dsv)
+	f.nElements = int32(len(f.elementTypes))
+	f.variableRecordLength = 0 // Recomputed by GetVariableRecordLength
+	if f.recordType == FIXED {
+		f.recordLength = int32(AlignedSize(f.getFieldRecordLength())) + 8 // add an 8-byte epoch field
+	}
+}
+
 func (f *TimeBucketInfo) readHeader(path string) (err error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -301,6 +346,7 @@ func (f *TimeBucketInfo) load(hp *Header, path string) {
 	f.nElements = int32(hp.NElements)
 	f.recordLength = int32(hp.RecordLength)
 	f.recordType = EnumRecordType(hp.RecordType)
+	f.format = EnumFileFormat(hp.Format)
 	f.elementNames = nil
 	f.elementTypes = nil
 	for i := 0; i < int(f.nElements); i++ {
@@ -310,6 +356,56 @@ func (f *TimeBucketInfo) load(hp *Header, path string) {
 	}
 }
 
+// ReadTimeBucketInfo reads the TimeBucketInfo from the header of the file at path
+func ReadTimeBucketInfo(path string) (*TimeBucketInfo, error) {
+	tbi := new(TimeBucketInfo)
+	if err := tbi.readHeader(path); err != nil {
+		return nil, err
+	}
+	if !tbi.IsRead {
+		return nil, fmt.Errorf("Short header in file %s", path)
+	}
+	return tbi, nil
+}
+
+// ReadFileFormat reads the format of the data from the header of the year file f
+func ReadFileFormat(f goio.ReaderAt) (EnumFileFormat, error) {
+	format, err := readHeaderField(f, unsafe.Offsetof(Header{}.Format))
+	return EnumFileFormat(format), err
+}
+
+// ReadRecordLength reads the record length from the header of the year file f
+func ReadRecordLength(f goio.ReaderAt) (int32, error) {
+	recordLength, err := readHeaderField(f, unsafe.Offsetof(Header{}.RecordLength))
+	return int32(recordLength), err
+}
+
+// ReadVariableRecordLength reads the length of the records stored in the data
+// area of the VARIABLE year file f from its header, trailer included
+func ReadVariableRecordLength(f goio.ReaderAt) (int32, error) {
+	nElements, err := readHeaderField(f, unsafe.Offsetof(Header{}.NElements))
+	if err != nil {
+		return 0, err
+	}
+	types := make([]byte, nElements)
+	if _, err = f.ReadAt(types, int64(unsafe.Offsetof(Header{}.ElementTypes))); err != nil {
+		return 0, err
+	}
+	recordLength := int32(4) // The interval ticks trailer
+	for _, elType := range types {
+		recordLength += int32(EnumElementType(elType).Size())
+	}
+	return recordLength, nil
+}
+
+func readHeaderField(f goio.ReaderAt, offset uintptr) (int64, error) {
+	var buffer [8]byte
+	if _, err := f.ReadAt(buffer[:], int64(offset)); err != nil {
+		return 0, err
+	}
+	return ToInt64(buffer[:]), nil
+}
+
 // NewTimeBucketInfoFromHeader creates a TimeBucketInfo from a given Header
 func NewTimeBucketInfoFromHeader(hp *Header, path string) *TimeBucketInfo {
 	tbi := new(TimeBucketInfo)
@@ -326,7 +422,7 @@ type Header struct {
 	RecordType   int64
 	NElements    int64
 	RecordLength int64
-	reserved1    int64
+	Format       int64 // EnumFileFormat, zero (DENSE) in files written before it was added
 	// Above is the fixed header portion - size is 312 Bytes = (7*8 + 256)
 	ElementNames [1024][32]byte
 	ElementTypes [1024]byte
@@ -357,6 +453,7 @@ func (hp *Header) Load(f *TimeBucketInfo) {
 	hp.NElements = int64(f.GetNelements())
 	hp.RecordLength = int64(f.GetRecordLength())
 	hp.RecordType = int64(f.GetRecordType())
+	hp.Format = int64(f.GetFormat())
 	for i := 0; i < int(hp.NElements); i++ {
 		copy(hp.ElementNames[i][:], f.GetElementNames()[i])
 		hp.ElementTypes[i] = byte(f.GetElementTypes()[i])
@@ -0,0 +1,291 @@
+package io
+
+import (
+	"encoding/binary"
+	goio "io"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+// PresenceWindow is the number of slots of a presence bitmap read or written at
+// once, so that the bitmaps of the finest timeframes are never held whole
+const PresenceWindow = 8 << 20
+
+// PresencePath returns the path of the presence bitmap of the year file at path
+func PresencePath(path string) string {
+	return strings.TrimSuffix(path, ".bin") + ".bitmap"
+}
+
+/*
+Presence is a part of the presence bitmap of a DENSE FIXED year file, which has
+a bit set for every slot holding a record, so that readers can skip the empty
+regions of sparse files without reading them. The bitmap is kept next to the
+year file, one bit per interval of the year starting at index 1, and is updated
+after the records are written, so a set bit may be for a slot just cleared but
+a written record always has its bit set.
+*/
+type Presence struct {
+	FirstIndex int64 // Index of the first bit of Bits, always 1 modulo 8
+	Bits       []byte
+}
+
+// NewPresence returns an empty Presence covering the indexes between
+// firstIndex and lastIndex (inclusive)
+func NewPresence(firstIndex, lastIndex int64) *Presence {
+	first := (firstIndex-1)/8*8 + 1
+	return &Presence{
+		FirstIndex: first,
+		Bits:       make([]byte, (lastIndex-first)/8+1),
+	}
+}
+
+// CreatePresence creates the empty presence bitmap of the new year file at path
+// with slots intervals
+func CreatePresence(path string, slots int64) error {
+	fp, err := os.OpenFile(PresencePath(path), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = fp.Truncate((slots + 7) / 8); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// ReadPresence reads the part of the presence bitmap of the year file at path
+// covering the indexes between firstIndex and lastIndex (inclusive). It returns
+// an os.IsNotExist error if the file has no bitmap.
+func ReadPresence(path string, firstIndex, lastIndex int64) (*Presence, error) {
+	fp, err := os.Open(PresencePath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	p := NewPresence(firstIndex, lastIndex)
+	if err = p.read(fp); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+/*
+ReadPresentRuns calls fn with the runs of slots holding records between
+firstIndex and lastIndex (inclusive) from the presence bitmap of the year file
+at path, until it returns false. The runs separated by at most minGap empty
+slots are merged. They are passed from the last if backward is set. The bitmap
+is read PresenceWindow slots at a time. It returns an os.IsNotExist error if
+the file has no bitmap.
+*/
+func ReadPresentRuns(path string, firstIndex, lastIndex, minGap int64, backward bool,
+	fn func(first, last int64) bool) error {
+	fp, err := os.Open(PresencePath(path))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	runs := &runMerger{minGap: minGap, backward: backward, emit: fn}
+	p := NewPresence(1, PresenceWindow)
+	// The windows start and end on byte boundaries
+	for first, last := firstIndex, lastIndex; first <= last; {
+		from, to := first, (first-1)/8*8+PresenceWindow
+		if backward {
+			from, to = (last-1)/8*8+8-PresenceWindow+1, last
+		}
+		if from < first {
+			from = first
+		}
+		if to > last {
+			to = last
+		}
+		p.FirstIndex = (from-1)/8*8 + 1
+		p.Bits = p.Bits[:(to-p.FirstIndex)/8+1]
+		if err = p.read(fp); err != nil {
+			return err
+		}
+		if !p.eachRun(from, to, backward, runs.add) {
+			return nil
+		}
+		if backward {
+			last = from - 1
+		} else {
+			first = to + 1
+		}
+		// A run is passed once no later one can be merged into it
+		if !runs.passed(first, last) {
+			return nil
+		}
+	}
+	runs.flush()
+	return nil
+}
+
+// read reads the part of the bitmap fp covered by p
+func (p *Presence) read(fp *os.File) error {
+	n, err := fp.ReadAt(p.Bits, (p.FirstIndex-1)/8)
+	if err != nil && err != goio.EOF {
+		return err
+	}
+	// There are no slots past the end of the bitmap
+	for i := n; i < len(p.Bits); i++ {
+		p.Bits[i] = 0
+	}
+	return nil
+}
+
+// Write writes p into the presence bitmap of the year file at path
+func (p *Presence) Write(path string) error {
+	fp, err := os.OpenFile(PresencePath(path), os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = fp.WriteAt(p.Bits, (p.FirstIndex-1)/8); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Has returns true if the slot at index holds a record
+func (p *Presence) Has(index int64) bool {
+	bit := index - p.FirstIndex
+	return p.Bits[bit/8]&(1<<uint(bit%8)) != 0
+}
+
+// Set marks the slot at index as holding a record or not
+func (p *Presence) Set(index int64, present bool) {
+	bit := index - p.FirstIndex
+	if present {
+		p.Bits[bit/8] |= 1 << uint(bit%8)
+	} else {
+		p.Bits[bit/8] &^= 1 << uint(bit%8)
+	}
+}
+
+/*
+Runs returns the runs of slots holding records between firstIndex and lastIndex
+(inclusive) as pairs of first and last index, merging the runs separated by
+at most minGap empty slots.
+*/
+func (p *Presence) Runs(firstIndex, lastIndex, minGap int64) (runs [][2]int64) {
+	merger := &runMerger{minGap: minGap, emit: func(first, last int64) bool {
+		runs = append(runs, [2]int64{first, last})
+		return true
+	}}
+	p.eachRun(firstIndex, lastIndex, false, merger.add)
+	merger.flush()
+	return runs
+}
+
+/*
+eachRun calls fn with the runs of set bits between firstIndex and lastIndex
+(inclusive) in order, or from the last if backward is set, until it returns
+false, and returns whether it did not. The bits are scanned a word at a time, a
+run crossing words is passed in parts.
+*/
+func (p *Presence) eachRun(firstIndex, lastIndex int64, backward bool, fn func(first, last int64) bool) bool {
+	firstBase := firstIndex - (firstIndex-p.FirstIndex)%64
+	lastBase := lastIndex - (lastIndex-p.FirstIndex)%64
+	for i := int64(0); i <= (lastBase-firstBase)/64; i++ {
+		base := firstBase + i*64
+		if backward {
+			base = lastBase - i*64
+		}
+		word := p.word(base)
+		if base < firstIndex {
+			word &^= 1<<uint(firstIndex-base) - 1
+		}
+		if lastIndex-base < 63 {
+			word &= 1<<uint(lastIndex-base+1) - 1
+		}
+		for word != 0 {
+			// The run of bits from low to high
+			low := bits.TrailingZeros64(word)
+			high := low + bits.TrailingZeros64(^(word >> uint(low))) - 1
+			if backward {
+				high = 63 - bits.LeadingZeros64(word)
+				low = high - bits.LeadingZeros64(^(word << uint(63-high))) + 1
+			}
+			if !fn(base+int64(low), base+int64(high)) {
+				return false
+			}
+			if high-low == 63 {
+				break
+			}
+			word &^= (1<<uint(high-low+1) - 1) << uint(low)
+		}
+	}
+	return true
+}
+
+// word returns the bits of the 64 slots from base, bit i for the slot base+i
+func (p *Presence) word(base int64) uint64 {
+	bytes := p.Bits[(base-p.FirstIndex)/8:]
+	if len(bytes) >= 8 {
+		return binary.LittleEndian.Uint64(bytes)
+	}
+	var buf [8]byte
+	copy(buf[:], bytes)
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// runMerger merges the runs added in order, or from the last if backward is
+// set, that are separated by at most minGap slots, and passes them to emit
+type runMerger struct {
+	minGap   int64
+	backward bool
+	emit     func(first, last int64) bool
+	run      [2]int64
+	pending  bool
+}
+
+func (m *runMerger) add(first, last int64) bool {
+	if m.pending {
+		gap := first - m.run[1] - 1
+		if m.backward {
+			gap = m.run[0] - last - 1
+		}
+		if gap <= m.minGap {
+			if m.backward {
+				m.run[0] = first
+			} else {
+				m.run[1] = last
+			}
+			return true
+		}
+		if !m.emit(m.run[0], m.run[1]) {
+			return false
+		}
+	}
+	m.run, m.pending = [2]int64{first, last}, true
+	return true
+}
+
+/*
+passed passes the pending run if the slots left to scan, between first and
+last, are all more than minGap slots away from it, and returns false if emit
+did.
+*/
+func (m *runMerger) passed(first, last int64) bool {
+	if !m.pending {
+		return true
+	}
+	gap := first - m.run[1] - 1
+	if m.backward {
+		gap = m.run[0] - last - 1
+	}
+	if gap <= m.minGap {
+		return true
+	}
+	m.pending = false
+	return m.emit(m.run[0], m.run[1])
+}
+
+// flush passes the last run
+func (m *runMerger) flush() {
+	if m.pending {
+		m.emit(m.run[0], m.run[1])
+		m.pending = false
+	}
+}
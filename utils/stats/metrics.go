@@ -0,0 +1,290 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Metrics are kept in a registry and served by Handler in the Prometheus text
+exposition format. Metrics register themselves when created, so they are
+usually declared as package variables of the package they measure.
+*/
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w)
+}
+
+// WriteMetrics writes all registered metrics in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	registry.Lock()
+	metrics := append([]metric{}, registry.metrics...)
+	registry.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// DefaultBuckets are the upper bounds of latency histograms, in seconds
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// ExponentialBuckets returns count upper bounds starting at start, each factor times the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+/*
+desc holds the name and labels of a metric, the series of a metric are keyed
+on their label values
+*/
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d *desc) seriesKey(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, have %d values",
+			d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+/*
+labelPairs formats the labels of a series, extra is appended as is, e.g. the
+le label of a histogram bucket
+*/
+func (d *desc) labelPairs(key string, extra string) string {
+	var pairs []string
+	if len(d.labels) != 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labels[i], labelEscaper.Replace(value)))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+/*
+Counter is a value that only goes up, with one series per set of label values
+*/
+type Counter struct {
+	desc
+	sync.Mutex
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.seriesKey(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += v
+}
+
+// Value returns the current value of a series, mostly useful in tests
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.seriesKey(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key, ""), formatFloat(c.values[key]))
+	}
+}
+
+/*
+Gauge is a value that can go up and down, with one series per set of label values
+*/
+type Gauge struct {
+	Counter
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{Counter{
+		desc:   desc{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	key := g.seriesKey(labelValues)
+	g.Lock()
+	defer g.Unlock()
+	g.values[key] = v
+}
+
+/*
+GaugeFunc is a gauge without labels whose value is read from f at each scrape
+*/
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{name: name, help: help, kind: "gauge"},
+		f:    f,
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+/*
+Histogram counts observations in cumulative buckets, with one series per set
+of label values
+*/
+type Histogram struct {
+	desc
+	sync.Mutex
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.seriesKey(labelValues)
+	h.Lock()
+	defer h.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations of a series, mostly useful in tests
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.seriesKey(labelValues)
+	h.Lock()
+	defer h.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	h.writeHeader(w)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				h.labelPairs(key, `le="`+formatFloat(bound)+`"`), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key, ""), s.count)
+	}
+}
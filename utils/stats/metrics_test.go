@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MetricsTestSuite struct{}
+
+var _ = Suite(&MetricsTestSuite{})
+
+func (s *MetricsTestSuite) TestTextFormat(c *C) {
+	counter := NewCounter("test_requests_total", "Requests", "method", "path")
+	counter.Inc("GET", "/a")
+	counter.Add(2, "GET", "/a")
+	counter.Inc("POST", `/"b"`)
+	gauge := NewGauge("test_running", "Running")
+	gauge.Set(1)
+	histogram := NewHistogram("test_latency_seconds", "Latency", []float64{0.1, 1}, "method")
+	histogram.Observe(0.05, "GET")
+	histogram.Observe(0.5, "GET")
+	histogram.Observe(5, "GET")
+	NewGaugeFunc("test_depth", "Depth", func() float64 { return 42 })
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	c.Assert(buf.String(), Equals, `# HELP test_requests_total Requests
+# TYPE test_requests_total counter
+test_requests_total{method="GET",path="/a"} 3
+test_requests_total{method="POST",path="/\"b\""} 1
+# HELP test_running Running
+# TYPE test_running gauge
+test_running 1
+# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{method="GET",le="0.1"} 1
+test_latency_seconds_bucket{method="GET",le="1"} 2
+test_latency_seconds_bucket{method="GET",le="+Inf"} 3
+test_latency_seconds_sum{method="GET"} 5.55
+test_latency_seconds_count{method="GET"} 3
+# HELP test_depth Depth
+# TYPE test_depth gauge
+test_depth 42
+`)
+	c.Assert(func() { counter.Inc("GET") }, PanicMatches, "metric test_requests_total has 2 labels, have 1 values")
+}
@@ -13,6 +13,7 @@ const Week = 7 * Day
 const Year = 365 * Day
 
 var timeframeDefs = []Timeframe{
+	{"ms", time.Millisecond},
 	{"S", time.Second},
 	{"Sec", time.Second},
 	{"T", time.Minute},
@@ -24,6 +25,10 @@ var timeframeDefs = []Timeframe{
 }
 
 var Timeframes = []*Timeframe{
+	{"1ms", time.Millisecond},
+	{"10ms", 10 * time.Millisecond},
+	{"100ms", 100 * time.Millisecond},
+	{"1Sec", time.Second},
 	{"1Min", time.Minute},
 	{"5Min", 5 * time.Minute},
 	{"15Min", 15 * time.Minute},
@@ -71,8 +76,8 @@ func TimeframeFromString(tf string) *Timeframe {
 }
 
 func TimeframeFromDuration(tf time.Duration) *Timeframe {
-	lowerDur := time.Second
-	lowerStr := "Sec"
+	lowerDur := time.Millisecond
+	lowerStr := "ms"
 	if tf < lowerDur {
 		return nil
 	}
@@ -146,15 +151,31 @@ func (cd *CandleDuration) IsWithin(ts, start time.Time) bool {
 	return false
 }
 
+// weekEpoch is the Monday that multi-week windows are counted from.
+var weekEpoch = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+
 // Truncate returns the lower boundary time of this candle window that
-// ts belongs to.
+// ts belongs to. Day, week, month and year windows follow the calendar
+// in the location of ts, weeks start on Monday.
 func (cd *CandleDuration) Truncate(ts time.Time) time.Time {
 	switch cd.suffix {
 	case "D":
 		yy, mm, dd := ts.Date()
 		return time.Date(yy, mm, dd, 0, 0, 0, 0, ts.Location())
+	case "W":
+		yy, mm, dd := ts.Date()
+		days := int(time.Date(yy, mm, dd, 0, 0, 0, 0, time.UTC).Sub(weekEpoch) / Day)
+		weeks := floorDiv(days, 7)
+		weeks -= mod(weeks, cd.multiplier)
+		return time.Date(1970, time.January, 5+weeks*7, 0, 0, 0, 0, ts.Location())
 	case "M":
-		return time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, ts.Location())
+		months := ts.Year()*12 + int(ts.Month()) - 1
+		months -= mod(months, cd.multiplier)
+		return time.Date(months/12, time.Month(months%12+1), 1, 0, 0, 0, 0, ts.Location())
+	case "Y":
+		year := ts.Year()
+		year -= mod(year, cd.multiplier)
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, ts.Location())
 	default:
 		return ts.Truncate(cd.duration)
 	}
@@ -163,23 +184,33 @@ func (cd *CandleDuration) Truncate(ts time.Time) time.Time {
 // Ceil returns the upper boundary time of this candle window that
 // ts belongs to.
 func (cd *CandleDuration) Ceil(ts time.Time) time.Time {
-	if cd.suffix == "D" {
+	switch cd.suffix {
+	case "D":
 		yy, mm, dd := ts.Add(Day).Date()
 		return time.Date(yy, mm, dd, 0, 0, 0, 0, ts.Location())
+	case "W":
+		return cd.Truncate(ts).AddDate(0, 0, 7*cd.multiplier)
+	case "M":
+		return cd.Truncate(ts).AddDate(0, cd.multiplier, 0)
+	case "Y":
+		return cd.Truncate(ts).AddDate(cd.multiplier, 0, 0)
+	default:
+		return (ts.Add(cd.duration)).Truncate(cd.duration)
 	}
-	if cd.suffix == "M" {
-		year := ts.Year()
-		month := ts.Month()
-		if month == time.December {
-			year++
-			month = time.January
-		} else {
-			month++
-		}
-		return time.Date(year, month, 1, 0, 0, 0, 0, ts.Location())
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
 	}
+	return a / b
+}
 
-	return (ts.Add(cd.duration)).Truncate(cd.duration)
+func mod(a, b int) int {
+	if b <= 1 {
+		return 0
+	}
+	return a - floorDiv(a, b)*b
 }
 
 func (cd *CandleDuration) QueryableTimeframe() string {
@@ -208,7 +239,7 @@ func (cd *CandleDuration) Duration() time.Duration {
 }
 
 func CandleDurationFromString(tf string) (cd *CandleDuration) {
-	re := regexp.MustCompile("([0-9]+)(Sec|Min|H|D|W|M|Y)")
+	re := regexp.MustCompile("([0-9]+)(ms|Sec|Min|H|D|W|M|Y)")
 	groups := re.FindStringSubmatch(tf)
 	if len(groups) == 0 {
 		return nil
@@ -225,6 +256,7 @@ func CandleDurationFromString(tf string) (cd *CandleDuration) {
 }
 
 var suffixDefs = map[string]time.Duration{
+	"ms":  time.Millisecond,
 	"S":   time.Second,
 	"Sec": time.Second,
 	"T":   time.Minute,
@@ -23,6 +23,10 @@ func (s *UtilsTestSuite) TestTimeframeFromDuration(c *C) {
 	c.Assert(tf.String, Equals, "1H")
 	c.Assert(tf.Duration, Equals, time.Hour)
 
+	tf = TimeframeFromDuration(100 * time.Millisecond)
+	c.Assert(tf.String, Equals, "100ms")
+	c.Assert(tf.Duration, Equals, 100*time.Millisecond)
+
 	tf = TimeframeFromDuration(time.Nanosecond)
 	c.Assert(tf, IsNil)
 
@@ -35,6 +39,13 @@ func (s *UtilsTestSuite) TestTimeframeFromString(c *C) {
 	c.Assert(tf.String, Equals, "15H")
 	c.Assert(tf.Duration, Equals, 15*time.Hour)
 
+	tf = TimeframeFromString("250ms")
+	c.Assert(tf.String, Equals, "250ms")
+	c.Assert(tf.Duration, Equals, 250*time.Millisecond)
+
+	tf = TimeframeFromString("1Min")
+	c.Assert(tf.Duration, Equals, time.Minute)
+
 	tf = TimeframeFromString("xyz")
 	c.Assert(tf, IsNil)
 
@@ -102,6 +113,36 @@ func (s *UtilsTestSuite) TestCandleDuration(c *C) {
 	c.Assert(cd.IsWithin(val, time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC)), Equals, false)
 	c.Assert(cd.IsWithin(val, time.Date(2018, 1, 8, 23, 59, 0, 0, time.UTC)), Equals, true)
 
+	// Calendar windows follow the location of the time
+	cd = CandleDurationFromString("1W")
+	val = time.Date(2018, 1, 10, 22, 0, 0, 0, loc) // Wednesday, Thursday in UTC
+	c.Assert(cd.Truncate(val), Equals, time.Date(2018, 1, 8, 0, 0, 0, 0, loc))
+	c.Assert(cd.Ceil(val), Equals, time.Date(2018, 1, 15, 0, 0, 0, 0, loc))
+	c.Assert(cd.Truncate(time.Date(2018, 1, 8, 0, 0, 0, 0, loc)), Equals, time.Date(2018, 1, 8, 0, 0, 0, 0, loc))
+
+	cd = CandleDurationFromString("2W")
+	start = cd.Truncate(time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC))
+	c.Assert(start.Weekday(), Equals, time.Monday)
+	c.Assert(cd.Ceil(start), Equals, start.AddDate(0, 0, 14))
+	c.Assert(cd.Truncate(start.AddDate(0, 0, 13)), Equals, start)
+
+	cd = CandleDurationFromString("3M")
+	val = time.Date(2017, 8, 10, 13, 47, 0, 0, loc)
+	c.Assert(cd.Truncate(val), Equals, time.Date(2017, 7, 1, 0, 0, 0, 0, loc))
+	c.Assert(cd.Ceil(val), Equals, time.Date(2017, 10, 1, 0, 0, 0, 0, loc))
+
+	cd = CandleDurationFromString("1Y")
+	val = time.Date(2017, 12, 31, 23, 0, 0, 0, loc) // 2018 in UTC
+	c.Assert(cd.Truncate(val), Equals, time.Date(2017, 1, 1, 0, 0, 0, 0, loc))
+	c.Assert(cd.Ceil(val), Equals, time.Date(2018, 1, 1, 0, 0, 0, 0, loc))
+
+	cd = CandleDurationFromString("500ms")
+	val = time.Date(2017, 9, 10, 13, 47, 0, 700000000, time.UTC)
+	c.Assert(cd.Truncate(val), Equals, time.Date(2017, 9, 10, 13, 47, 0, 500000000, time.UTC))
+	c.Assert(cd.Ceil(val), Equals, time.Date(2017, 9, 10, 13, 47, 1, 0, time.UTC))
+	c.Assert(cd.QueryableTimeframe(), Equals, "100ms")
+	c.Assert(cd.QueryableNrecords("100ms", 2), Equals, 10)
+
 	cd = CandleDurationFromString("abc")
 	c.Assert(cd, IsNil)
 }